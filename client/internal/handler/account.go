@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"encoding/json"
+	gophmodel "gophkeep/internal/model"
+	"gophkeep/internal/srp"
+	"net/http"
+	"strings"
+)
+
+// HandleChangePassword меняет пароль аккаунта: сервер получает только новый верификатор
+// SRP. Остальные сессии аккаунта сервер завершает.
+func (env *ClientEnv) HandleChangePassword(password string, newPassword string) (int, error) {
+	change, status, err := env.srpReauthenticate(password)
+	if err != nil || status != http.StatusOK {
+		return status, err
+	}
+
+	salt, verifier, err := srp.NewVerifier(newPassword)
+	if err != nil {
+		return 0, err
+	}
+	change.NewSRP = &gophmodel.SRPVerifier{Salt: salt, Verifier: verifier}
+
+	return env.changeAccount(changePasswordPath, change)
+}
+
+// HandleChangeLogin переименовывает аккаунт.
+func (env *ClientEnv) HandleChangeLogin(password string, newLogin string) (int, error) {
+	change, status, err := env.srpReauthenticate(password)
+	if err != nil || status != http.StatusOK {
+		return status, err
+	}
+	change.NewLogin = newLogin
+
+	status, err = env.changeAccount(changeLoginPath, change)
+	if err == nil && status == http.StatusOK {
+		env.login = strings.TrimSpace(newLogin)
+	}
+	return status, err
+}
+
+// HandleDeleteAccount удаляет аккаунт со всеми данными и завершает сессию на клиенте.
+func (env *ClientEnv) HandleDeleteAccount(password string) (int, error) {
+	change, status, err := env.srpReauthenticate(password)
+	if err != nil || status != http.StatusOK {
+		return status, err
+	}
+
+	status, err = env.changeAccount(deleteAccountPath, change)
+	if err == nil && status == http.StatusOK {
+		env.clearSession()
+	}
+	return status, err
+}
+
+func (env *ClientEnv) changeAccount(path string, change gophmodel.AccountChange) (int, error) {
+	body, err := json.Marshal(change)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := env.makeRequest(http.MethodPost, path, body, true)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	env.rememberRetryAfter(response)
+
+	return response.StatusCode, nil
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"encoding/json"
+	gophmodel "gophkeep/internal/model"
+	"gophkeep/internal/mtls"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// HandleIssueCertificate получает клиентский сертификат аккаунта и сохраняет его вместе с
+// ключом и сертификатом CA в каталоге настроек. Следующие запросы клиент отправляет с ним,
+// а после перезапуска входит без пароля. Возвращает путь к сертификату.
+func (env *ClientEnv) HandleIssueCertificate() (int, string, error) {
+	csrPEM, keyPEM, err := mtls.NewCSR(env.login)
+	if err != nil {
+		return 0, "", err
+	}
+
+	body, err := json.Marshal(gophmodel.CertificateRequest{CSR: string(csrPEM)})
+	if err != nil {
+		return 0, "", err
+	}
+
+	response, err := env.makeRequest(http.MethodPost, certificatesPath, body, true)
+	if err != nil {
+		return 0, "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusCreated {
+		return response.StatusCode, "", nil
+	}
+
+	var issued gophmodel.ClientCertificate
+	if err = json.NewDecoder(response.Body).Decode(&issued); err != nil {
+		return 0, "", err
+	}
+
+	dir, err := configDir()
+	if err != nil {
+		return 0, "", err
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return 0, "", err
+	}
+
+	config := env.config
+	config.CertFile = filepath.Join(dir, "client.crt")
+	config.KeyFile = filepath.Join(dir, "client.key")
+	if err = os.WriteFile(config.KeyFile, keyPEM, 0600); err != nil {
+		return 0, "", err
+	}
+	if err = os.WriteFile(config.CertFile, []byte(issued.Certificate), 0600); err != nil {
+		return 0, "", err
+	}
+	if len(config.CAFile) == 0 {
+		config.CAFile = filepath.Join(dir, "ca.crt")
+		if err = os.WriteFile(config.CAFile, []byte(issued.CA), 0600); err != nil {
+			return 0, "", err
+		}
+	}
+
+	client, err := newHTTPClient(config)
+	if err != nil {
+		return 0, "", err
+	}
+	if err = saveConfig(config); err != nil {
+		return 0, "", err
+	}
+	env.config = config
+	env.httpClient = client
+
+	return response.StatusCode, config.CertFile, nil
+}
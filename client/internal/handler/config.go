@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// ClientConfig - настройки клиента из config.json в каталоге настроек пользователя.
+// Переменные окружения GOPHKEEP_SERVER_URL, GOPHKEEP_CA_FILE, GOPHKEEP_CERT_FILE и
+// GOPHKEEP_KEY_FILE переопределяют значения из файла.
+type ClientConfig struct {
+	ServerURL string `json:"server_url"`
+	// CAFile - сертификат CA сервера, если сервер работает с -mtls
+	CAFile string `json:"ca_file,omitempty"`
+	// CertFile и KeyFile - клиентский сертификат и ключ, которыми клиент входит без пароля
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+}
+
+// configDir - каталог, в котором клиент хранит настройки между запусками.
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gophkeep"), nil
+}
+
+// LoadConfig читает настройки клиента. Если файла нет, используются значения по умолчанию.
+func LoadConfig() (ClientConfig, error) {
+	config := ClientConfig{ServerURL: baseURL}
+
+	dir, err := configDir()
+	if err != nil {
+		return config, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return config, err
+	}
+	if err == nil {
+		if err = json.Unmarshal(data, &config); err != nil {
+			return config, err
+		}
+	}
+
+	for name, value := range map[string]*string{
+		"GOPHKEEP_SERVER_URL": &config.ServerURL,
+		"GOPHKEEP_CA_FILE":    &config.CAFile,
+		"GOPHKEEP_CERT_FILE":  &config.CertFile,
+		"GOPHKEEP_KEY_FILE":   &config.KeyFile,
+	} {
+		if env := os.Getenv(name); env != "" {
+			*value = env
+		}
+	}
+
+	if len(config.ServerURL) == 0 {
+		config.ServerURL = baseURL
+	}
+	return config, nil
+}
+
+func saveConfig(config ClientConfig) error {
+	dir, err := configDir()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "config.json"), data, 0600)
+}
+
+// newHTTPClient создает HTTP-клиент, который доверяет CA сервера и предъявляет
+// клиентский сертификат из настроек.
+func newHTTPClient(config ClientConfig) (*http.Client, error) {
+	if len(config.CAFile) == 0 && len(config.CertFile) == 0 {
+		return &http.Client{}, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if len(config.CAFile) != 0 {
+		caPEM, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificates in " + config.CAFile)
+		}
+	}
+
+	if len(config.CertFile) != 0 {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}, nil
+}
+
+// HasCertificate сообщает, предъявляет ли клиент сертификат, с которым вход не нужен.
+func (env *ClientEnv) HasCertificate() bool {
+	return len(env.config.CertFile) != 0
+}
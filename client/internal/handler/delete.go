@@ -9,7 +9,6 @@ import (
 func (env *ClientEnv) HandleDelete(metadata gophmodel.Metadata) (int, error) {
 	deleteData := gophmodel.DataToDelete{
 		StaticID: metadata.StaticID,
-		UserID:   metadata.UserID,
 		DataType: metadata.DataType,
 	}
 
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	gophmodel "gophkeep/internal/model"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ClientVersion задается при сборке: -ldflags "-X gophkeep/client/internal/handler.ClientVersion=1.2.3".
+var ClientVersion = "dev"
+
+// deviceIDPath - файл, в котором клиент помнит id устройства между запусками.
+func deviceIDPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "device_id"), nil
+}
+
+// deviceInfo описывает устройство для входа. Без сохраненного id сервер зарегистрирует новое устройство.
+func (env *ClientEnv) deviceInfo() gophmodel.DeviceInfo {
+	name, err := os.Hostname()
+	if err != nil {
+		name = "unknown"
+	}
+
+	if len(env.deviceID) == 0 {
+		if path, err := deviceIDPath(); err == nil {
+			if data, err := os.ReadFile(path); err == nil {
+				env.deviceID = strings.TrimSpace(string(data))
+			}
+		}
+	}
+
+	return gophmodel.DeviceInfo{
+		ID:            env.deviceID,
+		Name:          name,
+		OS:            runtime.GOOS + "/" + runtime.GOARCH,
+		ClientVersion: ClientVersion,
+	}
+}
+
+// rememberDevice сохраняет id устройства из ответа на вход. Если сохранить не удалось,
+// при следующем запуске устройство зарегистрируется заново.
+func (env *ClientEnv) rememberDevice(response *http.Response) {
+	var session gophmodel.SessionInfo
+	if err := json.NewDecoder(response.Body).Decode(&session); err != nil {
+		return
+	}
+	env.saveDeviceID(session.DeviceID)
+}
+
+// saveDeviceID запоминает id устройства, выданный сервером.
+func (env *ClientEnv) saveDeviceID(deviceID string) {
+	if len(deviceID) == 0 || deviceID == env.deviceID {
+		return
+	}
+	env.deviceID = deviceID
+
+	path, err := deviceIDPath()
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	_ = os.WriteFile(path, []byte(deviceID), 0600)
+}
+
+// HandleDevices возвращает устройства аккаунта.
+func (env *ClientEnv) HandleDevices() (int, []gophmodel.Device, error) {
+	var devices []gophmodel.Device
+
+	response, err := env.makeRequest(http.MethodGet, devicesPath, nil, true)
+	if err != nil {
+		return 0, devices, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return response.StatusCode, devices, nil
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&devices)
+	return response.StatusCode, devices, err
+}
+
+// HandleRevokeDevice отзывает устройство и все его сессии.
+func (env *ClientEnv) HandleRevokeDevice(deviceID string) (int, error) {
+	body, err := json.Marshal(gophmodel.DeviceToRevoke{ID: deviceID})
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := env.makeRequest(http.MethodPost, revokeDevicePath, body, true)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	return response.StatusCode, nil
+}
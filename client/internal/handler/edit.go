@@ -8,17 +8,21 @@ import (
 )
 
 func (env *ClientEnv) HandleEdit(metadata gophmodel.Metadata, newMetadata gophmodel.SimpleMetadata, data []byte) (int, gophmodel.Metadata, error) {
+	var fullMetadata gophmodel.Metadata
+
+	data, err := env.sealData(data, recordOf(metadata))
+	if err != nil {
+		return 0, fullMetadata, err
+	}
+
 	editData := gophmodel.EditData{
 		StaticID:    metadata.StaticID,
-		UserID:      metadata.UserID,
 		Name:        metadata.Name,
 		Description: newMetadata.Description,
 		DataType:    metadata.DataType,
 		Data:        string(data),
 	}
 
-	var fullMetadata gophmodel.Metadata
-
 	body, err := json.Marshal(editData)
 	if err != nil {
 		return 0, fullMetadata, err
@@ -10,7 +10,6 @@ import (
 func (env *ClientEnv) HandleEditFile(metadata gophmodel.Metadata, newMetadata gophmodel.SimpleMetadata, filePath []byte) (int, gophmodel.Metadata, error) {
 	editData := gophmodel.EditData{
 		StaticID:    metadata.StaticID,
-		UserID:      metadata.UserID,
 		Name:        metadata.Name,
 		Description: newMetadata.Description,
 		DataType:    metadata.DataType,
@@ -23,7 +22,7 @@ func (env *ClientEnv) HandleEditFile(metadata gophmodel.Metadata, newMetadata go
 		return 0, fullMetadata, err
 	}
 
-	response, err := env.makeWriteFileRequest(editFilePath, string(filePath), bodyInfo)
+	response, err := env.makeWriteFileRequest(editFilePath, string(filePath), bodyInfo, recordOf(metadata))
 	if err != nil {
 		return 0, fullMetadata, err
 	}
@@ -3,44 +3,112 @@ package handler
 import (
 	"bytes"
 	"context"
+	"gophkeep/client/internal/vault"
+	"gophkeep/internal/auth"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 )
 
 type ClientEnv struct {
-	authCookie *http.Cookie
+	authCookie    *http.Cookie
+	refreshCookie *http.Cookie
+	// authExpires - когда истечет токен доступа, незадолго до этого клиент обновит сессию
+	authExpires time.Time
+	refreshMu   sync.Mutex
+	// mfaToken - токен ожидания второго фактора после верного пароля
+	mfaToken string
+	// login - логин аккаунта, в который выполнен вход, нужен для повторной проверки пароля
+	login string
+	// deviceID - id этого устройства, выданный сервером при первом входе
+	deviceID string
+	// retryAfter - сколько ждать после отказа 429 на попытку входа
+	retryAfter time.Duration
 	httpClient *http.Client
+	// config - адрес сервера и сертификаты TLS
+	config ClientConfig
+	// vaultKey - ключ шифрования на клиенте, пустой если режим не включен
+	vaultKey []byte
+	// oidc - начатый вход через провайдер, nil если его нет
+	oidc *oidcFlow
 }
 
 const (
-	TimeoutSeconds = 30
-	baseURL        = "http://localhost:8080"
-	loginPath      = "/api/user/login"
-	registerPath   = "/api/user/register"
-	deletePath     = "/api/delete"
-	editFilePath   = "/api/editfile"
-	editPath       = "/api/edit"
-	pingPath       = "/ping"
-	readFilePath   = "/api/readfile"
-	readPath       = "/api/read"
-	syncPath       = "/api/user/sync"
-	writeFilePath  = "/api/keepfile"
-	writePath      = "/api/keep"
+	TimeoutSeconds     = 30
+	baseURL            = "http://localhost:8080"
+	loginPath          = "/api/user/login"
+	logoutPath         = "/api/user/logout"
+	logoutAllPath      = "/api/user/logout-all"
+	otpPath            = "/api/user/login/otp"
+	srpStartPath       = "/api/user/login/srp/start"
+	srpVerifyPath      = "/api/user/login/srp/verify"
+	srpReauthPath      = "/api/user/reauth/srp"
+	oidcStartPath      = "/api/user/oidc/start"
+	oidcLinkStartPath  = "/api/user/oidc/link/start"
+	oidcFinishPath     = "/api/user/oidc/finish"
+	totpEnrollPath     = "/api/user/2fa/enroll"
+	totpConfirmPath    = "/api/user/2fa/confirm"
+	totpDisablePath    = "/api/user/2fa/disable"
+	refreshPath        = auth.RefreshPath
+	registerPath       = "/api/user/register"
+	registerModePath   = "/api/user/register/mode"
+	invitesPath        = "/api/user/invites"
+	changePasswordPath = "/api/user/change-password"
+	changeLoginPath    = "/api/user/change-login"
+	deleteAccountPath  = "/api/user/delete"
+	devicesPath        = "/api/user/devices"
+	certificatesPath   = "/api/user/certificates"
+	revokeDevicePath   = "/api/user/devices/revoke"
+	deletePath         = "/api/delete"
+	editFilePath       = "/api/editfile"
+	editPath           = "/api/edit"
+	pingPath           = "/ping"
+	readFilePath       = "/api/readfile"
+	readPath           = "/api/read"
+	syncPath           = "/api/user/sync"
+	vaultPath          = "/api/user/vault"
+	writeFilePath      = "/api/keepfile"
+	writePath          = "/api/keep"
 )
 
+// makeRequest отправляет запрос, а если токен доступа истек или отклонен, обновляет
+// сессию и повторяет запрос.
 func (env *ClientEnv) makeRequest(httpMethod string, requestPath string, body []byte, addAuthCookie bool) (*http.Response, error) {
+	if !addAuthCookie {
+		return env.sendRequest(httpMethod, requestPath, body, nil)
+	}
+
+	if err := env.refreshIfExpiring(); err != nil {
+		return nil, err
+	}
+
+	response, err := env.sendRequest(httpMethod, requestPath, body, env.authCookie)
+	if err != nil || response.StatusCode != http.StatusUnauthorized {
+		return response, err
+	}
+
+	refreshed, err := env.refreshSession()
+	if err != nil || !refreshed {
+		return response, nil
+	}
+	response.Body.Close()
+
+	return env.sendRequest(httpMethod, requestPath, body, env.authCookie)
+}
+
+func (env *ClientEnv) sendRequest(httpMethod string, requestPath string, body []byte, cookie *http.Cookie) (*http.Response, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*TimeoutSeconds)
 	defer cancel()
-	req, err := http.NewRequest(httpMethod, baseURL+requestPath, bytes.NewBuffer(body))
+	req, err := http.NewRequest(httpMethod, env.config.ServerURL+requestPath, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
 	req = req.WithContext(ctx)
-	if addAuthCookie {
-		req.AddCookie(env.authCookie)
+	if cookie != nil {
+		req.AddCookie(cookie)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -52,26 +120,55 @@ func (env *ClientEnv) makeRequest(httpMethod string, requestPath string, body []
 	return response, err
 }
 
-func (env *ClientEnv) makeWriteFileRequest(requestPath string, filepath string, bodyInfo []byte) (*http.Response, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*TimeoutSeconds)
-	defer cancel()
+// makeFileRequest не ограничивает запрос по времени: передача большого файла
+// может занять больше TimeoutSeconds, а тело ответа читается уже после возврата.
+// Поток файла нельзя отправить повторно, поэтому после обновления сессии запрос
+// повторяется, только если тело можно перемотать.
+func (env *ClientEnv) makeFileRequest(httpMethod string, requestPath string, body io.Reader, contentType string) (*http.Response, error) {
+	if err := env.refreshIfExpiring(); err != nil {
+		return nil, err
+	}
 
-	buf := &bytes.Buffer{}
-	writer := multipart.NewWriter(buf)
+	response, err := env.sendFileRequest(httpMethod, requestPath, body, contentType)
+	if err != nil || response.StatusCode != http.StatusUnauthorized {
+		return response, err
+	}
 
-	metaPart, err := writer.CreateFormField("metadata")
-	if err != nil {
-		return nil, err
+	seeker, ok := body.(io.Seeker)
+	if !ok {
+		return response, nil
 	}
 
-	metaPart.Write(bodyInfo)
+	refreshed, err := env.refreshSession()
+	if err != nil || !refreshed {
+		return response, nil
+	}
+	response.Body.Close()
 
-	s := fixFilePath(filepath)
+	if _, err = seeker.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
 
-	part, err := writer.CreateFormFile("file", s)
+	return env.sendFileRequest(httpMethod, requestPath, body, contentType)
+}
+
+func (env *ClientEnv) sendFileRequest(httpMethod string, requestPath string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequest(httpMethod, env.config.ServerURL+requestPath, body)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Content-Type", contentType)
+	if env.authCookie != nil {
+		req.AddCookie(env.authCookie)
+	}
+
+	return env.httpClient.Do(req)
+}
+
+// makeWriteFileRequest отправляет файл потоком: форма собирается в отдельной
+// горутине по мере чтения файла, поэтому файл не загружается в память целиком.
+func (env *ClientEnv) makeWriteFileRequest(requestPath string, filepath string, bodyInfo []byte, record vault.Record) (*http.Response, error) {
+	s := fixFilePath(filepath)
 
 	file, err := os.Open(s)
 	if err != nil {
@@ -79,29 +176,56 @@ func (env *ClientEnv) makeWriteFileRequest(requestPath string, filepath string,
 	}
 	defer file.Close()
 
-	_, err = io.Copy(part, file)
+	body, bodyWriter := io.Pipe()
+	writer := multipart.NewWriter(bodyWriter)
+
+	go func() {
+		bodyWriter.CloseWithError(env.writeFileForm(writer, bodyInfo, s, file, record))
+	}()
+
+	response, err := env.makeFileRequest(http.MethodPost, requestPath, body, writer.FormDataContentType())
 	if err != nil {
+		body.Close()
 		return nil, err
 	}
+	return response, err
+}
 
-	err = writer.Close()
+func (env *ClientEnv) writeFileForm(writer *multipart.Writer, bodyInfo []byte, fileName string, file io.Reader, record vault.Record) error {
+	metaPart, err := writer.CreateFormField("metadata")
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, baseURL+requestPath, buf)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	if err != nil {
-		return nil, err
+	if _, err = metaPart.Write(bodyInfo); err != nil {
+		return err
 	}
-	req = req.WithContext(ctx)
-	req.AddCookie(env.authCookie)
 
-	response, err := env.httpClient.Do(req)
+	part, err := writer.CreateFormFile("file", fileName)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return response, err
+
+	if env.vaultKey != nil {
+		stream, err := vault.NewStreamWriter(part, env.vaultKey, record)
+		if err != nil {
+			return err
+		}
+
+		if _, err = io.Copy(stream, file); err != nil {
+			return err
+		}
+
+		if err = stream.Close(); err != nil {
+			return err
+		}
+	} else {
+		if _, err = io.Copy(part, file); err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
 }
 
 func fixFilePath(filepath string) string {
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	gophmodel "gophkeep/internal/model"
+	"net/http"
+)
+
+// HandleRegistrationMode спрашивает у сервера режим регистрации: open, invite или closed.
+// Старые серверы режима не сообщают, для них регистрация открыта.
+func (env *ClientEnv) HandleRegistrationMode() (string, error) {
+	response, err := env.makeRequest(http.MethodGet, registerModePath, nil, false)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return "open", nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registration mode: unexpected status %d", response.StatusCode)
+	}
+
+	var mode gophmodel.RegistrationMode
+	if err = json.NewDecoder(response.Body).Decode(&mode); err != nil {
+		return "", err
+	}
+	return mode.Mode, nil
+}
+
+// HandleCreateInvite создает приглашение. Код приглашения сервер показывает только один раз.
+func (env *ClientEnv) HandleCreateInvite() (int, gophmodel.Invite, error) {
+	var invite gophmodel.Invite
+
+	response, err := env.makeRequest(http.MethodPost, invitesPath, nil, true)
+	if err != nil {
+		return 0, invite, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusCreated {
+		return response.StatusCode, invite, nil
+	}
+
+	if err = json.NewDecoder(response.Body).Decode(&invite); err != nil {
+		return 0, invite, err
+	}
+	return response.StatusCode, invite, nil
+}
@@ -3,10 +3,49 @@ package handler
 import (
 	"encoding/json"
 	gophmodel "gophkeep/internal/model"
+	"gophkeep/internal/srp"
 	"net/http"
 )
 
+// HandleLogin входит в аккаунт по SRP, не передавая пароль серверу. Если сервер ответил,
+// что у этого аккаунта нет верификатора, возвращается ErrPasswordLoginRequired: перейти
+// на SRP можно через HandleMigrateLogin после подтверждения пользователя.
 func (env *ClientEnv) HandleLogin(loginData gophmodel.SimpleAccountData) (int, error) {
+	device := env.deviceInfo()
+
+	attempt, status, err := env.srpProve(loginData.Login, loginData.Password)
+	if err != nil || status != http.StatusOK {
+		return status, err
+	}
+
+	attempt.proof.Device = &device
+	status, err = env.srpLogin(attempt.proof, attempt.expected)
+	if err == nil && (status == http.StatusOK || status == http.StatusAccepted) {
+		env.login = loginData.Login
+	}
+	return status, err
+}
+
+// HandleMigrateLogin один раз входит по паролю и передает верификатор SRP для следующих
+// входов. Вызывается только после ErrPasswordLoginRequired и согласия пользователя.
+func (env *ClientEnv) HandleMigrateLogin(loginData gophmodel.SimpleAccountData) (int, error) {
+	loginData.Device = env.deviceInfo()
+
+	status, err := env.passwordLogin(loginData)
+	if err == nil && (status == http.StatusOK || status == http.StatusAccepted) {
+		env.login = loginData.Login
+	}
+	return status, err
+}
+
+// passwordLogin входит по паролю и передает верификатор SRP для следующих входов.
+func (env *ClientEnv) passwordLogin(loginData gophmodel.SimpleAccountData) (int, error) {
+	salt, verifier, err := srp.NewVerifier(loginData.Password)
+	if err != nil {
+		return 0, err
+	}
+	loginData.SRP = &gophmodel.SRPVerifier{Salt: salt, Verifier: verifier}
+
 	body, err := json.Marshal(loginData)
 	if err != nil {
 		return 0, err
@@ -17,8 +56,19 @@ func (env *ClientEnv) HandleLogin(loginData gophmodel.SimpleAccountData) (int, e
 		return 0, err
 	}
 	defer response.Body.Close()
-	if len(response.Cookies()) != 0 {
-		env.authCookie = response.Cookies()[0]
+	env.rememberRetryAfter(response)
+	env.setSession(response)
+
+	// у аккаунта включена 2FA, сессия будет выдана после HandleOTP
+	if response.StatusCode == http.StatusAccepted {
+		var challenge gophmodel.MFAChallenge
+		if err = json.NewDecoder(response.Body).Decode(&challenge); err != nil {
+			return 0, err
+		}
+		env.mfaToken = challenge.MFAToken
+	}
+	if response.StatusCode == http.StatusOK {
+		env.rememberDevice(response)
 	}
 	return response.StatusCode, nil
 }
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	gophmodel "gophkeep/internal/model"
+	"gophkeep/internal/oidc"
+	"net"
+	"net/http"
+	"time"
+)
+
+// oidcCallbackWait - сколько после нажатия Enter ждать, пока браузер вернется от провайдера
+const oidcCallbackWait = time.Second * TimeoutSeconds
+
+var (
+	ErrOIDCNotStarted = errors.New("single sign-on was not started")
+	ErrOIDCTimeout    = errors.New("browser has not returned from the identity provider")
+)
+
+// oidcFlow - начатый вход через провайдер: локальный адрес, на который вернется браузер,
+// и секрет, без которого сервер не обменяет код из браузера на сессию.
+type oidcFlow struct {
+	server   *http.Server
+	verifier string
+	link     bool
+	result   chan oidcCallback
+}
+
+type oidcCallback struct {
+	code string
+	err  error
+}
+
+// StartOIDC начинает вход (или привязку, если link) через провайдер OpenID Connect и
+// возвращает адрес, который пользователь открывает в браузере. Браузер вернется на
+// 127.0.0.1 к этому клиенту.
+func (env *ClientEnv) StartOIDC(link bool) (int, string, error) {
+	env.stopOIDC()
+
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		return 0, "", err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, "", err
+	}
+
+	flow := &oidcFlow{verifier: verifier, link: link, result: make(chan oidcCallback, 1)}
+	flow.server = &http.Server{Handler: http.HandlerFunc(flow.callbackHandle), ReadHeaderTimeout: time.Second * 5}
+	go flow.server.Serve(listener)
+
+	start := gophmodel.OIDCStart{
+		RedirectURI:     fmt.Sprintf("http://%s/callback", listener.Addr()),
+		ClientChallenge: oidc.Challenge(verifier),
+	}
+	body, err := json.Marshal(start)
+	if err != nil {
+		flow.server.Close()
+		return 0, "", err
+	}
+
+	path := oidcStartPath
+	if link {
+		path = oidcLinkStartPath
+	}
+	response, err := env.makeRequest(http.MethodPost, path, body, link)
+	if err != nil {
+		flow.server.Close()
+		return 0, "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		flow.server.Close()
+		return response.StatusCode, "", nil
+	}
+
+	var authorization gophmodel.OIDCAuthorization
+	if err = json.NewDecoder(response.Body).Decode(&authorization); err != nil {
+		flow.server.Close()
+		return 0, "", err
+	}
+
+	env.oidc = flow
+	return response.StatusCode, authorization.AuthURL, nil
+}
+
+// FinishOIDC дожидается возвращения браузера и обменивает код на сессию. 202 значит, что
+// у аккаунта включена 2FA и вход завершается через HandleOTP.
+func (env *ClientEnv) FinishOIDC() (int, error) {
+	flow := env.oidc
+	if flow == nil {
+		return 0, ErrOIDCNotStarted
+	}
+	defer env.stopOIDC()
+
+	var callback oidcCallback
+	select {
+	case callback = <-flow.result:
+	case <-time.After(oidcCallbackWait):
+		return 0, ErrOIDCTimeout
+	}
+	if callback.err != nil {
+		return 0, callback.err
+	}
+
+	finish := gophmodel.OIDCFinish{Code: callback.code, ClientVerifier: flow.verifier}
+	if !flow.link {
+		device := env.deviceInfo()
+		finish.Device = &device
+	}
+	body, err := json.Marshal(finish)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := env.makeRequest(http.MethodPost, oidcFinishPath, body, false)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	env.rememberRetryAfter(response)
+
+	if flow.link {
+		return response.StatusCode, nil
+	}
+
+	env.setSession(response)
+	if response.StatusCode == http.StatusAccepted {
+		var challenge gophmodel.MFAChallenge
+		if err = json.NewDecoder(response.Body).Decode(&challenge); err != nil {
+			return 0, err
+		}
+		env.mfaToken = challenge.MFAToken
+	}
+	if response.StatusCode == http.StatusOK {
+		env.rememberDevice(response)
+	}
+	return response.StatusCode, nil
+}
+
+// stopOIDC закрывает локальный адрес брошенного или завершенного входа.
+func (env *ClientEnv) stopOIDC() {
+	if env.oidc != nil {
+		env.oidc.server.Close()
+		env.oidc = nil
+	}
+}
+
+// callbackHandle принимает браузер, вернувшийся от сервера. Учитывается только первый возврат.
+func (flow *oidcFlow) callbackHandle(res http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/callback" {
+		http.NotFound(res, req)
+		return
+	}
+
+	query := req.URL.Query()
+	callback := oidcCallback{code: query.Get("code")}
+	message := "Signed in. You can close this tab and return to the terminal."
+	if providerError := query.Get("error"); len(providerError) != 0 || len(callback.code) == 0 {
+		callback.err = fmt.Errorf("identity provider refused sign-in: %s", providerError)
+		message = "Sign-in failed. Return to the terminal and try again."
+	}
+
+	select {
+	case flow.result <- callback:
+	default:
+	}
+
+	res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(res, message)
+}
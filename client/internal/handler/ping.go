@@ -4,8 +4,20 @@ import (
 	"net/http"
 )
 
+// HandlePingServer загружает настройки клиента и проверяет, что сервер доступен.
 func (env *ClientEnv) HandlePingServer() (int, error) {
-	env.httpClient = &http.Client{}
+	config, err := LoadConfig()
+	if err != nil {
+		return 0, err
+	}
+
+	client, err := newHTTPClient(config)
+	if err != nil {
+		return 0, err
+	}
+	env.config = config
+	env.httpClient = client
+
 	response, err := env.makeRequest(http.MethodGet, pingPath, nil, false)
 	if err != nil {
 		return 0, err
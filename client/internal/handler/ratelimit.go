@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rememberRetryAfter сохраняет, через сколько сервер разрешит следующую попытку входа.
+func (env *ClientEnv) rememberRetryAfter(response *http.Response) {
+	env.retryAfter = 0
+	if response.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+
+	seconds, err := strconv.Atoi(response.Header.Get("Retry-After"))
+	if err == nil && seconds > 0 {
+		env.retryAfter = time.Duration(seconds) * time.Second
+	}
+}
+
+// RetryAfter возвращает время до следующей попытки после ответа 429.
+func (env *ClientEnv) RetryAfter() time.Duration {
+	return env.retryAfter
+}
@@ -8,39 +8,53 @@ import (
 	"net/http"
 )
 
-func (env ClientEnv) HandleRead(metadata gophmodel.Metadata) (int, []byte, error) {
+func (env *ClientEnv) HandleRead(metadata gophmodel.Metadata) (int, []byte, error) {
+	status, raw, err := env.readRecord(metadata)
+	if err != nil || status != http.StatusOK {
+		return status, nil, err
+	}
+
+	data, err := env.openData([]byte(raw), recordOf(metadata))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return status, data, nil
+}
+
+// readRecord читает данные записи в том виде, в котором их хранит сервер.
+func (env *ClientEnv) readRecord(metadata gophmodel.Metadata) (int, string, error) {
 	dataInfo := gophmodel.DataToRead{
 		StaticID: metadata.StaticID,
-		UserID:   metadata.UserID,
 		DataType: metadata.DataType,
 	}
 
 	body, err := json.Marshal(dataInfo)
 	if err != nil {
-		err = fmt.Errorf("error: %s with data: %s %s %s", err, dataInfo.StaticID, dataInfo.UserID, dataInfo.DataType)
-		return 0, nil, err
+		err = fmt.Errorf("error: %s with data: %s %s", err, dataInfo.StaticID, dataInfo.DataType)
+		return 0, "", err
 	}
 
 	response, err := env.makeRequest(http.MethodGet, readPath, body, true)
 	if err != nil {
-		return 0, nil, err
+		return 0, "", err
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		return response.StatusCode, nil, nil
+		return response.StatusCode, "", nil
 	}
 
 	var readData gophmodel.ReadResponse
 
 	bytes, err := io.ReadAll(response.Body)
 	if err != nil {
-		return 0, nil, err
+		return 0, "", err
 	}
 
 	if err = json.Unmarshal(bytes, &readData); err != nil {
-		return 0, nil, err
+		return 0, "", err
 	}
 
-	return response.StatusCode, []byte(readData.Data), nil
+	return response.StatusCode, readData.Data, nil
 }
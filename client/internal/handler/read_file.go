@@ -1,27 +1,20 @@
 package handler
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"gophkeep/client/internal/vault"
 	gophmodel "gophkeep/internal/model"
 	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 )
 
-func (env ClientEnv) HandleReadFile(metadata gophmodel.Metadata) (int, string, error) {
-	dataInfo := gophmodel.DataToRead{
-		StaticID: metadata.StaticID,
-		UserID:   metadata.UserID,
-		DataType: metadata.DataType,
-	}
-
-	body, err := json.Marshal(dataInfo)
-	if err != nil {
-		return 0, "", err
-	}
-
-	response, err := env.makeRequest(http.MethodGet, readPath, body, true)
+func (env *ClientEnv) HandleReadFile(metadata gophmodel.Metadata) (int, string, error) {
+	response, err := env.requestFile(metadata)
 	if err != nil {
 		return 0, "", err
 	}
@@ -31,40 +24,62 @@ func (env ClientEnv) HandleReadFile(metadata gophmodel.Metadata) (int, string, e
 		return response.StatusCode, "", nil
 	}
 
-	filePath, err := makeFile(response.Body)
+	filePath, err := env.makeFile(responseFileName(response, metadata), response.Body, recordOf(metadata))
 	if err != nil {
 		return 0, "", err
 	}
 	return response.StatusCode, filePath, err
 }
 
-func makeFile(respBody io.ReadCloser) (string, error) {
-	var fileData gophmodel.FileData
+// requestFile запрашивает содержимое файла, тело ответа закрывает вызывающий.
+func (env *ClientEnv) requestFile(metadata gophmodel.Metadata) (*http.Response, error) {
+	dataInfo := gophmodel.DataToRead{
+		StaticID: metadata.StaticID,
+		DataType: metadata.DataType,
+	}
 
-	bytes, err := io.ReadAll(respBody)
+	body, err := json.Marshal(dataInfo)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	err = json.Unmarshal(bytes, &fileData)
+	return env.makeFileRequest(http.MethodGet, readFilePath, bytes.NewReader(body), "application/json")
+}
+
+// responseFileName - имя файла из ответа сервера или имя записи.
+func responseFileName(response *http.Response, metadata gophmodel.Metadata) string {
+	_, params, err := mime.ParseMediaType(response.Header.Get("Content-Disposition"))
+	if err == nil && len(params["filename"]) != 0 {
+		return filepath.Base(params["filename"])
+	}
+	return metadata.Name
+}
+
+// makeFile пишет содержимое файла на диск по мере получения, расшифровывая его
+// ключом хранилища, если файл был зашифрован на клиенте.
+func (env *ClientEnv) makeFile(fileName string, respBody io.Reader, record vault.Record) (string, error) {
+	content, err := env.openFileContent(bufio.NewReader(respBody), record)
 	if err != nil {
 		return "", err
 	}
 
-	filePath := "/tmp/" + fileData.Name
+	filePath := "/tmp/" + fileName
 
 	err = os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
 	if err != nil {
 		return "", err
 	}
 
-	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0666)
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return "", err
 	}
 
-	_, err = file.Write([]byte(fileData.Data))
+	_, err = io.Copy(file, content)
 	if err != nil {
+		file.Close()
+		// не оставляем на диске обрезанный или поддельный файл
+		os.Remove(filePath)
 		return "", err
 	}
 
@@ -80,3 +95,36 @@ func makeFile(respBody io.ReadCloser) (string, error) {
 	filePath = filepath.Join(path, filePath)
 	return filePath, nil
 }
+
+// openFileContent выбирает способ расшифровки по префиксу содержимого. Файлы,
+// зашифрованные на клиенте до потокового формата, расшифровываются целиком. Пока
+// хранилище открыто, открытое содержимое отклоняется.
+func (env *ClientEnv) openFileContent(content *bufio.Reader, record vault.Record) (io.Reader, error) {
+	prefix, _ := content.Peek(len(gophmodel.VaultRecordStreamPrefix))
+	if gophmodel.IsVaultStream(string(prefix)) {
+		if env.vaultKey == nil {
+			return nil, vault.ErrWrongPassword
+		}
+		return vault.NewStreamReader(content, env.vaultKey, record)
+	}
+
+	prefix, _ = content.Peek(len(gophmodel.VaultCiphertextPrefix))
+	if !vault.IsEncrypted(string(prefix)) {
+		if env.vaultKey != nil {
+			return nil, vault.ErrNotEncrypted
+		}
+		return content, nil
+	}
+
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = env.openData(data, record)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(data), nil
+}
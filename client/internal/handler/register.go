@@ -3,10 +3,20 @@ package handler
 import (
 	"encoding/json"
 	gophmodel "gophkeep/internal/model"
+	"gophkeep/internal/srp"
 	"net/http"
 )
 
+// HandleRegister создает аккаунт. Сервер получает только верификатор SRP, но не пароль.
 func (env *ClientEnv) HandleRegister(registerData gophmodel.SimpleAccountData) (int, error) {
+	salt, verifier, err := srp.NewVerifier(registerData.Password)
+	if err != nil {
+		return 0, err
+	}
+	registerData.Password = ""
+	registerData.SRP = &gophmodel.SRPVerifier{Salt: salt, Verifier: verifier}
+	registerData.Device = env.deviceInfo()
+
 	body, err := json.Marshal(registerData)
 	if err != nil {
 		return 0, err
@@ -17,8 +27,11 @@ func (env *ClientEnv) HandleRegister(registerData gophmodel.SimpleAccountData) (
 		return 0, err
 	}
 	defer response.Body.Close()
-	if len(response.Cookies()) != 0 {
-		env.authCookie = response.Cookies()[0]
+	env.rememberRetryAfter(response)
+	env.setSession(response)
+	if response.StatusCode == http.StatusOK {
+		env.login = registerData.Login
+		env.rememberDevice(response)
 	}
 	return response.StatusCode, nil
 }
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"fmt"
+	"gophkeep/internal/auth"
+	"net/http"
+	"time"
+)
+
+// refreshMargin - за сколько до истечения токена доступа клиент обновляет сессию.
+const refreshMargin = time.Second * 30
+
+// setSession запоминает токены сессии из ответа сервера. Кука с отрицательным
+// MaxAge означает, что сервер завершил сессию.
+func (env *ClientEnv) setSession(response *http.Response) {
+	for _, cookie := range response.Cookies() {
+		switch cookie.Name {
+		case auth.AccessCookieName:
+			if cookie.MaxAge < 0 {
+				env.authCookie = nil
+				continue
+			}
+			env.authCookie = cookie
+			env.authExpires = time.Now().Add(time.Duration(cookie.MaxAge) * time.Second)
+		case auth.RefreshCookieName:
+			if cookie.MaxAge < 0 {
+				env.refreshCookie = nil
+				continue
+			}
+			env.refreshCookie = cookie
+		}
+	}
+}
+
+func (env *ClientEnv) clearSession() {
+	env.authCookie = nil
+	env.refreshCookie = nil
+	env.authExpires = time.Time{}
+	env.vaultKey = nil
+}
+
+// refreshIfExpiring заранее обновляет сессию, если токен доступа скоро истечет.
+func (env *ClientEnv) refreshIfExpiring() error {
+	if env.authCookie == nil || env.authExpires.IsZero() || time.Until(env.authExpires) > refreshMargin {
+		return nil
+	}
+
+	_, err := env.refreshSession()
+	return err
+}
+
+// refreshSession обменивает токен обновления на новую пару токенов. Возвращает false,
+// если сессия отозвана или истекла и нужно войти заново.
+func (env *ClientEnv) refreshSession() (bool, error) {
+	// старый токен обновления после обмена считается украденным, поэтому обмены не должны пересекаться
+	env.refreshMu.Lock()
+	defer env.refreshMu.Unlock()
+
+	if env.refreshCookie == nil {
+		return false, nil
+	}
+
+	response, err := env.sendRequest(http.MethodPost, refreshPath, nil, env.refreshCookie)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		env.setSession(response)
+		return true, nil
+	case http.StatusUnauthorized:
+		env.clearSession()
+		return false, nil
+	default:
+		return false, fmt.Errorf("could not refresh session, unexpected status: %d", response.StatusCode)
+	}
+}
+
+// HandleLogout завершает текущую сессию, а если all - все сессии аккаунта.
+// Локальные токены и ключ хранилища удаляются в любом случае.
+func (env *ClientEnv) HandleLogout(all bool) (int, error) {
+	defer env.clearSession()
+
+	requestPath := logoutPath
+	if all {
+		requestPath = logoutAllPath
+	}
+
+	response, err := env.makeRequest(http.MethodPost, requestPath, nil, true)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	return response.StatusCode, nil
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	gophmodel "gophkeep/internal/model"
+	"gophkeep/internal/srp"
+	"net/http"
+)
+
+// ErrServerProof - сервер не доказал, что знает верификатор пароля. Так отвечает
+// подменный сервер или прокси, ответу верить нельзя.
+var ErrServerProof = errors.New("server failed to prove knowledge of the password verifier")
+
+// ErrPasswordLoginRequired - у аккаунта нет верификатора SRP, войти можно только паролем,
+// который увидит сервер. Пароль отправляется лишь после явного согласия пользователя.
+var ErrPasswordLoginRequired = errors.New("account has no SRP verifier yet, the password has to be sent to the server once")
+
+// srpAttempt - начатое рукопожатие SRP: доказательство клиента и ожидаемое доказательство сервера.
+type srpAttempt struct {
+	proof    gophmodel.SRPProof
+	expected []byte
+}
+
+// srpProve выполняет первый шаг SRP и вычисляет доказательство клиента и ожидаемое
+// доказательство сервера. Если сервер не начал рукопожатие, возвращается его статус.
+func (env *ClientEnv) srpProve(login string, password string) (srpAttempt, int, error) {
+	var attempt srpAttempt
+
+	private, public, err := srp.ClientStart()
+	if err != nil {
+		return attempt, 0, err
+	}
+
+	body, err := json.Marshal(gophmodel.SRPStart{Login: login, ClientPublic: public})
+	if err != nil {
+		return attempt, 0, err
+	}
+
+	response, err := env.makeRequest(http.MethodPost, srpStartPath, body, false)
+	if err != nil {
+		return attempt, 0, err
+	}
+	defer response.Body.Close()
+	env.rememberRetryAfter(response)
+
+	if response.StatusCode != http.StatusOK {
+		return attempt, response.StatusCode, nil
+	}
+
+	var challenge gophmodel.SRPChallenge
+	if err = json.NewDecoder(response.Body).Decode(&challenge); err != nil {
+		return attempt, 0, err
+	}
+
+	clientProof, serverProof, err := srp.ClientProof(login, password, challenge.Salt, private, public, challenge.ServerPublic)
+	if err != nil {
+		return attempt, 0, err
+	}
+
+	attempt.proof = gophmodel.SRPProof{HandshakeID: challenge.HandshakeID, Proof: clientProof}
+	attempt.expected = serverProof
+	return attempt, http.StatusOK, nil
+}
+
+// srpLogin завершает вход по SRP. Сессия принимается, только если сервер прислал верное
+// доказательство M2.
+func (env *ClientEnv) srpLogin(proof gophmodel.SRPProof, expected []byte) (int, error) {
+	body, err := json.Marshal(proof)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := env.makeRequest(http.MethodPost, srpVerifyPath, body, false)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	env.rememberRetryAfter(response)
+
+	switch response.StatusCode {
+	case http.StatusUnauthorized:
+		if passwordLoginRequired(response) {
+			return response.StatusCode, ErrPasswordLoginRequired
+		}
+	case http.StatusOK:
+		var session gophmodel.SessionInfo
+		if err = json.NewDecoder(response.Body).Decode(&session); err != nil {
+			return 0, err
+		}
+		if !serverProofValid(session.ServerProof, expected) {
+			return 0, ErrServerProof
+		}
+		env.setSession(response)
+		env.saveDeviceID(session.DeviceID)
+	// у аккаунта включена 2FA, сессия будет выдана после HandleOTP
+	case http.StatusAccepted:
+		var challenge gophmodel.MFAChallenge
+		if err = json.NewDecoder(response.Body).Decode(&challenge); err != nil {
+			return 0, err
+		}
+		if !serverProofValid(challenge.ServerProof, expected) {
+			return 0, ErrServerProof
+		}
+		env.mfaToken = challenge.MFAToken
+	}
+
+	return response.StatusCode, nil
+}
+
+// srpReauthenticate повторно проверяет пароль текущего аккаунта. Пароль серверу не
+// передается: аккаунт без верификатора получает ErrPasswordLoginRequired и переходит
+// на SRP при следующем входе.
+func (env *ClientEnv) srpReauthenticate(password string) (gophmodel.AccountChange, int, error) {
+	attempt, status, err := env.srpProve(env.login, password)
+	if err != nil {
+		return gophmodel.AccountChange{}, 0, err
+	}
+	if status != http.StatusOK {
+		return gophmodel.AccountChange{}, status, nil
+	}
+
+	body, err := json.Marshal(attempt.proof)
+	if err != nil {
+		return gophmodel.AccountChange{}, 0, err
+	}
+
+	response, err := env.makeRequest(http.MethodPost, srpReauthPath, body, true)
+	if err != nil {
+		return gophmodel.AccountChange{}, 0, err
+	}
+	defer response.Body.Close()
+	env.rememberRetryAfter(response)
+
+	if response.StatusCode == http.StatusForbidden && passwordLoginRequired(response) {
+		return gophmodel.AccountChange{}, response.StatusCode, ErrPasswordLoginRequired
+	}
+	if response.StatusCode != http.StatusOK {
+		return gophmodel.AccountChange{}, response.StatusCode, nil
+	}
+
+	var reauth gophmodel.ReauthInfo
+	if err = json.NewDecoder(response.Body).Decode(&reauth); err != nil {
+		return gophmodel.AccountChange{}, 0, err
+	}
+	if !serverProofValid(reauth.ServerProof, attempt.expected) {
+		return gophmodel.AccountChange{}, 0, ErrServerProof
+	}
+
+	return gophmodel.AccountChange{ReauthToken: reauth.ReauthToken}, http.StatusOK, nil
+}
+
+// passwordLoginRequired сообщает, что сервер отклонил рукопожатие, потому что у аккаунта
+// нет верификатора.
+func passwordLoginRequired(response *http.Response) bool {
+	var failure gophmodel.SRPFailure
+	if err := json.NewDecoder(response.Body).Decode(&failure); err != nil {
+		return false
+	}
+	return failure.PasswordLogin
+}
+
+func serverProofValid(proof []byte, expected []byte) bool {
+	return len(proof) != 0 && subtle.ConstantTimeCompare(proof, expected) == 1
+}
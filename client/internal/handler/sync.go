@@ -8,7 +8,7 @@ import (
 	"net/http"
 )
 
-func (env ClientEnv) HandleSync() (int, []gophmodel.Metadata, error) {
+func (env *ClientEnv) HandleSync() (int, []gophmodel.Metadata, error) {
 	response, err := env.makeRequest(http.MethodGet, syncPath, nil, true)
 	if err != nil {
 		return 0, nil, err
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"encoding/json"
+	gophmodel "gophkeep/internal/model"
+	"net/http"
+	"strings"
+)
+
+// otpData отличает код TOTP из шести цифр от кода восстановления.
+func otpData(code string) gophmodel.OTPData {
+	code = strings.TrimSpace(code)
+	if len(code) == 6 && strings.Trim(code, "0123456789") == "" {
+		return gophmodel.OTPData{Code: code}
+	}
+	return gophmodel.OTPData{RecoveryCode: code}
+}
+
+// HandleOTP завершает вход вторым фактором: кодом TOTP или кодом восстановления.
+func (env *ClientEnv) HandleOTP(code string) (int, error) {
+	otp := otpData(code)
+	otp.MFAToken = env.mfaToken
+	device := env.deviceInfo()
+	otp.Device = &device
+
+	body, err := json.Marshal(otp)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := env.makeRequest(http.MethodPost, otpPath, body, false)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	env.rememberRetryAfter(response)
+
+	if response.StatusCode == http.StatusOK {
+		env.mfaToken = ""
+		env.setSession(response)
+		env.rememberDevice(response)
+	}
+	return response.StatusCode, nil
+}
+
+// HandleEnrollTOTP запрашивает новый секрет TOTP для приложения-аутентификатора.
+func (env *ClientEnv) HandleEnrollTOTP() (int, gophmodel.TOTPEnrollment, error) {
+	var enrollment gophmodel.TOTPEnrollment
+
+	response, err := env.makeRequest(http.MethodPost, totpEnrollPath, nil, true)
+	if err != nil {
+		return 0, enrollment, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusOK {
+		if err = json.NewDecoder(response.Body).Decode(&enrollment); err != nil {
+			return 0, enrollment, err
+		}
+	}
+	return response.StatusCode, enrollment, nil
+}
+
+// HandleConfirmTOTP включает 2FA кодом из приложения и возвращает коды восстановления.
+func (env *ClientEnv) HandleConfirmTOTP(code string) (int, []string, error) {
+	body, err := json.Marshal(gophmodel.OTPData{Code: strings.TrimSpace(code)})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	response, err := env.makeRequest(http.MethodPost, totpConfirmPath, body, true)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer response.Body.Close()
+
+	var codes gophmodel.RecoveryCodes
+	if response.StatusCode == http.StatusOK {
+		if err = json.NewDecoder(response.Body).Decode(&codes); err != nil {
+			return 0, nil, err
+		}
+	}
+	return response.StatusCode, codes.Codes, nil
+}
+
+// HandleDisableTOTP отключает 2FA кодом TOTP или кодом восстановления,
+// подтвердив его паролем аккаунта.
+func (env *ClientEnv) HandleDisableTOTP(password string, code string) (int, error) {
+	change, status, err := env.srpReauthenticate(password)
+	if err != nil || status != http.StatusOK {
+		return status, err
+	}
+
+	otp := otpData(code)
+	otp.Password = change.Password
+	otp.ReauthToken = change.ReauthToken
+
+	body, err := json.Marshal(otp)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := env.makeRequest(http.MethodPost, totpDisablePath, body, true)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	env.rememberRetryAfter(response)
+
+	return response.StatusCode, nil
+}
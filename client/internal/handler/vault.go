@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"encoding/json"
+	"gophkeep/client/internal/vault"
+	gophmodel "gophkeep/internal/model"
+	"io"
+	"net/http"
+)
+
+// HandleGetVault запрашивает параметры ключа хранилища. Если шифрование на клиенте
+// не включено, сервер отвечает 204 и параметры пустые.
+func (env *ClientEnv) HandleGetVault() (int, gophmodel.VaultParams, error) {
+	var params gophmodel.VaultParams
+
+	response, err := env.makeRequest(http.MethodGet, vaultPath, nil, true)
+	if err != nil {
+		return 0, params, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusOK {
+		bytes, err := io.ReadAll(response.Body)
+		if err != nil {
+			return 0, params, err
+		}
+
+		if err = json.Unmarshal(bytes, &params); err != nil {
+			return 0, params, err
+		}
+	}
+	return response.StatusCode, params, nil
+}
+
+// HandleEnableVault включает шифрование на клиенте с ключом из мастер-пароля.
+func (env *ClientEnv) HandleEnableVault(password string) (int, error) {
+	params, key, err := vault.NewParams(password)
+	if err != nil {
+		return 0, err
+	}
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := env.makeRequest(http.MethodPost, vaultPath, body, true)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusOK {
+		env.vaultKey = key
+	}
+	return response.StatusCode, nil
+}
+
+// UnlockVault выводит ключ хранилища из мастер-пароля и запоминает его до конца сессии.
+func (env *ClientEnv) UnlockVault(password string, params gophmodel.VaultParams) error {
+	key, err := vault.Unlock(password, params)
+	if err != nil {
+		return err
+	}
+
+	env.vaultKey = key
+	return nil
+}
+
+func (env *ClientEnv) VaultUnlocked() bool {
+	return env.vaultKey != nil
+}
+
+// sealData шифрует данные записи ключом хранилища, если шифрование на клиенте включено.
+func (env *ClientEnv) sealData(data []byte, record vault.Record) ([]byte, error) {
+	if env.vaultKey == nil {
+		return data, nil
+	}
+
+	ciphertext, err := vault.EncryptRecord(env.vaultKey, record, data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(ciphertext), nil
+}
+
+// openData расшифровывает данные записи ключом хранилища. Пока хранилище открыто, данные
+// без шифртекста хранилища отклоняются: иначе сервер мог бы подложить открытую запись.
+func (env *ClientEnv) openData(data []byte, record vault.Record) ([]byte, error) {
+	if env.vaultKey == nil {
+		if vault.IsEncrypted(string(data)) {
+			return nil, vault.ErrWrongPassword
+		}
+		return data, nil
+	}
+
+	return vault.DecryptRecord(env.vaultKey, record, string(data))
+}
+
+// recordOf - запись, к которой привязан шифртекст хранилища.
+func recordOf(metadata gophmodel.Metadata) vault.Record {
+	return vault.Record{StaticID: metadata.StaticID, DataType: metadata.DataType}
+}
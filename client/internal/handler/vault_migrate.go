@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"gophkeep/client/internal/vault"
+	gophmodel "gophkeep/internal/model"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MigrateVault перешифровывает ключом хранилища записи, сохраненные до его включения
+// открытыми или в формате без привязки к записи, и возвращает их количество. Вызывается
+// при включении хранилища и повторяется командой, если проход прервался.
+func (env *ClientEnv) MigrateVault() (int, error) {
+	if env.vaultKey == nil {
+		return 0, vault.ErrWrongPassword
+	}
+
+	status, records, err := env.HandleSync()
+	if err != nil {
+		return 0, err
+	}
+	if status != http.StatusOK && status != http.StatusNoContent {
+		return 0, fmt.Errorf("could not list records, status %d", status)
+	}
+
+	migrated := 0
+	for _, metadata := range records {
+		var done bool
+		if metadata.DataType == "files" {
+			done, err = env.migrateFile(metadata)
+		} else {
+			done, err = env.migrateRecord(metadata)
+		}
+		if err != nil {
+			return migrated, fmt.Errorf("record %s: %w", metadata.Name, err)
+		}
+		if done {
+			migrated++
+		}
+	}
+
+	return migrated, nil
+}
+
+func (env *ClientEnv) migrateRecord(metadata gophmodel.Metadata) (bool, error) {
+	status, raw, err := env.readRecord(metadata)
+	if err != nil {
+		return false, err
+	}
+	if status != http.StatusOK {
+		return false, fmt.Errorf("read: unexpected status %d", status)
+	}
+
+	if strings.HasPrefix(raw, gophmodel.VaultRecordPrefix) {
+		return false, nil
+	}
+
+	data := []byte(raw)
+	if vault.IsEncrypted(raw) {
+		if data, err = vault.Decrypt(env.vaultKey, raw); err != nil {
+			return false, err
+		}
+	}
+
+	status, _, err = env.HandleEdit(metadata, gophmodel.SimpleMetadata{Description: metadata.Description}, data)
+	if err != nil {
+		return false, err
+	}
+	if status != http.StatusOK {
+		return false, fmt.Errorf("edit: unexpected status %d", status)
+	}
+
+	return true, nil
+}
+
+// migrateFile расшифровывает файл во временный каталог и загружает его заново. Имя
+// файла сохраняется.
+func (env *ClientEnv) migrateFile(metadata gophmodel.Metadata) (bool, error) {
+	response, err := env.requestFile(metadata)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("read: unexpected status %d", response.StatusCode)
+	}
+
+	content := bufio.NewReader(response.Body)
+	prefix, _ := content.Peek(len(gophmodel.VaultRecordStreamPrefix))
+	if string(prefix) == gophmodel.VaultRecordStreamPrefix {
+		return false, nil
+	}
+
+	var plaintext io.Reader = content
+	switch {
+	case string(prefix) == gophmodel.VaultStreamPrefix:
+		if plaintext, err = vault.NewLegacyStreamReader(content, env.vaultKey); err != nil {
+			return false, err
+		}
+	case vault.IsEncrypted(string(prefix)):
+		data, err := io.ReadAll(content)
+		if err != nil {
+			return false, err
+		}
+		if data, err = vault.Decrypt(env.vaultKey, string(data)); err != nil {
+			return false, err
+		}
+		plaintext = bytes.NewReader(data)
+	}
+
+	dir, err := os.MkdirTemp("", "gophkeep-migrate-")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, responseFileName(response, metadata))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return false, err
+	}
+	if _, err = io.Copy(file, plaintext); err != nil {
+		file.Close()
+		return false, err
+	}
+	if err = file.Close(); err != nil {
+		return false, err
+	}
+	response.Body.Close()
+
+	status, _, err := env.HandleEditFile(metadata, gophmodel.SimpleMetadata{Description: metadata.Description}, []byte(path))
+	if err != nil {
+		return false, err
+	}
+	if status != http.StatusOK {
+		return false, fmt.Errorf("edit: unexpected status %d", status)
+	}
+
+	return true, nil
+}
+
+// NeedsVaultMigration сообщает, что запись не прочитана, потому что сохранена до
+// включения хранилища или в старом формате, и её исправит MigrateVault.
+func NeedsVaultMigration(err error) bool {
+	return errors.Is(err, vault.ErrUnbound) || errors.Is(err, vault.ErrNotEncrypted)
+}
@@ -2,22 +2,34 @@ package handler
 
 import (
 	"encoding/json"
+	"gophkeep/client/internal/vault"
 	"gophkeep/internal/logger"
 	gophmodel "gophkeep/internal/model"
 	"io"
 	"net/http"
+
+	"github.com/google/uuid"
 )
 
 func (env *ClientEnv) HandleWrite(metadata gophmodel.SimpleMetadata, data []byte) (int, gophmodel.Metadata, error) {
+	var fullMetadata gophmodel.Metadata
+
+	// id записи выбирает клиент, чтобы привязать к нему шифртекст хранилища
+	record := vault.Record{StaticID: uuid.New().String(), DataType: metadata.DataType}
+
+	data, err := env.sealData(data, record)
+	if err != nil {
+		return 0, fullMetadata, err
+	}
+
 	initialData := gophmodel.InitialData{
 		Name:        metadata.Name,
 		Description: metadata.Description,
 		DataType:    metadata.DataType,
 		Data:        string(data),
+		StaticID:    record.StaticID,
 	}
 
-	var fullMetadata gophmodel.Metadata
-
 	body, err := json.Marshal(initialData)
 	if err != nil {
 		return 0, fullMetadata, err
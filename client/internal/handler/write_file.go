@@ -2,17 +2,22 @@ package handler
 
 import (
 	"encoding/json"
+	"gophkeep/client/internal/vault"
 	"gophkeep/internal/logger"
 	gophmodel "gophkeep/internal/model"
 	"io"
 	"net/http"
+
+	"github.com/google/uuid"
 )
 
 func (env *ClientEnv) HandleWriteFile(metadata gophmodel.SimpleMetadata, filePath []byte) (int, gophmodel.Metadata, error) {
+	record := vault.Record{StaticID: uuid.New().String(), DataType: metadata.DataType}
 	initialData := gophmodel.InitialData{
 		Name:        metadata.Name,
 		Description: metadata.Description,
 		DataType:    metadata.DataType,
+		StaticID:    record.StaticID,
 	}
 
 	var fullMetadata gophmodel.Metadata
@@ -22,7 +27,7 @@ func (env *ClientEnv) HandleWriteFile(metadata gophmodel.SimpleMetadata, filePat
 		return 0, fullMetadata, err
 	}
 
-	response, err := env.makeWriteFileRequest(writeFilePath, string(filePath), bodyInfo)
+	response, err := env.makeWriteFileRequest(writeFilePath, string(filePath), bodyInfo, record)
 	if err != nil {
 		return 0, fullMetadata, err
 	}
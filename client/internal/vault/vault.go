@@ -0,0 +1,211 @@
+// Package vault шифрует данные на клиенте ключом, выведенным из мастер-пароля,
+// так что сервер хранит и возвращает только непрозрачный шифртекст.
+package vault
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+
+	"gophkeep/internal/encryption"
+	gophmodel "gophkeep/internal/model"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	kdfArgon2id  = "argon2id"
+	saltLength   = 16
+	keyLength    = chacha20poly1305.KeySize
+	checkMessage = "gophkeep vault check"
+)
+
+var (
+	ErrWrongPassword  = errors.New("wrong master password")
+	ErrNotEncrypted   = errors.New("data is not vault ciphertext")
+	ErrUnsupportedKDF = errors.New("unsupported key derivation function")
+	// ErrUnbound - шифртекст хранилища старого формата, не привязанный к записи.
+	ErrUnbound = errors.New("vault ciphertext is not bound to its record")
+)
+
+// NewParams создает параметры Argon2id со случайной солью и проверочное значение,
+// по которому другое устройство убедится, что мастер-пароль введен верно.
+func NewParams(password string) (gophmodel.VaultParams, []byte, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return gophmodel.VaultParams{}, nil, err
+	}
+
+	params := gophmodel.VaultParams{
+		KDF:     kdfArgon2id,
+		Salt:    base64.StdEncoding.EncodeToString(salt),
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 4,
+	}
+
+	key, err := DeriveKey(password, params)
+	if err != nil {
+		return gophmodel.VaultParams{}, nil, err
+	}
+
+	check, err := Encrypt(key, []byte(checkMessage))
+	if err != nil {
+		return gophmodel.VaultParams{}, nil, err
+	}
+	params.Check = check
+
+	return params, key, nil
+}
+
+// Unlock выводит ключ хранилища и сверяет его с проверочным значением.
+func Unlock(password string, params gophmodel.VaultParams) ([]byte, error) {
+	key, err := DeriveKey(password, params)
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := Decrypt(key, params.Check)
+	if err != nil || subtle.ConstantTimeCompare(check, []byte(checkMessage)) != 1 {
+		return nil, ErrWrongPassword
+	}
+
+	return key, nil
+}
+
+func DeriveKey(password string, params gophmodel.VaultParams) ([]byte, error) {
+	if params.KDF != kdfArgon2id {
+		return nil, ErrUnsupportedKDF
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(params.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, keyLength), nil
+}
+
+// Record - запись, к которой привязывается шифртекст хранилища. Сервер не может выдать
+// шифртекст одной записи или вида данных за другой.
+type Record struct {
+	StaticID string
+	DataType string
+}
+
+func (record Record) aad(prefix string) []byte {
+	return []byte(prefix + record.DataType + "\x00" + record.StaticID)
+}
+
+// Encrypt шифрует данные XChaCha20-Poly1305 без привязки к записи. Так шифруется
+// проверочное значение хранилища.
+func Encrypt(key []byte, plaintext []byte) (string, error) {
+	return seal(key, gophmodel.VaultCiphertextPrefix, []byte(gophmodel.VaultCiphertextPrefix), plaintext)
+}
+
+// EncryptRecord шифрует данные записи с привязкой к её id и виду.
+func EncryptRecord(key []byte, record Record, plaintext []byte) (string, error) {
+	return seal(key, gophmodel.VaultRecordPrefix, record.aad(gophmodel.VaultRecordPrefix), plaintext)
+}
+
+// Decrypt расшифровывает данные без привязки к записи: проверочное значение и записи,
+// сохраненные до EncryptRecord.
+func Decrypt(key []byte, ciphertext string) ([]byte, error) {
+	if !strings.HasPrefix(ciphertext, gophmodel.VaultCiphertextPrefix) {
+		return nil, ErrNotEncrypted
+	}
+
+	return open(key, strings.TrimPrefix(ciphertext, gophmodel.VaultCiphertextPrefix), []byte(gophmodel.VaultCiphertextPrefix))
+}
+
+// DecryptRecord расшифровывает данные записи. Шифртекст без привязки к записи
+// отклоняется с ErrUnbound.
+func DecryptRecord(key []byte, record Record, ciphertext string) ([]byte, error) {
+	if strings.HasPrefix(ciphertext, gophmodel.VaultCiphertextPrefix) {
+		return nil, ErrUnbound
+	}
+	if !strings.HasPrefix(ciphertext, gophmodel.VaultRecordPrefix) {
+		return nil, ErrNotEncrypted
+	}
+
+	return open(key, strings.TrimPrefix(ciphertext, gophmodel.VaultRecordPrefix), record.aad(gophmodel.VaultRecordPrefix))
+}
+
+func seal(key []byte, prefix string, aad []byte, plaintext []byte) (string, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, aad)
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func open(key []byte, encoded string, aad []byte) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrNotEncrypted
+	}
+
+	return aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], aad)
+}
+
+func IsEncrypted(data string) bool {
+	return gophmodel.IsVaultCiphertext(data)
+}
+
+// NewStreamWriter шифрует файл записи ключом хранилища кусками, не держа его в памяти.
+// Поток начинается с префикса формата, по которому его узнают сервер и клиент.
+func NewStreamWriter(w io.Writer, key []byte, record Record) (io.WriteCloser, error) {
+	if _, err := io.WriteString(w, gophmodel.VaultRecordStreamPrefix); err != nil {
+		return nil, err
+	}
+
+	return encryption.NewStreamWriter(w, key, record.aad(gophmodel.VaultRecordStreamPrefix))
+}
+
+// NewLegacyStreamReader расшифровывает поток формата без привязки к записи, чтобы
+// перешифровать его.
+func NewLegacyStreamReader(r io.Reader, key []byte) (io.Reader, error) {
+	prefix := make([]byte, len(gophmodel.VaultStreamPrefix))
+	if _, err := io.ReadFull(r, prefix); err != nil || string(prefix) != gophmodel.VaultStreamPrefix {
+		return nil, ErrNotEncrypted
+	}
+
+	return encryption.NewStreamReader(r, key, []byte(gophmodel.VaultStreamPrefix))
+}
+
+// NewStreamReader расшифровывает поток, записанный NewStreamWriter для той же записи.
+// Поток без привязки к записи отклоняется с ErrUnbound.
+func NewStreamReader(r io.Reader, key []byte, record Record) (io.Reader, error) {
+	prefix := make([]byte, len(gophmodel.VaultRecordStreamPrefix))
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, ErrNotEncrypted
+	}
+	if string(prefix) == gophmodel.VaultStreamPrefix {
+		return nil, ErrUnbound
+	}
+	if string(prefix) != gophmodel.VaultRecordStreamPrefix {
+		return nil, ErrNotEncrypted
+	}
+
+	return encryption.NewStreamReader(r, key, record.aad(gophmodel.VaultRecordStreamPrefix))
+}
@@ -2,15 +2,19 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	handler "gophkeep/client/internal/handler"
 	gophmodel "gophkeep/internal/model"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+	qrcode "github.com/skip2/go-qrcode"
 )
 
 type model struct {
@@ -24,6 +28,11 @@ type model struct {
 	OutputData *string
 
 	UserMetadata *[]gophmodel.Metadata
+	VaultParams  *gophmodel.VaultParams
+	TOTP         *totpState
+	Account      *accountState
+	SSO          *ssoState
+	Devices      *[]gophmodel.Device
 	TextInput    textinput.Model
 }
 
@@ -32,6 +41,25 @@ type stageState struct {
 	errorMessage string
 }
 
+// totpState - данные подключения 2FA между стадиями меню.
+type totpState struct {
+	Secret        string
+	QRCode        string
+	RecoveryCodes []string
+}
+
+// ssoState - начатый вход или привязка через провайдер единого входа.
+type ssoState struct {
+	AuthURL string
+	Link    bool
+}
+
+// accountState - выбранное изменение аккаунта между стадиями меню account.
+type accountState struct {
+	Action   string
+	NewValue string
+}
+
 type ui struct {
 	Width  int
 	Height int
@@ -50,6 +78,8 @@ type newData struct {
 	LoginAndPasswordData gophmodel.LoginAndPasswordData
 	CardData             gophmodel.CardData
 	FilePath             string
+	// InviteRequired - сервер регистрирует только по приглашениям
+	InviteRequired bool
 }
 
 func initialModel() model {
@@ -64,6 +94,11 @@ func initialModel() model {
 		stageState:   &stageState{},
 		ClientEnv:    &handler.ClientEnv{},
 		UserMetadata: &[]gophmodel.Metadata{},
+		VaultParams:  &gophmodel.VaultParams{},
+		TOTP:         &totpState{},
+		Account:      &accountState{},
+		SSO:          &ssoState{},
+		Devices:      &[]gophmodel.Device{},
 
 		TargetObject: &targetObject{},
 		OutputData:   &outputData,
@@ -104,14 +139,40 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m.updateLoginRegisterInputs(msg, cmd)
 	case "PasswordInput":
 		return m.updatePasswordInput(msg, cmd)
+	case "InviteInput":
+		return m.updateInviteInput(msg, cmd)
+	case "OTPInput":
+		return m.updateOTPInput(msg, cmd)
+	case "OIDCWait":
+		return m.updateOIDCWait(msg, cmd)
 	case "Auth":
 		m.updateAuth(cmd)
 		return m, cmd
 	case "AuthFailed":
 		return m.updateAuthFailed(cmd)
+	case "PasswordMigration":
+		return m.updatePasswordMigration(msg, cmd)
 	case "Sync":
 		m.updateSync(cmd)
 		return m, cmd
+	case "VaultUnlock":
+		return m.updateVaultUnlock(msg, cmd)
+	case "VaultSetup":
+		return m.updateVaultSetup(msg, cmd)
+	case "TOTPSetup":
+		return m.updateTOTPSetup(msg, cmd)
+	case "TOTPRecoveryCodes":
+		return m.updateTOTPRecoveryCodes(msg, cmd)
+	case "TOTPDisable":
+		return m.updateTOTPDisable(msg, cmd)
+	case "AccountMenu":
+		return m.updateAccountMenu(msg, cmd)
+	case "AccountNewValue":
+		return m.updateAccountNewValue(msg, cmd)
+	case "AccountPassword":
+		return m.updateAccountPassword(msg, cmd)
+	case "Devices":
+		return m.updateDevices(msg, cmd)
 	case "MainMenu":
 		return m.updateMainMenu(msg, cmd)
 	case "Write":
@@ -665,6 +726,171 @@ func (m *model) updateMainMenu(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
+func (m model) updateVaultUnlock(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyRunes, tea.KeyBackspace:
+			m.TextInput, cmd = m.TextInput.Update(msg)
+			return m, cmd
+		}
+		switch msg.String() {
+		case "enter":
+			m.handleVaultUnlock(m.TextInput.Value())
+			m.TextInput.SetValue("")
+			return m, cmd
+		}
+	}
+	return m, cmd
+}
+
+func (m model) updateVaultSetup(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyRunes, tea.KeyBackspace:
+			m.TextInput, cmd = m.TextInput.Update(msg)
+			return m, cmd
+		}
+		switch msg.String() {
+		case "enter":
+			m.handleVaultSetup(m.TextInput.Value())
+			m.TextInput.SetValue("")
+			return m, cmd
+		}
+	}
+	return m, cmd
+}
+
+func (m model) updateTOTPSetup(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyRunes, tea.KeyBackspace:
+			m.TextInput, cmd = m.TextInput.Update(msg)
+			return m, cmd
+		}
+		switch msg.String() {
+		case "enter":
+			m.handleTOTPConfirm(m.TextInput.Value())
+			m.TextInput.SetValue("")
+			return m, cmd
+		}
+	}
+	return m, cmd
+}
+
+func (m model) updateTOTPRecoveryCodes(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "enter":
+			*m.TOTP = totpState{}
+			m.stageState.nextStage = "MainMenu"
+			return m, cmd
+		}
+	}
+	return m, cmd
+}
+
+func (m model) updateTOTPDisable(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyRunes, tea.KeyBackspace:
+			m.TextInput, cmd = m.TextInput.Update(msg)
+			return m, cmd
+		}
+		switch msg.String() {
+		case "enter":
+			*m.Account = accountState{Action: "2fa", NewValue: m.TextInput.Value()}
+			m.stageState.errorMessage = ""
+			m.stageState.nextStage = "AccountPassword"
+			m.TextInput.SetValue("")
+			return m, cmd
+		}
+	}
+	return m, cmd
+}
+
+func (m model) updateAccountMenu(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "1":
+			*m.Account = accountState{Action: "password"}
+			m.stageState.nextStage = "AccountNewValue"
+		case "2":
+			*m.Account = accountState{Action: "login"}
+			m.stageState.nextStage = "AccountNewValue"
+		case "3":
+			*m.Account = accountState{Action: "delete"}
+			m.stageState.nextStage = "AccountPassword"
+		case "enter":
+			m.stageState.nextStage = "MainMenu"
+		default:
+			return m, cmd
+		}
+		m.stageState.errorMessage = ""
+	}
+	return m, cmd
+}
+
+func (m model) updateAccountNewValue(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyRunes, tea.KeyBackspace:
+			m.TextInput, cmd = m.TextInput.Update(msg)
+			return m, cmd
+		}
+		switch msg.String() {
+		case "enter":
+			m.Account.NewValue = m.TextInput.Value()
+			m.stageState.nextStage = "AccountPassword"
+			m.TextInput.SetValue("")
+			return m, cmd
+		}
+	}
+	return m, cmd
+}
+
+func (m model) updateAccountPassword(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyRunes, tea.KeyBackspace:
+			m.TextInput, cmd = m.TextInput.Update(msg)
+			return m, cmd
+		}
+		switch msg.String() {
+		case "enter":
+			m.handleAccountChange(m.TextInput.Value())
+			m.TextInput.SetValue("")
+			return m, cmd
+		}
+	}
+	return m, cmd
+}
+
+func (m model) updateDevices(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyRunes, tea.KeyBackspace:
+			m.TextInput, cmd = m.TextInput.Update(msg)
+			return m, cmd
+		}
+		switch msg.String() {
+		case "enter":
+			m.handleRevokeDevice(m.TextInput.Value())
+			m.TextInput.SetValue("")
+			return m, cmd
+		}
+	}
+	return m, cmd
+}
+
 func (m model) updateSync(cmd tea.Cmd) (tea.Model, tea.Cmd) {
 	m.handleSync()
 	return m, cmd
@@ -675,6 +901,21 @@ func (m model) updateAuthFailed(cmd tea.Cmd) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
+func (m model) updatePasswordMigration(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "y":
+			m.handleMigrateLogin()
+		case "n", "enter", "esc":
+			m.NewData.LoginInfo.Password = ""
+			m.stageState.errorMessage = ""
+			m.stageState.nextStage = "SignInChoise"
+		}
+	}
+	return m, cmd
+}
+
 func (m model) updateAuth(cmd tea.Cmd) (tea.Model, tea.Cmd) {
 	if m.NewData.AuthType == "login" {
 		m.handleLogin()
@@ -688,6 +929,24 @@ func (m model) updateAuth(cmd tea.Cmd) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
+func (m model) updateOTPInput(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyRunes, tea.KeyBackspace:
+			m.TextInput, cmd = m.TextInput.Update(msg)
+			return m, cmd
+		}
+		switch msg.String() {
+		case "enter":
+			m.handleOTP(m.TextInput.Value())
+			m.TextInput.SetValue("")
+			return m, cmd
+		}
+	}
+	return m, cmd
+}
+
 func (m model) updatePasswordInput(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
@@ -699,6 +958,9 @@ func (m model) updatePasswordInput(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd
 		switch msg.String() {
 		case "enter":
 			m.stageState.nextStage = "Auth"
+			if m.NewData.AuthType == "register" && m.NewData.InviteRequired {
+				m.stageState.nextStage = "InviteInput"
+			}
 			m.NewData.LoginInfo.Password = m.TextInput.Value()
 			m.TextInput.SetValue("")
 			return m, cmd
@@ -707,6 +969,25 @@ func (m model) updatePasswordInput(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd
 	return m, cmd
 }
 
+func (m model) updateInviteInput(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyRunes, tea.KeyBackspace:
+			m.TextInput, cmd = m.TextInput.Update(msg)
+			return m, cmd
+		}
+		switch msg.String() {
+		case "enter":
+			m.stageState.nextStage = "Auth"
+			m.NewData.LoginInfo.Invite = strings.TrimSpace(m.TextInput.Value())
+			m.TextInput.SetValue("")
+			return m, cmd
+		}
+	}
+	return m, cmd
+}
+
 func (m model) updateLoginRegisterInputs(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
@@ -736,9 +1017,22 @@ func (m model) updateSignInChoise(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd)
 			m.TextInput.Placeholder = "Enter your login here"
 			return m, cmd
 		case "r":
-			m.stageState.nextStage = "LoginRegisterInputs"
-			m.NewData.AuthType = "register"
-			m.TextInput.Placeholder = "Enter your new login here"
+			m.handleRegistrationMode()
+			return m, cmd
+		case "s":
+			m.handleStartOIDC(false)
+			return m, cmd
+		}
+	}
+	return m, cmd
+}
+
+func (m model) updateOIDCWait(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "enter":
+			m.handleFinishOIDC()
 			return m, cmd
 		}
 	}
@@ -763,13 +1057,18 @@ func (m model) View() string {
 	case "PingServer":
 		s = "Connecting to server"
 	case "SignInChoise":
-		s = "type 'l' or 'r' to login or register"
+		s = "type 'l' or 'r' to login or register, 's' to sign in with single sign-on"
 		if len(m.stageState.errorMessage) != 0 {
 			s = m.stageState.errorMessage + "\n" + s
 		}
 	case "PingFail":
 		s = m.stageState.errorMessage + "\n Could not connect to the server" +
 			"\n Press Enter to retry"
+	case "PasswordMigration":
+		s = "The server says this account does not support password-less sign in yet." +
+			"\n To switch it, your password has to be sent to the server once." +
+			"\n If you did not expect this, the server may be fake: refuse and contact the administrator." +
+			"\n\n type 'y' to send the password and switch the account, 'n' to cancel"
 
 	case "LoginRegisterInputs":
 		return fmt.Sprintf(
@@ -784,6 +1083,139 @@ func (m model) View() string {
 			"Input your password: \n\n%s\n\n",
 			m.TextInput.View(),
 		) + "\n"
+	case "InviteInput":
+		m.TextInput.Placeholder = "Invite code"
+		return fmt.Sprintf(
+			"The server registers new accounts by invitation only.\nInput your invite code:\n\n%s\n\n",
+			m.TextInput.View(),
+		) + "\n"
+	case "OTPInput":
+		m.TextInput.Placeholder = "Code"
+		title := "Input the code from your authenticator app or a recovery code:"
+		if len(m.stageState.errorMessage) != 0 {
+			title = m.stageState.errorMessage + "\n\n" + title
+		}
+		return fmt.Sprintf(
+			"%s\n\n%s\n\n",
+			title,
+			m.TextInput.View(),
+		) + "\n"
+	case "OIDCWait":
+		title := "Open this address in your browser and sign in:"
+		if m.SSO.Link {
+			title = "Open this address in your browser and sign in with the account to link:"
+		}
+		return title + "\n\n" + m.SSO.AuthURL + "\n\nPress Enter after signing in\n"
+	case "TOTPSetup":
+		m.TextInput.Placeholder = "Code"
+		title := "Scan the QR code with your authenticator app or enter the secret manually:"
+		if len(m.stageState.errorMessage) != 0 {
+			title = m.stageState.errorMessage + "\n\n" + title
+		}
+		return fmt.Sprintf(
+			"%s\n\n%s\nSecret: %s\n\nInput the code from the app to enable 2FA:\n\n%s\n\n",
+			title,
+			m.TOTP.QRCode,
+			m.TOTP.Secret,
+			m.TextInput.View(),
+		) + "\n"
+	case "TOTPRecoveryCodes":
+		return "2FA is enabled. Save these recovery codes, each of them signs you in once" +
+			" if you lose your authenticator:\n\n" + strings.Join(m.TOTP.RecoveryCodes, "\n") +
+			"\n\nPress Enter to continue\n"
+	case "TOTPDisable":
+		m.TextInput.Placeholder = "Code"
+		title := "Input the code from your authenticator app or a recovery code to disable 2FA:"
+		if len(m.stageState.errorMessage) != 0 {
+			title = m.stageState.errorMessage + "\n\n" + title
+		}
+		return fmt.Sprintf(
+			"%s\n\n%s\n\n",
+			title,
+			m.TextInput.View(),
+		) + "\n"
+	case "AccountMenu":
+		title := "Account"
+		if len(m.stageState.errorMessage) != 0 {
+			title = m.stageState.errorMessage + "\n\n" + title
+		}
+		return title + "\n\ntype:\n\n1 to change password" +
+			"\n\n2 to change login" +
+			"\n\n3 to delete the account with all your data" +
+			"\n\nEnter to return to the menu\n"
+	case "AccountNewValue":
+		title := "Input your new login:"
+		m.TextInput.Placeholder = "New login"
+		if m.Account.Action == "password" {
+			title = "Input your new password, you will stay signed in only on this device:"
+			m.TextInput.Placeholder = "New password"
+			m.TextInput.EchoMode = textinput.EchoPassword
+			m.TextInput.EchoCharacter = '*'
+		}
+		return fmt.Sprintf(
+			"%s\n\n%s\n\n",
+			title,
+			m.TextInput.View(),
+		) + "\n"
+	case "AccountPassword":
+		m.TextInput.Placeholder = "Password"
+		m.TextInput.EchoMode = textinput.EchoPassword
+		m.TextInput.EchoCharacter = '*'
+		title := "Input your current password to confirm:"
+		if m.Account.Action == "2fa" {
+			title = "Input your current password to disable 2FA:"
+		}
+		if m.Account.Action == "delete" {
+			title = "The account and all your data will be deleted permanently.\n" +
+				"Input your current password to confirm:"
+		}
+		if len(m.stageState.errorMessage) != 0 {
+			title = m.stageState.errorMessage + "\n\n" + title
+		}
+		return fmt.Sprintf(
+			"%s\n\n%s\n\n",
+			title,
+			m.TextInput.View(),
+		) + "\n"
+	case "Devices":
+		m.TextInput.Placeholder = "Device number"
+		title := "Devices signed in to your account:"
+		if len(m.stageState.errorMessage) != 0 {
+			title = m.stageState.errorMessage + "\n\n" + title
+		}
+		lines := make([]string, 0, len(*m.Devices))
+		for i, device := range *m.Devices {
+			line := fmt.Sprintf("%d. %s (%s, client %s), added %s, last seen %s from %s", i+1,
+				device.Name, device.OS, device.ClientVersion, device.Created.Format(time.DateTime),
+				device.LastSeenAt.Format(time.DateTime), device.LastIP)
+			if device.Current {
+				line += " - this device"
+			}
+			lines = append(lines, line)
+		}
+		return fmt.Sprintf(
+			"%s\n\n%s\n\nInput a device number to sign it out, or press Enter to return to the menu:\n\n%s\n\n",
+			title,
+			strings.Join(lines, "\n"),
+			m.TextInput.View(),
+		) + "\n"
+	case "VaultUnlock", "VaultSetup":
+		m.TextInput.Placeholder = "Master password"
+		m.TextInput.EchoMode = textinput.EchoPassword
+		m.TextInput.EchoCharacter = '*'
+		title := "Your vault is encrypted on this device. Input your master password:"
+		if m.stageState.nextStage == "VaultSetup" {
+			title = "Input new master password. Data will be encrypted before it leaves this device\n" +
+				"and cannot be recovered if the password is lost:"
+		}
+		if len(m.stageState.errorMessage) != 0 {
+			title = m.stageState.errorMessage + "\n\n" + title
+		}
+		return fmt.Sprintf(
+			"%s\n\n%s\n\n",
+			title,
+			m.TextInput.View(),
+		) + "\n"
 	case "Sync":
 		s = "Loading data from server"
 	case "SyncFail":
@@ -798,7 +1230,15 @@ func (m model) View() string {
 			"\n\nwrite to add new data" +
 			"\n\nlist to view all names and descriptions of your data" +
 			"\n\ndelete <name> to delete data" +
-			"\n\nedit <name> to edit data \n\n" + m.TextInput.View()
+			"\n\nedit <name> to edit data" +
+			"\n\nvault to encrypt your data on this device with a master password, vault migrate to finish re-encrypting" +
+			"\n\n2fa to enable two-factor authentication, 2fa off to disable it" +
+			"\n\naccount to change password or login or to delete the account" +
+			"\n\ndevices to view and sign out devices" +
+			"\n\ncertificate to get a client certificate for signing in without a password" +
+			"\n\nsso link to sign in to this account with single sign-on too" +
+			"\n\ninvite to create a single-use invite code for a new user" +
+			"\n\nlogout to sign out, logout all to sign out on every device \n\n" + m.TextInput.View()
 	case "WriteName":
 		m.TextInput.Placeholder = "Name"
 		return fmt.Sprintf(
@@ -893,16 +1333,47 @@ func startAppCmd() tea.Msg {
 
 func (m model) handleLogin() {
 	status, err := m.ClientEnv.HandleLogin(m.NewData.LoginInfo)
+	if errors.Is(err, handler.ErrPasswordLoginRequired) {
+		m.stageState.errorMessage = ""
+		m.stageState.nextStage = "PasswordMigration"
+		return
+	}
+	m.finishLogin(status, err)
+}
+
+// handleMigrateLogin входит паролем в аккаунт без верификатора SRP после согласия пользователя.
+func (m model) handleMigrateLogin() {
+	status, err := m.ClientEnv.HandleMigrateLogin(m.NewData.LoginInfo)
+	m.finishLogin(status, err)
+}
+
+// finishLogin переводит меню на следующую стадию по ответу на вход.
+func (m model) finishLogin(status int, err error) {
 	if err != nil {
 		m.stageState.errorMessage = err.Error()
 		m.stageState.nextStage = "AuthFailed"
 		return
 	}
-	if status == http.StatusUnauthorized {
+	if status == http.StatusTooManyRequests {
+		m.stageState.errorMessage = tooManyAttemptsMessage(m.ClientEnv.RetryAfter())
+		m.stageState.nextStage = "AuthFailed"
+		return
+	}
+	if status == http.StatusUnauthorized {
 		m.stageState.errorMessage = "no such login and password pair found"
 		m.stageState.nextStage = "AuthFailed"
 		return
 	}
+	if status == http.StatusForbidden {
+		m.stageState.errorMessage = accountDisabledMessage
+		m.stageState.nextStage = "AuthFailed"
+		return
+	}
+	if status == http.StatusAccepted {
+		m.stageState.errorMessage = ""
+		m.stageState.nextStage = "OTPInput"
+		return
+	}
 	if status == http.StatusOK {
 		m.stageState.errorMessage = ""
 		m.stageState.nextStage = "Sync"
@@ -912,6 +1383,207 @@ func (m model) handleLogin() {
 	m.stageState.nextStage = "AuthFailed"
 }
 
+// handleOTP завершает вход вторым фактором. Токен ожидания живет несколько минут и
+// принимает несколько кодов, после этого нужно снова ввести пароль.
+func (m model) handleOTP(code string) {
+	status, err := m.ClientEnv.HandleOTP(code)
+	if err != nil {
+		m.stageState.errorMessage = err.Error()
+		m.stageState.nextStage = "AuthFailed"
+		return
+	}
+	if status == http.StatusTooManyRequests {
+		m.stageState.errorMessage = tooManyAttemptsMessage(m.ClientEnv.RetryAfter())
+		m.stageState.nextStage = "OTPInput"
+		return
+	}
+	if status == http.StatusUnauthorized {
+		m.stageState.errorMessage = "invalid code or the sign in has expired, press Esc and sign in again if it keeps failing"
+		m.stageState.nextStage = "OTPInput"
+		return
+	}
+	if status == http.StatusForbidden {
+		m.stageState.errorMessage = accountDisabledMessage
+		m.stageState.nextStage = "AuthFailed"
+		return
+	}
+	if status == http.StatusOK {
+		m.stageState.errorMessage = ""
+		m.stageState.nextStage = "Sync"
+		return
+	}
+	m.stageState.errorMessage = "server error, unexpected status: " + fmt.Sprint(status)
+	m.stageState.nextStage = "AuthFailed"
+}
+
+func (m model) handleTOTPEnroll() {
+	status, enrollment, err := m.ClientEnv.HandleEnrollTOTP()
+	m.stageState.nextStage = "MainMenu"
+	if err != nil {
+		m.stageState.errorMessage = err.Error()
+		return
+	}
+	if status == http.StatusConflict {
+		m.stageState.errorMessage = "2FA is already enabled, type 2fa off to disable it"
+		return
+	}
+	if status != http.StatusOK {
+		m.stageState.errorMessage = "Something went wrong with status: " + fmt.Sprint(status)
+		return
+	}
+
+	code, err := qrcode.New(enrollment.URI, qrcode.Medium)
+	if err != nil {
+		m.stageState.errorMessage = err.Error()
+		return
+	}
+
+	*m.TOTP = totpState{Secret: enrollment.Secret, QRCode: code.ToSmallString(false)}
+	m.stageState.errorMessage = ""
+	m.stageState.nextStage = "TOTPSetup"
+}
+
+func (m model) handleTOTPConfirm(code string) {
+	status, recoveryCodes, err := m.ClientEnv.HandleConfirmTOTP(code)
+	if err != nil {
+		m.stageState.errorMessage = err.Error()
+		m.stageState.nextStage = "MainMenu"
+		return
+	}
+	if status == http.StatusForbidden {
+		m.stageState.errorMessage = "invalid code, check the time on your device and try again"
+		m.stageState.nextStage = "TOTPSetup"
+		return
+	}
+	if status != http.StatusOK {
+		m.stageState.errorMessage = "Something went wrong with status: " + fmt.Sprint(status)
+		m.stageState.nextStage = "MainMenu"
+		return
+	}
+	m.TOTP.RecoveryCodes = recoveryCodes
+	m.stageState.errorMessage = ""
+	m.stageState.nextStage = "TOTPRecoveryCodes"
+}
+
+func (m model) handleLogout(all bool) {
+	status, err := m.ClientEnv.HandleLogout(all)
+	*m.UserMetadata = nil
+	m.stageState.nextStage = "SignInChoise"
+	if err != nil {
+		m.stageState.errorMessage = err.Error()
+		return
+	}
+	if status != http.StatusOK {
+		m.stageState.errorMessage = "server error, unexpected status: " + fmt.Sprint(status)
+		return
+	}
+	m.stageState.errorMessage = ""
+}
+
+// handleAccountChange отправляет выбранное в меню account изменение, подтвержденное паролем.
+func (m model) handleAccountChange(password string) {
+	var status int
+	var err error
+	switch m.Account.Action {
+	case "password":
+		status, err = m.ClientEnv.HandleChangePassword(password, m.Account.NewValue)
+	case "login":
+		status, err = m.ClientEnv.HandleChangeLogin(password, m.Account.NewValue)
+	case "delete":
+		status, err = m.ClientEnv.HandleDeleteAccount(password)
+	case "2fa":
+		status, err = m.ClientEnv.HandleDisableTOTP(password, m.Account.NewValue)
+	}
+
+	action := m.Account.Action
+	*m.Account = accountState{}
+	m.stageState.nextStage = "AccountMenu"
+	if action == "2fa" {
+		m.stageState.nextStage = "MainMenu"
+	}
+	switch {
+	case errors.Is(err, handler.ErrPasswordLoginRequired):
+		m.stageState.errorMessage = "the account still signs in with a plain password, sign out and sign in again to switch it"
+	case err != nil:
+		m.stageState.errorMessage = err.Error()
+	case status == http.StatusForbidden && action == "2fa":
+		m.stageState.errorMessage = "wrong password or code"
+	case status == http.StatusForbidden:
+		m.stageState.errorMessage = "wrong password"
+	case status == http.StatusTooManyRequests:
+		m.stageState.errorMessage = tooManyAttemptsMessage(m.ClientEnv.RetryAfter())
+	case status == http.StatusConflict:
+		m.stageState.errorMessage = "login alredy in use"
+	case status == http.StatusNotFound && action == "2fa":
+		m.stageState.errorMessage = "2FA is not enabled"
+	case status == http.StatusBadRequest:
+		m.stageState.errorMessage = "new value must not be empty"
+	case status != http.StatusOK:
+		m.stageState.errorMessage = "Something went wrong with status: " + fmt.Sprint(status)
+	case action == "2fa":
+		m.stageState.errorMessage = "2FA is disabled"
+	case action == "delete":
+		*m.UserMetadata = nil
+		m.stageState.errorMessage = "account deleted"
+		m.stageState.nextStage = "SignInChoise"
+	case action == "password":
+		m.stageState.errorMessage = "password changed, other devices are signed out"
+	default:
+		m.stageState.errorMessage = "login changed"
+	}
+}
+
+func (m model) handleDevices() {
+	status, devices, err := m.ClientEnv.HandleDevices()
+	switch {
+	case err != nil:
+		m.stageState.errorMessage = err.Error()
+		m.stageState.nextStage = "MainMenu"
+	case status != http.StatusOK:
+		m.stageState.errorMessage = "Something went wrong with status: " + fmt.Sprint(status)
+		m.stageState.nextStage = "MainMenu"
+	default:
+		*m.Devices = devices
+		m.stageState.nextStage = "Devices"
+	}
+}
+
+// handleRevokeDevice отзывает устройство по номеру из списка. Пустой ввод возвращает в меню.
+func (m model) handleRevokeDevice(input string) {
+	if len(strings.TrimSpace(input)) == 0 {
+		m.stageState.errorMessage = ""
+		m.stageState.nextStage = "MainMenu"
+		return
+	}
+
+	number, err := strconv.Atoi(strings.TrimSpace(input))
+	if err != nil || number < 1 || number > len(*m.Devices) {
+		m.stageState.errorMessage = "no device with such number"
+		return
+	}
+	device := (*m.Devices)[number-1]
+
+	status, err := m.ClientEnv.HandleRevokeDevice(device.ID)
+	switch {
+	case err != nil:
+		m.stageState.errorMessage = err.Error()
+		return
+	case status != http.StatusOK:
+		m.stageState.errorMessage = "Something went wrong with status: " + fmt.Sprint(status)
+		return
+	}
+
+	// отозвано это устройство, его сессия больше не действует
+	if device.Current {
+		m.handleLogout(false)
+		m.stageState.errorMessage = "this device is signed out"
+		return
+	}
+
+	m.handleDevices()
+	m.stageState.errorMessage = device.Name + " is signed out"
+}
+
 func (m model) handleRegister() {
 	status, err := m.ClientEnv.HandleRegister(m.NewData.LoginInfo)
 	if err != nil {
@@ -919,11 +1591,21 @@ func (m model) handleRegister() {
 		m.stageState.nextStage = "AuthFailed"
 		return
 	}
+	if status == http.StatusTooManyRequests {
+		m.stageState.errorMessage = tooManyAttemptsMessage(m.ClientEnv.RetryAfter())
+		m.stageState.nextStage = "AuthFailed"
+		return
+	}
 	if status == http.StatusConflict {
 		m.stageState.errorMessage = "login alredy in use"
 		m.stageState.nextStage = "AuthFailed"
 		return
 	}
+	if status == http.StatusForbidden {
+		m.stageState.errorMessage = "registration refused: the invite code is invalid, used or expired"
+		m.stageState.nextStage = "AuthFailed"
+		return
+	}
 	if status == http.StatusOK {
 		m.stageState.errorMessage = ""
 		m.stageState.nextStage = "AuthSuccess"
@@ -933,6 +1615,56 @@ func (m model) handleRegister() {
 	m.stageState.nextStage = "AuthFailed"
 }
 
+// handleRegistrationMode узнает у сервера, открыта ли регистрация и нужен ли код приглашения.
+func (m model) handleRegistrationMode() {
+	mode, err := m.ClientEnv.HandleRegistrationMode()
+	if err != nil {
+		m.stageState.errorMessage = err.Error()
+		return
+	}
+	if mode == "closed" {
+		m.stageState.errorMessage = "registration is closed on this server"
+		return
+	}
+
+	m.stageState.errorMessage = ""
+	m.stageState.nextStage = "LoginRegisterInputs"
+	m.NewData.AuthType = "register"
+	m.NewData.InviteRequired = mode == "invite"
+	m.NewData.LoginInfo.Invite = ""
+	m.TextInput.Placeholder = "Enter your new login here"
+}
+
+// handleCreateInvite создает приглашение и показывает его код.
+func (m model) handleCreateInvite() {
+	status, invite, err := m.ClientEnv.HandleCreateInvite()
+	m.stageState.nextStage = "MainMenu"
+	if err != nil {
+		m.stageState.errorMessage = err.Error()
+		return
+	}
+	if status == http.StatusForbidden {
+		m.stageState.errorMessage = "this account cannot create more invites"
+		return
+	}
+	if status != http.StatusCreated {
+		m.stageState.errorMessage = "Something went wrong with status: " + fmt.Sprint(status)
+		return
+	}
+	m.stageState.errorMessage = "invite code " + invite.Code + ", valid until " +
+		invite.Expires.Local().Format(time.DateTime) + ", it is shown only once"
+}
+
+// accountDisabledMessage - ответ на вход в аккаунт, отключенный администратором.
+const accountDisabledMessage = "the account is disabled, contact the administrator"
+
+func tooManyAttemptsMessage(retryAfter time.Duration) string {
+	if retryAfter <= 0 {
+		return "too many failed attempts, try again later"
+	}
+	return "too many failed attempts, try again in " + retryAfter.String()
+}
+
 func (m model) handlePingServer() {
 	status, err := m.ClientEnv.HandlePingServer()
 	if err != nil {
@@ -948,8 +1680,113 @@ func (m model) handlePingServer() {
 	if status == http.StatusOK {
 		m.stageState.errorMessage = ""
 		m.stageState.nextStage = "SignInChoise"
+	}
+
+	// с клиентским сертификатом вход по паролю не нужен
+	if m.ClientEnv.HasCertificate() {
+		status, userMetadata, err := m.ClientEnv.HandleSync()
+		if err == nil && (status == http.StatusOK || status == http.StatusNoContent) {
+			*m.UserMetadata = userMetadata
+			m.stageState.nextStage = "MainMenu"
+		}
+	}
+}
+
+// handleIssueCertificate получает клиентский сертификат, с которым клиент будет входить без пароля.
+func (m model) handleIssueCertificate() {
+	status, path, err := m.ClientEnv.HandleIssueCertificate()
+	m.stageState.nextStage = "MainMenu"
+	if err != nil {
+		m.stageState.errorMessage = err.Error()
+		return
+	}
+	if status == http.StatusNotFound {
+		m.stageState.errorMessage = "server does not issue client certificates"
+		return
+	}
+	if status != http.StatusCreated {
+		m.stageState.errorMessage = "Something went wrong with status: " + fmt.Sprint(status)
+		return
+	}
+	m.stageState.errorMessage = "client certificate saved to " + path + ", next time the client signs in with it"
+}
+
+// handleStartOIDC начинает вход через провайдер единого входа или привязку к текущему аккаунту.
+func (m model) handleStartOIDC(link bool) {
+	failStage := "SignInChoise"
+	if link {
+		failStage = "MainMenu"
+	}
+
+	status, authURL, err := m.ClientEnv.StartOIDC(link)
+	if err != nil {
+		m.stageState.errorMessage = err.Error()
+		m.stageState.nextStage = failStage
+		return
+	}
+	if status == http.StatusNotFound {
+		m.stageState.errorMessage = "server does not support single sign-on"
+		m.stageState.nextStage = failStage
+		return
+	}
+	if status != http.StatusOK {
+		m.stageState.errorMessage = "server error, unexpected status: " + fmt.Sprint(status)
+		m.stageState.nextStage = failStage
 		return
 	}
+
+	*m.SSO = ssoState{AuthURL: authURL, Link: link}
+	m.stageState.errorMessage = ""
+	m.stageState.nextStage = "OIDCWait"
+}
+
+// handleFinishOIDC завершает вход или привязку, когда браузер вернулся от провайдера.
+func (m model) handleFinishOIDC() {
+	link := m.SSO.Link
+	*m.SSO = ssoState{}
+
+	status, err := m.ClientEnv.FinishOIDC()
+	if link {
+		m.stageState.nextStage = "MainMenu"
+		switch {
+		case err != nil:
+			m.stageState.errorMessage = err.Error()
+		case status == http.StatusConflict:
+			m.stageState.errorMessage = "this single sign-on account is already linked to another account"
+		case status != http.StatusOK:
+			m.stageState.errorMessage = "Something went wrong with status: " + fmt.Sprint(status)
+		default:
+			m.stageState.errorMessage = "single sign-on account linked"
+		}
+		return
+	}
+
+	if err != nil {
+		m.stageState.errorMessage = err.Error()
+		m.stageState.nextStage = "AuthFailed"
+		return
+	}
+	switch status {
+	case http.StatusOK:
+		m.stageState.errorMessage = ""
+		m.stageState.nextStage = "Sync"
+	case http.StatusAccepted:
+		m.stageState.errorMessage = ""
+		m.stageState.nextStage = "OTPInput"
+	case http.StatusTooManyRequests:
+		m.stageState.errorMessage = tooManyAttemptsMessage(m.ClientEnv.RetryAfter())
+		m.stageState.nextStage = "AuthFailed"
+	case http.StatusUnauthorized:
+		m.stageState.errorMessage = "single sign-on has expired, try again"
+		m.stageState.nextStage = "AuthFailed"
+	case http.StatusForbidden:
+		m.stageState.errorMessage = accountDisabledMessage +
+			", or no account is linked to this single sign-on account and registration is not open"
+		m.stageState.nextStage = "AuthFailed"
+	default:
+		m.stageState.errorMessage = "server error, unexpected status: " + fmt.Sprint(status)
+		m.stageState.nextStage = "AuthFailed"
+	}
 }
 
 func (m model) handleSync() {
@@ -964,8 +1801,84 @@ func (m model) handleSync() {
 	if status == http.StatusNoContent || status == http.StatusOK {
 		m.stageState.errorMessage = ""
 		m.stageState.nextStage = "MainMenu"
+		m.handleVaultCheck()
+		return
+	}
+}
+
+// handleVaultCheck просит мастер-пароль, если у аккаунта включено шифрование на клиенте.
+func (m model) handleVaultCheck() {
+	status, params, err := m.ClientEnv.HandleGetVault()
+	if err != nil {
+		m.stageState.errorMessage = err.Error()
+		return
+	}
+	if status == http.StatusOK {
+		*m.VaultParams = params
+		m.stageState.nextStage = "VaultUnlock"
+	}
+}
+
+func (m model) handleVaultUnlock(password string) {
+	err := m.ClientEnv.UnlockVault(password, *m.VaultParams)
+	if err != nil {
+		m.stageState.errorMessage = err.Error()
+		m.stageState.nextStage = "VaultUnlock"
+		return
+	}
+	m.stageState.errorMessage = ""
+	m.stageState.nextStage = "MainMenu"
+}
+
+func (m model) handleVaultSetup(password string) {
+	if len(password) == 0 {
+		m.stageState.errorMessage = "master password can not be empty"
+		m.stageState.nextStage = "VaultSetup"
+		return
+	}
+
+	status, err := m.ClientEnv.HandleEnableVault(password)
+	if err != nil {
+		m.stageState.errorMessage = err.Error()
+		m.stageState.nextStage = "MainMenu"
+		return
+	}
+	if status == http.StatusConflict {
+		m.stageState.errorMessage = "vault is already enabled for this account"
+		m.stageState.nextStage = "MainMenu"
+		return
+	}
+	if status != http.StatusOK {
+		m.stageState.errorMessage = "Something went wrong with status: " + fmt.Sprint(status)
+		m.stageState.nextStage = "MainMenu"
 		return
 	}
+	m.handleVaultMigrate()
+}
+
+// handleVaultMigrate перешифровывает записи, сохраненные до включения хранилища.
+func (m model) handleVaultMigrate() {
+	m.stageState.nextStage = "MainMenu"
+	if !m.ClientEnv.VaultUnlocked() {
+		m.stageState.errorMessage = "vault is not enabled"
+		return
+	}
+
+	migrated, err := m.ClientEnv.MigrateVault()
+	if err != nil {
+		m.stageState.errorMessage = fmt.Sprintf("re-encrypted %d records, then failed: %s, run vault migrate to retry",
+			migrated, err)
+		return
+	}
+	m.stageState.errorMessage = fmt.Sprintf("vault is enabled, %d existing records re-encrypted", migrated)
+}
+
+// vaultMigrationHint подсказывает команду, которая исправит запись старого формата.
+func vaultMigrationHint(err error) string {
+	if handler.NeedsVaultMigration(err) {
+		return ", run vault migrate"
+	}
+	return ""
 }
 
 func (m model) handleMainMenuCommand() {
@@ -983,6 +1896,27 @@ func (m model) handleMainMenuCommand() {
 		case "list":
 			m.stageState.errorMessage = ""
 			m.stageState.nextStage = "List"
+		case "vault":
+			m.stageState.errorMessage = ""
+			m.stageState.nextStage = "VaultSetup"
+			if m.ClientEnv.VaultUnlocked() {
+				m.stageState.errorMessage = "vault is already enabled"
+				m.stageState.nextStage = "MainMenu"
+			}
+		case "2fa":
+			m.handleTOTPEnroll()
+		case "account":
+			m.stageState.errorMessage = ""
+			m.stageState.nextStage = "AccountMenu"
+		case "devices":
+			m.stageState.errorMessage = ""
+			m.handleDevices()
+		case "certificate":
+			m.handleIssueCertificate()
+		case "invite":
+			m.handleCreateInvite()
+		case "logout":
+			m.handleLogout(false)
 		default:
 			m.stageState.errorMessage = "Unknown command"
 			m.stageState.nextStage = "MainMenu"
@@ -998,6 +1932,39 @@ func (m model) handleMainMenuCommand() {
 		case "edit":
 			m.stageState.errorMessage = ""
 			m.stageState.nextStage = "Edit"
+		case "2fa":
+			if commandSlice[1] != "off" {
+				m.stageState.errorMessage = "Unknown command"
+				m.stageState.nextStage = "MainMenu"
+				return
+			}
+			m.stageState.errorMessage = ""
+			m.stageState.nextStage = "TOTPDisable"
+			return
+		case "logout":
+			if commandSlice[1] != "all" {
+				m.stageState.errorMessage = "Unknown command"
+				m.stageState.nextStage = "MainMenu"
+				return
+			}
+			m.handleLogout(true)
+			return
+		case "vault":
+			if commandSlice[1] != "migrate" {
+				m.stageState.errorMessage = "Unknown command"
+				m.stageState.nextStage = "MainMenu"
+				return
+			}
+			m.handleVaultMigrate()
+			return
+		case "sso":
+			if commandSlice[1] != "link" {
+				m.stageState.errorMessage = "Unknown command"
+				m.stageState.nextStage = "MainMenu"
+				return
+			}
+			m.handleStartOIDC(true)
+			return
 		default:
 			m.stageState.errorMessage = "Unknown command"
 			m.stageState.nextStage = "MainMenu"
@@ -1194,7 +2161,7 @@ func (m model) readHandle() {
 	if metadataToRead.DataType == "files" {
 		status, filePath, err := m.ClientEnv.HandleReadFile(metadataToRead)
 		if err != nil {
-			m.stageState.errorMessage = "Could not request file " + err.Error()
+			m.stageState.errorMessage = "Could not request file " + err.Error() + vaultMigrationHint(err)
 			m.stageState.nextStage = "MainMenu"
 			return
 		}
@@ -1212,7 +2179,8 @@ func (m model) readHandle() {
 	} else {
 		status, data, err := m.ClientEnv.HandleRead(metadataToRead)
 		if err != nil {
-			m.stageState.errorMessage = "Could not request data: " + metadataToRead.StaticID + " " + err.Error()
+			m.stageState.errorMessage = "Could not request data: " + metadataToRead.StaticID + " " + err.Error() +
+				vaultMigrationHint(err)
 			m.stageState.nextStage = "MainMenu"
 			return
 		}
@@ -0,0 +1,189 @@
+// Утилита администратора: список аккаунтов с количеством и объемом записей, отключение,
+// включение, принудительный выход, смена роли и удаление аккаунта. Работает напрямую с
+// базой, поэтому ею назначается первый администратор. Записи не расшифровываются.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/database"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = "usage: admin list|disable|enable|logout|role|delete [flags]"
+
+// auditDetail - кем выполнено действие, в журналах аккаунта и администраторов
+const auditDetail = "by admin CLI"
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal(usage)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "list":
+		err = listCommand(os.Args[2:])
+	case "disable", "enable", "logout", "role", "delete":
+		err = accountCommand(os.Args[1], os.Args[2:])
+	default:
+		err = fmt.Errorf("unknown command %q, %s", os.Args[1], usage)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// connect подключается к базе по флагу -d или DATABASE_URI и к базе ключей по флагу
+// -key-store или KEY_STORE_URI, как сервер.
+func connect(ctx context.Context, dbAddress, keyStoreAddress string) database.PostgreDB {
+	if envDBConnectionAddress := os.Getenv("DATABASE_URI"); envDBConnectionAddress != "" {
+		dbAddress = envDBConnectionAddress
+	}
+
+	if envKeyStoreAddress := os.Getenv("KEY_STORE_URI"); envKeyStoreAddress != "" {
+		keyStoreAddress = envKeyStoreAddress
+	}
+
+	return database.PostgreDB{
+		DatabaseConnection: database.NewDBConnection(ctx, dbAddress),
+		KeyStore:           database.NewDBConnection(ctx, keyStoreAddress),
+	}
+}
+
+func dbFlags(fs *flag.FlagSet) (*string, *string) {
+	return fs.String("d", "host=localhost port=5432 user=postgres password=vvv dbname=gophkeep sslmode=disable", "database connection address"),
+		fs.String("key-store", "host=localhost port=5432 user=postgres password=vvv dbname=gophkeep_keys sslmode=disable", "connection address of the separate database with account keys")
+}
+
+func listCommand(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	dbAddress, keyStoreAddress := dbFlags(fs)
+	fs.Parse(args)
+
+	ctx := context.Background()
+	storage := connect(ctx, *dbAddress, *keyStoreAddress)
+	defer storage.Close()
+
+	accounts, err := storage.ListAccounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tLOGIN\tROLE\tCREATED\tDISABLED\tINVITED BY\tSESSIONS\tRECORDS\tSTORAGE")
+	for _, account := range accounts {
+		disabled := "-"
+		if account.DisabledAt != nil {
+			disabled = account.DisabledAt.Format(time.DateTime)
+		}
+
+		invitedBy := account.InvitedBy
+		if len(invitedBy) == 0 {
+			invitedBy = "-"
+		}
+
+		kinds := make([]string, 0, len(account.Records))
+		for kind, count := range account.Records {
+			kinds = append(kinds, fmt.Sprintf("%s:%d", kind, count))
+		}
+		sort.Strings(kinds)
+		records := strings.Join(kinds, " ")
+		if len(records) == 0 {
+			records = "-"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%d B\n", account.ID, account.Login, account.Role,
+			account.Created.Format(time.DateTime), disabled, invitedBy, account.Sessions, records, account.StorageBytes)
+	}
+
+	return w.Flush()
+}
+
+// accountCommand выполняет действие над аккаунтом, заданным флагом -user или -login.
+func accountCommand(command string, args []string) error {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	dbAddress, keyStoreAddress := dbFlags(fs)
+	userID := fs.String("user", "", "uuid of the account")
+	login := fs.String("login", "", "login of the account")
+	role := fs.String("role", auth.RoleAdmin, "role to assign with the role command: user or admin")
+	fs.Parse(args)
+
+	if (len(*userID) == 0) == (len(*login) == 0) {
+		return errors.New("exactly one of -user and -login is required")
+	}
+	if command == "role" && !auth.ValidRole(*role) {
+		return errors.New("role must be user or admin")
+	}
+
+	ctx := context.Background()
+	storage := connect(ctx, *dbAddress, *keyStoreAddress)
+	defer storage.Close()
+
+	id := *userID
+	if len(id) == 0 {
+		var err error
+		if id, err = storage.AccountIDByLogin(ctx, *login); err != nil {
+			return err
+		}
+	}
+
+	// журнал аккаунта удаляется вместе с ним, поэтому удаление записывается заранее
+	// и только в журнал администраторов
+	if command == "delete" {
+		if err := storage.RecordAdminAudit(ctx, "", id, database.AuditAdminDelete, auditDetail); err != nil {
+			return err
+		}
+	}
+
+	var event string
+	var err error
+	switch command {
+	case "disable":
+		event = database.AuditAdminDisable
+		err = storage.SetAccountDisabled(ctx, id, true)
+	case "enable":
+		event = database.AuditAdminEnable
+		err = storage.SetAccountDisabled(ctx, id, false)
+	case "logout":
+		var revoked int64
+		event = database.AuditAdminLogout
+		revoked, err = storage.RevokeAccountSessions(ctx, id)
+		if err == nil {
+			log.Printf("Revoked %d sessions", revoked)
+		}
+	case "role":
+		event = database.AuditAdminRole
+		err = storage.SetAccountRole(ctx, id, *role)
+	case "delete":
+		err = storage.DeleteAccount(ctx, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(event) != 0 {
+		detail := auditDetail
+		if command == "role" {
+			detail += " " + *role
+		}
+		if err = storage.RecordAdminAudit(ctx, "", id, event, detail); err != nil {
+			log.Printf("could not record admin audit event: %v", err)
+		}
+		if err = storage.RecordAudit(auth.WithUserID(ctx, id), event, detail); err != nil {
+			log.Printf("could not record audit event: %v", err)
+		}
+	}
+
+	log.Printf("Account %s: %s done", id, command)
+	return nil
+}
@@ -0,0 +1,44 @@
+// Удаление аккаунта по запросу на стирание данных. Вместе с аккаунтом удаляются его
+// записи и ключ в базе ключей, после чего записи из резервных копий не расшифровываются.
+package main
+
+import (
+	"context"
+	"flag"
+	"gophkeep/internal/database"
+	"log"
+	"os"
+)
+
+func main() {
+	var dbAddress, keyStoreAddress, userID string
+	flag.StringVar(&dbAddress, "d", "host=localhost port=5432 user=postgres password=vvv dbname=gophkeep sslmode=disable", "database connection address")
+	flag.StringVar(&keyStoreAddress, "key-store", "host=localhost port=5432 user=postgres password=vvv dbname=gophkeep_keys sslmode=disable", "connection address of the separate database with account keys")
+	flag.StringVar(&userID, "user", "", "uuid of the account to erase")
+	flag.Parse()
+
+	if envDBConnectionAddress := os.Getenv("DATABASE_URI"); envDBConnectionAddress != "" {
+		dbAddress = envDBConnectionAddress
+	}
+
+	if envKeyStoreAddress := os.Getenv("KEY_STORE_URI"); envKeyStoreAddress != "" {
+		keyStoreAddress = envKeyStoreAddress
+	}
+
+	if len(userID) == 0 {
+		log.Fatal("account uuid is required")
+	}
+
+	ctx := context.Background()
+	storage := database.PostgreDB{
+		DatabaseConnection: database.NewDBConnection(ctx, dbAddress),
+		KeyStore:           database.NewDBConnection(ctx, keyStoreAddress),
+	}
+	defer storage.Close()
+
+	if err := storage.DeleteAccount(ctx, userID); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Account %s erased", userID)
+}
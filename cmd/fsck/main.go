@@ -0,0 +1,103 @@
+// Проверка целостности хранилища: записи без пары в infos и таблицах данных, записи
+// удаленных аккаунтов и шифртекст, который не расшифровывается. Отчет выводится в JSON.
+// Флаги базы данных и мастер-ключа те же, что у сервера.
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gophkeep/internal/config"
+	"gophkeep/internal/database"
+	"gophkeep/internal/encryption"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+func main() {
+	var options database.FsckOptions
+	var output string
+	flag.BoolVar(&options.Repair, "repair", false, "delete orphaned rows and records of deleted accounts")
+	flag.BoolVar(&options.Quarantine, "quarantine", false, "move every broken record to the quarantine table")
+	flag.StringVar(&output, "o", "", "write the report to a file instead of stdout")
+
+	cfg := config.MakeConfig()
+
+	keyProvider, err := config.NewKeyProvider(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	encryption.SetKeyProvider(keyProvider)
+
+	if err := unsealFromTerminal(); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	storage := database.PostgreDB{
+		DatabaseConnection: database.NewDBConnection(ctx, cfg.FlagDBConnectionAddress),
+		KeyStore:           database.NewDBConnection(ctx, cfg.FlagKeyStoreAddress),
+	}
+	defer storage.Close()
+
+	report, err := storage.Fsck(ctx, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	content = append(content, '\n')
+
+	if len(output) != 0 {
+		err = os.WriteFile(output, content, 0600)
+	} else {
+		_, err = os.Stdout.Write(content)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// как и fsck, завершаемся с ошибкой, если в хранилище остались проблемы
+	if unresolved := report.Unresolved(); unresolved > 0 {
+		log.Printf("%d problems left unresolved", unresolved)
+		os.Exit(1)
+	}
+}
+
+// unsealFromTerminal запрашивает доли мастер-ключа, если выбран провайдер shamir.
+func unsealFromTerminal() error {
+	for encryption.Sealed() {
+		status := encryption.CurrentSealStatus()
+		fmt.Fprintf(os.Stderr, "Unseal share (%d of %d): ", status.Progress+1, status.Threshold)
+		input, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+
+		share, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(input)))
+		if err != nil {
+			return err
+		}
+
+		_, err = encryption.Unseal(share)
+		if errors.Is(err, encryption.ErrUnsealFailed) || errors.Is(err, encryption.ErrDuplicateShare) {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+// Добавляет новый ключ подписи токенов в файл ключей сервера. Новый ключ становится
+// активным после SIGHUP, токены, подписанные прежними ключами, продолжают приниматься.
+package main
+
+import (
+	"flag"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/config"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	var keyFile, alg string
+	flag.StringVar(&keyFile, "jwt-key-file", config.DefaultPath(config.JWTKeyFileName), "path to the token signing keys")
+	flag.StringVar(&alg, "alg", auth.TokenAlgEdDSA, "signing algorithm: EdDSA or HS256")
+	flag.Parse()
+
+	if envJWTKeyFile := os.Getenv("JWT_KEY_FILE"); envJWTKeyFile != "" {
+		keyFile = envJWTKeyFile
+	}
+
+	line, err := auth.GenerateTokenKey(alg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		log.Fatal(err)
+	}
+
+	file, err := os.OpenFile(keyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(line + "\n"); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Added %s token signing key to %s, send SIGHUP to the server to start using it", alg, keyFile)
+}
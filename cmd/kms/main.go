@@ -0,0 +1,123 @@
+// Локальная замена внешнего сервиса ключей для провайдера transit.
+// Держит кольцо мастер-ключей из файла и оборачивает ключи по HTTP.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"gophkeep/internal/config"
+	"gophkeep/internal/encryption"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+type kms struct {
+	provider encryption.KeyProvider
+	token    string
+}
+
+func main() {
+	var runAddr, keyFile, token string
+	flag.StringVar(&runAddr, "a", ":8200", "address to run key service")
+	flag.StringVar(&keyFile, "key-file", config.DefaultPath(config.MasterKeyFileName), "path to the master key file")
+	flag.StringVar(&token, "token", os.Getenv("KMS_TOKEN"), "token clients must present")
+	flag.Parse()
+
+	provider, err := encryption.NewFileKeyProvider(keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	service := &kms{provider: provider, token: token}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(encryption.TransitKeysPath, service.authorized(service.keysHandle))
+	mux.HandleFunc(encryption.TransitEncryptPath, service.authorized(service.encryptHandle))
+	mux.HandleFunc(encryption.TransitDecryptPath, service.authorized(service.decryptHandle))
+
+	go func() {
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		for range reload {
+			if err := provider.Reload(); err != nil {
+				log.Printf("could not reload keys: %v", err)
+			}
+		}
+	}()
+
+	log.Printf("Starting key service on %s", runAddr)
+	log.Fatal(http.ListenAndServe(runAddr, mux))
+}
+
+func (s *kms) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if len(s.token) != 0 && req.Header.Get("Authorization") != "Bearer "+s.token {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h(res, req)
+	}
+}
+
+func (s *kms) keysHandle(res http.ResponseWriter, req *http.Request) {
+	writeJSON(res, encryption.TransitKeysResponse{ActiveKeyID: s.provider.ActiveKeyID()})
+}
+
+func (s *kms) encryptHandle(res http.ResponseWriter, req *http.Request) {
+	var request encryption.TransitEncryptRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := s.provider.Wrap(request.KeyID, request.Plaintext, request.AAD)
+	if err != nil {
+		writeError(res, err)
+		return
+	}
+
+	writeJSON(res, encryption.TransitResponse{Data: data})
+}
+
+func (s *kms) decryptHandle(res http.ResponseWriter, req *http.Request) {
+	var request encryption.TransitDecryptRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := s.provider.Unwrap(request.KeyID, request.Ciphertext, request.AAD)
+	if err != nil {
+		writeError(res, err)
+		return
+	}
+
+	writeJSON(res, encryption.TransitResponse{Data: data})
+}
+
+func writeError(res http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, encryption.ErrUnknownMasterKey):
+		http.Error(res, err.Error(), http.StatusNotFound)
+	case errors.Is(err, encryption.ErrDecrypt), errors.Is(err, encryption.ErrUnknownFormat):
+		http.Error(res, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(res http.ResponseWriter, v any) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write(resp)
+}
@@ -0,0 +1,252 @@
+// Локальный провайдер OpenID Connect для проверки единого входа. Выдает ID-токены
+// любому пользователю, который введет имя на странице входа, и проверяет PKCE.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gophkeep/internal/oidc"
+	"html"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	keyID          = "mockidp-1"
+	codeLifetime   = time.Minute
+	idTokenExpires = time.Minute * 5
+)
+
+// authorization - выданный, но еще не обмененный код авторизации.
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	user          string
+	expires       time.Time
+}
+
+type idp struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	autoUser     string
+	key          *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+func main() {
+	var runAddr string
+	service := &idp{codes: make(map[string]authorization)}
+	flag.StringVar(&runAddr, "a", ":9000", "address to run identity provider")
+	flag.StringVar(&service.issuer, "issuer", "http://localhost:9000", "issuer, the address clients reach the provider at")
+	flag.StringVar(&service.clientID, "client-id", "gophkeep", "the only registered client")
+	flag.StringVar(&service.clientSecret, "client-secret", "", "client secret, not checked if empty")
+	flag.StringVar(&service.autoUser, "auto-user", "", "sign in as this user without showing the login page")
+	flag.Parse()
+
+	service.issuer = strings.TrimSuffix(service.issuer, "/")
+
+	var err error
+	service.key, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidc.DiscoveryPath, service.discoveryHandle)
+	mux.HandleFunc("/authorize", service.authorizeHandle)
+	mux.HandleFunc("/token", service.tokenHandle)
+	mux.HandleFunc("/jwks", service.jwksHandle)
+
+	log.Printf("Starting identity provider %s on %s", service.issuer, runAddr)
+	log.Fatal(http.ListenAndServe(runAddr, mux))
+}
+
+func (s *idp) discoveryHandle(res http.ResponseWriter, req *http.Request) {
+	writeJSON(res, http.StatusOK, oidc.Discovery{
+		Issuer:                s.issuer,
+		AuthorizationEndpoint: s.issuer + "/authorize",
+		TokenEndpoint:         s.issuer + "/token",
+		JWKSURI:               s.issuer + "/jwks",
+	})
+}
+
+func (s *idp) jwksHandle(res http.ResponseWriter, req *http.Request) {
+	writeJSON(res, http.StatusOK, oidc.JWKS{Keys: []oidc.JWK{{
+		KeyType:   "RSA",
+		KeyID:     keyID,
+		Use:       "sig",
+		Algorithm: "RS256",
+		N:         base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+	}}})
+}
+
+// authorizeHandle показывает страницу входа, а после ввода имени возвращает браузер
+// клиенту с кодом авторизации.
+func (s *idp) authorizeHandle(res http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Form.Get("client_id") != s.clientID || req.Form.Get("response_type") != "code" {
+		http.Error(res, "unknown client or response type", http.StatusBadRequest)
+		return
+	}
+	if req.Form.Get("code_challenge_method") != "S256" || len(req.Form.Get("code_challenge")) == 0 {
+		http.Error(res, "S256 code challenge is required", http.StatusBadRequest)
+		return
+	}
+
+	user := strings.TrimSpace(req.Form.Get("user"))
+	if len(user) == 0 && req.Method == http.MethodGet {
+		user = s.autoUser
+	}
+	if len(user) == 0 {
+		s.loginPage(res, req.Form)
+		return
+	}
+
+	code, err := oidc.NewVerifier()
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.Lock()
+	s.codes[code] = authorization{
+		clientID:      s.clientID,
+		redirectURI:   req.Form.Get("redirect_uri"),
+		codeChallenge: req.Form.Get("code_challenge"),
+		nonce:         req.Form.Get("nonce"),
+		user:          user,
+		expires:       time.Now().Add(codeLifetime),
+	}
+	s.mu.Unlock()
+
+	redirect, err := url.Parse(req.Form.Get("redirect_uri"))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := redirect.Query()
+	query.Set("code", code)
+	query.Set("state", req.Form.Get("state"))
+	redirect.RawQuery = query.Encode()
+
+	http.Redirect(res, req, redirect.String(), http.StatusFound)
+}
+
+func (s *idp) loginPage(res http.ResponseWriter, form url.Values) {
+	var fields strings.Builder
+	for _, name := range []string{"client_id", "response_type", "redirect_uri", "state", "nonce", "code_challenge", "code_challenge_method"} {
+		fmt.Fprintf(&fields, `<input type="hidden" name="%s" value="%s">`, name, html.EscapeString(form.Get(name)))
+	}
+
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(res, `<!doctype html><title>mock IdP</title><form method="post" action="/authorize">%s`+
+		`<label>User <input name="user" autofocus></label> <button>Sign in</button></form>`, fields.String())
+}
+
+// tokenHandle обменивает код авторизации на ID-токен.
+func (s *idp) tokenHandle(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		tokenError(res, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	clientID, clientSecret, ok := req.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID = req.Form.Get("client_id")
+	}
+	if clientID != s.clientID ||
+		(len(s.clientSecret) != 0 && subtle.ConstantTimeCompare([]byte(clientSecret), []byte(s.clientSecret)) != 1) {
+		tokenError(res, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	code := req.Form.Get("code")
+	s.mu.Lock()
+	auth, found := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+
+	challenge := oidc.Challenge(req.Form.Get("code_verifier"))
+	if req.Form.Get("grant_type") != "authorization_code" || !found || time.Now().After(auth.expires) ||
+		auth.clientID != clientID || auth.redirectURI != req.Form.Get("redirect_uri") || challenge != auth.codeChallenge {
+		tokenError(res, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := oidc.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.issuer,
+			Subject:   auth.user,
+			Audience:  jwt.ClaimStrings{s.clientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(idTokenExpires)),
+		},
+		Nonce:             auth.nonce,
+		Email:             auth.user + "@example.com",
+		EmailVerified:     true,
+		PreferredUsername: auth.user,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	idToken, err := token.SignedString(s.key)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, err := oidc.NewVerifier()
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, oidc.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		IDToken:     idToken,
+		ExpiresIn:   int(idTokenExpires.Seconds()),
+	})
+}
+
+func tokenError(res http.ResponseWriter, status int, code string) {
+	writeJSON(res, status, map[string]string{"error": code})
+}
+
+func writeJSON(res http.ResponseWriter, status int, v any) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	if err := json.NewEncoder(res).Encode(v); err != nil {
+		log.Printf("could not write response: %v", err)
+	}
+}
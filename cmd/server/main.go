@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"gophkeep/internal/auth"
 	"gophkeep/internal/config"
 	"gophkeep/internal/database"
+	"gophkeep/internal/encryption"
 	"gophkeep/internal/handler"
 	"gophkeep/internal/logger"
+	"gophkeep/internal/ratelimit"
 	"log"
 	"net/http"
 	"os"
@@ -18,6 +21,13 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	keyRotationBatchSize = 100
+	// сессии хранятся еще неделю после отзыва или истечения, чтобы по ним можно было разобрать инцидент
+	sessionRetention       = time.Hour * 24 * 7
+	sessionCleanupInterval = time.Hour
+)
+
 func main() {
 	zapLogger, err := zap.NewDevelopment()
 	if err != nil {
@@ -31,51 +41,190 @@ func main() {
 
 	ctx := context.Background()
 
+	if err := auth.SetPasswordPolicy(cfg.PasswordPolicy()); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := config.CheckRegistration(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := auth.ConfigureTokens(cfg.FlagJWTKeyFile, cfg.FlagJWTIssuer, cfg.FlagJWTAudience); err != nil {
+		log.Fatal(err)
+	}
+
+	keyProvider, err := config.NewKeyProvider(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	encryption.SetKeyProvider(keyProvider)
+
 	env := &handler.Env{
 		ConfigStruct: cfg,
-		Storage:      database.NewDB(ctx, cfg.FlagDBConnectionAddress),
+		Storage:      database.NewDB(ctx, cfg.FlagDBConnectionAddress, cfg.FlagKeyStoreAddress),
 		UserID:       "",
 	}
 
 	defer env.Storage.Close()
 
+	err = auth.ConfigureSessions(env.Storage, cfg.FlagAccessTokenTTL, cfg.FlagRefreshTokenTTL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	auth.SetTokenChecker(env.Storage)
+
+	fakeSaltSecret, err := env.Storage.ServerSecret(ctx, auth.FakeSaltSecretName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	auth.SetFakeSaltSecret(fakeSaltSecret)
+
+	env.Limiter, err = config.NewLimiter(cfg, env.Storage)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ca, tlsConfig, err := config.NewTLS(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ca != nil {
+		env.CA = ca
+		auth.SetCertificateChecker(env.Storage)
+	}
+
+	env.OIDC, err = config.NewOIDC(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// запечатанный сервер перешифрует старые данные после распечатывания
+	if !encryption.Sealed() {
+		err = env.Storage.MigrateEncryptedData(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		sugar.Infow("Server is sealed, submit unseal shares to /api/sys/unseal")
+	}
+
+	rotationCtx, stopRotation := context.WithCancel(ctx)
+	defer stopRotation()
+	go runKeyRotation(rotationCtx, env.Storage, cfg.FlagKeyRotationInterval)
+	go runSessionCleanup(rotationCtx, env.Storage, env.Limiter)
+
 	r := chi.NewRouter()
 	r.Use(logger.LoggingMiddleware)
 	r.Use(auth.CookieMiddleware)
+	r.Use(env.AuditMiddleware)
 
 	r.Get(`/ping`, env.PingDBHandle)
-	r.Get(`/api/user/sync`, env.SyncHandle)
-	r.Get("/api/read", env.ReadHandle)
-	r.Get("/api/readfile", env.ReadFileHandle)
-
-	r.Post("/api/user/register", env.RegisterHandle)
-	r.Post("/api/user/login", env.AuthHandle)
-	r.Post("/api/keepfile", env.KeepFileHandle)
-	r.Post("/api/keep", env.KeepHandle)
-	r.Post("/api/delete", env.DeleteHandle)
-	r.Post("/api/edit", env.EditHandle)
-	r.Post("/api/editfile", env.EditFileHandle)
+	r.Get(`/health`, env.HealthHandle)
+	r.Get("/api/sys/seal-status", env.SealStatusHandle)
+	r.Get("/api/sys/ca", env.CAHandle)
+	r.Post("/api/sys/unseal", env.UnsealHandle)
+	r.Post("/api/sys/seal", env.SealHandle)
+	// сессии не зависят от мастер-ключа, поэтому клиент остается в системе, пока сервер запечатан
+	r.Post("/api/user/refresh", env.RefreshHandle)
+	r.Post("/api/user/logout", env.LogoutHandle)
+	r.Post("/api/user/logout-all", env.LogoutAllHandle)
+	r.Post("/api/user/tokens", env.CreatePersonalTokenHandle)
+	r.Get("/api/user/tokens", env.ListPersonalTokensHandle)
+	r.Post("/api/user/tokens/revoke", env.RevokePersonalTokenHandle)
+	r.Get("/api/user/audit", env.AuditHandle)
+	r.Get("/api/user/devices", env.ListDevicesHandle)
+	r.Post("/api/user/devices/revoke", env.RevokeDeviceHandle)
+	r.Post("/api/user/certificates", env.IssueCertificateHandle)
+	r.Get("/api/user/certificates", env.ListCertificatesHandle)
+	r.Post("/api/user/certificates/revoke", env.RevokeCertificateHandle)
+	r.Get("/api/user/oidc/identities", env.ListOIDCIdentitiesHandle)
+	r.Post("/api/user/oidc/unlink", env.UnlinkOIDCIdentityHandle)
+	r.Get("/api/user/register/mode", env.RegistrationModeHandle)
+	r.Post("/api/user/invites", env.CreateInviteHandle)
+	r.Get("/api/user/invites", env.ListInvitesHandle)
+	r.Post("/api/user/invites/revoke", env.RevokeInviteHandle)
+
+	// управление аккаунтами доступно только сессиям администраторов и не раскрывает записи
+	r.Group(func(r chi.Router) {
+		r.Use(auth.AdminMiddleware)
+
+		r.Get("/api/admin/accounts", env.ListAccountsHandle)
+		r.Post("/api/admin/accounts/disable", env.DisableAccountHandle)
+		r.Post("/api/admin/accounts/enable", env.EnableAccountHandle)
+		r.Post("/api/admin/accounts/logout", env.ForceLogoutHandle)
+		r.Post("/api/admin/accounts/role", env.SetAccountRoleHandle)
+		r.Post("/api/admin/accounts/delete", env.AdminDeleteAccountHandle)
+	})
+
+	// запросы к данным требуют мастер-ключ и отклоняются, пока сервер запечатан
+	r.Group(func(r chi.Router) {
+		r.Use(handler.SealedMiddleware)
+
+		r.Get(`/api/user/sync`, env.SyncHandle)
+		r.Get("/api/read", env.ReadHandle)
+		r.Get("/api/readfile", env.ReadFileHandle)
+		r.Get("/api/find", env.FindHandle)
+		r.Get("/api/user/vault", env.VaultHandle)
+
+		r.Post("/api/user/register", env.RegisterHandle)
+		r.Post("/api/user/login", env.AuthHandle)
+		r.Post("/api/user/login/otp", env.OTPHandle)
+		r.Post("/api/user/login/srp/start", env.SRPStartHandle)
+		r.Post("/api/user/login/srp/verify", env.SRPLoginHandle)
+		r.Post("/api/user/reauth/srp", env.SRPReauthHandle)
+		r.Post("/api/user/oidc/start", env.OIDCStartHandle)
+		r.Post("/api/user/oidc/link/start", env.OIDCLinkStartHandle)
+		r.Get("/api/user/oidc/callback", env.OIDCCallbackHandle)
+		r.Post("/api/user/oidc/finish", env.OIDCFinishHandle)
+		r.Post("/api/user/2fa/enroll", env.EnrollTOTPHandle)
+		r.Post("/api/user/2fa/confirm", env.ConfirmTOTPHandle)
+		r.Post("/api/user/2fa/disable", env.DisableTOTPHandle)
+		r.Post("/api/user/change-password", env.ChangePasswordHandle)
+		r.Post("/api/user/change-login", env.ChangeLoginHandle)
+		r.Post("/api/user/delete", env.DeleteAccountHandle)
+		r.Post("/api/keepfile", env.KeepFileHandle)
+		r.Post("/api/keep", env.KeepHandle)
+		r.Post("/api/delete", env.DeleteHandle)
+		r.Post("/api/edit", env.EditHandle)
+		r.Post("/api/editfile", env.EditFileHandle)
+		r.Post("/api/user/vault", env.EnableVaultHandle)
+	})
 
 	sugar.Infow(
 		"Starting server",
 		"addr", cfg.FlagRunAddr,
+		"mtls", cfg.FlagMTLS,
+		"oidc", cfg.FlagOIDCIssuer,
+		"registration", cfg.FlagRegistration,
 	)
 
 	server := &http.Server{
-		Addr:    cfg.FlagRunAddr,
-		Handler: r,
+		Addr:      cfg.FlagRunAddr,
+		Handler:   r,
+		TLSConfig: tlsConfig,
 	}
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsConfig != nil {
+			// сертификат сервера уже в TLSConfig
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatal(err)
 		}
 	}()
 
+	go reloadKeysOnSignal()
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 	<-stop
 
+	stopRotation()
+
 	shutdownCtx, shutdownRelease := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownRelease()
 
@@ -91,3 +240,118 @@ func main() {
 	env.Storage.Close()
 	sugar.Infow("Server stopped")
 }
+
+// runKeyRotation периодически переоборачивает ключи объектов активным мастер-ключом.
+// Для ротации достаточно добавить новую версию ключа и отправить серверу SIGHUP.
+func runKeyRotation(ctx context.Context, storage database.Storage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		count, err := storage.RewrapKeys(ctx, keyRotationBatchSize)
+		switch {
+		case errors.Is(err, encryption.ErrSealed):
+			// ротация продолжится после распечатывания
+		case err != nil:
+			logger.Sugar.Errorw("Key rotation failed", "error", err)
+		case count > 0:
+			logger.Sugar.Infow("Rewrapped object keys", "count", count)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runSessionCleanup удаляет давно истекшие и отозванные сессии, брошенные рукопожатия SRP,
+// ожидания второго фактора и входы через провайдер, просроченные приглашения, устаревшие счетчики неудачных входов.
+func runSessionCleanup(ctx context.Context, storage database.Storage, limiter *ratelimit.Limiter) {
+	ticker := time.NewTicker(sessionCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		count, err := storage.DeleteExpiredSessions(ctx, sessionRetention)
+		switch {
+		case err != nil:
+			logger.Sugar.Errorw("Session cleanup failed", "error", err)
+		case count > 0:
+			logger.Sugar.Infow("Deleted expired sessions", "count", count)
+		}
+
+		count, err = storage.DeleteExpiredSRPHandshakes(ctx)
+		switch {
+		case err != nil:
+			logger.Sugar.Errorw("SRP handshake cleanup failed", "error", err)
+		case count > 0:
+			logger.Sugar.Infow("Deleted expired SRP handshakes", "count", count)
+		}
+
+		count, err = storage.DeleteExpiredReauthTokens(ctx)
+		switch {
+		case err != nil:
+			logger.Sugar.Errorw("Reauthentication token cleanup failed", "error", err)
+		case count > 0:
+			logger.Sugar.Infow("Deleted expired reauthentication tokens", "count", count)
+		}
+
+		count, err = storage.DeleteExpiredMFAChallenges(ctx)
+		switch {
+		case err != nil:
+			logger.Sugar.Errorw("MFA challenge cleanup failed", "error", err)
+		case count > 0:
+			logger.Sugar.Infow("Deleted expired MFA challenges", "count", count)
+		}
+
+		count, err = storage.DeleteExpiredOIDCLogins(ctx)
+		switch {
+		case err != nil:
+			logger.Sugar.Errorw("OIDC login cleanup failed", "error", err)
+		case count > 0:
+			logger.Sugar.Infow("Deleted expired OIDC logins", "count", count)
+		}
+
+		count, err = storage.DeleteExpiredInvites(ctx)
+		switch {
+		case err != nil:
+			logger.Sugar.Errorw("Invite cleanup failed", "error", err)
+		case count > 0:
+			logger.Sugar.Infow("Deleted expired invites", "count", count)
+		}
+
+		count, err = limiter.DeleteStale(ctx)
+		switch {
+		case err != nil:
+			logger.Sugar.Errorw("Login failures cleanup failed", "error", err)
+		case count > 0:
+			logger.Sugar.Infow("Deleted stale login failures", "count", count)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reloadKeysOnSignal перечитывает мастер-ключи и ключи подписи токенов по SIGHUP.
+func reloadKeysOnSignal() {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	for range reload {
+		if err := auth.ReloadTokenKeys(); err != nil {
+			logger.Sugar.Errorw("Could not reload token signing keys", "error", err)
+		} else {
+			logger.Sugar.Infow("Token signing keys reloaded")
+		}
+
+		if err := encryption.ReloadKeys(); err != nil {
+			logger.Sugar.Errorw("Could not reload master keys", "error", err)
+			continue
+		}
+		logger.Sugar.Infow("Master keys reloaded")
+	}
+}
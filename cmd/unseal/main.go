@@ -0,0 +1,161 @@
+// Утилита оператора для запечатанного сервера: init делит мастер-ключ на доли Шамира,
+// unseal, seal и status обращаются к административным эндпоинтам сервера.
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gophkeep/internal/config"
+	"gophkeep/internal/encryption"
+	"gophkeep/internal/model"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const usage = "usage: unseal init|unseal|seal|status [flags]"
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal(usage)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "init":
+		err = initCommand(os.Args[2:])
+	case "unseal":
+		err = unsealCommand(os.Args[2:])
+	case "seal":
+		err = sealCommand(os.Args[2:])
+	case "status":
+		err = statusCommand(os.Args[2:])
+	default:
+		err = fmt.Errorf("unknown command %q, %s", os.Args[1], usage)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// initCommand создает конфигурацию печати и печатает доли. Существующий файл
+// с ключами можно перевести на доли флагом -key-file, после чего его нужно удалить.
+func initCommand(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	sealFile := fs.String("seal-file", config.DefaultPath(config.SealFileName), "where to write the seal config")
+	keyFile := fs.String("key-file", "", "existing master key file to split instead of generating a new key")
+	shares := fs.Int("shares", 5, "number of shares")
+	threshold := fs.Int("threshold", 3, "number of shares required to unseal")
+	fs.Parse(args)
+
+	var keyring string
+	if len(*keyFile) != 0 {
+		content, err := os.ReadFile(*keyFile)
+		if err != nil {
+			return err
+		}
+		keyring = string(content)
+	}
+
+	parts, err := encryption.InitSeal(*sealFile, keyring, *shares, *threshold)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Seal config written to %s. Give each share to a different operator:\n", *sealFile)
+	for i, part := range parts {
+		fmt.Printf("Share %d: %s\n", i+1, base64.StdEncoding.EncodeToString(part))
+	}
+	if len(*keyFile) != 0 {
+		fmt.Printf("Remove %s and start the server with -key-provider shamir.\n", *keyFile)
+	}
+
+	return nil
+}
+
+func unsealCommand(args []string) error {
+	fs := flag.NewFlagSet("unseal", flag.ExitOnError)
+	address := fs.String("a", "http://localhost:8080", "server address")
+	share := fs.String("share", "", "share in base64, asked interactively when empty")
+	fs.Parse(args)
+
+	if len(*share) == 0 {
+		fmt.Fprint(os.Stderr, "Unseal share: ")
+		input, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+		*share = strings.TrimSpace(string(input))
+	}
+
+	body, err := json.Marshal(model.UnsealRequest{Share: *share})
+	if err != nil {
+		return err
+	}
+
+	return call(http.MethodPost, *address+"/api/sys/unseal", "", body)
+}
+
+func sealCommand(args []string) error {
+	fs := flag.NewFlagSet("seal", flag.ExitOnError)
+	address := fs.String("a", "http://localhost:8080", "server address")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "admin token of the server")
+	fs.Parse(args)
+
+	return call(http.MethodPost, *address+"/api/sys/seal", *token, nil)
+}
+
+func statusCommand(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	address := fs.String("a", "http://localhost:8080", "server address")
+	fs.Parse(args)
+
+	return call(http.MethodGet, *address+"/api/sys/seal-status", "", nil)
+}
+
+func call(method string, url string, token string, body []byte) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(token) != 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	response, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server responded with status %d: %s", res.StatusCode, strings.TrimSpace(string(response)))
+	}
+
+	var status encryption.SealStatus
+	if err := json.Unmarshal(response, &status); err != nil {
+		return err
+	}
+
+	state := "unsealed"
+	if status.Sealed {
+		state = "sealed"
+	}
+	fmt.Printf("Server is %s, shares submitted: %d of %d (total %d)\n", state, status.Progress, status.Threshold, status.Shares)
+	return nil
+}
@@ -2,74 +2,101 @@ package auth
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"net/http"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
-const TokenExp = time.Hour * 3
-const SecretKey = "tratatata"
 type userIDKey int
 
 const (
-    KeyUserID userIDKey = iota
+	KeyUserID userIDKey = iota
+	KeySessionID
+	KeyRole
 )
 
+// Claims - утверждения токена доступа. Кроме UserID токен содержит сессию sid, издателя,
+// аудиторию, время выпуска и уникальный идентификатор jti. Токены с непустым Purpose
+// выдаются для отдельных шагов, например второго фактора, и не дают доступа к API.
+// Role - роль аккаунта на момент выпуска, при смене роли сессии аккаунта отзываются.
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID string
+	UserID    string
+	SessionID string `json:"sid,omitempty"`
+	Purpose   string `json:"pur,omitempty"`
+	Role      string `json:"role,omitempty"`
 }
 
-func CookieIsValid(r *http.Request) (string, bool) {
-	cookie, err := r.Cookie("auth_token")
+func CookieIsValid(r *http.Request) (*Claims, bool) {
+	cookie, err := r.Cookie(AccessCookieName)
 	// проверяем есть ли кука
 	if err != nil {
-		return "", false
+		return nil, false
 	}
 
 	// в случае если кука есть проверяем что она проходит проверку подлинности
-	token := cookie.Value
-	id, ok := GetUserID(token)
-	return id, ok
+	return ParseAccessToken(cookie.Value)
 }
 
 func GetUserID(tokenString string) (string, bool) {
+	claims, ok := ParseAccessToken(tokenString)
+	if !ok {
+		return "", false
+	}
+	return claims.UserID, true
+}
+
+// ParseAccessToken проверяет подпись и утверждения токена доступа. Отзыв сессии
+// здесь не проверяется, это делает CookieMiddleware.
+func ParseAccessToken(tokenString string) (*Claims, bool) {
+	claims, ok := parseToken(tokenString, "")
+	if !ok || len(claims.SessionID) == 0 {
+		return nil, false
+	}
+	return claims, true
+}
+
+func parseToken(tokenString string, purpose string) (*Claims, bool) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims,
-		func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-			}
-			return []byte(SecretKey), nil
-		})
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey)
 	if err != nil {
-		return "", false
+		return nil, false
 	}
 
 	if !token.Valid {
 		log.Printf("Token is not valid")
-		return "", false
+		return nil, false
+	}
+
+	issuer, audience := expectedClaims()
+	if !claims.VerifyIssuer(issuer, true) || !claims.VerifyAudience(audience, true) || claims.IssuedAt == nil ||
+		claims.Purpose != purpose {
+		log.Printf("Token is not valid")
+		return nil, false
 	}
 
 	log.Printf("Token is valid")
-	return claims.UserID, true
+	return claims, true
 }
 
-func CreateNewCookie(id string) (http.Cookie, error) {
-	tokenString, err := buildJWTString(id)
+// CreateNewCookie создает куку с токеном доступа сессии sessionID аккаунта с ролью role.
+func CreateNewCookie(id string, sessionID string, role string) (http.Cookie, error) {
+	_, exp := sessionSettings()
+	tokenString, err := buildJWTString(id, sessionID, role, "", exp)
 	if err != nil {
 		return http.Cookie{}, err
 	}
 	// создание новой куки для юзера если такой куки не существует или она не проходит проверку подлинности
 	cookie := http.Cookie{
-		Name:     "auth_token",
+		Name:     AccessCookieName,
 		Value:    tokenString,
 		Path:     "/",
-		MaxAge:   3600 * 3,
+		MaxAge:   int(exp.Seconds()),
 		HttpOnly: true,
 		Secure:   false,
 	}
@@ -77,20 +104,39 @@ func CreateNewCookie(id string) (http.Cookie, error) {
 	return cookie, nil
 }
 
-func buildJWTString(newID string) (string, error) {
-	// создаём новый токен с алгоритмом подписи HS256 и утверждениями — Claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+func buildJWTString(newID string, sessionID string, role string, purpose string, exp time.Duration) (string, error) {
+	return buildJWTStringWithID(uuid.New().String(), newID, sessionID, role, purpose, exp)
+}
+
+// buildJWTStringWithID создает токен с заданным jti, по которому сервер находит его в базе.
+func buildJWTStringWithID(tokenID string, newID string, sessionID string, role string, purpose string, exp time.Duration) (string, error) {
+	key, issuer, audience, err := signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(key.method, Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			// когда создан токен
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenExp)),
+			Issuer:    issuer,
+			Subject:   newID,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(exp)),
+			ID:        tokenID,
 		},
 		// собственное утверждение
 
-		UserID: newID,
+		UserID:    newID,
+		SessionID: sessionID,
+		Purpose:   purpose,
+		Role:      role,
 	})
+	// по kid сервер находит ключ для проверки, в том числе после ротации
+	token.Header["kid"] = key.id
 
 	// создаём строку токена
-	tokenString, err := token.SignedString([]byte(SecretKey))
+	tokenString, err := token.SignedString(key.signKey)
 	if err != nil {
 		return "", err
 	}
@@ -99,18 +145,62 @@ func buildJWTString(newID string) (string, error) {
 	return tokenString, nil
 }
 
-// CookieMiddleware создает куки если её не было, и добавляет к запросу и к ответу.
+// CookieMiddleware пропускает запрос, если токен доступа действителен и его сессия не отозвана,
+// и добавляет аккаунт и сессию в контекст запроса. Токен доступа берется из куки или из
+// заголовка "Authorization: Bearer", там же принимаются персональные токены. Без них
+// запрос проходит по проверенному клиентскому сертификату (SetCertificateChecker).
 func CookieMiddleware(h http.Handler) http.Handler {
 	cookieFn := func(w http.ResponseWriter, r *http.Request) {
-		skipPaths := []string{"/ping", "/api/user/login", "/api/user/register"}
+		skipPaths := []string{"/ping", "/health", "/api/user/login", "/api/user/login/otp", "/api/user/register", "/api/user/register/mode", RefreshPath,
+			"/api/user/login/srp/start", "/api/user/login/srp/verify",
+			"/api/user/oidc/start", "/api/user/oidc/callback", "/api/user/oidc/finish",
+			"/api/sys/unseal", "/api/sys/seal", "/api/sys/seal-status", "/api/sys/ca"}
 
 		if !slices.Contains(skipPaths, r.URL.Path) {
-			userID, ok := CookieIsValid(r)
+			bearer, hasBearer := bearerToken(r)
+			if hasBearer && strings.HasPrefix(bearer, PersonalTokenPrefix) {
+				servePersonalToken(h, w, r, bearer)
+				return
+			}
+
+			// клиентский сертификат - запасной способ входа, когда нет ни куки, ни токена
+			if _, err := r.Cookie(AccessCookieName); err != nil && !hasBearer {
+				if cert, ok := peerCertificate(r); ok {
+					serveCertificate(h, w, r, cert)
+					return
+				}
+			}
+
+			var claims *Claims
+			var ok bool
+			if hasBearer {
+				claims, ok = ParseAccessToken(bearer)
+			} else {
+				claims, ok = CookieIsValid(r)
+			}
 			if !ok {
 				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
-			ctx := context.WithValue(r.Context(), KeyUserID, userID)
+
+			checker, _ := sessionSettings()
+			if checker == nil {
+				http.Error(w, "sessions are not configured", http.StatusInternalServerError)
+				return
+			}
+
+			active, err := checker.SessionActive(r.Context(), claims.SessionID, claims.UserID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !active {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			ctx := WithSessionID(WithUserID(r.Context(), claims.UserID), claims.SessionID)
+			ctx = context.WithValue(ctx, KeyRole, claims.Role)
 			h.ServeHTTP(w, r.WithContext(ctx))
 		} else {
 			h.ServeHTTP(w, r)
@@ -118,3 +208,14 @@ func CookieMiddleware(h http.Handler) http.Handler {
 	}
 	return http.HandlerFunc(cookieFn)
 }
+
+// WithUserID добавляет в контекст аутентифицированный аккаунт.
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, KeyUserID, userID)
+}
+
+// UserIDFromContext возвращает аккаунт, от имени которого выполняется запрос.
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(KeyUserID).(string)
+	return userID, ok && len(userID) != 0
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"gophkeep/internal/mtls"
+	"net/http"
+	"sync"
+)
+
+// CertificateChecker находит действующий клиентский сертификат по серийному номеру и
+// возвращает аккаунт, которому он выдан, и вид удостоверения (mtls.KindAccount или
+// mtls.KindService).
+type CertificateChecker interface {
+	CheckClientCertificate(ctx context.Context, serial string) (string, string, bool, error)
+}
+
+type certificateKey int
+
+const keyCertificateSerial certificateKey = iota
+
+var (
+	certificateCheckerMu sync.RWMutex
+	certificateChecker   CertificateChecker
+)
+
+// SetCertificateChecker включает вход по клиентским сертификатам: CookieMiddleware
+// принимает проверенный сертификат, если в запросе нет куки и токена.
+func SetCertificateChecker(checker CertificateChecker) {
+	certificateCheckerMu.Lock()
+	defer certificateCheckerMu.Unlock()
+
+	certificateChecker = checker
+}
+
+func currentCertificateChecker() CertificateChecker {
+	certificateCheckerMu.RLock()
+	defer certificateCheckerMu.RUnlock()
+
+	return certificateChecker
+}
+
+// peerCertificate возвращает клиентский сертификат, цепочку которого проверил TLS.
+func peerCertificate(r *http.Request) (*x509.Certificate, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	return r.TLS.VerifiedChains[0][0], true
+}
+
+// serveCertificate пропускает запрос по клиентскому сертификату. Сертификат аккаунта
+// действует как сессия, сертификату сервиса доступны те же эндпоинты данных, что и
+// персональному токену.
+func serveCertificate(h http.Handler, w http.ResponseWriter, r *http.Request, cert *x509.Certificate) {
+	checker := currentCertificateChecker()
+	if checker == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	serial := mtls.Serial(cert)
+	userID, kind, ok, err := checker.CheckClientCertificate(r.Context(), serial)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if kind == mtls.KindService {
+		if _, allowed := personalTokenRoutes[r.Method+" "+r.URL.Path]; !allowed {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	ctx := context.WithValue(WithUserID(r.Context(), userID), keyCertificateSerial, serial)
+	h.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// CertificateFromContext возвращает серийный номер клиентского сертификата, которым
+// аутентифицирован запрос.
+func CertificateFromContext(ctx context.Context) (string, bool) {
+	serial, ok := ctx.Value(keyCertificateSerial).(string)
+	return serial, ok && len(serial) != 0
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"strings"
+)
+
+// inviteCodeLength - длина кода приглашения без разделителей, 75 бит
+const inviteCodeLength = 15
+
+// NewInviteCode создает одноразовый код приглашения вида "xxxxx-xxxxx-xxxxx".
+func NewInviteCode() (string, error) {
+	raw := make([]byte, inviteCodeLength*5/8)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(recoveryEncoding.EncodeToString(raw))
+	return code[:5] + "-" + code[5:10] + "-" + code[10:], nil
+}
+
+// HashInviteCode возвращает хеш кода приглашения для хранения. Регистр и разделители
+// при вводе не важны.
+func HashInviteCode(code string) []byte {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return sum[:]
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"strings"
+	"time"
+)
+
+const (
+	// MFATokenExp - сколько после проверки пароля можно ввести второй фактор.
+	MFATokenExp = time.Minute * 5
+	// MFAMaxAttempts - сколько кодов можно проверить по одному токену ожидания.
+	MFAMaxAttempts = 5
+	// RecoveryCodeCount - сколько одноразовых кодов восстановления выдается при включении 2FA.
+	RecoveryCodeCount = 10
+
+	mfaTokenPurpose    = "mfa"
+	recoveryCodeLength = 10
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// CreateMFAToken выдает токен ожидания второго фактора. Он подтверждает только то,
+// что пароль аккаунта был введен верно, и обменивается на сессию после проверки кода.
+// challengeID - ожидание в базе, по которому считаются попытки ввода кода.
+func CreateMFAToken(userID string, challengeID string) (string, error) {
+	return buildJWTString(userID, challengeID, "", mfaTokenPurpose, MFATokenExp)
+}
+
+// ParseMFAToken проверяет токен ожидания второго фактора и возвращает его аккаунт и ожидание.
+func ParseMFAToken(tokenString string) (string, string, bool) {
+	claims, ok := parseToken(tokenString, mfaTokenPurpose)
+	if !ok || len(claims.SessionID) == 0 {
+		return "", "", false
+	}
+	return claims.UserID, claims.SessionID, true
+}
+
+// GenerateRecoveryCodes создает одноразовые коды восстановления вида "xxxxx-xxxxx".
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		raw := make([]byte, recoveryCodeLength*5/8)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(recoveryEncoding.EncodeToString(raw))
+		codes = append(codes, code[:recoveryCodeLength/2]+"-"+code[recoveryCodeLength/2:])
+	}
+
+	return codes, nil
+}
+
+// HashRecoveryCode возвращает хеш кода восстановления для хранения. Регистр и
+// разделители при вводе не важны.
+func HashRecoveryCode(code string) []byte {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return sum[:]
+}
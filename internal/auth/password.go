@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Пароли хранятся строками в формате PHC ($argon2id$v=19$m=...,t=...,p=...$соль$хеш)
+// или bcrypt ($2a$...). Параметры хеша записаны в самой строке, поэтому после смены
+// политики старые хеши проверяются как раньше и пересчитываются при входе.
+const (
+	PasswordArgon2id = "argon2id"
+	PasswordBcrypt   = "bcrypt"
+
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var (
+	ErrUnknownPasswordHash = errors.New("unknown password hash format")
+	// ErrPasswordTooLong - bcrypt учитывает только первые 72 байта, более длинный пароль не принимается.
+	ErrPasswordTooLong = errors.New("password is too long, bcrypt accepts at most 72 bytes")
+)
+
+// PasswordPolicy - алгоритм и параметры, которыми хешируются новые пароли.
+type PasswordPolicy struct {
+	Algorithm string
+	// Argon2Time - число проходов, Argon2Memory - память в КиБ.
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+	BcryptCost    int
+}
+
+// DefaultPasswordPolicy - параметры Argon2id из рекомендаций OWASP.
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		Algorithm:     PasswordArgon2id,
+		Argon2Time:    3,
+		Argon2Memory:  64 * 1024,
+		Argon2Threads: 2,
+		BcryptCost:    12,
+	}
+}
+
+var (
+	policyMu       sync.RWMutex
+	passwordPolicy = DefaultPasswordPolicy()
+	// dummyHash проверяется при входе под несуществующим логином, чтобы время ответа не выдавало, есть ли такой логин
+	dummyHash string
+)
+
+// SetPasswordPolicy задает политику хеширования. Неизвестный алгоритм - ошибка конфигурации.
+func SetPasswordPolicy(policy PasswordPolicy) error {
+	switch policy.Algorithm {
+	case PasswordArgon2id:
+		if policy.Argon2Time == 0 || policy.Argon2Memory == 0 || policy.Argon2Threads == 0 {
+			return errors.New("argon2id parameters must be positive")
+		}
+	case PasswordBcrypt:
+		if policy.BcryptCost < bcrypt.MinCost || policy.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return fmt.Errorf("unknown password hash algorithm %q", policy.Algorithm)
+	}
+
+	policyMu.Lock()
+	defer policyMu.Unlock()
+
+	passwordPolicy = policy
+	dummyHash = ""
+	return nil
+}
+
+func currentPasswordPolicy() PasswordPolicy {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+
+	return passwordPolicy
+}
+
+// HashPassword хеширует пароль со случайной солью по текущей политике. Для bcrypt пароль
+// длиннее 72 байт дает ErrPasswordTooLong.
+func HashPassword(password string) (string, error) {
+	policy := currentPasswordPolicy()
+	if policy.Algorithm == PasswordBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), policy.BcryptCost)
+		if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+			return "", ErrPasswordTooLong
+		}
+		return string(hash), err
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, policy.Argon2Time, policy.Argon2Memory, policy.Argon2Threads, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		policy.Argon2Memory, policy.Argon2Time, policy.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// VerifyPassword сравнивает пароль с хешем за постоянное время. rehash = true, если
+// хеш сделан не по текущей политике и его стоит пересчитать, пока пароль известен.
+func VerifyPassword(password string, encoded string) (ok bool, rehash bool, err error) {
+	policy := currentPasswordPolicy()
+
+	if strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$") {
+		err = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return false, false, err
+		}
+
+		return true, policy.Algorithm != PasswordBcrypt || cost != policy.BcryptCost, nil
+	}
+
+	params, salt, hash, err := parseArgon2Hash(encoded)
+	if err != nil {
+		return false, false, err
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, params.Argon2Time, params.Argon2Memory, params.Argon2Threads, uint32(len(hash)))
+	if subtle.ConstantTimeCompare(computed, hash) != 1 {
+		return false, false, nil
+	}
+
+	rehash = policy.Algorithm != PasswordArgon2id ||
+		params.Argon2Time != policy.Argon2Time ||
+		params.Argon2Memory != policy.Argon2Memory ||
+		params.Argon2Threads != policy.Argon2Threads ||
+		len(salt) != argon2SaltLength || len(hash) != argon2KeyLength
+
+	return true, rehash, nil
+}
+
+// VerifyLegacyPassword сравнивает пароль с паролем, сохраненным открытым текстом до
+// появления хеширования. Сравниваются дайджесты, чтобы время не зависело от длины.
+func VerifyLegacyPassword(password string, stored string) bool {
+	given := sha256.Sum256([]byte(password))
+	expected := sha256.Sum256([]byte(stored))
+
+	return subtle.ConstantTimeCompare(given[:], expected[:]) == 1
+}
+
+// SimulatePasswordCheck тратит на проверку столько же времени, сколько настоящая проверка.
+// Вызывается, когда логина нет в базе.
+func SimulatePasswordCheck(password string) {
+	policyMu.RLock()
+	hash := dummyHash
+	policyMu.RUnlock()
+
+	if len(hash) == 0 {
+		var err error
+		hash, err = HashPassword("gophkeep dummy password")
+		if err != nil {
+			return
+		}
+
+		policyMu.Lock()
+		dummyHash = hash
+		policyMu.Unlock()
+	}
+
+	VerifyPassword(password, hash)
+}
+
+func parseArgon2Hash(encoded string) (PasswordPolicy, []byte, []byte, error) {
+	params := PasswordPolicy{Algorithm: PasswordArgon2id}
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", соль, хеш
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != PasswordArgon2id {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Argon2Memory, &params.Argon2Time, &params.Argon2Threads)
+	if err != nil || params.Argon2Time == 0 || params.Argon2Memory == 0 || params.Argon2Threads == 0 {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(hash) == 0 {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	return params, salt, hash, nil
+}
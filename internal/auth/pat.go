@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"gophkeep/internal/model"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Персональный токен доступа передается в заголовке "Authorization: Bearer gkp_<id>_<секрет>".
+// По id сервер находит токен, а секрет хранит только в виде хеша.
+const (
+	PersonalTokenPrefix = "gkp_"
+
+	personalTokenIDLength     = 16
+	personalTokenSecretLength = 32
+)
+
+var ErrInvalidPersonalToken = errors.New("invalid personal access token")
+
+// personalTokenRoutes - запросы, доступные по персональному токену, и признак того,
+// что запрос изменяет данные. Управление токенами, сессиями и аккаунтом токену недоступно.
+var personalTokenRoutes = map[string]bool{
+	"GET /api/user/sync":  false,
+	"GET /api/read":       false,
+	"GET /api/readfile":   false,
+	"GET /api/find":       false,
+	"GET /api/user/vault": false,
+	"POST /api/keep":      true,
+	"POST /api/keepfile":  true,
+	"POST /api/edit":      true,
+	"POST /api/editfile":  true,
+	"POST /api/delete":    true,
+}
+
+// TokenChecker находит действующий персональный токен и возвращает его аккаунт и ограничения.
+type TokenChecker interface {
+	CheckPersonalToken(ctx context.Context, tokenID string, tokenHash []byte) (string, model.TokenScopes, bool, error)
+}
+
+type personalTokenKey int
+
+const (
+	keyPersonalTokenID personalTokenKey = iota
+	keyTokenScopes
+)
+
+var (
+	tokenCheckerMu sync.RWMutex
+	tokenChecker   TokenChecker
+)
+
+// SetTokenChecker задает хранилище, по которому CookieMiddleware проверяет персональные токены.
+func SetTokenChecker(checker TokenChecker) {
+	tokenCheckerMu.Lock()
+	defer tokenCheckerMu.Unlock()
+
+	tokenChecker = checker
+}
+
+func currentTokenChecker() TokenChecker {
+	tokenCheckerMu.RLock()
+	defer tokenCheckerMu.RUnlock()
+
+	return tokenChecker
+}
+
+// NewPersonalToken создает персональный токен. Возвращает его id, сам токен и хеш секрета.
+func NewPersonalToken() (string, string, []byte, error) {
+	id := make([]byte, personalTokenIDLength)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", nil, err
+	}
+
+	secret := make([]byte, personalTokenSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", nil, err
+	}
+
+	tokenID := hex.EncodeToString(id)
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	return tokenID, PersonalTokenPrefix + tokenID + "_" + encoded, hashTokenSecret(encoded), nil
+}
+
+// ParsePersonalToken возвращает id персонального токена и хеш его секрета.
+func ParsePersonalToken(token string) (string, []byte, error) {
+	rest, ok := strings.CutPrefix(token, PersonalTokenPrefix)
+	if !ok {
+		return "", nil, ErrInvalidPersonalToken
+	}
+
+	tokenID, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(tokenID) != hex.EncodedLen(personalTokenIDLength) || len(secret) == 0 {
+		return "", nil, ErrInvalidPersonalToken
+	}
+
+	return tokenID, hashTokenSecret(secret), nil
+}
+
+// bearerToken возвращает токен из заголовка Authorization.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || len(token) == 0 {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// servePersonalToken пропускает запрос по персональному токену, если маршрут ему доступен.
+func servePersonalToken(h http.Handler, w http.ResponseWriter, r *http.Request, token string) {
+	tokenID, tokenHash, err := ParsePersonalToken(token)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	checker := currentTokenChecker()
+	if checker == nil {
+		http.Error(w, "personal access tokens are not configured", http.StatusInternalServerError)
+		return
+	}
+
+	userID, scopes, ok, err := checker.CheckPersonalToken(r.Context(), tokenID, tokenHash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	write, allowed := personalTokenRoutes[r.Method+" "+r.URL.Path]
+	if !allowed || (write && scopes.ReadOnly) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	ctx := WithUserID(r.Context(), userID)
+	ctx = context.WithValue(ctx, keyPersonalTokenID, tokenID)
+	ctx = context.WithValue(ctx, keyTokenScopes, scopes)
+	h.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// PersonalTokenFromContext возвращает персональный токен, которым аутентифицирован запрос.
+func PersonalTokenFromContext(ctx context.Context) (string, bool) {
+	tokenID, ok := ctx.Value(keyPersonalTokenID).(string)
+	return tokenID, ok && len(tokenID) != 0
+}
+
+// ScopesFromContext возвращает ограничения персонального токена запроса. Запросы
+// из сессии ограничений не имеют, для них возвращается false.
+func ScopesFromContext(ctx context.Context) (model.TokenScopes, bool) {
+	scopes, ok := ctx.Value(keyTokenScopes).(model.TokenScopes)
+	return scopes, ok
+}
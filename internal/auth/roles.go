@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+)
+
+// Роли аккаунтов.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// ValidRole сообщает, что роль существует.
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleAdmin
+}
+
+// RoleFromContext возвращает роль из токена доступа запроса. У запросов по персональному
+// токену и клиентскому сертификату роли нет.
+func RoleFromContext(ctx context.Context) string {
+	role, _ := ctx.Value(KeyRole).(string)
+	return role
+}
+
+// AdminMiddleware пропускает только запросы сессий администраторов.
+func AdminMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if RoleFromContext(r.Context()) != RoleAdmin {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
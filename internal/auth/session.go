@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Сессия - это пара токенов: короткий токен доступа (JWT в куке auth_token) и долгий
+// токен обновления "<id сессии>.<секрет>" в куке refresh_token. Сервер хранит только
+// хеш последнего выданного секрета, при каждом обновлении секрет меняется.
+const (
+	AccessCookieName  = "auth_token"
+	RefreshCookieName = "refresh_token"
+	// RefreshPath - единственный путь, на который браузер отправляет куку обновления.
+	RefreshPath = "/api/user/refresh"
+
+	DefaultAccessTokenExp  = time.Minute * 15
+	DefaultRefreshTokenExp = time.Hour * 24 * 30
+
+	refreshSecretLength = 32
+)
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// SessionChecker сообщает, не отозвана ли сессия аккаунта и не отключен ли сам аккаунт.
+type SessionChecker interface {
+	SessionActive(ctx context.Context, sessionID string, userID string) (bool, error)
+}
+
+var (
+	sessionMu       sync.RWMutex
+	sessionChecker  SessionChecker
+	accessTokenExp  = DefaultAccessTokenExp
+	refreshTokenExp = DefaultRefreshTokenExp
+)
+
+// ConfigureSessions задает время жизни токенов и хранилище, по которому
+// CookieMiddleware проверяет отзыв сессий.
+func ConfigureSessions(checker SessionChecker, accessExp time.Duration, refreshExp time.Duration) error {
+	if accessExp <= 0 || refreshExp <= 0 {
+		return errors.New("token lifetimes must be positive")
+	}
+	if refreshExp < accessExp {
+		return errors.New("refresh token must live longer than access token")
+	}
+
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
+	sessionChecker = checker
+	accessTokenExp = accessExp
+	refreshTokenExp = refreshExp
+	return nil
+}
+
+// RefreshTokenExp возвращает время жизни сессии без обновления.
+func RefreshTokenExp() time.Duration {
+	sessionMu.RLock()
+	defer sessionMu.RUnlock()
+
+	return refreshTokenExp
+}
+
+func sessionSettings() (SessionChecker, time.Duration) {
+	sessionMu.RLock()
+	defer sessionMu.RUnlock()
+
+	return sessionChecker, accessTokenExp
+}
+
+// NewRefreshToken создает токен обновления сессии и хеш его секрета для хранения на сервере.
+func NewRefreshToken(sessionID string) (string, []byte, error) {
+	secret := make([]byte, refreshSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	return sessionID + "." + encoded, hashTokenSecret(encoded), nil
+}
+
+// ParseRefreshToken возвращает сессию токена обновления и хеш его секрета.
+func ParseRefreshToken(token string) (string, []byte, error) {
+	sessionID, secret, ok := strings.Cut(token, ".")
+	if !ok || len(sessionID) == 0 || len(secret) == 0 {
+		return "", nil, ErrInvalidRefreshToken
+	}
+
+	return sessionID, hashTokenSecret(secret), nil
+}
+
+func hashTokenSecret(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// CreateRefreshCookie создает куку с токеном обновления.
+func CreateRefreshCookie(token string) http.Cookie {
+	return http.Cookie{
+		Name:     RefreshCookieName,
+		Value:    token,
+		Path:     RefreshPath,
+		MaxAge:   int(RefreshTokenExp().Seconds()),
+		HttpOnly: true,
+		Secure:   false,
+	}
+}
+
+// ExpiredCookies возвращает куки, которые удаляют токены сессии из браузера.
+func ExpiredCookies() []http.Cookie {
+	return []http.Cookie{
+		{Name: AccessCookieName, Path: "/", MaxAge: -1, HttpOnly: true},
+		{Name: RefreshCookieName, Path: RefreshPath, MaxAge: -1, HttpOnly: true},
+	}
+}
+
+// WithSessionID добавляет в контекст сессию, которой выдан токен запроса.
+func WithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, KeySessionID, sessionID)
+}
+
+// SessionIDFromContext возвращает сессию, от имени которой выполняется запрос.
+func SessionIDFromContext(ctx context.Context) (string, bool) {
+	sessionID, ok := ctx.Value(KeySessionID).(string)
+	return sessionID, ok && len(sessionID) != 0
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"gophkeep/internal/srp"
+	"sync"
+	"time"
+)
+
+const (
+	// SRPHandshakeExp - сколько ждать второй шаг входа по SRP.
+	SRPHandshakeExp = time.Minute
+	// ReauthTokenExp - сколько после повторной проверки пароля можно изменять аккаунт.
+	ReauthTokenExp = time.Minute * 5
+
+	// FakeSaltSecretName - имя секрета фиктивных солей в хранилище секретов сервера.
+	FakeSaltSecretName = "srp_fake_salt"
+
+	reauthTokenPurpose = "reauth"
+	fakeSaltLabel      = "gophkeep srp fake salt"
+)
+
+// ErrNoFakeSaltSecret - секрет фиктивных солей не задан.
+var ErrNoFakeSaltSecret = errors.New("fake SRP salt secret is not configured")
+
+var (
+	fakeSaltMu     sync.RWMutex
+	fakeSaltSecret []byte
+)
+
+// CreateReauthToken выдает сессии sessionID токен повторной проверки пароля по SRP.
+// Им подтверждаются смена пароля или логина, удаление аккаунта и отключение 2FA.
+// tokenID - строка в базе, которая удаляется при использовании токена.
+func CreateReauthToken(userID string, sessionID string, tokenID string) (string, error) {
+	return buildJWTStringWithID(tokenID, userID, sessionID, "", reauthTokenPurpose, ReauthTokenExp)
+}
+
+// ParseReauthToken проверяет токен повторной проверки пароля и возвращает его аккаунт,
+// сессию и идентификатор токена.
+func ParseReauthToken(tokenString string) (string, string, string, bool) {
+	claims, ok := parseToken(tokenString, reauthTokenPurpose)
+	if !ok || len(claims.SessionID) == 0 || len(claims.ID) == 0 {
+		return "", "", "", false
+	}
+	return claims.UserID, claims.SessionID, claims.ID, true
+}
+
+// SetFakeSaltSecret задает секрет, из которого выводятся фиктивные соли. Он хранится
+// отдельно от ключей подписи и не меняется при их ротации.
+func SetFakeSaltSecret(secret []byte) {
+	fakeSaltMu.Lock()
+	defer fakeSaltMu.Unlock()
+	fakeSaltSecret = append([]byte(nil), secret...)
+}
+
+// FakeSRPSalt возвращает соль для несуществующего логина. Она постоянна для логина,
+// поэтому по ответу на первый шаг входа нельзя понять, зарегистрирован ли логин.
+func FakeSRPSalt(login string) ([]byte, error) {
+	fakeSaltMu.RLock()
+	defer fakeSaltMu.RUnlock()
+	if len(fakeSaltSecret) == 0 {
+		return nil, ErrNoFakeSaltSecret
+	}
+
+	mac := hmac.New(sha256.New, fakeSaltSecret)
+	mac.Write([]byte(fakeSaltLabel))
+	mac.Write([]byte(login))
+	return mac.Sum(nil)[:srp.SaltLength], nil
+}
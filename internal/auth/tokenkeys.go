@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Ключи подписи токенов хранятся в файле строками "<kid>:<алгоритм>:<ключ в base64>".
+// Токены подписываются ключом из последней строки, а проверяются любым ключом из файла,
+// поэтому для ротации достаточно дописать новый ключ и отправить серверу SIGHUP.
+// Старый ключ удаляется из файла, когда истекут выданные им токены.
+const (
+	TokenAlgEdDSA = "EdDSA"
+	TokenAlgHS256 = "HS256"
+
+	minHMACKeyLength = 32
+)
+
+var ErrNoTokenKeys = errors.New("token signing keys are not configured")
+
+type tokenKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+}
+
+type tokenKeyring struct {
+	keys   map[string]tokenKey
+	active tokenKey
+}
+
+var (
+	tokenMu       sync.RWMutex
+	tokenKeys     *tokenKeyring
+	tokenKeyPath  string
+	tokenIssuer   = "gophkeep"
+	tokenAudience = "gophkeep"
+)
+
+// ConfigureTokens загружает ключи подписи из keyPath и задает издателя и аудиторию токенов.
+// Если файла нет, он создается с новым ключом Ed25519.
+func ConfigureTokens(keyPath string, issuer string, audience string) error {
+	if _, err := os.Stat(keyPath); errors.Is(err, os.ErrNotExist) {
+		line, err := GenerateTokenKey(TokenAlgEdDSA)
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(keyPath, []byte(line+"\n"), 0600); err != nil {
+			return err
+		}
+	}
+
+	ring, err := loadTokenKeyring(keyPath)
+	if err != nil {
+		return err
+	}
+
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+
+	tokenKeys = ring
+	tokenKeyPath = keyPath
+	tokenIssuer = issuer
+	tokenAudience = audience
+	return nil
+}
+
+// ReloadTokenKeys перечитывает файл с ключами подписи.
+func ReloadTokenKeys() error {
+	tokenMu.RLock()
+	path := tokenKeyPath
+	tokenMu.RUnlock()
+
+	if len(path) == 0 {
+		return ErrNoTokenKeys
+	}
+
+	ring, err := loadTokenKeyring(path)
+	if err != nil {
+		return err
+	}
+
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+
+	tokenKeys = ring
+	return nil
+}
+
+// GenerateTokenKey создает строку для файла ключей с новым случайным ключом.
+func GenerateTokenKey(alg string) (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	var secret []byte
+	switch alg {
+	case TokenAlgEdDSA:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		secret = privateKey.Seed()
+	case TokenAlgHS256:
+		secret = make([]byte, minHMACKeyLength)
+		if _, err := rand.Read(secret); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown token signing algorithm %q", alg)
+	}
+
+	return hex.EncodeToString(id) + ":" + alg + ":" + base64.StdEncoding.EncodeToString(secret), nil
+}
+
+func loadTokenKeyring(path string) (*tokenKeyring, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ring := &tokenKeyring{keys: make(map[string]tokenKey)}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, err := parseTokenKey(line)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := ring.keys[key.id]; ok {
+			return nil, fmt.Errorf("duplicate token key id %q", key.id)
+		}
+
+		ring.keys[key.id] = key
+		ring.active = key
+	}
+
+	if len(ring.keys) == 0 {
+		return nil, ErrNoTokenKeys
+	}
+
+	return ring, nil
+}
+
+func parseTokenKey(line string) (tokenKey, error) {
+	parts := strings.SplitN(line, ":", 3)
+	if len(parts) != 3 || len(parts[0]) == 0 {
+		return tokenKey{}, errors.New("token key must be \"<kid>:<alg>:<key>\"")
+	}
+
+	key := tokenKey{id: parts[0]}
+	secret, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return key, fmt.Errorf("token key %q is not base64: %w", key.id, err)
+	}
+
+	switch parts[1] {
+	case TokenAlgEdDSA:
+		if len(secret) != ed25519.SeedSize {
+			return key, fmt.Errorf("token key %q must be a %d-byte Ed25519 seed", key.id, ed25519.SeedSize)
+		}
+		privateKey := ed25519.NewKeyFromSeed(secret)
+		key.method = jwt.SigningMethodEdDSA
+		key.signKey = privateKey
+		key.verifyKey = privateKey.Public()
+	case TokenAlgHS256:
+		if len(secret) < minHMACKeyLength {
+			return key, fmt.Errorf("token key %q must be at least %d bytes", key.id, minHMACKeyLength)
+		}
+		key.method = jwt.SigningMethodHS256
+		key.signKey = secret
+		key.verifyKey = secret
+	default:
+		return key, fmt.Errorf("token key %q has unknown algorithm %q", key.id, parts[1])
+	}
+
+	return key, nil
+}
+
+// signingKey возвращает активный ключ вместе с издателем и аудиторией.
+func signingKey() (tokenKey, string, string, error) {
+	tokenMu.RLock()
+	defer tokenMu.RUnlock()
+
+	if tokenKeys == nil {
+		return tokenKey{}, "", "", ErrNoTokenKeys
+	}
+
+	return tokenKeys.active, tokenIssuer, tokenAudience, nil
+}
+
+// verificationKey ищет ключ по kid из заголовка. Алгоритм токена должен совпадать
+// с алгоритмом ключа, иначе публичный ключ можно было бы выдать за секрет HMAC.
+func verificationKey(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	tokenMu.RLock()
+	defer tokenMu.RUnlock()
+
+	if tokenKeys == nil {
+		return nil, ErrNoTokenKeys
+	}
+
+	key, ok := tokenKeys.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown token key %q", kid)
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.verifyKey, nil
+}
+
+// expectedClaims возвращает издателя и аудиторию, которые должны быть в токене.
+func expectedClaims() (string, string) {
+	tokenMu.RLock()
+	defer tokenMu.RUnlock()
+
+	return tokenIssuer, tokenAudience
+}
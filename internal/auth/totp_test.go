@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret - ключ SHA1 из приложения B RFC 6238 ("12345678901234567890") в base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// Векторы SHA1 из приложения B RFC 6238. В RFC коды из 8 цифр, у нас из 6:
+// это последние 6 цифр того же значения.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{unix: 59, code: "287082"},
+	{unix: 1111111109, code: "081804"},
+	{unix: 1111111111, code: "050471"},
+	{unix: 1234567890, code: "005924"},
+	{unix: 2000000000, code: "279037"},
+	{unix: 20000000000, code: "353130"},
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	for _, tt := range rfc6238Vectors {
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.code {
+			t.Errorf("totpCode(T=%d) = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		step, ok := ValidateTOTP(rfc6238Secret, tt.code, time.Unix(tt.unix, 0))
+		if !ok || step != tt.unix/totpPeriod {
+			t.Errorf("ValidateTOTP(T=%d, %s) = %d, %v, want %d, true", tt.unix, tt.code, step, ok, tt.unix/totpPeriod)
+		}
+	}
+
+	// код шага 1111111109/30 и время относительно начала этого шага
+	const code = "081804"
+	stepStart := time.Unix(1111111109/totpPeriod*totpPeriod, 0)
+	step := stepStart.Unix() / totpPeriod
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		now    time.Time
+		ok     bool
+	}{
+		{name: "same step", secret: rfc6238Secret, code: code, now: stepStart, ok: true},
+		{name: "lowercase secret", secret: strings.ToLower(rfc6238Secret), code: code, now: stepStart, ok: true},
+		{name: "previous step", secret: rfc6238Secret, code: code, now: stepStart.Add(totpPeriod * time.Second), ok: true},
+		{name: "next step", secret: rfc6238Secret, code: code, now: stepStart.Add(-time.Second), ok: true},
+		{name: "two steps late", secret: rfc6238Secret, code: code, now: stepStart.Add(2 * totpPeriod * time.Second)},
+		{name: "two steps early", secret: rfc6238Secret, code: code, now: stepStart.Add(-totpPeriod*time.Second - time.Second)},
+		{name: "wrong code", secret: rfc6238Secret, code: "081805", now: stepStart},
+		{name: "rfc 8 digit code", secret: rfc6238Secret, code: "07081804", now: stepStart},
+		{name: "short code", secret: rfc6238Secret, code: "81804", now: stepStart},
+		{name: "empty code", secret: rfc6238Secret, code: "", now: stepStart},
+		{name: "other secret", secret: "JBSWY3DPEHPK3PXP", code: code, now: stepStart},
+		{name: "invalid secret", secret: "not base32!", code: code, now: stepStart},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := ValidateTOTP(tt.secret, tt.code, tt.now)
+			if ok != tt.ok {
+				t.Fatalf("ValidateTOTP() ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && gotStep != step {
+				t.Errorf("ValidateTOTP() step = %d, want %d", gotStep, step)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret: %v", err)
+	}
+
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != totpSecretLength {
+		t.Fatalf("secret %q decodes to %d bytes, %v", secret, len(key), err)
+	}
+
+	now := time.Now()
+	if _, ok := ValidateTOTP(secret, totpCode(key, now.Unix()/totpPeriod), now); !ok {
+		t.Errorf("ValidateTOTP() rejected the current code of a generated secret")
+	}
+
+	uri, err := url.Parse(TOTPURI(secret, "alice"))
+	if err != nil {
+		t.Fatalf("parse TOTPURI: %v", err)
+	}
+	query := uri.Query()
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || query.Get("secret") != secret ||
+		query.Get("digits") != "6" || query.Get("period") != "30" || query.Get("algorithm") != "SHA1" {
+		t.Errorf("TOTPURI() = %s", uri)
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes: %v", err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("GenerateRecoveryCodes() returned %d codes, want %d", len(codes), RecoveryCodeCount)
+	}
+
+	code := codes[0]
+	hash := HashRecoveryCode(code)
+	for _, input := range []string{strings.ToUpper(code), strings.ReplaceAll(code, "-", ""), " " + code + " "} {
+		if !bytes.Equal(HashRecoveryCode(input), hash) {
+			t.Errorf("HashRecoveryCode(%q) differs from HashRecoveryCode(%q)", input, code)
+		}
+	}
+	if bytes.Equal(HashRecoveryCode(codes[1]), hash) {
+		t.Errorf("different recovery codes have the same hash")
+	}
+}
@@ -2,14 +2,68 @@ package config
 
 import (
 	"flag"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/ratelimit"
+	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Имена файлов ключей в каталоге -config-dir.
+const (
+	// JWTKeyFileName - ключи подписи токенов, их дописывает cmd/jwtkey.
+	JWTKeyFileName = "jwt.keys"
+	// SealFileName - конфигурация печати, ее создает cmd/unseal init.
+	SealFileName = "seal.json"
+	// MasterKeyFileName - кольцо мастер-ключей провайдера file и cmd/kms.
+	MasterKeyFileName = "encryption.txt"
+
+	keySaltFileName = "passphrase.salt"
+	caDirName       = "ca"
 )
 
 type Config struct {
 	FlagRunAddr             string
 	FlagLogLevel            string
 	FlagDBConnectionAddress string
+	FlagKeyStoreAddress     string
 	FlagMinioEndpoint       string
+	FlagKeyRotationInterval time.Duration
+	FlagConfigDir           string
+	FlagKeyProvider         string
+	FlagKeyFile             string
+	FlagKeySaltFile         string
+	FlagKMSAddress          string
+	FlagKMSToken            string
+	FlagSealFile            string
+	FlagAdminToken          string
+	FlagPasswordHash        string
+	FlagArgon2Time          uint
+	FlagArgon2Memory        uint
+	FlagArgon2Threads       uint
+	FlagBcryptCost          int
+	FlagJWTKeyFile          string
+	FlagJWTIssuer           string
+	FlagJWTAudience         string
+	FlagAccessTokenTTL      time.Duration
+	FlagRefreshTokenTTL     time.Duration
+	FlagRateLimitStore      string
+	FlagLockoutAfter        int
+	FlagLockoutDuration     time.Duration
+	FlagRealIPHeader        string
+	FlagMTLS                bool
+	FlagCADir               string
+	FlagTLSHosts            string
+	FlagClientCertTTL       time.Duration
+	FlagOIDCIssuer          string
+	FlagOIDCClientID        string
+	FlagOIDCClientSecret    string
+	FlagOIDCRedirectURL     string
+	FlagRegistration        string
+	FlagInviteQuota         int
+	FlagInviteTTL           time.Duration
 }
 
 func MakeConfig() *Config {
@@ -18,7 +72,45 @@ func MakeConfig() *Config {
 	flag.StringVar(&config.FlagRunAddr, "a", ":8080", "address to run server")
 	flag.StringVar(&config.FlagLogLevel, "l", "info", "log level")
 	flag.StringVar(&config.FlagDBConnectionAddress, "d", "host=localhost port=5432 user=postgres password=vvv dbname=gophkeep sslmode=disable", "database connection address")
+	flag.StringVar(&config.FlagKeyStoreAddress, "key-store", "host=localhost port=5432 user=postgres password=vvv dbname=gophkeep_keys sslmode=disable", "connection address of the separate database with account keys")
 	flag.StringVar(&config.FlagMinioEndpoint, "m", "localhost:9000", "minio endpoint")
+	flag.DurationVar(&config.FlagKeyRotationInterval, "k", time.Minute*10, "how often to rewrap object keys with the active master key")
+
+	flag.StringVar(&config.FlagConfigDir, "config-dir", defaultConfigDir(), "directory of the server keys that default key paths are resolved against")
+	flag.StringVar(&config.FlagKeyProvider, "key-provider", "file", "master key provider: file, env, passphrase, transit or shamir")
+	flag.StringVar(&config.FlagKeyFile, "key-file", "", "path to the master key file (default <config-dir>/"+MasterKeyFileName+")")
+	flag.StringVar(&config.FlagKeySaltFile, "key-salt-file", "", "path to the salt and check value for the passphrase-derived master key (default <config-dir>/"+keySaltFileName+")")
+	flag.StringVar(&config.FlagKMSAddress, "kms-address", "http://localhost:8200", "address of the transit key service")
+	flag.StringVar(&config.FlagKMSToken, "kms-token", "", "token for the transit key service")
+	flag.StringVar(&config.FlagSealFile, "seal-file", "", "path to the seal config of the shamir key provider (default <config-dir>/"+SealFileName+")")
+	flag.StringVar(&config.FlagAdminToken, "admin-token", "", "token required to seal the server")
+	flag.StringVar(&config.FlagJWTKeyFile, "jwt-key-file", "", "path to the token signing keys, created with a new Ed25519 key if missing (default <config-dir>/"+JWTKeyFileName+")")
+	flag.StringVar(&config.FlagJWTIssuer, "jwt-issuer", "gophkeep", "issuer of session tokens")
+	flag.StringVar(&config.FlagJWTAudience, "jwt-audience", "gophkeep", "audience of session tokens")
+	flag.DurationVar(&config.FlagAccessTokenTTL, "access-token-ttl", auth.DefaultAccessTokenExp, "lifetime of access tokens")
+	flag.DurationVar(&config.FlagRefreshTokenTTL, "refresh-token-ttl", auth.DefaultRefreshTokenExp, "how long a session lives without refresh")
+	flag.StringVar(&config.FlagRateLimitStore, "rate-limit-store", "memory", "where login attempt counters live: memory or postgres (shared by replicas)")
+	flag.IntVar(&config.FlagLockoutAfter, "lockout-after", ratelimit.DefaultAccountPolicy.LockoutAfter, "failed logins in a row that lock an account")
+	flag.DurationVar(&config.FlagLockoutDuration, "lockout-duration", ratelimit.DefaultAccountPolicy.LockoutDuration, "how long a locked account stays locked")
+	flag.StringVar(&config.FlagRealIPHeader, "real-ip-header", "", "header with the client address set by a trusted reverse proxy, e.g. X-Real-IP")
+	flag.BoolVar(&config.FlagMTLS, "mtls", false, "serve HTTPS with a certificate from the local CA and accept client certificates")
+	flag.StringVar(&config.FlagCADir, "ca-dir", "", "directory of the local CA, created on first start (default <config-dir>/"+caDirName+")")
+	flag.StringVar(&config.FlagTLSHosts, "tls-hosts", "localhost,127.0.0.1", "comma-separated host names and addresses of the server certificate")
+	flag.DurationVar(&config.FlagClientCertTTL, "client-cert-ttl", time.Hour*24*90, "lifetime of issued client certificates")
+	flag.StringVar(&config.FlagOIDCIssuer, "oidc-issuer", "", "OpenID Connect provider for single sign-on, disabled if empty")
+	flag.StringVar(&config.FlagOIDCClientID, "oidc-client-id", "gophkeep", "client ID registered at the OpenID Connect provider")
+	flag.StringVar(&config.FlagOIDCClientSecret, "oidc-client-secret", "", "client secret registered at the OpenID Connect provider")
+	flag.StringVar(&config.FlagOIDCRedirectURL, "oidc-redirect-url", "http://localhost:8080/api/user/oidc/callback", "callback address registered at the OpenID Connect provider")
+	flag.StringVar(&config.FlagRegistration, "registration", RegistrationOpen, "who can register: open, invite (with an invite code) or closed")
+	flag.IntVar(&config.FlagInviteQuota, "invite-quota", 0, "invites a user can create, administrators are not limited")
+	flag.DurationVar(&config.FlagInviteTTL, "invite-ttl", time.Hour*24*7, "lifetime of invite codes")
+
+	defaultPolicy := auth.DefaultPasswordPolicy()
+	flag.StringVar(&config.FlagPasswordHash, "password-hash", defaultPolicy.Algorithm, "password hash algorithm: argon2id or bcrypt")
+	flag.UintVar(&config.FlagArgon2Time, "argon2-time", uint(defaultPolicy.Argon2Time), "argon2id iterations")
+	flag.UintVar(&config.FlagArgon2Memory, "argon2-memory", uint(defaultPolicy.Argon2Memory), "argon2id memory in KiB")
+	flag.UintVar(&config.FlagArgon2Threads, "argon2-threads", uint(defaultPolicy.Argon2Threads), "argon2id parallelism")
+	flag.IntVar(&config.FlagBcryptCost, "bcrypt-cost", defaultPolicy.BcryptCost, "bcrypt cost")
 
 	flag.Parse()
 
@@ -34,8 +126,180 @@ func MakeConfig() *Config {
 		config.FlagDBConnectionAddress = envDBConnectionAddress
 	}
 
+	if envKeyStoreAddress := os.Getenv("KEY_STORE_URI"); envKeyStoreAddress != "" {
+		config.FlagKeyStoreAddress = envKeyStoreAddress
+	}
+
 	if envMinioEndpoint := os.Getenv("DATABASE_URI"); envMinioEndpoint != "" {
 		config.FlagMinioEndpoint = envMinioEndpoint
 	}
+
+	if envKeyRotationInterval := os.Getenv("KEY_ROTATION_INTERVAL"); envKeyRotationInterval != "" {
+		interval, err := time.ParseDuration(envKeyRotationInterval)
+		if err == nil {
+			config.FlagKeyRotationInterval = interval
+		}
+	}
+
+	if envConfigDir := os.Getenv("CONFIG_DIR"); envConfigDir != "" {
+		config.FlagConfigDir = envConfigDir
+	}
+
+	if envKeyProvider := os.Getenv("KEY_PROVIDER"); envKeyProvider != "" {
+		config.FlagKeyProvider = envKeyProvider
+	}
+
+	if envKeyFile := os.Getenv("KEY_FILE"); envKeyFile != "" {
+		config.FlagKeyFile = envKeyFile
+	}
+
+	if envKeySaltFile := os.Getenv("KEY_SALT_FILE"); envKeySaltFile != "" {
+		config.FlagKeySaltFile = envKeySaltFile
+	}
+
+	if envKMSAddress := os.Getenv("KMS_ADDRESS"); envKMSAddress != "" {
+		config.FlagKMSAddress = envKMSAddress
+	}
+
+	if envKMSToken := os.Getenv("KMS_TOKEN"); envKMSToken != "" {
+		config.FlagKMSToken = envKMSToken
+	}
+
+	if envSealFile := os.Getenv("SEAL_FILE"); envSealFile != "" {
+		config.FlagSealFile = envSealFile
+	}
+
+	if envAdminToken := os.Getenv("ADMIN_TOKEN"); envAdminToken != "" {
+		config.FlagAdminToken = envAdminToken
+	}
+
+	if envPasswordHash := os.Getenv("PASSWORD_HASH"); envPasswordHash != "" {
+		config.FlagPasswordHash = envPasswordHash
+	}
+
+	if envJWTKeyFile := os.Getenv("JWT_KEY_FILE"); envJWTKeyFile != "" {
+		config.FlagJWTKeyFile = envJWTKeyFile
+	}
+
+	if envAccessTokenTTL := os.Getenv("ACCESS_TOKEN_TTL"); envAccessTokenTTL != "" {
+		ttl, err := time.ParseDuration(envAccessTokenTTL)
+		if err == nil {
+			config.FlagAccessTokenTTL = ttl
+		}
+	}
+
+	if envRefreshTokenTTL := os.Getenv("REFRESH_TOKEN_TTL"); envRefreshTokenTTL != "" {
+		ttl, err := time.ParseDuration(envRefreshTokenTTL)
+		if err == nil {
+			config.FlagRefreshTokenTTL = ttl
+		}
+	}
+
+	if envRateLimitStore := os.Getenv("RATE_LIMIT_STORE"); envRateLimitStore != "" {
+		config.FlagRateLimitStore = envRateLimitStore
+	}
+
+	if envRealIPHeader := os.Getenv("REAL_IP_HEADER"); envRealIPHeader != "" {
+		config.FlagRealIPHeader = envRealIPHeader
+	}
+
+	if envMTLS := os.Getenv("MTLS"); envMTLS != "" {
+		enabled, err := strconv.ParseBool(envMTLS)
+		if err == nil {
+			config.FlagMTLS = enabled
+		}
+	}
+
+	if envCADir := os.Getenv("CA_DIR"); envCADir != "" {
+		config.FlagCADir = envCADir
+	}
+
+	if envTLSHosts := os.Getenv("TLS_HOSTS"); envTLSHosts != "" {
+		config.FlagTLSHosts = envTLSHosts
+	}
+
+	if envOIDCIssuer := os.Getenv("OIDC_ISSUER"); envOIDCIssuer != "" {
+		config.FlagOIDCIssuer = envOIDCIssuer
+	}
+
+	if envOIDCClientID := os.Getenv("OIDC_CLIENT_ID"); envOIDCClientID != "" {
+		config.FlagOIDCClientID = envOIDCClientID
+	}
+
+	if envOIDCClientSecret := os.Getenv("OIDC_CLIENT_SECRET"); envOIDCClientSecret != "" {
+		config.FlagOIDCClientSecret = envOIDCClientSecret
+	}
+
+	if envOIDCRedirectURL := os.Getenv("OIDC_REDIRECT_URL"); envOIDCRedirectURL != "" {
+		config.FlagOIDCRedirectURL = envOIDCRedirectURL
+	}
+
+	if envRegistration := os.Getenv("REGISTRATION"); envRegistration != "" {
+		config.FlagRegistration = envRegistration
+	}
+
+	if envInviteQuota := os.Getenv("INVITE_QUOTA"); envInviteQuota != "" {
+		quota, err := strconv.Atoi(envInviteQuota)
+		if err == nil {
+			config.FlagInviteQuota = quota
+		}
+	}
+
+	if envInviteTTL := os.Getenv("INVITE_TTL"); envInviteTTL != "" {
+		ttl, err := time.ParseDuration(envInviteTTL)
+		if err == nil {
+			config.FlagInviteTTL = ttl
+		}
+	}
+
+	config.FlagKeyFile = config.inConfigDir(config.FlagKeyFile, MasterKeyFileName)
+	config.FlagKeySaltFile = config.inConfigDir(config.FlagKeySaltFile, keySaltFileName)
+	config.FlagSealFile = config.inConfigDir(config.FlagSealFile, SealFileName)
+	config.FlagJWTKeyFile = config.inConfigDir(config.FlagJWTKeyFile, JWTKeyFileName)
+	config.FlagCADir = config.inConfigDir(config.FlagCADir, caDirName)
 	return config
 }
+
+// DefaultPath возвращает путь к файлу name в каталоге ключей по умолчанию. Им пользуются
+// утилиты, которые работают с теми же ключами, что и сервер.
+func DefaultPath(name string) string {
+	return filepath.Join(defaultConfigDir(), name)
+}
+
+// defaultConfigDir - каталог ключей сервера: CONFIG_DIR или gophkeep-server в каталоге
+// настроек пользователя. Пути по умолчанию не зависят от рабочей папки, поэтому сервер
+// находит ключи, откуда бы его ни запустили.
+func defaultConfigDir() string {
+	if envConfigDir := os.Getenv("CONFIG_DIR"); envConfigDir != "" {
+		return envConfigDir
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gophkeep-server")
+}
+
+// inConfigDir возвращает path или, если он не задан, name в каталоге -config-dir.
+func (config *Config) inConfigDir(path string, name string) string {
+	if len(path) != 0 {
+		return path
+	}
+
+	if len(config.FlagConfigDir) == 0 {
+		log.Fatalf("cannot find a directory for %s: set -config-dir or CONFIG_DIR", name)
+	}
+	return filepath.Join(config.FlagConfigDir, name)
+}
+
+// PasswordPolicy возвращает политику хеширования паролей из флагов.
+func (config *Config) PasswordPolicy() auth.PasswordPolicy {
+	return auth.PasswordPolicy{
+		Algorithm:     config.FlagPasswordHash,
+		Argon2Time:    uint32(config.FlagArgon2Time),
+		Argon2Memory:  uint32(config.FlagArgon2Memory),
+		Argon2Threads: uint8(config.FlagArgon2Threads),
+		BcryptCost:    config.FlagBcryptCost,
+	}
+}
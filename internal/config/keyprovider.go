@@ -0,0 +1,37 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gophkeep/internal/encryption"
+	"os"
+)
+
+// masterKeyEnv - переменная окружения с кольцом мастер-ключей для провайдера env.
+const masterKeyEnv = "MASTER_KEY"
+
+// NewKeyProvider создает провайдер мастер-ключа, выбранный флагом -key-provider.
+func NewKeyProvider(cfg *Config) (encryption.KeyProvider, error) {
+	switch cfg.FlagKeyProvider {
+	case "file":
+		provider, err := encryption.NewFileKeyProvider(cfg.FlagKeyFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("master key file is missing, set -key-file or -config-dir: %w", err)
+		}
+		return provider, err
+	case "env":
+		return encryption.NewEnvKeyProvider(masterKeyEnv)
+	case "passphrase":
+		return encryption.NewPassphraseKeyProvider(os.Stdin, os.Stderr, cfg.FlagKeySaltFile, 1)
+	case "transit":
+		return encryption.NewTransitKeyProvider(cfg.FlagKMSAddress, cfg.FlagKMSToken)
+	case "shamir":
+		provider, err := encryption.NewSealedKeyProvider(cfg.FlagSealFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("seal config is missing, run cmd/unseal init or set -seal-file: %w", err)
+		}
+		return provider, err
+	}
+
+	return nil, fmt.Errorf("unknown key provider %q", cfg.FlagKeyProvider)
+}
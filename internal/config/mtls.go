@@ -0,0 +1,42 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"gophkeep/internal/mtls"
+	"strings"
+)
+
+// NewTLS загружает локальный CA из -ca-dir и выпускает сертификат сервера для -tls-hosts.
+// Без -mtls сервер работает по HTTP и возвращает nil.
+func NewTLS(cfg *Config) (*mtls.CA, *tls.Config, error) {
+	if !cfg.FlagMTLS {
+		return nil, nil, nil
+	}
+
+	if cfg.FlagClientCertTTL <= 0 {
+		return nil, nil, errors.New("client certificate lifetime must be positive")
+	}
+
+	var hosts []string
+	for _, host := range strings.Split(cfg.FlagTLSHosts, ",") {
+		if host = strings.TrimSpace(host); len(host) != 0 {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, nil, errors.New("at least one TLS host is required")
+	}
+
+	ca, err := mtls.LoadOrCreateCA(cfg.FlagCADir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig, err := ca.ServerTLSConfig(hosts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ca, tlsConfig, nil
+}
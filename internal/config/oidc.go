@@ -0,0 +1,16 @@
+package config
+
+import (
+	"context"
+	"gophkeep/internal/oidc"
+)
+
+// NewOIDC загружает настройки провайдера единого входа. Без -oidc-issuer вход через
+// провайдер выключен и возвращается nil.
+func NewOIDC(ctx context.Context, cfg *Config) (*oidc.Provider, error) {
+	if len(cfg.FlagOIDCIssuer) == 0 {
+		return nil, nil
+	}
+
+	return oidc.Discover(ctx, cfg.FlagOIDCIssuer, cfg.FlagOIDCClientID, cfg.FlagOIDCClientSecret, cfg.FlagOIDCRedirectURL)
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gophkeep/internal/ratelimit"
+)
+
+// NewLimiter создает ограничитель попыток входа с хранилищем, выбранным флагом
+// -rate-limit-store. shared - хранилище в базе, общее для всех реплик.
+func NewLimiter(cfg *Config, shared ratelimit.Store) (*ratelimit.Limiter, error) {
+	if cfg.FlagLockoutAfter <= 0 || cfg.FlagLockoutDuration <= 0 {
+		return nil, errors.New("lockout threshold and duration must be positive")
+	}
+
+	account := ratelimit.DefaultAccountPolicy
+	account.LockoutAfter = cfg.FlagLockoutAfter
+	account.LockoutDuration = cfg.FlagLockoutDuration
+	account.FreeAttempts = min(account.FreeAttempts, account.LockoutAfter-1)
+
+	switch cfg.FlagRateLimitStore {
+	case "memory":
+		return ratelimit.New(ratelimit.NewMemoryStore(), account, ratelimit.DefaultClientPolicy), nil
+	case "postgres":
+		return ratelimit.New(shared, account, ratelimit.DefaultClientPolicy), nil
+	}
+
+	return nil, fmt.Errorf("unknown rate limit store %q", cfg.FlagRateLimitStore)
+}
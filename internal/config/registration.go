@@ -0,0 +1,31 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Режимы регистрации новых аккаунтов
+const (
+	RegistrationOpen   = "open"
+	RegistrationInvite = "invite"
+	RegistrationClosed = "closed"
+)
+
+// CheckRegistration проверяет флаги регистрации и приглашений.
+func CheckRegistration(cfg *Config) error {
+	switch cfg.FlagRegistration {
+	case RegistrationOpen, RegistrationInvite, RegistrationClosed:
+	default:
+		return fmt.Errorf("unknown registration mode %q, expected open, invite or closed", cfg.FlagRegistration)
+	}
+
+	if cfg.FlagInviteQuota < 0 {
+		return errors.New("invite quota must not be negative")
+	}
+	if cfg.FlagInviteTTL <= 0 {
+		return errors.New("invite lifetime must be positive")
+	}
+
+	return nil
+}
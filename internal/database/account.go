@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/model"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrLoginInUse - логин уже занят другим аккаунтом.
+var ErrLoginInUse = errors.New("login already in use")
+
+const revokePasswordChange = "password change"
+
+// ChangePassword сохраняет новый пароль аккаунта из контекста и отзывает все его сессии,
+// кроме текущей. Если передан верификатор SRP, сохраняется он, а хеш пароля удаляется,
+// иначе удаляется старый верификатор. Возвращает количество отозванных сессий.
+func (dbData PostgreDB) ChangePassword(ctx context.Context, newPassword string, newSRP *model.SRPVerifier) (int64, error) {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := "UPDATE " + accountsTableName + " SET (password, password_version, srp_salt, srp_verifier) = ($1, $2, $3, $4)" +
+		" WHERE uuid = $5"
+	args := []any{nil, passwordHashed, nil, nil, userID}
+	if newSRP != nil {
+		args[2], args[3] = newSRP.Salt, newSRP.Verifier
+	} else {
+		passwordHash, err := auth.HashPassword(newPassword)
+		if err != nil {
+			return 0, err
+		}
+		args[0] = passwordHash
+	}
+
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	// без сессии в контексте отзываются все сессии аккаунта
+	sessionID, _ := auth.SessionIDFromContext(ctx)
+	stmt = "UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = $3" +
+		" WHERE account_uuid = $1 AND id <> $2 AND revoked_at IS NULL"
+	result, err := tx.ExecContext(ctx, stmt, userID, sessionID, revokePasswordChange)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return revoked, tx.Commit()
+}
+
+// ChangeLogin переименовывает аккаунт из контекста.
+func (dbData PostgreDB) ChangeLogin(ctx context.Context, newLogin string) error {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	stmt := "UPDATE " + accountsTableName + " SET username = $1 WHERE uuid = $2"
+	_, err = dbData.DatabaseConnection.ExecContext(ctx, stmt, newLogin, userID)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return ErrLoginInUse
+	}
+
+	return err
+}
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS account_keys(
+    account_uuid TEXT PRIMARY KEY,
+    wrapped_key  TEXT NOT NULL,
+    key_id       INTEGER NOT NULL,
+    created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+CREATE INDEX IF NOT EXISTS account_keys_key_id_idx ON account_keys (key_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS account_keys;
+-- +goose StatementEnd
@@ -0,0 +1,6 @@
+package accountkeysmigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedAccountKeys embed.FS
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gophkeep/internal/kinds"
+	"gophkeep/internal/model"
+
+	adminmigrations "gophkeep/internal/database/admin_migrations"
+)
+
+var (
+	// ErrAccountNotFound - аккаунта с таким id или логином нет.
+	ErrAccountNotFound = errors.New("account not found")
+	// ErrAccountDisabled - аккаунт отключен администратором.
+	ErrAccountDisabled = errors.New("account is disabled")
+)
+
+const (
+	revokeAccountDisabled = "account disabled"
+	revokeRoleChange      = "role change"
+	revokeAdminLogout     = "admin logout"
+)
+
+func (dbData PostgreDB) CreateAdminColumns(ctx context.Context) error {
+	return dbData.upMigrations(ctx, adminmigrations.EmbedAdmin)
+}
+
+// AccountRole возвращает роль аккаунта. Отключенный аккаунт дает ErrAccountDisabled.
+func (dbData PostgreDB) AccountRole(ctx context.Context, userID string) (string, error) {
+	var role string
+	var disabled bool
+
+	stmt := "SELECT role, disabled_at IS NOT NULL FROM " + accountsTableName + " WHERE uuid = $1"
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, stmt, userID).Scan(&role, &disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrAccountNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if disabled {
+		return "", ErrAccountDisabled
+	}
+
+	return role, nil
+}
+
+// AccountIDByLogin возвращает id аккаунта по логину.
+func (dbData PostgreDB) AccountIDByLogin(ctx context.Context, login string) (string, error) {
+	var userID string
+
+	stmt := "SELECT uuid FROM " + accountsTableName + " WHERE username = $1"
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, stmt, login).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrAccountNotFound
+	}
+
+	return userID, err
+}
+
+// ListAccounts возвращает все аккаунты с количеством записей, объемом их шифртекста
+// и числом действующих сессий. Содержимое записей не читается.
+func (dbData PostgreDB) ListAccounts(ctx context.Context) ([]model.AccountSummary, error) {
+	stmt := "SELECT a.uuid, a.username, a.role, a.created_at, a.disabled_at, COALESCE(a.invited_by, '')," +
+		" (SELECT COUNT(*) FROM sessions s WHERE s.account_uuid = a.uuid AND s.revoked_at IS NULL AND s.expires_at > CURRENT_TIMESTAMP)" +
+		" FROM " + accountsTableName + " a ORDER BY a.created_at"
+	rows, err := dbData.DatabaseConnection.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := make([]model.AccountSummary, 0)
+	index := make(map[string]int)
+	for rows.Next() {
+		account := model.AccountSummary{Records: make(map[string]int64)}
+		var disabledAt sql.NullTime
+		err = rows.Scan(&account.ID, &account.Login, &account.Role, &account.Created, &disabledAt,
+			&account.InvitedBy, &account.Sessions)
+		if err != nil {
+			return nil, err
+		}
+		if disabledAt.Valid {
+			account.DisabledAt = &disabledAt.Time
+		}
+		index[account.ID] = len(accounts)
+		accounts = append(accounts, account)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, kind := range kinds.All() {
+		stmt = "SELECT i.account_uuid, COUNT(*), COALESCE(SUM(octet_length(d.data)), 0) FROM infos i" +
+			" JOIN " + kind.Table + " d ON d.id = i.static_id WHERE i.type = $1 GROUP BY i.account_uuid"
+		err = dbData.addUsage(ctx, accounts, index, kind.Name, stmt, kind.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// содержимое файлов, переданных потоком, хранится частями
+	stmt = "SELECT i.account_uuid, 0, COALESCE(SUM(octet_length(c.data)), 0) FROM infos i" +
+		" JOIN file_chunks c ON c.file_id = i.static_id GROUP BY i.account_uuid"
+	if err = dbData.addUsage(ctx, accounts, index, "", stmt); err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// addUsage добавляет к аккаунтам количество записей вида kind и их объем из stmt.
+func (dbData PostgreDB) addUsage(ctx context.Context, accounts []model.AccountSummary, index map[string]int, kind string, stmt string, args ...any) error {
+	rows, err := dbData.DatabaseConnection.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID string
+		var count, size int64
+		if err = rows.Scan(&userID, &count, &size); err != nil {
+			return err
+		}
+
+		i, ok := index[userID]
+		if !ok {
+			continue
+		}
+		if len(kind) != 0 {
+			accounts[i].Records[kind] += count
+		}
+		accounts[i].StorageBytes += size
+	}
+
+	return rows.Err()
+}
+
+// SetAccountDisabled отключает или включает аккаунт. Сессии отключенного аккаунта
+// отзываются, а его персональные токены и сертификаты перестают приниматься.
+func (dbData PostgreDB) SetAccountDisabled(ctx context.Context, userID string, disabled bool) error {
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := "UPDATE " + accountsTableName + " SET disabled_at = NULL WHERE uuid = $1"
+	if disabled {
+		stmt = "UPDATE " + accountsTableName + " SET disabled_at = COALESCE(disabled_at, CURRENT_TIMESTAMP) WHERE uuid = $1"
+	}
+	result, err := tx.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return err
+	}
+	if err = accountUpdated(result); err != nil {
+		return err
+	}
+
+	if disabled {
+		if _, err = revokeAccountSessions(ctx, tx, userID, revokeAccountDisabled); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetAccountRole меняет роль аккаунта и отзывает его сессии: роль записана в токенах доступа.
+func (dbData PostgreDB) SetAccountRole(ctx context.Context, userID string, role string) error {
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := "UPDATE " + accountsTableName + " SET role = $2 WHERE uuid = $1"
+	result, err := tx.ExecContext(ctx, stmt, userID, role)
+	if err != nil {
+		return err
+	}
+	if err = accountUpdated(result); err != nil {
+		return err
+	}
+
+	if _, err = revokeAccountSessions(ctx, tx, userID, revokeRoleChange); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RevokeAccountSessions отзывает все сессии аккаунта и возвращает их количество.
+func (dbData PostgreDB) RevokeAccountSessions(ctx context.Context, userID string) (int64, error) {
+	return revokeAccountSessions(ctx, dbData.DatabaseConnection, userID, revokeAdminLogout)
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func revokeAccountSessions(ctx context.Context, db execer, userID string, reason string) (int64, error) {
+	stmt := "UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = $2" +
+		" WHERE account_uuid = $1 AND revoked_at IS NULL"
+	result, err := db.ExecContext(ctx, stmt, userID, reason)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func accountUpdated(result sql.Result) error {
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrAccountNotFound
+	}
+	return nil
+}
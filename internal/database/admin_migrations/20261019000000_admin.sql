@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user';
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE accounts DROP COLUMN IF EXISTS disabled_at;
+ALTER TABLE accounts DROP COLUMN IF EXISTS role;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS admin_audit_log(
+    id           BIGSERIAL PRIMARY KEY,
+    admin_uuid   TEXT,
+    account_uuid TEXT NOT NULL,
+    event        TEXT NOT NULL,
+    detail       TEXT,
+    created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+CREATE INDEX IF NOT EXISTS admin_audit_log_account_uuid_idx ON admin_audit_log (account_uuid, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS admin_audit_log;
+-- +goose StatementEnd
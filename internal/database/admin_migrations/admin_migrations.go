@@ -0,0 +1,6 @@
+package adminmigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedAdmin embed.FS
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/model"
+
+	auditmigrations "gophkeep/internal/database/audit_migrations"
+)
+
+// События журнала действий аккаунта.
+const (
+	AuditTokenCreate = "token_create"
+	AuditTokenRevoke = "token_revoke"
+	AuditTokenUse    = "token_use"
+
+	AuditPasswordChange = "password_change"
+	AuditLoginChange    = "login_change"
+	AuditNewDevice      = "new_device"
+	AuditDeviceRevoke   = "device_revoke"
+
+	AuditCertificateIssue  = "certificate_issue"
+	AuditCertificateRevoke = "certificate_revoke"
+
+	AuditOIDCProvision = "oidc_provision"
+	AuditOIDCLink      = "oidc_link"
+	AuditOIDCUnlink    = "oidc_unlink"
+
+	AuditAdminDisable = "admin_disable"
+	AuditAdminEnable  = "admin_enable"
+	AuditAdminLogout  = "admin_logout"
+	AuditAdminRole    = "admin_role"
+	AuditAdminDelete  = "admin_delete"
+
+	AuditRegistered   = "registered"
+	AuditInviteCreate = "invite_create"
+	AuditInviteRevoke = "invite_revoke"
+	AuditInviteUsed   = "invite_used"
+)
+
+func (dbData PostgreDB) CreateAuditTable(ctx context.Context) error {
+	return dbData.upMigrations(ctx, auditmigrations.EmbedAudit)
+}
+
+// RecordAudit добавляет событие в журнал аккаунта из контекста. Если запрос выполнен
+// по персональному токену, событие помечается его id.
+func (dbData PostgreDB) RecordAudit(ctx context.Context, event string, detail string) error {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var tokenID sql.NullString
+	tokenID.String, tokenID.Valid = auth.PersonalTokenFromContext(ctx)
+
+	stmt := "INSERT INTO audit_log (account_uuid, event, token_id, detail) VALUES ($1, $2, $3, $4)"
+	_, err = dbData.DatabaseConnection.ExecContext(ctx, stmt, userID, event, tokenID, detail)
+	return err
+}
+
+// RecordAdminAudit добавляет действие администратора в журнал администраторов. Этот журнал
+// не удаляется вместе с аккаунтом, поэтому в нем остается и удаление. Пустой adminID -
+// действие выполнено утилитой администратора напрямую в базе.
+func (dbData PostgreDB) RecordAdminAudit(ctx context.Context, adminID string, userID string, event string, detail string) error {
+	admin := sql.NullString{String: adminID, Valid: len(adminID) != 0}
+
+	stmt := "INSERT INTO admin_audit_log (admin_uuid, account_uuid, event, detail) VALUES ($1, $2, $3, $4)"
+	_, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, admin, userID, event, detail)
+	return err
+}
+
+// GetAuditLog возвращает последние limit событий журнала аккаунта из контекста, новые первыми.
+func (dbData PostgreDB) GetAuditLog(ctx context.Context, limit int) ([]model.AuditEvent, error) {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := "SELECT created_at, event, COALESCE(token_id, ''), COALESCE(detail, '') FROM audit_log" +
+		" WHERE account_uuid = $1 ORDER BY created_at DESC, id DESC LIMIT $2"
+	rows, err := dbData.DatabaseConnection.QueryContext(ctx, stmt, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]model.AuditEvent, 0)
+	for rows.Next() {
+		var event model.AuditEvent
+		if err = rows.Scan(&event.Time, &event.Event, &event.TokenID, &event.Detail); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
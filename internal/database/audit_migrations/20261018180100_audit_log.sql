@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS audit_log(
+    id           BIGSERIAL PRIMARY KEY,
+    account_uuid TEXT NOT NULL,
+    event        TEXT NOT NULL,
+    token_id     TEXT,
+    detail       TEXT,
+    created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+CREATE INDEX IF NOT EXISTS audit_log_account_uuid_idx ON audit_log (account_uuid, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS audit_log;
+-- +goose StatementEnd
@@ -0,0 +1,6 @@
+package auditmigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedAudit embed.FS
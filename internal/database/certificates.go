@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gophkeep/internal/model"
+
+	certificatesmigrations "gophkeep/internal/database/certificates_migrations"
+)
+
+// ErrCertificateNotFound - у аккаунта нет такого клиентского сертификата.
+var ErrCertificateNotFound = errors.New("client certificate not found")
+
+func (dbData PostgreDB) CreateCertificatesTable(ctx context.Context) error {
+	return dbData.upMigrations(ctx, certificatesmigrations.EmbedCertificates)
+}
+
+// SaveClientCertificate запоминает выданный аккаунту из контекста клиентский сертификат.
+// Сам сертификат не хранится, только его серийный номер и удостоверение.
+func (dbData PostgreDB) SaveClientCertificate(ctx context.Context, cert model.ClientCertificate) error {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	stmt := "INSERT INTO client_certificates (serial, account_uuid, kind, name, not_after) VALUES ($1, $2, $3, $4, $5)"
+	_, err = dbData.DatabaseConnection.ExecContext(ctx, stmt, cert.Serial, userID, cert.Kind, cert.Name, cert.NotAfter)
+	return err
+}
+
+// GetClientCertificates возвращает клиентские сертификаты аккаунта из контекста.
+func (dbData PostgreDB) GetClientCertificates(ctx context.Context) ([]model.ClientCertificate, error) {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := "SELECT serial, kind, name, not_after, created_at, revoked_at FROM client_certificates" +
+		" WHERE account_uuid = $1 ORDER BY created_at"
+	rows, err := dbData.DatabaseConnection.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	certs := make([]model.ClientCertificate, 0)
+	for rows.Next() {
+		var cert model.ClientCertificate
+		var revokedAt sql.NullTime
+		if err = rows.Scan(&cert.Serial, &cert.Kind, &cert.Name, &cert.NotAfter, &cert.Created, &revokedAt); err != nil {
+			return nil, err
+		}
+		if revokedAt.Valid {
+			cert.RevokedAt = &revokedAt.Time
+		}
+		certs = append(certs, cert)
+	}
+
+	return certs, rows.Err()
+}
+
+// RevokeClientCertificate отзывает клиентский сертификат аккаунта из контекста.
+func (dbData PostgreDB) RevokeClientCertificate(ctx context.Context, serial string) error {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	stmt := "UPDATE client_certificates SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP)" +
+		" WHERE serial = $1 AND account_uuid = $2"
+	result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, serial, userID)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return ErrCertificateNotFound
+	}
+
+	return nil
+}
+
+// CheckClientCertificate находит действующий клиентский сертификат включенного аккаунта.
+// Реализует auth.CertificateChecker.
+func (dbData PostgreDB) CheckClientCertificate(ctx context.Context, serial string) (string, string, bool, error) {
+	var userID, kind string
+
+	stmt := "SELECT account_uuid, kind FROM client_certificates" +
+		" WHERE serial = $1 AND revoked_at IS NULL AND not_after > CURRENT_TIMESTAMP" +
+		" AND account_uuid IN (SELECT uuid FROM " + accountsTableName + " WHERE disabled_at IS NULL)"
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, stmt, serial).Scan(&userID, &kind)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", false, nil
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+
+	return userID, kind, true, nil
+}
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS client_certificates(
+    serial       TEXT PRIMARY KEY,
+    account_uuid TEXT NOT NULL,
+    kind         TEXT NOT NULL,
+    name         TEXT NOT NULL,
+    not_after    TIMESTAMP NOT NULL,
+    created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at   TIMESTAMP
+    );
+CREATE INDEX IF NOT EXISTS client_certificates_account_uuid_idx ON client_certificates (account_uuid);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS client_certificates;
+-- +goose StatementEnd
@@ -0,0 +1,6 @@
+package certificatesmigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedCertificates embed.FS
@@ -4,27 +4,108 @@ import (
 	"context"
 	"database/sql"
 	"gophkeep/internal/model"
+	"gophkeep/internal/ratelimit"
+	"io"
 	"log"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
+// Storage - хранилище записей. Методы с записями пользователя работают только с записями
+// аккаунта из контекста запроса (auth.WithUserID), чужие записи для них не существуют.
 type Storage interface {
 	PingDB() error
 	AddNewAccount(context.Context, model.SimpleAccountData) (bool, string, error)
 	CheckLogin(context.Context, model.SimpleAccountData) (string, error)
-	AddData(context.Context, model.Metadata, string, string, string) error
-	GetMetadataByUserID(context.Context, string) ([]model.Metadata, error)
+	AddData(context.Context, model.Metadata, string) error
+	GetMetadata(context.Context) ([]model.Metadata, error)
+	GetMetadataByName(context.Context, string) (model.Metadata, error)
 	Delete(context.Context, model.DataToDelete) error
-	Edit(context.Context, model.EditData, string, string) error
+	Edit(context.Context, model.EditData, string) error
 	Read(context.Context, model.DataToRead) (string, error)
+	RewrapKeys(context.Context, int) (int, error)
+	MigrateEncryptedData(context.Context) error
+	GetVault(context.Context) (model.VaultParams, bool, error)
+	AddVault(context.Context, model.VaultParams) (bool, error)
+	DeleteAccount(context.Context, string) error
+	ChangePassword(context.Context, string, *model.SRPVerifier) (int64, error)
+	ChangeLogin(context.Context, string) error
+	GetSRPVerifier(context.Context, string) (string, model.SRPVerifier, error)
+	SetSRPVerifier(context.Context, model.SRPVerifier) error
+	PasswordLoginAccount(context.Context, string) (string, error)
+	ServerSecret(context.Context, string) ([]byte, error)
+	CreateSRPHandshake(context.Context, SRPHandshake, time.Duration) error
+	TakeSRPHandshake(context.Context, string) (SRPHandshake, error)
+	DeleteExpiredSRPHandshakes(context.Context) (int64, error)
+	CreateReauthToken(context.Context, string, string, string, time.Duration) error
+	RedeemReauthToken(context.Context, string, string, string) (bool, error)
+	DeleteExpiredReauthTokens(context.Context) (int64, error)
+	RegisterDevice(context.Context, string, model.DeviceInfo, string) (string, bool, error)
+	TouchDevice(context.Context, string, string) error
+	GetDevices(context.Context) ([]model.Device, error)
+	RevokeDevice(context.Context, string) error
+	AddFile(context.Context, model.Metadata, string, io.Reader) error
+	EditFile(context.Context, model.EditData, string, io.Reader) error
+	ReadFile(context.Context, model.DataToRead) (model.FileData, io.ReadCloser, error)
+	CreateSession(context.Context, string, string, string, []byte, time.Duration) error
+	RotateRefreshToken(context.Context, string, []byte, []byte, time.Duration) (string, error)
+	SessionActive(context.Context, string, string) (bool, error)
+	RevokeSession(context.Context, string) error
+	RevokeAllSessions(context.Context) (int64, error)
+	DeleteExpiredSessions(context.Context, time.Duration) (int64, error)
+	GetAccountLogin(context.Context) (string, error)
+	EnrollTOTP(context.Context, string) (bool, error)
+	ConfirmTOTP(context.Context, string) ([]string, error)
+	TOTPEnabled(context.Context, string) (bool, error)
+	CheckSecondFactor(context.Context, string, model.OTPData) (bool, error)
+	CreateMFAChallenge(context.Context, string, string, time.Duration) error
+	TakeMFAAttempt(context.Context, string, string, int) (bool, error)
+	DeleteMFAChallenge(context.Context, string) error
+	DeleteExpiredMFAChallenges(context.Context) (int64, error)
+	DisableTOTP(context.Context, model.OTPData) error
+	CreatePersonalToken(context.Context, model.PersonalToken, []byte) error
+	GetPersonalTokens(context.Context) ([]model.PersonalToken, error)
+	RevokePersonalToken(context.Context, string) error
+	CheckPersonalToken(context.Context, string, []byte) (string, model.TokenScopes, bool, error)
+	SaveClientCertificate(context.Context, model.ClientCertificate) error
+	GetClientCertificates(context.Context) ([]model.ClientCertificate, error)
+	RevokeClientCertificate(context.Context, string) error
+	CheckClientCertificate(context.Context, string) (string, string, bool, error)
+	CreateOIDCLogin(context.Context, OIDCLogin, time.Duration) error
+	GetOIDCLogin(context.Context, string) (OIDCLogin, error)
+	CompleteOIDCLogin(context.Context, string, model.OIDCIdentity, string, []byte) error
+	TakeOIDCLogin(context.Context, []byte) (OIDCLogin, error)
+	DeleteExpiredOIDCLogins(context.Context) (int64, error)
+	FindOIDCAccount(context.Context, model.OIDCIdentity) (string, error)
+	ProvisionOIDCAccount(context.Context, model.OIDCIdentity, string) (string, error)
+	LinkOIDCIdentity(context.Context, model.OIDCIdentity) error
+	GetOIDCIdentities(context.Context) ([]model.OIDCIdentity, error)
+	UnlinkOIDCIdentity(context.Context, model.OIDCIdentity) error
+	AccountRole(context.Context, string) (string, error)
+	AccountIDByLogin(context.Context, string) (string, error)
+	ListAccounts(context.Context) ([]model.AccountSummary, error)
+	SetAccountDisabled(context.Context, string, bool) error
+	SetAccountRole(context.Context, string, string) error
+	RevokeAccountSessions(context.Context, string) (int64, error)
+	CreateInvite(context.Context, []byte, time.Duration, int) (model.Invite, error)
+	GetInvites(context.Context) ([]model.Invite, error)
+	RevokeInvite(context.Context, string) error
+	AddInvitedAccount(context.Context, model.SimpleAccountData, []byte) (bool, string, string, error)
+	DeleteExpiredInvites(context.Context) (int64, error)
+	RecordAudit(context.Context, string, string) error
+	RecordAdminAudit(context.Context, string, string, string, string) error
+	GetAuditLog(context.Context, int) ([]model.AuditEvent, error)
+	ratelimit.Store
 	// TODO добавление произвольных данных
 	Close()
 }
 
-func NewDB(ctx context.Context, connectionString string) Storage {
+// NewDB подключается к базе записей и к отдельной базе ключей аккаунтов keyStoreString.
+func NewDB(ctx context.Context, connectionString, keyStoreString string) Storage {
 	dbData := PostgreDB{
 		DatabaseConnection: NewDBConnection(ctx, connectionString),
+		KeyStore:           NewDBConnection(ctx, keyStoreString),
 	}
 
 	err := dbData.CreateAccountsTable(ctx)
@@ -56,9 +137,128 @@ func NewDB(ctx context.Context, connectionString string) Storage {
 		return nil
 	}
 
+	err = dbData.CreateKeyIDColumns(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.CreateVaultTable(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.CreateAccountKeysTable(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.MoveAccountKeys(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.CreateFileChunksTable(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.CreateMetadataColumns(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.CreatePasswordHashColumn(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.CreateSessionsTable(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.CreateTOTPTables(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.CreatePersonalTokensTable(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.CreateAuditTable(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.CreateLoginFailuresTable(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.CreateDevicesTable(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.CreateSRPTables(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.CreateCertificatesTable(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.CreateOIDCTables(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.CreateAdminColumns(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
+	err = dbData.CreateInviteTables(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
+
 	return dbData
 }
 
+// MigrateEncryptedData переводит данные старых форматов на текущие. Для этого нужен
+// мастер-ключ, поэтому запечатанный сервер вызывает его после распечатывания.
+func (dbData PostgreDB) MigrateEncryptedData(ctx context.Context) error {
+	err := dbData.EncryptPlainMetadata(ctx)
+	if err != nil {
+		return err
+	}
+
+	return dbData.ReencryptUnboundData(ctx)
+}
+
 func NewDBConnection(ctx context.Context, connectionString string) *sql.DB {
 	// Закрывается в основном потоке
 	db, err := sql.Open("pgx", connectionString)
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/model"
+
+	devicesmigrations "gophkeep/internal/database/devices_migrations"
+
+	"github.com/google/uuid"
+)
+
+// ErrDeviceNotFound - у аккаунта нет такого действующего устройства.
+var ErrDeviceNotFound = errors.New("device not found")
+
+const revokeDevice = "device revoked"
+
+func (dbData PostgreDB) CreateDevicesTable(ctx context.Context) error {
+	return dbData.upMigrations(ctx, devicesmigrations.EmbedDevices)
+}
+
+// RegisterDevice отмечает вход с устройства. Известное действующее устройство аккаунта
+// обновляется, иначе регистрируется новое. Возвращает id устройства и признак того, что оно новое.
+func (dbData PostgreDB) RegisterDevice(ctx context.Context, userID string, device model.DeviceInfo, ip string) (string, bool, error) {
+	if len(device.ID) != 0 {
+		stmt := "UPDATE devices SET (name, os, client_version, last_seen_at, last_ip) = ($1, $2, $3, CURRENT_TIMESTAMP, $4)" +
+			" WHERE id = $5 AND account_uuid = $6 AND revoked_at IS NULL"
+		result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, device.Name, device.OS, device.ClientVersion, ip, device.ID, userID)
+		if err != nil {
+			return "", false, err
+		}
+
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return "", false, err
+		}
+		if updated != 0 {
+			return device.ID, false, nil
+		}
+	}
+
+	// id выдает сервер, чтобы клиент не мог занять чужое или отозванное устройство
+	deviceID := uuid.New().String()
+	stmt := "INSERT INTO devices (id, account_uuid, name, os, client_version, last_ip) VALUES ($1, $2, $3, $4, $5, $6)"
+	_, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, deviceID, userID, device.Name, device.OS, device.ClientVersion, ip)
+	if err != nil {
+		return "", false, err
+	}
+
+	return deviceID, true, nil
+}
+
+// TouchDevice отмечает активность устройства, которому принадлежит сессия.
+func (dbData PostgreDB) TouchDevice(ctx context.Context, sessionID string, ip string) error {
+	stmt := "UPDATE devices SET (last_seen_at, last_ip) = (CURRENT_TIMESTAMP, $2)" +
+		" WHERE id = (SELECT device_id FROM sessions WHERE id = $1)"
+	_, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, sessionID, ip)
+	return err
+}
+
+// GetDevices возвращает действующие устройства аккаунта из контекста и отмечает то,
+// с которого выполняется запрос.
+func (dbData PostgreDB) GetDevices(ctx context.Context) ([]model.Device, error) {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sessionID, _ := auth.SessionIDFromContext(ctx)
+
+	stmt := "SELECT id, name, os, client_version, created_at, last_seen_at, last_ip," +
+		" id IS NOT DISTINCT FROM (SELECT device_id FROM sessions WHERE id = $2)" +
+		" FROM devices WHERE account_uuid = $1 AND revoked_at IS NULL ORDER BY last_seen_at DESC"
+	rows, err := dbData.DatabaseConnection.QueryContext(ctx, stmt, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := make([]model.Device, 0)
+	for rows.Next() {
+		var device model.Device
+		err = rows.Scan(&device.ID, &device.Name, &device.OS, &device.ClientVersion, &device.Created,
+			&device.LastSeenAt, &device.LastIP, &device.Current)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+
+	return devices, rows.Err()
+}
+
+// RevokeDevice отзывает устройство аккаунта из контекста вместе со всеми его сессиями.
+func (dbData PostgreDB) RevokeDevice(ctx context.Context, deviceID string) error {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var revoked string
+	stmt := "UPDATE devices SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND account_uuid = $2 AND revoked_at IS NULL RETURNING id"
+	err = tx.QueryRowContext(ctx, stmt, deviceID, userID).Scan(&revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrDeviceNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	stmt = "UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = $3" +
+		" WHERE device_id = $1 AND account_uuid = $2 AND revoked_at IS NULL"
+	if _, err = tx.ExecContext(ctx, stmt, deviceID, userID, revokeDevice); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS devices(
+    id             TEXT PRIMARY KEY,
+    account_uuid   TEXT NOT NULL,
+    name           TEXT NOT NULL,
+    os             TEXT NOT NULL,
+    client_version TEXT NOT NULL,
+    created_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_ip        TEXT NOT NULL,
+    revoked_at     TIMESTAMP
+    );
+CREATE INDEX IF NOT EXISTS devices_account_uuid_idx ON devices (account_uuid);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device_id TEXT;
+CREATE INDEX IF NOT EXISTS sessions_device_id_idx ON sessions (device_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS sessions_device_id_idx;
+ALTER TABLE sessions DROP COLUMN IF EXISTS device_id;
+DROP TABLE IF EXISTS devices;
+-- +goose StatementEnd
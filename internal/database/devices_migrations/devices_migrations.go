@@ -0,0 +1,6 @@
+package devicesmigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedDevices embed.FS
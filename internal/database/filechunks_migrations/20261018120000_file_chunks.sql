@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS file_chunks(
+    file_id TEXT NOT NULL REFERENCES files (id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+    seq     INTEGER NOT NULL,
+    data    BYTEA NOT NULL,
+    PRIMARY KEY (file_id, seq)
+    );
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS file_chunks;
+-- +goose StatementEnd
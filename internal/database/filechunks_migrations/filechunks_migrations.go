@@ -0,0 +1,6 @@
+package filechunksmigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedFileChunks embed.FS
@@ -0,0 +1,297 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"gophkeep/internal/encryption"
+	"gophkeep/internal/kinds"
+	"gophkeep/internal/model"
+	"io"
+	"strings"
+
+	filechunksmigrations "gophkeep/internal/database/filechunks_migrations"
+)
+
+// Содержимое файла хранится потоком зашифрованных кусков в file_chunks,
+// а в files остаются только имя и размер. Так файл не собирается в памяти целиком.
+
+func (dbData PostgreDB) CreateFileChunksTable(ctx context.Context) error {
+	return dbData.upMigrations(ctx, filechunksmigrations.EmbedFileChunks)
+}
+
+// AddFile сохраняет файл аккаунта из контекста, шифруя его содержимое потоком по мере чтения из content.
+func (dbData PostgreDB) AddFile(ctx context.Context, metadata model.Metadata, fileName string, content io.Reader) error {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	metadata.UserID = userID
+
+	kind, err := kinds.Lookup(kinds.Files)
+	if err != nil {
+		return err
+	}
+	metadata.DataType = kind.Name
+
+	err = checkCreateScope(ctx, kind.Name)
+	if err != nil {
+		return err
+	}
+
+	record := encryption.Record{
+		StaticID: metadata.StaticID,
+		UserID:   metadata.UserID,
+		DataType: kind.Name,
+		KeyID:    encryption.AccountWrappedKeyID,
+	}
+
+	encryptedSK, realSK, err := dbData.newObjectKey(ctx, record)
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = dbData.insertInfo(ctx, tx, metadata)
+	if err != nil {
+		return err
+	}
+
+	encryptedData, err := writeFileChunks(ctx, tx, record, realSK, fileName, kind.LimitReader(content))
+	if err != nil {
+		return err
+	}
+
+	fileInsertStmt := "INSERT INTO " + kind.Table + " (id, data, sk, key_id) VALUES ($1, $2, $3, $4)"
+	_, err = tx.ExecContext(ctx, fileInsertStmt, metadata.StaticID, encryptedData, encryptedSK, record.KeyID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// EditFile заменяет содержимое файла, перешифровывая его новым ключом объекта.
+func (dbData PostgreDB) EditFile(ctx context.Context, editData model.EditData, fileName string, content io.Reader) error {
+	kind, err := kinds.Lookup(kinds.Files)
+	if err != nil {
+		return err
+	}
+
+	decryptedData, err := dataAccess(ctx, dbData, editData.StaticID, kind.Name)
+	if err != nil {
+		return err
+	}
+
+	if len(decryptedData) == 0 {
+		return errors.New("data is not accessible")
+	}
+
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	record := encryption.Record{
+		StaticID: editData.StaticID,
+		UserID:   userID,
+		DataType: kind.Name,
+		KeyID:    encryption.AccountWrappedKeyID,
+	}
+
+	encryptedSK, realSK, err := dbData.newObjectKey(ctx, record)
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	editData.DataType = kind.Name
+	err = dbData.updateInfo(ctx, tx, userID, editData)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM file_chunks WHERE file_id = $1", editData.StaticID)
+	if err != nil {
+		return err
+	}
+
+	encryptedData, err := writeFileChunks(ctx, tx, record, realSK, fileName, kind.LimitReader(content))
+	if err != nil {
+		return err
+	}
+
+	updateStmt := "UPDATE " + kind.Table + " SET (data, sk, key_id) = ($1, $2, $3) WHERE id = $4"
+	_, err = tx.ExecContext(ctx, updateStmt, encryptedData, encryptedSK, record.KeyID, editData.StaticID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReadFile возвращает имя и размер файла и поток с расшифрованным содержимым.
+// Поток держит открытым запрос к базе, его нужно закрыть после чтения.
+func (dbData PostgreDB) ReadFile(ctx context.Context, readData model.DataToRead) (model.FileData, io.ReadCloser, error) {
+	var fileData model.FileData
+
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return fileData, nil, err
+	}
+
+	stored, err := dbData.loadRecord(ctx, userID, readData.StaticID, kinds.Files)
+	if err != nil {
+		return fileData, nil, err
+	}
+
+	decryptedData, err := encryption.DecryptData(stored.accountKey, stored.record, stored.sk, stored.data)
+	if errors.Is(err, encryption.ErrIntegrity) {
+		logIntegrityViolation(stored.record, userID)
+		return fileData, nil, err
+	}
+	if err != nil {
+		return fileData, nil, err
+	}
+
+	if err = json.Unmarshal([]byte(decryptedData), &fileData); err != nil {
+		return fileData, nil, err
+	}
+
+	// файлы, сохраненные до потокового формата, лежат целиком в записи
+	if len(fileData.Data) != 0 {
+		content := fileData.Data
+		fileData.Data = ""
+		return fileData, io.NopCloser(strings.NewReader(content)), nil
+	}
+
+	realSK, err := encryption.UnwrapSK(stored.accountKey, stored.record, stored.sk)
+	if errors.Is(err, encryption.ErrIntegrity) {
+		logIntegrityViolation(stored.record, userID)
+		return fileData, nil, err
+	}
+	if err != nil {
+		return fileData, nil, err
+	}
+
+	rows, err := dbData.DatabaseConnection.QueryContext(ctx, "SELECT data FROM file_chunks WHERE file_id = $1 ORDER BY seq", readData.StaticID)
+	if err != nil {
+		return fileData, nil, err
+	}
+
+	chunks := &chunkReader{rows: rows}
+	content, err := encryption.NewRecordStreamReader(chunks, realSK, stored.record)
+	if err != nil {
+		rows.Close()
+		if errors.Is(err, encryption.ErrStreamTruncated) {
+			logIntegrityViolation(stored.record, userID)
+		}
+		return fileData, nil, err
+	}
+
+	return fileData, &fileContentReader{
+		Reader: content,
+		chunks: chunks,
+		record: stored.record,
+		userID: userID,
+	}, nil
+}
+
+// writeFileChunks шифрует content в file_chunks и возвращает зашифрованные имя и размер файла.
+func writeFileChunks(ctx context.Context, tx *sql.Tx, record encryption.Record, realSK []byte, fileName string, content io.Reader) (string, error) {
+	chunks := &chunkWriter{ctx: ctx, tx: tx, fileID: record.StaticID}
+	stream, err := encryption.NewRecordStreamWriter(chunks, realSK, record)
+	if err != nil {
+		return "", err
+	}
+
+	size, err := io.Copy(stream, content)
+	if err != nil {
+		return "", err
+	}
+
+	if err = stream.Close(); err != nil {
+		return "", err
+	}
+
+	fileJSON, err := json.Marshal(model.FileData{Name: fileName, Size: size})
+	if err != nil {
+		return "", err
+	}
+
+	return encryption.EncryptSimpleData(realSK, record, string(fileJSON))
+}
+
+// chunkWriter сохраняет каждую запись потока отдельной строкой file_chunks.
+type chunkWriter struct {
+	ctx    context.Context
+	tx     *sql.Tx
+	fileID string
+	seq    int
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	_, err := w.tx.ExecContext(w.ctx, "INSERT INTO file_chunks (file_id, seq, data) VALUES ($1, $2, $3)", w.fileID, w.seq, p)
+	if err != nil {
+		return 0, err
+	}
+	w.seq++
+
+	return len(p), nil
+}
+
+// chunkReader склеивает строки file_chunks в один поток, читая их по одной.
+type chunkReader struct {
+	rows  *sql.Rows
+	chunk []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if !r.rows.Next() {
+			if err := r.rows.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		if err := r.rows.Scan(&r.chunk); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// fileContentReader пишет событие безопасности, если поток файла изменен или обрезан.
+type fileContentReader struct {
+	io.Reader
+	chunks *chunkReader
+	record encryption.Record
+	userID string
+}
+
+func (r *fileContentReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if errors.Is(err, encryption.ErrIntegrity) || errors.Is(err, encryption.ErrStreamTruncated) {
+		logIntegrityViolation(r.record, r.userID)
+	}
+
+	return n, err
+}
+
+func (r *fileContentReader) Close() error {
+	return r.chunks.rows.Close()
+}
@@ -0,0 +1,390 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophkeep/internal/encryption"
+	"gophkeep/internal/kinds"
+	"gophkeep/internal/model"
+	"io"
+	"time"
+
+	quarantinemigrations "gophkeep/internal/database/quarantine_migrations"
+)
+
+// Проверка целостности хранилища. AddData, Edit и Delete пишут сразу в infos и таблицу
+// данных, поэтому после сбоев или ручных правок могут остаться строки без пары,
+// а шифртекст - перестать расшифровываться.
+const (
+	// ProblemOrphanInfo - метаданные без строки с данными.
+	ProblemOrphanInfo = "orphan_info"
+	// ProblemOrphanData - строка с данными без метаданных.
+	ProblemOrphanData = "orphan_data"
+	// ProblemOrphanAccount - запись аккаунта, которого уже нет.
+	ProblemOrphanAccount = "orphan_account"
+	// ProblemUnknownType - тип записи не соответствует ни одной таблице данных.
+	ProblemUnknownType = "unknown_type"
+	// ProblemAccountKey - ключ аккаунта не разворачивается мастер-ключом.
+	ProblemAccountKey = "account_key"
+	// ProblemUndecryptable - данные записи не расшифровываются.
+	ProblemUndecryptable = "undecryptable"
+	// ProblemMetadata - имя или описание записи не расшифровываются.
+	ProblemMetadata = "undecryptable_metadata"
+)
+
+const (
+	FsckActionDeleted     = "deleted"
+	FsckActionQuarantined = "quarantined"
+)
+
+// FsckOptions задает, что делать с найденными проблемами. Repair удаляет строки без пары
+// и записи удаленных аккаунтов, нерасшифровываемые записи при этом только попадают в отчет.
+// Quarantine переносит любые сломанные записи в таблицу quarantine и убирает их из рабочих таблиц.
+type FsckOptions struct {
+	Repair     bool
+	Quarantine bool
+}
+
+type FsckProblem struct {
+	Kind     string `json:"kind"`
+	StaticID string `json:"static_id,omitempty"`
+	DataType string `json:"data_type,omitempty"`
+	Account  string `json:"account,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	// Action - что сделано с записью; пусто, если запись оставлена как есть.
+	Action string `json:"action,omitempty"`
+}
+
+type FsckReport struct {
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Accounts   int           `json:"accounts"`
+	Records    int           `json:"records"`
+	Problems   []FsckProblem `json:"problems"`
+}
+
+// Unresolved возвращает число проблем, которые остались в хранилище.
+func (report FsckReport) Unresolved() int {
+	count := 0
+	for _, problem := range report.Problems {
+		if len(problem.Action) == 0 {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (dbData PostgreDB) CreateQuarantineTable(ctx context.Context) error {
+	return dbData.upMigrations(ctx, quarantinemigrations.EmbedQuarantine)
+}
+
+// Fsck обходит все аккаунты и проверяет, что у каждой записи есть и метаданные, и данные,
+// а данные, ключ объекта и метаданные расшифровываются. Для проверки нужен мастер-ключ.
+// Исправлять хранилище лучше на остановленном сервере, чтобы не задеть записи в процессе изменения.
+func (dbData PostgreDB) Fsck(ctx context.Context, options FsckOptions) (FsckReport, error) {
+	report := FsckReport{StartedAt: time.Now(), Problems: make([]FsckProblem, 0)}
+
+	if options.Quarantine {
+		if err := dbData.CreateQuarantineTable(ctx); err != nil {
+			return report, err
+		}
+	}
+
+	accounts, err := queryStrings(ctx, dbData.DatabaseConnection, "SELECT uuid FROM "+accountsTableName+" ORDER BY uuid")
+	if err != nil {
+		return report, err
+	}
+
+	for _, userID := range accounts {
+		accountKey, err := dbData.existingAccountKey(ctx, userID)
+		if err != nil {
+			report.Problems = append(report.Problems, FsckProblem{Kind: ProblemAccountKey, Account: userID, Detail: err.Error()})
+		}
+
+		infos, err := dbData.queryInfos(ctx, "WHERE account_uuid = $1", userID)
+		if err != nil {
+			return report, err
+		}
+
+		for _, info := range infos {
+			problem, ok, err := dbData.checkRecord(ctx, accountKey, info)
+			if err != nil {
+				return report, err
+			}
+			if ok {
+				report.Problems = append(report.Problems, problem)
+			}
+		}
+
+		report.Accounts++
+		report.Records += len(infos)
+	}
+
+	// записи, оставшиеся от удаленных аккаунтов
+	orphans, err := dbData.queryInfos(ctx, "WHERE NOT EXISTS (SELECT 1 FROM "+accountsTableName+" a WHERE a.uuid = infos.account_uuid)")
+	if err != nil {
+		return report, err
+	}
+	for _, info := range orphans {
+		report.Problems = append(report.Problems, FsckProblem{
+			Kind:     ProblemOrphanAccount,
+			StaticID: info.StaticID,
+			DataType: info.DataType,
+			Account:  info.UserID,
+		})
+	}
+	report.Records += len(orphans)
+
+	for _, kind := range kinds.All() {
+		stmt := "SELECT d.id FROM " + kind.Table + " d" +
+			" WHERE NOT EXISTS (SELECT 1 FROM infos i WHERE i.static_id = d.id AND i.type = $1) ORDER BY d.id"
+		ids, err := queryStrings(ctx, dbData.DatabaseConnection, stmt, kind.Name)
+		if err != nil {
+			return report, err
+		}
+
+		for _, id := range ids {
+			report.Problems = append(report.Problems, FsckProblem{Kind: ProblemOrphanData, StaticID: id, DataType: kind.Name})
+		}
+	}
+
+	if options.Repair || options.Quarantine {
+		for i := range report.Problems {
+			action, err := dbData.resolveProblem(ctx, report.Problems[i], options)
+			if err != nil {
+				return report, err
+			}
+			report.Problems[i].Action = action
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+type fsckInfo struct {
+	model.Metadata
+	version int
+}
+
+func (dbData PostgreDB) queryInfos(ctx context.Context, where string, args ...any) ([]fsckInfo, error) {
+	stmt := "SELECT static_id, name, description, type, account_uuid, metadata_version FROM infos " + where + " ORDER BY static_id"
+	rows, err := dbData.DatabaseConnection.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	infos := make([]fsckInfo, 0)
+	for rows.Next() {
+		var info fsckInfo
+		err := rows.Scan(&info.StaticID, &info.Name, &info.Description, &info.DataType, &info.UserID, &info.version)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, rows.Err()
+}
+
+// existingAccountKey возвращает ключ аккаунта, не создавая его. У аккаунтов, созданных
+// до появления ключей аккаунтов, ключа может не быть - тогда возвращается nil.
+func (dbData PostgreDB) existingAccountKey(ctx context.Context, userID string) ([]byte, error) {
+	var wrappedKey string
+
+	stmt := "SELECT wrapped_key FROM account_keys WHERE account_uuid = $1"
+	err := dbData.KeyStore.QueryRowContext(ctx, stmt, userID).Scan(&wrappedKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return encryption.UnwrapAccountKey(wrappedKey)
+}
+
+// checkRecord проверяет одну запись. Возвращает ok = true, если у записи есть проблема.
+// Ошибка возвращается только при сбоях самой базы.
+func (dbData PostgreDB) checkRecord(ctx context.Context, accountKey []byte, info fsckInfo) (FsckProblem, bool, error) {
+	problem := FsckProblem{StaticID: info.StaticID, DataType: info.DataType, Account: info.UserID}
+
+	kind, err := kinds.Lookup(info.DataType)
+	if err != nil {
+		problem.Kind = ProblemUnknownType
+		return problem, true, nil
+	}
+
+	record := encryption.Record{StaticID: info.StaticID, UserID: info.UserID, DataType: kind.Name}
+	var data, sk string
+	stmt := "SELECT data, sk, key_id FROM " + kind.Table + " WHERE id = $1"
+	err = dbData.DatabaseConnection.QueryRowContext(ctx, stmt, info.StaticID).Scan(&data, &sk, &record.KeyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		problem.Kind = ProblemOrphanInfo
+		return problem, true, nil
+	}
+	if err != nil {
+		return problem, false, err
+	}
+
+	decryptedData, err := encryption.DecryptData(accountKey, record, sk, data)
+	if err == nil && kind.Stream {
+		err = dbData.checkFileContent(ctx, accountKey, record, sk, decryptedData)
+	}
+	if err != nil {
+		problem.Kind = ProblemUndecryptable
+		problem.Detail = err.Error()
+		return problem, true, nil
+	}
+
+	metadata := info.Metadata
+	if err := openMetadata(accountKey, &metadata, info.version); err != nil {
+		problem.Kind = ProblemMetadata
+		problem.Detail = err.Error()
+		return problem, true, nil
+	}
+
+	return problem, false, nil
+}
+
+// checkFileContent дочитывает поток файла до конца, проверяя каждый кусок.
+func (dbData PostgreDB) checkFileContent(ctx context.Context, accountKey []byte, record encryption.Record, sk string, decryptedData string) error {
+	var fileData model.FileData
+	if err := json.Unmarshal([]byte(decryptedData), &fileData); err != nil {
+		return err
+	}
+
+	// файл старого формата лежит целиком в записи и уже расшифрован
+	if len(fileData.Data) != 0 {
+		return nil
+	}
+
+	realSK, err := encryption.UnwrapSK(accountKey, record, sk)
+	if err != nil {
+		return err
+	}
+
+	rows, err := dbData.DatabaseConnection.QueryContext(ctx, "SELECT data FROM file_chunks WHERE file_id = $1 ORDER BY seq", record.StaticID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	content, err := encryption.NewRecordStreamReader(&chunkReader{rows: rows}, realSK, record)
+	if err != nil {
+		return err
+	}
+
+	size, err := io.Copy(io.Discard, content)
+	if err != nil {
+		return err
+	}
+
+	if size != fileData.Size {
+		return fmt.Errorf("file size %d does not match stored size %d", size, fileData.Size)
+	}
+
+	return nil
+}
+
+// resolveProblem исправляет проблему согласно options и возвращает выполненное действие.
+func (dbData PostgreDB) resolveProblem(ctx context.Context, problem FsckProblem, options FsckOptions) (string, error) {
+	// строку без пары удаляем только с той стороны, где она есть: static_id
+	// строки данных может совпадать с id записи другого типа
+	dropInfo, dropData := true, true
+	switch problem.Kind {
+	case ProblemOrphanInfo, ProblemUnknownType:
+		dropData = false
+	case ProblemOrphanData:
+		dropInfo = false
+	case ProblemUndecryptable, ProblemMetadata:
+		if !options.Quarantine {
+			return "", nil
+		}
+	case ProblemAccountKey:
+		// ключ аккаунта не восстановить, его записи попадают в отчет по отдельности
+		return "", nil
+	}
+
+	kind, err := kinds.Lookup(problem.DataType)
+	if err != nil {
+		dropData = false
+	}
+
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	action := FsckActionDeleted
+	if options.Quarantine {
+		err = quarantineRecord(ctx, tx, problem, kind, dropInfo, dropData)
+		if err != nil {
+			return "", err
+		}
+		action = FsckActionQuarantined
+	}
+
+	if dropInfo {
+		_, err = tx.ExecContext(ctx, "DELETE FROM infos WHERE static_id = $1 AND type = $2", problem.StaticID, problem.DataType)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if dropData {
+		// куски файла удаляются каскадно
+		_, err = tx.ExecContext(ctx, "DELETE FROM "+kind.Table+" WHERE id = $1", problem.StaticID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return action, tx.Commit()
+}
+
+// quarantineRecord копирует строки записи в quarantine в виде JSON, чтобы их можно было
+// изучить или вернуть вручную.
+func quarantineRecord(ctx context.Context, tx *sql.Tx, problem FsckProblem, kind kinds.Kind, withInfo bool, withData bool) error {
+	info, data, chunks := "NULL", "NULL", "NULL"
+	if withInfo {
+		info = "(SELECT row_to_json(i) FROM infos i WHERE i.static_id = $1 AND i.type = $2)"
+	}
+	if withData {
+		data = "(SELECT row_to_json(d) FROM " + kind.Table + " d WHERE d.id = $1)"
+	}
+	if withData && kind.Stream {
+		chunks = "(SELECT json_agg(c ORDER BY c.seq) FROM file_chunks c WHERE c.file_id = $1)"
+	}
+
+	stmt := "INSERT INTO quarantine (static_id, data_type, account_uuid, reason, info, data, chunks)" +
+		" VALUES ($1, $2, NULLIF($3, ''), $4, " + info + ", " + data + ", " + chunks + ")"
+	_, err := tx.ExecContext(ctx, stmt, problem.StaticID, problem.DataType, problem.Account, problem.Kind)
+
+	return err
+}
+
+func queryStrings(ctx context.Context, db *sql.DB, stmt string, args ...any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make([]string, 0)
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gophkeep/internal/model"
+	"time"
+
+	invitesmigrations "gophkeep/internal/database/invites_migrations"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInviteInvalid - приглашения с таким кодом нет, оно использовано или истекло.
+	ErrInviteInvalid = errors.New("invite code is invalid or expired")
+	// ErrInviteQuota - аккаунт исчерпал квоту приглашений.
+	ErrInviteQuota = errors.New("invite quota exceeded")
+	// ErrInviteNotFound - у аккаунта нет такого неиспользованного приглашения.
+	ErrInviteNotFound = errors.New("invite not found")
+)
+
+func (dbData PostgreDB) CreateInviteTables(ctx context.Context) error {
+	return dbData.upMigrations(ctx, invitesmigrations.EmbedInvites)
+}
+
+// CreateInvite сохраняет приглашение аккаунта из контекста на lifetime. В квоту входят
+// использованные и еще действующие приглашения, отрицательная квота не ограничена.
+func (dbData PostgreDB) CreateInvite(ctx context.Context, codeHash []byte, lifetime time.Duration, quota int) (model.Invite, error) {
+	invite := model.Invite{ID: uuid.New().String()}
+
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return invite, err
+	}
+
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return invite, err
+	}
+	defer tx.Rollback()
+
+	// блокировка аккаунта не дает параллельным запросам обойти квоту
+	stmt := "SELECT uuid FROM " + accountsTableName + " WHERE uuid = $1 FOR UPDATE"
+	if err = tx.QueryRowContext(ctx, stmt, userID).Scan(&userID); err != nil {
+		return invite, err
+	}
+
+	if quota >= 0 {
+		var count int
+		stmt = "SELECT COUNT(*) FROM invites WHERE created_by = $1" +
+			" AND (used_at IS NOT NULL OR expires_at > CURRENT_TIMESTAMP)"
+		if err = tx.QueryRowContext(ctx, stmt, userID).Scan(&count); err != nil {
+			return invite, err
+		}
+		if count >= quota {
+			return invite, ErrInviteQuota
+		}
+	}
+
+	stmt = "INSERT INTO invites (id, code_hash, created_by, expires_at)" +
+		" VALUES ($1, $2, $3, CURRENT_TIMESTAMP + make_interval(secs => $4)) RETURNING created_at, expires_at"
+	err = tx.QueryRowContext(ctx, stmt, invite.ID, codeHash, userID, lifetime.Seconds()).Scan(&invite.Created, &invite.Expires)
+	if err != nil {
+		return invite, err
+	}
+
+	return invite, tx.Commit()
+}
+
+// GetInvites возвращает приглашения аккаунта из контекста. Для использованных указан
+// логин зарегистрированного аккаунта.
+func (dbData PostgreDB) GetInvites(ctx context.Context) ([]model.Invite, error) {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := "SELECT i.id, i.created_at, i.expires_at, COALESCE(a.username, i.used_by, ''), i.used_at FROM invites i" +
+		" LEFT JOIN " + accountsTableName + " a ON a.uuid = i.used_by WHERE i.created_by = $1 ORDER BY i.created_at"
+	rows, err := dbData.DatabaseConnection.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := make([]model.Invite, 0)
+	for rows.Next() {
+		var invite model.Invite
+		var usedAt sql.NullTime
+		if err = rows.Scan(&invite.ID, &invite.Created, &invite.Expires, &invite.UsedBy, &usedAt); err != nil {
+			return nil, err
+		}
+		if usedAt.Valid {
+			invite.UsedAt = &usedAt.Time
+		}
+		invites = append(invites, invite)
+	}
+
+	return invites, rows.Err()
+}
+
+// RevokeInvite удаляет неиспользованное приглашение аккаунта из контекста.
+func (dbData PostgreDB) RevokeInvite(ctx context.Context, inviteID string) error {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	stmt := "DELETE FROM invites WHERE id = $1 AND created_by = $2 AND used_at IS NULL"
+	result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, inviteID, userID)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return ErrInviteNotFound
+	}
+
+	return nil
+}
+
+// AddInvitedAccount создает аккаунт по приглашению и возвращает, кроме результата
+// AddNewAccount, id пригласившего. Приглашение гасится вместе с созданием аккаунта, если
+// логин занят, оно остается действующим.
+func (dbData PostgreDB) AddInvitedAccount(ctx context.Context, accountData model.SimpleAccountData, codeHash []byte) (bool, string, string, error) {
+	id := uuid.New().String()
+
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return false, "", "", err
+	}
+	defer tx.Rollback()
+
+	var invitedBy string
+	stmt := "UPDATE invites SET used_by = $2, used_at = CURRENT_TIMESTAMP" +
+		" WHERE code_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP RETURNING created_by"
+	err = tx.QueryRowContext(ctx, stmt, codeHash, id).Scan(&invitedBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, "", "", ErrInviteInvalid
+	}
+	if err != nil {
+		return false, "", "", err
+	}
+
+	loginInUse, err := insertAccount(ctx, tx, id, accountData, sql.NullString{String: invitedBy, Valid: true})
+	if loginInUse || err != nil {
+		return loginInUse, "", "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, "", "", err
+	}
+
+	if _, err = dbData.accountKey(ctx, id); err != nil {
+		return false, "", "", err
+	}
+
+	return false, id, invitedBy, nil
+}
+
+// DeleteExpiredInvites удаляет истекшие неиспользованные приглашения. Использованные
+// остаются как запись о том, кто кого пригласил.
+func (dbData PostgreDB) DeleteExpiredInvites(ctx context.Context) (int64, error) {
+	stmt := "DELETE FROM invites WHERE used_at IS NULL AND expires_at < CURRENT_TIMESTAMP"
+	result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
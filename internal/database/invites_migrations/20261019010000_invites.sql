@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS invites(
+    id TEXT PRIMARY KEY,
+    code_hash BYTEA NOT NULL UNIQUE,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    used_by TEXT,
+    used_at TIMESTAMP
+    );
+CREATE INDEX IF NOT EXISTS invites_created_by ON invites (created_by);
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS invited_by TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE accounts DROP COLUMN IF EXISTS invited_by;
+DROP TABLE IF EXISTS invites;
+-- +goose StatementEnd
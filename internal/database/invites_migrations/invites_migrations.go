@@ -0,0 +1,6 @@
+package invitesmigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedInvites embed.FS
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS key_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE passwords ADD COLUMN IF NOT EXISTS key_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS key_id INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS cards_key_id_idx ON cards (key_id);
+CREATE INDEX IF NOT EXISTS passwords_key_id_idx ON passwords (key_id);
+CREATE INDEX IF NOT EXISTS files_key_id_idx ON files (key_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE cards DROP COLUMN IF EXISTS key_id;
+ALTER TABLE passwords DROP COLUMN IF EXISTS key_id;
+ALTER TABLE files DROP COLUMN IF EXISTS key_id;
+-- +goose StatementEnd
@@ -0,0 +1,6 @@
+package keyidsmigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedKeyIDs embed.FS
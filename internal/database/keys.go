@@ -0,0 +1,421 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gophkeep/internal/encryption"
+	"gophkeep/internal/kinds"
+	"log"
+	"time"
+
+	accountkeysmigrations "gophkeep/internal/database/accountkeys_migrations"
+)
+
+// ErrNoAccountKey - у аккаунта нет ключа: аккаунт удален и его данные уничтожены.
+var ErrNoAccountKey = errors.New("account key does not exist")
+
+// ErrSharedKeyStore - ключи аккаунтов настроены на ту же базу, что и записи.
+var ErrSharedKeyStore = errors.New("key store must be a separate database")
+
+// CreateAccountKeysTable создает таблицу ключей аккаунтов в отдельной базе ключей.
+func (dbData PostgreDB) CreateAccountKeysTable(ctx context.Context) error {
+	return PostgreDB{DatabaseConnection: dbData.KeyStore}.upMigrations(ctx, accountkeysmigrations.EmbedAccountKeys)
+}
+
+// MoveAccountKeys переносит ключи аккаунтов, созданные до появления базы ключей, из базы
+// записей и удаляет там таблицу. Повторный запуск после сбоя продолжает перенос.
+func (dbData PostgreDB) MoveAccountKeys(ctx context.Context) error {
+	mainDB, err := databaseIdentity(ctx, dbData.DatabaseConnection)
+	if err != nil {
+		return err
+	}
+	keyStore, err := databaseIdentity(ctx, dbData.KeyStore)
+	if err != nil {
+		return err
+	}
+	if mainDB == keyStore {
+		return ErrSharedKeyStore
+	}
+
+	var exists bool
+	err = dbData.DatabaseConnection.QueryRowContext(ctx, "SELECT to_regclass('account_keys') IS NOT NULL").Scan(&exists)
+	if err != nil || !exists {
+		return err
+	}
+
+	rows, err := dbData.DatabaseConnection.QueryContext(ctx, "SELECT account_uuid, wrapped_key, key_id, created_at FROM account_keys")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	insertStmt := "INSERT INTO account_keys (account_uuid, wrapped_key, key_id, created_at) VALUES ($1, $2, $3, $4)" +
+		" ON CONFLICT (account_uuid) DO NOTHING"
+	count := 0
+	for rows.Next() {
+		var accountUUID, wrappedKey string
+		var keyID uint32
+		var createdAt time.Time
+		if err := rows.Scan(&accountUUID, &wrappedKey, &keyID, &createdAt); err != nil {
+			return err
+		}
+
+		_, err = dbData.KeyStore.ExecContext(ctx, insertStmt, accountUUID, wrappedKey, keyID, createdAt)
+		if err != nil {
+			return err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = dbData.DatabaseConnection.ExecContext(ctx, "DROP TABLE account_keys")
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Moved %d account keys to the key store", count)
+	return nil
+}
+
+// databaseIdentity возвращает адрес сервера и имя базы соединения.
+func databaseIdentity(ctx context.Context, db *sql.DB) (string, error) {
+	var name string
+	var addr sql.NullString
+	var port sql.NullInt64
+	err := db.QueryRowContext(ctx, "SELECT current_database(), inet_server_addr()::text, inet_server_port()").Scan(&name, &addr, &port)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s:%d/%s", addr.String, port.Int64, name), nil
+}
+
+// accountKey возвращает ключ аккаунта, при необходимости создавая его. Ключ создается
+// только для существующего аккаунта, чтобы удаленный пользователь не получил новый.
+func (dbData PostgreDB) accountKey(ctx context.Context, userID string) ([]byte, error) {
+	var wrappedKey string
+
+	stmt := "SELECT wrapped_key FROM account_keys WHERE account_uuid = $1"
+	err := dbData.KeyStore.QueryRowContext(ctx, stmt, userID).Scan(&wrappedKey)
+	if err == nil {
+		return encryption.UnwrapAccountKey(wrappedKey)
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	exists, err := dbData.accountExists(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNoAccountKey
+	}
+
+	wrappedKey, accountKey, err := encryption.GenerateAccountKey()
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, err := encryption.WrappingKeyID(wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	insertStmt := "INSERT INTO account_keys (account_uuid, wrapped_key, key_id) VALUES ($1, $2, $3)" +
+		" ON CONFLICT (account_uuid) DO NOTHING"
+	result, err := dbData.KeyStore.ExecContext(ctx, insertStmt, userID, wrappedKey, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if inserted == 0 {
+		// ключ успел создать параллельный запрос
+		err = dbData.KeyStore.QueryRowContext(ctx, stmt, userID).Scan(&wrappedKey)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoAccountKey
+		}
+		if err != nil {
+			return nil, err
+		}
+		return encryption.UnwrapAccountKey(wrappedKey)
+	}
+
+	// аккаунт могли удалить, пока создавался ключ
+	exists, err = dbData.accountExists(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := dbData.shredAccountKey(ctx, userID); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoAccountKey
+	}
+
+	return accountKey, nil
+}
+
+func (dbData PostgreDB) accountExists(ctx context.Context, userID string) (bool, error) {
+	var exists bool
+	stmt := "SELECT EXISTS (SELECT 1 FROM " + accountsTableName + " WHERE uuid = $1)"
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, stmt, userID).Scan(&exists)
+	return exists, err
+}
+
+// shredAccountKey удаляет ключ аккаунта из базы ключей.
+func (dbData PostgreDB) shredAccountKey(ctx context.Context, userID string) error {
+	_, err := dbData.KeyStore.ExecContext(ctx, "DELETE FROM account_keys WHERE account_uuid = $1", userID)
+	return err
+}
+
+// newObjectKey создает ключ объекта для записи, обернутый ключом её владельца.
+func (dbData PostgreDB) newObjectKey(ctx context.Context, record encryption.Record) (string, []byte, error) {
+	accountKey, err := dbData.accountKey(ctx, record.UserID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return encryption.GenerateSK(accountKey, record)
+}
+
+// encryptForAccount шифрует данные новым ключом объекта и оборачивает его ключом аккаунта.
+// Ключ и данные привязываются к записи.
+func (dbData PostgreDB) encryptForAccount(ctx context.Context, record encryption.Record, data string) (string, string, error) {
+	encryptedSK, realSK, err := dbData.newObjectKey(ctx, record)
+	if err != nil {
+		return "", "", err
+	}
+
+	encryptedData, err := encryption.EncryptSimpleData(realSK, record, data)
+	if err != nil {
+		return "", "", err
+	}
+
+	return encryptedData, encryptedSK, nil
+}
+
+// DeleteAccount удаляет аккаунт, его записи и ключ аккаунта. Ключ удаляется первым,
+// поэтому записи из прерванного удаления и из резервных копий базы записей уже не
+// расшифровываются. Если аккаунта нет, возвращается ErrAccountNotFound.
+func (dbData PostgreDB) DeleteAccount(ctx context.Context, userID string) error {
+	err := dbData.shredAccountKey(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmts := make([]string, 0)
+	for _, kind := range kinds.All() {
+		stmts = append(stmts, "DELETE FROM "+kind.Table+" WHERE id IN (SELECT static_id FROM infos WHERE account_uuid = $1)")
+	}
+	for _, stmt := range append(stmts,
+		"DELETE FROM infos WHERE account_uuid = $1",
+		"DELETE FROM vaults WHERE account_uuid = $1",
+		"DELETE FROM sessions WHERE account_uuid = $1",
+		"DELETE FROM devices WHERE account_uuid = $1",
+		"DELETE FROM recovery_codes WHERE account_uuid = $1",
+		"DELETE FROM account_totp WHERE account_uuid = $1",
+		"DELETE FROM mfa_challenges WHERE account_uuid = $1",
+		"DELETE FROM personal_tokens WHERE account_uuid = $1",
+		"DELETE FROM audit_log WHERE account_uuid = $1",
+		"DELETE FROM srp_handshakes WHERE account_uuid = $1",
+		"DELETE FROM client_certificates WHERE account_uuid = $1",
+		"DELETE FROM oidc_identities WHERE account_uuid = $1",
+		"DELETE FROM oidc_logins WHERE link_account_uuid = $1",
+		"DELETE FROM invites WHERE created_by = $1 AND used_at IS NULL",
+	) {
+		_, err = tx.ExecContext(ctx, stmt, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM "+accountsTableName+" WHERE uuid = $1", userID)
+	if err != nil {
+		return err
+	}
+	if err = accountUpdated(result); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	// ключ мог создать параллельный запрос записи
+	return dbData.shredAccountKey(ctx, userID)
+}
+
+// ReencryptUnboundData перешифровывает записи старых форматов: на JWT, с ключом,
+// обернутым напрямую мастер-ключом, и без привязки шифртекста к записи.
+func (dbData PostgreDB) ReencryptUnboundData(ctx context.Context) error {
+	for _, kind := range kinds.All() {
+		dataType := kind.Table
+		stmt := "SELECT d.id, d.data, d.sk, d.key_id, i.account_uuid FROM " + kind.Table + " d JOIN infos i ON i.static_id = d.id AND i.type = $1"
+		rows, err := dbData.DatabaseConnection.QueryContext(ctx, stmt, kind.Name)
+		if err != nil {
+			return err
+		}
+
+		type unboundRow struct {
+			data, sk string
+			record   encryption.Record
+		}
+		unboundRows := make([]unboundRow, 0)
+		for rows.Next() {
+			var row unboundRow
+			err := rows.Scan(&row.record.StaticID, &row.data, &row.sk, &row.record.KeyID, &row.record.UserID)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			row.record.DataType = kind.Name
+			if !encryption.IsBound(row.sk, row.data) {
+				unboundRows = append(unboundRows, row)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		updateStmt := "UPDATE " + dataType + " SET (data, sk, key_id) = ($1, $2, $3) WHERE id = $4 AND data = $5"
+		for _, row := range unboundRows {
+			accountKey, err := dbData.accountKey(ctx, row.record.UserID)
+			if err != nil {
+				log.Printf("Failed to get account key for record %s in %s: %v", row.record.StaticID, dataType, err)
+				continue
+			}
+
+			record := row.record
+			record.KeyID = encryption.AccountWrappedKeyID
+			newSK, newData, err := encryption.Reencrypt(accountKey, row.record, record, row.sk, row.data)
+			if err != nil {
+				log.Printf("Failed to reencrypt record %s in %s: %v", row.record.StaticID, dataType, err)
+				continue
+			}
+
+			_, err = dbData.DatabaseConnection.ExecContext(ctx, updateStmt, newData, newSK, record.KeyID, record.StaticID, row.data)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(unboundRows) > 0 {
+			log.Printf("Reencrypted %d records of old formats in %s", len(unboundRows), dataType)
+		}
+	}
+
+	return nil
+}
+
+// RewrapKeys переоборачивает ключи аккаунтов, обернутые неактивными мастер-ключами.
+// Ключи объектов обернуты ключами аккаунтов и ротацией не затрагиваются. Работает пачками по batchSize записей. Прогресс хранится в колонках key_id, поэтому
+// прерванная ротация продолжается со следующего вызова. Возвращает число обработанных ключей.
+func (dbData PostgreDB) RewrapKeys(ctx context.Context, batchSize int) (int, error) {
+	activeKeyID, err := encryption.ActiveKeyID()
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	lastID := ""
+	for {
+		count, nextID, err := dbData.rewrapAccountKeysBatch(ctx, activeKeyID, lastID, batchSize)
+		total += count
+		if err != nil {
+			return total, err
+		}
+		if len(nextID) == 0 {
+			break
+		}
+		lastID = nextID
+	}
+
+	return total, nil
+}
+
+// rewrapAccountKeysBatch обрабатывает одну пачку ключей аккаунтов в транзакции и возвращает
+// id последней просмотренной записи или пустую строку, если записей больше нет.
+func (dbData PostgreDB) rewrapAccountKeysBatch(ctx context.Context, activeKeyID uint32, lastID string, batchSize int) (int, string, error) {
+	tx, err := dbData.KeyStore.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	stmt := "SELECT account_uuid, wrapped_key FROM account_keys WHERE key_id <> $1 AND account_uuid > $2" +
+		" ORDER BY account_uuid LIMIT $3 FOR UPDATE SKIP LOCKED"
+	keys, err := queryWrappedKeys(ctx, tx, stmt, activeKeyID, lastID, batchSize)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if len(keys) == 0 {
+		return 0, "", nil
+	}
+
+	updateStmt := "UPDATE account_keys SET (wrapped_key, key_id) = ($1, $2) WHERE account_uuid = $3"
+	count := 0
+	for _, key := range keys {
+		newKey, keyID, err := encryption.RewrapSK(key.sk)
+		if err != nil {
+			log.Printf("Failed to rewrap key of account %s: %v", key.id, err)
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, updateStmt, newKey, keyID, key.id)
+		if err != nil {
+			return 0, "", err
+		}
+		count++
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, "", err
+	}
+
+	return count, keys[len(keys)-1].id, nil
+}
+
+type wrappedKey struct {
+	id, sk string
+}
+
+func queryWrappedKeys(ctx context.Context, tx *sql.Tx, stmt string, args ...any) ([]wrappedKey, error) {
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]wrappedKey, 0)
+	for rows.Next() {
+		var key wrappedKey
+		if err := rows.Scan(&key.id, &key.sk); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
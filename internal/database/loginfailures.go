@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	loginfailuresmigrations "gophkeep/internal/database/loginfailures_migrations"
+)
+
+// Счетчики неудачных попыток входа в базе реализуют ratelimit.Store, чтобы реплики
+// сервера блокировали перебор вместе.
+
+func (dbData PostgreDB) CreateLoginFailuresTable(ctx context.Context) error {
+	return dbData.upMigrations(ctx, loginfailuresmigrations.EmbedLoginFailures)
+}
+
+func (dbData PostgreDB) LoginBlockedFor(ctx context.Context, key string) (time.Duration, error) {
+	var seconds float64
+	stmt := "SELECT EXTRACT(EPOCH FROM blocked_until - CURRENT_TIMESTAMP)::float8 FROM login_failures" +
+		" WHERE key = $1 AND blocked_until > CURRENT_TIMESTAMP"
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, stmt, key).Scan(&seconds)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func (dbData PostgreDB) AddLoginFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	var failures int
+	// счетчик увеличивается одним запросом, чтобы параллельные попытки с разных реплик не терялись
+	stmt := "INSERT INTO login_failures (key, failures) VALUES ($1, 1)" +
+		" ON CONFLICT (key) DO UPDATE SET last_failure = CURRENT_TIMESTAMP, failures = CASE" +
+		" WHEN login_failures.last_failure < CURRENT_TIMESTAMP - make_interval(secs => $2) THEN 1" +
+		" ELSE login_failures.failures + 1 END" +
+		" RETURNING failures"
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, stmt, key, window.Seconds()).Scan(&failures)
+	return failures, err
+}
+
+func (dbData PostgreDB) BlockLogin(ctx context.Context, key string, duration time.Duration) error {
+	stmt := "UPDATE login_failures SET blocked_until = GREATEST(COALESCE(blocked_until, CURRENT_TIMESTAMP)," +
+		" CURRENT_TIMESTAMP + make_interval(secs => $2)) WHERE key = $1"
+	_, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, key, duration.Seconds())
+	return err
+}
+
+func (dbData PostgreDB) ResetLoginFailures(ctx context.Context, key string) error {
+	_, err := dbData.DatabaseConnection.ExecContext(ctx, "DELETE FROM login_failures WHERE key = $1", key)
+	return err
+}
+
+func (dbData PostgreDB) DeleteStaleLoginFailures(ctx context.Context, olderThan time.Duration) (int64, error) {
+	stmt := "DELETE FROM login_failures WHERE last_failure < CURRENT_TIMESTAMP - make_interval(secs => $1)" +
+		" AND (blocked_until IS NULL OR blocked_until < CURRENT_TIMESTAMP)"
+	result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
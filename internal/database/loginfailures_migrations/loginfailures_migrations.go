@@ -0,0 +1,6 @@
+package loginfailuresmigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedLoginFailures embed.FS
@@ -0,0 +1,240 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gophkeep/internal/encryption"
+	"gophkeep/internal/model"
+	"log"
+	"time"
+
+	metadatamigrations "gophkeep/internal/database/metadata_migrations"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Имя и описание записи хранятся в infos зашифрованными ключом аккаунта.
+// Для поиска по точному имени и проверки уникальности имени у пользователя
+// рядом хранится слепой индекс имени name_index.
+const (
+	metadataPlain     = 0
+	metadataEncrypted = 1
+)
+
+var (
+	ErrDuplicateName  = errors.New("record with such name already exists")
+	ErrRecordNotFound = errors.New("record not found")
+)
+
+func (dbData PostgreDB) CreateMetadataColumns(ctx context.Context) error {
+	return dbData.upMigrations(ctx, metadatamigrations.EmbedMetadata)
+}
+
+func metadataRecord(staticID string, userID string, dataType string) encryption.Record {
+	return encryption.Record{
+		StaticID: staticID,
+		UserID:   userID,
+		DataType: dataType,
+		KeyID:    encryption.AccountWrappedKeyID,
+	}
+}
+
+type sealedMetadata struct {
+	name, description, nameIndex string
+}
+
+func sealMetadata(accountKey []byte, record encryption.Record, name string, description string) (sealedMetadata, error) {
+	var sealed sealedMetadata
+	var err error
+
+	sealed.name, err = encryption.EncryptMetadata(accountKey, record, encryption.MetadataName, name)
+	if err != nil {
+		return sealed, err
+	}
+
+	sealed.description, err = encryption.EncryptMetadata(accountKey, record, encryption.MetadataDescription, description)
+	if err != nil {
+		return sealed, err
+	}
+
+	sealed.nameIndex, err = encryption.BlindIndex(accountKey, name)
+	return sealed, err
+}
+
+// insertInfo сохраняет метаданные новой записи в транзакции tx.
+func (dbData PostgreDB) insertInfo(ctx context.Context, tx *sql.Tx, metadata model.Metadata) error {
+	accountKey, err := dbData.accountKey(ctx, metadata.UserID)
+	if err != nil {
+		return err
+	}
+
+	record := metadataRecord(metadata.StaticID, metadata.UserID, metadata.DataType)
+	sealed, err := sealMetadata(accountKey, record, metadata.Name, metadata.Description)
+	if err != nil {
+		return err
+	}
+
+	insertStmt := "INSERT INTO infos (static_id, dynamic_id, name, description, type, account_uuid, created_at, changed_at, name_index, metadata_version)" +
+		" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"
+
+	_, err = tx.ExecContext(ctx, insertStmt,
+		metadata.StaticID, metadata.DynamicID, sealed.name, sealed.description, metadata.DataType, metadata.UserID,
+		metadata.Created, metadata.Changed, sealed.nameIndex, metadataEncrypted)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return ErrDuplicateName
+		}
+		return err
+	}
+
+	return nil
+}
+
+// updateInfo меняет описание записи аккаунта userID и её dynamic_id в транзакции tx.
+func (dbData PostgreDB) updateInfo(ctx context.Context, tx *sql.Tx, userID string, editData model.EditData) error {
+	accountKey, err := dbData.accountKey(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	record := metadataRecord(editData.StaticID, userID, editData.DataType)
+	description, err := encryption.EncryptMetadata(accountKey, record, encryption.MetadataDescription, editData.Description)
+	if err != nil {
+		return err
+	}
+
+	stmt := "UPDATE infos SET dynamic_id = $1, description = $2, changed_at = $3 WHERE static_id = $4 AND account_uuid = $5"
+
+	dynamicID := uuid.New().String()
+	_, err = tx.ExecContext(ctx, stmt, dynamicID, description, time.Now(), editData.StaticID, userID)
+
+	return err
+}
+
+// openMetadata расшифровывает имя и описание, прочитанные из infos.
+func openMetadata(accountKey []byte, metadata *model.Metadata, version int) error {
+	if version == metadataPlain {
+		return nil
+	}
+
+	record := metadataRecord(metadata.StaticID, metadata.UserID, metadata.DataType)
+	name, err := encryption.DecryptMetadata(accountKey, record, encryption.MetadataName, metadata.Name)
+	if err != nil {
+		return err
+	}
+
+	description, err := encryption.DecryptMetadata(accountKey, record, encryption.MetadataDescription, metadata.Description)
+	if err != nil {
+		return err
+	}
+
+	metadata.Name = name
+	metadata.Description = description
+	return nil
+}
+
+// GetMetadataByName ищет запись аккаунта из контекста по точному имени через слепой индекс.
+func (dbData PostgreDB) GetMetadataByName(ctx context.Context, name string) (model.Metadata, error) {
+	var metadata model.Metadata
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return metadata, err
+	}
+	metadata.UserID = userID
+
+	accountKey, err := dbData.accountKey(ctx, userID)
+	if err != nil {
+		return metadata, err
+	}
+
+	nameIndex, err := encryption.BlindIndex(accountKey, name)
+	if err != nil {
+		return metadata, err
+	}
+
+	var version int
+	stmt := "SELECT static_id, dynamic_id, name, description, type, created_at, changed_at, metadata_version FROM infos WHERE account_uuid = $1 AND name_index = $2"
+	err = dbData.DatabaseConnection.QueryRowContext(ctx, stmt, userID, nameIndex).Scan(&metadata.StaticID, &metadata.DynamicID,
+		&metadata.Name, &metadata.Description, &metadata.DataType, &metadata.Created, &metadata.Changed, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return metadata, ErrRecordNotFound
+	}
+	if err != nil {
+		return metadata, err
+	}
+
+	if !scopeAllows(ctx, metadata.DataType, metadata.StaticID) {
+		return model.Metadata{UserID: userID}, ErrRecordNotFound
+	}
+
+	err = openMetadata(accountKey, &metadata, version)
+	if errors.Is(err, encryption.ErrIntegrity) {
+		logIntegrityViolation(metadataRecord(metadata.StaticID, userID, metadata.DataType), userID)
+	}
+
+	return metadata, err
+}
+
+// EncryptPlainMetadata шифрует имена и описания, сохраненные открытым текстом.
+// Если у пользователя уже были записи с одинаковыми именами, слепой индекс
+// получает только первая из них, остальные не находятся поиском по имени.
+func (dbData PostgreDB) EncryptPlainMetadata(ctx context.Context) error {
+	stmt := "SELECT static_id, name, description, type, account_uuid FROM infos WHERE metadata_version = $1"
+	rows, err := dbData.DatabaseConnection.QueryContext(ctx, stmt, metadataPlain)
+	if err != nil {
+		return err
+	}
+
+	plainRows := make([]model.Metadata, 0)
+	for rows.Next() {
+		var metadata model.Metadata
+		err := rows.Scan(&metadata.StaticID, &metadata.Name, &metadata.Description, &metadata.DataType, &metadata.UserID)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		plainRows = append(plainRows, metadata)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	updateStmt := "UPDATE infos SET (name, description, name_index, metadata_version) = ($1, $2, $3, $4) WHERE static_id = $5 AND metadata_version = $6"
+	for _, metadata := range plainRows {
+		accountKey, err := dbData.accountKey(ctx, metadata.UserID)
+		if err != nil {
+			log.Printf("Failed to get account key for record %s: %v", metadata.StaticID, err)
+			continue
+		}
+
+		record := metadataRecord(metadata.StaticID, metadata.UserID, metadata.DataType)
+		sealed, err := sealMetadata(accountKey, record, metadata.Name, metadata.Description)
+		if err != nil {
+			return err
+		}
+
+		_, err = dbData.DatabaseConnection.ExecContext(ctx, updateStmt,
+			sealed.name, sealed.description, sealed.nameIndex, metadataEncrypted, metadata.StaticID, metadataPlain)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			log.Printf("Record %s has a duplicate name, it is left out of the name index", metadata.StaticID)
+			_, err = dbData.DatabaseConnection.ExecContext(ctx, updateStmt,
+				sealed.name, sealed.description, nil, metadataEncrypted, metadata.StaticID, metadataPlain)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(plainRows) > 0 {
+		log.Printf("Encrypted metadata of %d records", len(plainRows))
+	}
+
+	return nil
+}
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE infos ADD COLUMN IF NOT EXISTS name_index TEXT;
+ALTER TABLE infos ADD COLUMN IF NOT EXISTS metadata_version INTEGER NOT NULL DEFAULT 0;
+CREATE UNIQUE INDEX IF NOT EXISTS infos_account_name_index_idx ON infos (account_uuid, name_index);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS infos_account_name_index_idx;
+ALTER TABLE infos DROP COLUMN IF EXISTS metadata_version;
+ALTER TABLE infos DROP COLUMN IF EXISTS name_index;
+-- +goose StatementEnd
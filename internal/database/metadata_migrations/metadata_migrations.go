@@ -0,0 +1,6 @@
+package metadatamigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedMetadata embed.FS
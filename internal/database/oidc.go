@@ -0,0 +1,278 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"gophkeep/internal/model"
+	"time"
+
+	oidcmigrations "gophkeep/internal/database/oidc_migrations"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrOIDCLoginNotFound - вход через провайдер не начат, уже завершен или истек.
+	ErrOIDCLoginNotFound = errors.New("OIDC login not found")
+	// ErrIdentityLinked - учетная запись провайдера уже привязана к другому аккаунту.
+	ErrIdentityLinked = errors.New("identity is linked to another account")
+	// ErrIdentityNotFound - у аккаунта нет такой привязанной учетной записи.
+	ErrIdentityNotFound = errors.New("identity not found")
+)
+
+// сколько раз подбирается свободный логин для нового аккаунта
+const provisionAttempts = 5
+
+// OIDCLogin - начатый вход через провайдер. После возврата от провайдера в нем
+// заполняется Identity, а клиент получает одноразовый код для завершения входа.
+// LinkAccount задан, если учетная запись привязывается к существующему аккаунту.
+type OIDCLogin struct {
+	State           string
+	Nonce           string
+	CodeVerifier    string
+	ClientChallenge string
+	ClientRedirect  string
+	LinkAccount     string
+	Identity        model.OIDCIdentity
+	Username        string
+}
+
+func (dbData PostgreDB) CreateOIDCTables(ctx context.Context) error {
+	return dbData.upMigrations(ctx, oidcmigrations.EmbedOIDC)
+}
+
+// CreateOIDCLogin сохраняет начатый вход на lifetime.
+func (dbData PostgreDB) CreateOIDCLogin(ctx context.Context, login OIDCLogin, lifetime time.Duration) error {
+	linkAccount := sql.NullString{String: login.LinkAccount, Valid: len(login.LinkAccount) != 0}
+
+	stmt := "INSERT INTO oidc_logins (state, nonce, code_verifier, client_challenge, client_redirect, link_account_uuid, expires_at)" +
+		" VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP + make_interval(secs => $7))"
+	_, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, login.State, login.Nonce, login.CodeVerifier,
+		login.ClientChallenge, login.ClientRedirect, linkAccount, lifetime.Seconds())
+	return err
+}
+
+// GetOIDCLogin возвращает начатый и еще не завершенный вход по state.
+func (dbData PostgreDB) GetOIDCLogin(ctx context.Context, state string) (OIDCLogin, error) {
+	var login OIDCLogin
+	var linkAccount sql.NullString
+
+	stmt := "SELECT state, nonce, code_verifier, client_challenge, client_redirect, link_account_uuid FROM oidc_logins" +
+		" WHERE state = $1 AND completion_hash IS NULL AND expires_at > CURRENT_TIMESTAMP"
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, stmt, state).Scan(&login.State, &login.Nonce,
+		&login.CodeVerifier, &login.ClientChallenge, &login.ClientRedirect, &linkAccount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return login, ErrOIDCLoginNotFound
+	}
+
+	login.LinkAccount = linkAccount.String
+	return login, err
+}
+
+// CompleteOIDCLogin запоминает учетную запись, подтвержденную провайдером, и хеш
+// одноразового кода, которым клиент завершит вход. Завершить вход можно один раз.
+func (dbData PostgreDB) CompleteOIDCLogin(ctx context.Context, state string, identity model.OIDCIdentity, username string, completionHash []byte) error {
+	stmt := "UPDATE oidc_logins SET (issuer, subject, email, username, completion_hash) = ($2, $3, $4, $5, $6)" +
+		" WHERE state = $1 AND completion_hash IS NULL AND expires_at > CURRENT_TIMESTAMP"
+	result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, state, identity.Issuer, identity.Subject,
+		identity.Email, username, completionHash)
+	if err != nil {
+		return err
+	}
+
+	completed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if completed == 0 {
+		return ErrOIDCLoginNotFound
+	}
+
+	return nil
+}
+
+// TakeOIDCLogin удаляет завершенный у провайдера вход и возвращает его.
+func (dbData PostgreDB) TakeOIDCLogin(ctx context.Context, completionHash []byte) (OIDCLogin, error) {
+	var login OIDCLogin
+	var linkAccount sql.NullString
+
+	stmt := "DELETE FROM oidc_logins WHERE completion_hash = $1 AND expires_at > CURRENT_TIMESTAMP" +
+		" RETURNING state, client_challenge, link_account_uuid, issuer, subject, email, username"
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, stmt, completionHash).Scan(&login.State,
+		&login.ClientChallenge, &linkAccount, &login.Identity.Issuer, &login.Identity.Subject,
+		&login.Identity.Email, &login.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return login, ErrOIDCLoginNotFound
+	}
+
+	login.LinkAccount = linkAccount.String
+	return login, err
+}
+
+// DeleteExpiredOIDCLogins удаляет брошенные входы.
+func (dbData PostgreDB) DeleteExpiredOIDCLogins(ctx context.Context) (int64, error) {
+	stmt := "DELETE FROM oidc_logins WHERE expires_at < CURRENT_TIMESTAMP"
+	result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// FindOIDCAccount возвращает аккаунт, к которому привязана учетная запись провайдера,
+// или пустую строку, если она не привязана.
+func (dbData PostgreDB) FindOIDCAccount(ctx context.Context, identity model.OIDCIdentity) (string, error) {
+	var userID string
+
+	stmt := "UPDATE oidc_identities SET last_login_at = CURRENT_TIMESTAMP, email = $3" +
+		" WHERE issuer = $1 AND subject = $2 RETURNING account_uuid"
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, stmt, identity.Issuer, identity.Subject, identity.Email).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	return userID, err
+}
+
+// ProvisionOIDCAccount создает аккаунт без пароля для учетной записи провайдера. Если
+// логин занят, к нему добавляется случайный суффикс. Если ту же учетную запись
+// параллельно привязали, возвращается ее аккаунт.
+func (dbData PostgreDB) ProvisionOIDCAccount(ctx context.Context, identity model.OIDCIdentity, username string) (string, error) {
+	login := username
+	for attempt := 0; attempt < provisionAttempts; attempt++ {
+		id, err := dbData.insertOIDCAccount(ctx, identity, login)
+
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			if pgErr.TableName == "oidc_identities" {
+				return dbData.FindOIDCAccount(ctx, identity)
+			}
+
+			suffix := make([]byte, 3)
+			if _, err = rand.Read(suffix); err != nil {
+				return "", err
+			}
+			login = username + "-" + hex.EncodeToString(suffix)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if _, err = dbData.accountKey(ctx, id); err != nil {
+			return "", err
+		}
+		return id, nil
+	}
+
+	return "", ErrLoginInUse
+}
+
+func (dbData PostgreDB) insertOIDCAccount(ctx context.Context, identity model.OIDCIdentity, login string) (string, error) {
+	id := uuid.New().String()
+
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	stmt := "INSERT INTO " + accountsTableName + " (uuid, username, password, password_version) VALUES ($1, $2, NULL, $3)"
+	if _, err = tx.ExecContext(ctx, stmt, id, login, passwordHashed); err != nil {
+		return "", err
+	}
+
+	stmt = "INSERT INTO oidc_identities (issuer, subject, account_uuid, email, last_login_at)" +
+		" VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)"
+	if _, err = tx.ExecContext(ctx, stmt, identity.Issuer, identity.Subject, id, identity.Email); err != nil {
+		return "", err
+	}
+
+	return id, tx.Commit()
+}
+
+// LinkOIDCIdentity привязывает учетную запись провайдера к аккаунту из контекста.
+func (dbData PostgreDB) LinkOIDCIdentity(ctx context.Context, identity model.OIDCIdentity) error {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	stmt := "INSERT INTO oidc_identities (issuer, subject, account_uuid, email) VALUES ($1, $2, $3, $4)" +
+		" ON CONFLICT (issuer, subject) DO NOTHING"
+	if _, err = dbData.DatabaseConnection.ExecContext(ctx, stmt, identity.Issuer, identity.Subject, userID, identity.Email); err != nil {
+		return err
+	}
+
+	linked, err := dbData.FindOIDCAccount(ctx, identity)
+	if err != nil {
+		return err
+	}
+	if linked != userID {
+		return ErrIdentityLinked
+	}
+
+	return nil
+}
+
+// GetOIDCIdentities возвращает учетные записи провайдеров, привязанные к аккаунту из контекста.
+func (dbData PostgreDB) GetOIDCIdentities(ctx context.Context) ([]model.OIDCIdentity, error) {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := "SELECT issuer, subject, email, created_at, last_login_at FROM oidc_identities" +
+		" WHERE account_uuid = $1 ORDER BY created_at"
+	rows, err := dbData.DatabaseConnection.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := make([]model.OIDCIdentity, 0)
+	for rows.Next() {
+		var identity model.OIDCIdentity
+		var lastLoginAt sql.NullTime
+		err = rows.Scan(&identity.Issuer, &identity.Subject, &identity.Email, &identity.Created, &lastLoginAt)
+		if err != nil {
+			return nil, err
+		}
+		if lastLoginAt.Valid {
+			identity.LastLoginAt = &lastLoginAt.Time
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+// UnlinkOIDCIdentity отвязывает учетную запись провайдера от аккаунта из контекста.
+func (dbData PostgreDB) UnlinkOIDCIdentity(ctx context.Context, identity model.OIDCIdentity) error {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	stmt := "DELETE FROM oidc_identities WHERE issuer = $1 AND subject = $2 AND account_uuid = $3"
+	result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, identity.Issuer, identity.Subject, userID)
+	if err != nil {
+		return err
+	}
+
+	unlinked, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if unlinked == 0 {
+		return ErrIdentityNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS oidc_identities(
+    issuer        TEXT NOT NULL,
+    subject       TEXT NOT NULL,
+    account_uuid  TEXT NOT NULL,
+    email         TEXT NOT NULL DEFAULT '',
+    created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMP,
+    PRIMARY KEY (issuer, subject)
+    );
+CREATE INDEX IF NOT EXISTS oidc_identities_account_uuid_idx ON oidc_identities (account_uuid);
+CREATE TABLE IF NOT EXISTS oidc_logins(
+    state             TEXT PRIMARY KEY,
+    nonce             TEXT NOT NULL,
+    code_verifier     TEXT NOT NULL,
+    client_challenge  TEXT NOT NULL,
+    client_redirect   TEXT NOT NULL,
+    link_account_uuid TEXT,
+    completion_hash   BYTEA UNIQUE,
+    issuer            TEXT,
+    subject           TEXT,
+    email             TEXT,
+    username          TEXT,
+    expires_at        TIMESTAMP NOT NULL
+    );
+CREATE INDEX IF NOT EXISTS oidc_logins_expires_at_idx ON oidc_logins (expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS oidc_logins;
+DROP TABLE IF EXISTS oidc_identities;
+-- +goose StatementEnd
@@ -0,0 +1,6 @@
+package oidcmigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedOIDC embed.FS
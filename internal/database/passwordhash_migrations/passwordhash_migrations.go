@@ -0,0 +1,6 @@
+package passwordhashmigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedPasswordHash embed.FS
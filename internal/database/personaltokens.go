@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/model"
+	"slices"
+	"time"
+
+	personaltokensmigrations "gophkeep/internal/database/personaltokens_migrations"
+)
+
+var (
+	// ErrTokenNotFound - у аккаунта нет такого персонального токена.
+	ErrTokenNotFound = errors.New("personal access token not found")
+	// ErrForbidden - ограничения персонального токена не разрешают действие.
+	ErrForbidden = errors.New("action is not allowed for this token")
+)
+
+func (dbData PostgreDB) CreatePersonalTokensTable(ctx context.Context) error {
+	return dbData.upMigrations(ctx, personaltokensmigrations.EmbedPersonalTokens)
+}
+
+// CreatePersonalToken сохраняет персональный токен аккаунта из контекста. Записи, которыми
+// ограничен токен, должны принадлежать этому аккаунту.
+func (dbData PostgreDB) CreatePersonalToken(ctx context.Context, token model.PersonalToken, tokenHash []byte) error {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, staticID := range token.Scopes.Records {
+		var owned bool
+		stmt := "SELECT EXISTS (SELECT 1 FROM infos WHERE static_id = $1 AND account_uuid = $2)"
+		if err = dbData.DatabaseConnection.QueryRowContext(ctx, stmt, staticID, userID).Scan(&owned); err != nil {
+			return err
+		}
+		if !owned {
+			return ErrRecordNotFound
+		}
+	}
+
+	scopes, err := json.Marshal(token.Scopes)
+	if err != nil {
+		return err
+	}
+
+	stmt := "INSERT INTO personal_tokens (id, account_uuid, name, token_hash, scopes, expires_at)" +
+		" VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP + make_interval(secs => $6))"
+	_, err = dbData.DatabaseConnection.ExecContext(ctx, stmt, token.ID, userID, token.Name, tokenHash, scopes,
+		time.Until(token.ExpiresAt).Seconds())
+	return err
+}
+
+// GetPersonalTokens возвращает персональные токены аккаунта из контекста, включая истекшие и отозванные.
+func (dbData PostgreDB) GetPersonalTokens(ctx context.Context) ([]model.PersonalToken, error) {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := "SELECT id, name, scopes, expires_at, created_at, last_used_at, revoked_at FROM personal_tokens" +
+		" WHERE account_uuid = $1 ORDER BY created_at"
+	rows, err := dbData.DatabaseConnection.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]model.PersonalToken, 0)
+	for rows.Next() {
+		var token model.PersonalToken
+		var scopes []byte
+		var lastUsedAt, revokedAt sql.NullTime
+		err = rows.Scan(&token.ID, &token.Name, &scopes, &token.ExpiresAt, &token.Created, &lastUsedAt, &revokedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = json.Unmarshal(scopes, &token.Scopes); err != nil {
+			return nil, err
+		}
+		if lastUsedAt.Valid {
+			token.LastUsedAt = &lastUsedAt.Time
+		}
+		if revokedAt.Valid {
+			token.RevokedAt = &revokedAt.Time
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// RevokePersonalToken отзывает персональный токен аккаунта из контекста.
+func (dbData PostgreDB) RevokePersonalToken(ctx context.Context, tokenID string) error {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	stmt := "UPDATE personal_tokens SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP) WHERE id = $1 AND account_uuid = $2"
+	result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, tokenID, userID)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return ErrTokenNotFound
+	}
+
+	return nil
+}
+
+// CheckPersonalToken находит действующий персональный токен включенного аккаунта и отмечает
+// время его использования. Реализует auth.TokenChecker.
+func (dbData PostgreDB) CheckPersonalToken(ctx context.Context, tokenID string, tokenHash []byte) (string, model.TokenScopes, bool, error) {
+	var userID string
+	var scopes model.TokenScopes
+	var rawScopes []byte
+
+	stmt := "UPDATE personal_tokens SET last_used_at = CURRENT_TIMESTAMP" +
+		" WHERE id = $1 AND token_hash = $2 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP" +
+		" AND account_uuid IN (SELECT uuid FROM " + accountsTableName + " WHERE disabled_at IS NULL)" +
+		" RETURNING account_uuid, scopes"
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, stmt, tokenID, tokenHash).Scan(&userID, &rawScopes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", scopes, false, nil
+	}
+	if err != nil {
+		return "", scopes, false, err
+	}
+
+	if err = json.Unmarshal(rawScopes, &scopes); err != nil {
+		return "", scopes, false, err
+	}
+
+	return userID, scopes, true, nil
+}
+
+// scopeAllows сообщает, видна ли запись запросу. Персональный токен видит только записи
+// из своих ограничений, для сессии ограничений нет.
+func scopeAllows(ctx context.Context, dataType string, staticID string) bool {
+	scopes, ok := auth.ScopesFromContext(ctx)
+	if !ok {
+		return true
+	}
+
+	if len(scopes.DataTypes) != 0 && !slices.Contains(scopes.DataTypes, dataType) {
+		return false
+	}
+
+	return len(scopes.Records) == 0 || slices.Contains(scopes.Records, staticID)
+}
+
+// checkCreateScope проверяет, что запрос может создать запись вида dataType. Токен,
+// ограниченный отдельными записями, новых записей не создает.
+func checkCreateScope(ctx context.Context, dataType string) error {
+	scopes, ok := auth.ScopesFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	if scopes.ReadOnly || len(scopes.Records) != 0 {
+		return ErrForbidden
+	}
+
+	if len(scopes.DataTypes) != 0 && !slices.Contains(scopes.DataTypes, dataType) {
+		return ErrForbidden
+	}
+
+	return nil
+}
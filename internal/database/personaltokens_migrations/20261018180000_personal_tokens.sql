@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS personal_tokens(
+    id           TEXT PRIMARY KEY,
+    account_uuid TEXT NOT NULL,
+    name         TEXT NOT NULL,
+    token_hash   BYTEA NOT NULL,
+    scopes       JSONB NOT NULL,
+    expires_at   TIMESTAMP NOT NULL,
+    created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked_at   TIMESTAMP
+    );
+CREATE INDEX IF NOT EXISTS personal_tokens_account_uuid_idx ON personal_tokens (account_uuid);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS personal_tokens;
+-- +goose StatementEnd
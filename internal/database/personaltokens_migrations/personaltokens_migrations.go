@@ -0,0 +1,6 @@
+package personaltokensmigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedPersonalTokens embed.FS
@@ -4,9 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"gophkeep/internal/auth"
 	"gophkeep/internal/encryption"
+	"gophkeep/internal/kinds"
 	"gophkeep/internal/logger"
 	"gophkeep/internal/model"
+	"io/fs"
 	"log"
 	"time"
 
@@ -14,6 +17,8 @@ import (
 	cardsmigrations "gophkeep/internal/database/cards_migrations"
 	filesmigrations "gophkeep/internal/database/files_migrations"
 	infosmigrations "gophkeep/internal/database/infos_migrations"
+	keyidsmigrations "gophkeep/internal/database/keyids_migrations"
+	passwordhashmigrations "gophkeep/internal/database/passwordhash_migrations"
 	passwordsmigrations "gophkeep/internal/database/passwords_migrations"
 
 	"github.com/google/uuid"
@@ -25,8 +30,19 @@ import (
 
 const accountsTableName = "accounts"
 
+// ErrUnauthenticated - в контексте нет аккаунта, от имени которого выполняется запрос.
+var ErrUnauthenticated = errors.New("request is not authenticated")
+
+// Версии колонки accounts.password: до хеширования пароли хранились открытым текстом.
+const (
+	passwordPlain  = 0
+	passwordHashed = 1
+)
+
 type PostgreDB struct {
 	DatabaseConnection *sql.DB
+	// KeyStore - отдельная база с ключами аккаунтов, её резервные копии не смешиваются с копиями записей
+	KeyStore *sql.DB
 }
 
 func (dbData PostgreDB) PingDB() error {
@@ -36,40 +52,78 @@ func (dbData PostgreDB) PingDB() error {
 
 func (dbData PostgreDB) Close() {
 	dbData.DatabaseConnection.Close()
+	if dbData.KeyStore != nil {
+		dbData.KeyStore.Close()
+	}
 }
 
 // Возвращает true если такой логин уже хранится в базе
 func (dbData PostgreDB) AddNewAccount(ctx context.Context, accountData model.SimpleAccountData) (bool, string, error) {
 	id := uuid.New().String()
 
-	insertStmt := "INSERT INTO " + accountsTableName + " (uuid, username, password)" +
-		" VALUES ($1, $2, $3)"
+	loginInUse, err := insertAccount(ctx, dbData.DatabaseConnection, id, accountData, sql.NullString{})
+	if loginInUse || err != nil {
+		return loginInUse, "", err
+	}
 
-	_, err := dbData.DatabaseConnection.ExecContext(ctx, insertStmt, id, accountData.Login, accountData.Password)
+	_, err = dbData.accountKey(ctx, id)
+	if err != nil {
+		return false, "", err
+	}
+
+	return false, id, nil
+}
+
+// insertAccount добавляет аккаунт id, invitedBy - пригласивший аккаунт, если он есть.
+// Возвращает true если такой логин уже хранится в базе
+func insertAccount(ctx context.Context, db execer, id string, accountData model.SimpleAccountData, invitedBy sql.NullString) (bool, error) {
+	// с верификатором SRP пароль серверу не известен и не хранится
+	var passwordHash sql.NullString
+	var srpSalt, srpVerifier []byte
+	if accountData.SRP != nil {
+		srpSalt, srpVerifier = accountData.SRP.Salt, accountData.SRP.Verifier
+	} else {
+		hash, err := auth.HashPassword(accountData.Password)
+		if err != nil {
+			return false, err
+		}
+		passwordHash = sql.NullString{String: hash, Valid: true}
+	}
+
+	insertStmt := "INSERT INTO " + accountsTableName + " (uuid, username, password, password_version, srp_salt, srp_verifier, invited_by)" +
+		" VALUES ($1, $2, $3, $4, $5, $6, $7)"
+
+	_, err := db.ExecContext(ctx, insertStmt, id, accountData.Login, passwordHash, passwordHashed,
+		srpSalt, srpVerifier, invitedBy)
 
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
 			log.Printf("login %s already in database", accountData.Login)
-			return true, "", nil
+			return true, nil
 		}
 		log.Printf("Failed to insert a record: " + accountData.Login)
-		return false, "", err
+		return false, err
 	}
 
-	return false, id, nil
+	return false, nil
 }
 
+// CheckLogin проверяет пароль и возвращает uuid аккаунта или пустую строку, если пара не подходит.
+// Пароли старого формата и хеши, сделанные не по текущей политике, пересчитываются после успешного входа.
 func (dbData PostgreDB) CheckLogin(ctx context.Context, accountData model.SimpleAccountData) (string, error) {
 
-	checkStmt := "SELECT uuid FROM " + accountsTableName + " WHERE username=$1 AND password=$2"
+	checkStmt := "SELECT uuid, password, password_version FROM " + accountsTableName + " WHERE username=$1"
 
 	var id string
+	var password sql.NullString
+	var version int
 
-	err := dbData.DatabaseConnection.QueryRowContext(ctx, checkStmt, accountData.Login, accountData.Password).Scan(&id)
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, checkStmt, accountData.Login).Scan(&id, &password, &version)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
+			auth.SimulatePasswordCheck(accountData.Password)
 			log.Printf("No such login password pair: " + accountData.Login)
 			return "", nil
 
@@ -78,32 +132,101 @@ func (dbData PostgreDB) CheckLogin(ctx context.Context, accountData model.Simple
 		return "", err
 	}
 
+	// аккаунт входит только по SRP
+	if !password.Valid {
+		auth.SimulatePasswordCheck(accountData.Password)
+		log.Printf("No such login password pair: " + accountData.Login)
+		return "", nil
+	}
+	storedPassword := password.String
+
+	ok, rehash := false, true
+	if version == passwordPlain {
+		ok = auth.VerifyLegacyPassword(accountData.Password, storedPassword)
+	} else {
+		ok, rehash, err = auth.VerifyPassword(accountData.Password, storedPassword)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if !ok {
+		log.Printf("No such login password pair: " + accountData.Login)
+		return "", nil
+	}
+
+	if rehash {
+		dbData.rehashPassword(ctx, id, accountData.Password, storedPassword)
+	}
+
 	return id, nil
 }
 
-func (dbData PostgreDB) AddData(ctx context.Context, metadata model.Metadata, data string, dataSK string, dataType string) error {
-	tx, err := dbData.DatabaseConnection.Begin()
+// rehashPassword сохраняет хеш пароля по текущей политике. Ошибка не мешает входу:
+// пароль будет пересчитан при следующем входе.
+func (dbData PostgreDB) rehashPassword(ctx context.Context, id string, password string, storedPassword string) {
+	passwordHash, err := auth.HashPassword(password)
 	if err != nil {
-		return err
+		log.Printf("Failed to rehash password of account %s: %v", id, err)
+		return
 	}
 
-	insertStmt := "INSERT INTO infos (static_id, dynamic_id, name, description, type, account_uuid, created_at, changed_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
+	// условие на старое значение не дает затереть пароль, измененный параллельно
+	stmt := "UPDATE " + accountsTableName + " SET (password, password_version) = ($1, $2) WHERE uuid = $3 AND password = $4"
+	_, err = dbData.DatabaseConnection.ExecContext(ctx, stmt, passwordHash, passwordHashed, id, storedPassword)
+	if err != nil {
+		log.Printf("Failed to rehash password of account %s: %v", id, err)
+	}
+}
 
-	_, err = dbData.DatabaseConnection.ExecContext(ctx, insertStmt,
-		metadata.StaticID, metadata.DynamicID, metadata.Name, metadata.Description, metadata.DataType, metadata.UserID, metadata.Created, metadata.Changed)
+// AddData шифрует данные новым ключом объекта, обернутым ключом аккаунта, и сохраняет их вместе с метаданными.
+// Владельцем записи становится аккаунт из контекста.
+func (dbData PostgreDB) AddData(ctx context.Context, metadata model.Metadata, data string) error {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	metadata.UserID = userID
 
+	kind, err := kinds.Lookup(metadata.DataType)
 	if err != nil {
 		return err
 	}
 
-	cardInsertStmt := "INSERT INTO " + dataType + " (id, data, sk) VALUES ($1, $2, $3)"
+	err = checkCreateScope(ctx, kind.Name)
+	if err != nil {
+		return err
+	}
+
+	record := encryption.Record{
+		StaticID: metadata.StaticID,
+		UserID:   metadata.UserID,
+		DataType: metadata.DataType,
+		KeyID:    encryption.AccountWrappedKeyID,
+	}
+	encryptedData, encryptedSK, err := dbData.encryptForAccount(ctx, record, data)
+	if err != nil {
+		return err
+	}
 
-	_, err = dbData.DatabaseConnection.ExecContext(ctx, cardInsertStmt, metadata.StaticID, data, dataSK)
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	err = dbData.insertInfo(ctx, tx, metadata)
+	if err != nil {
+		return err
+	}
+
+	cardInsertStmt := "INSERT INTO " + kind.Table + " (id, data, sk, key_id) VALUES ($1, $2, $3, $4)"
+
+	_, err = tx.ExecContext(ctx, cardInsertStmt, metadata.StaticID, encryptedData, encryptedSK, encryption.AccountWrappedKeyID)
+	if err != nil {
+		return err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return err
@@ -207,7 +330,42 @@ func (dbData PostgreDB) CreateAccountsTable(ctx context.Context) error {
 	return nil
 }
 
-func (dbData PostgreDB) GetMetadataByUserID(ctx context.Context, userID string) ([]model.Metadata, error) {
+func (dbData PostgreDB) CreateKeyIDColumns(ctx context.Context) error {
+	return dbData.upMigrations(ctx, keyidsmigrations.EmbedKeyIDs)
+}
+
+func (dbData PostgreDB) CreatePasswordHashColumn(ctx context.Context) error {
+	return dbData.upMigrations(ctx, passwordhashmigrations.EmbedPasswordHash)
+}
+
+// upMigrations применяет встроенные миграции goose. Все наборы миграций делят одну
+// таблицу версий, поэтому порядок версий между наборами не проверяется.
+func (dbData PostgreDB) upMigrations(ctx context.Context, migrations fs.FS) error {
+	provider, err := goose.NewProvider(database.DialectPostgres, dbData.DatabaseConnection, migrations, goose.WithAllowOutofOrder(true))
+	if err != nil {
+		return err
+	}
+
+	results, err := provider.Up(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		log.Printf("%-3s %-2v done: %v\n", r.Source.Type, r.Source.Version, r.Duration)
+	}
+
+	logger.Log.Debug("Applied migrations with goose embed")
+	return nil
+}
+
+// GetMetadata возвращает метаданные всех записей аккаунта из контекста.
+func (dbData PostgreDB) GetMetadata(ctx context.Context) ([]model.Metadata, error) {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	metadata := make([]model.Metadata, 0)
 	exists, err := dbData.tableExists(ctx, "infos")
 	if err != nil {
@@ -217,7 +375,12 @@ func (dbData PostgreDB) GetMetadataByUserID(ctx context.Context, userID string)
 		return metadata, nil
 	}
 
-	stmt := "SELECT static_id, dynamic_id, name, description, type, created_at, changed_at FROM infos WHERE account_uuid = $1"
+	accountKey, err := dbData.accountKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := "SELECT static_id, dynamic_id, name, description, type, created_at, changed_at, metadata_version FROM infos WHERE account_uuid = $1"
 	rows, err := dbData.DatabaseConnection.QueryContext(ctx, stmt, userID)
 	if err != nil {
 		return nil, err
@@ -225,12 +388,17 @@ func (dbData PostgreDB) GetMetadataByUserID(ctx context.Context, userID string)
 	for rows.Next() {
 		var name, description, dataType, static_id, dynamic_id string
 		var created_at, changed_at time.Time
-		err := rows.Scan(&static_id, &dynamic_id, &name, &description, &dataType, &created_at, &changed_at)
+		var version int
+		err := rows.Scan(&static_id, &dynamic_id, &name, &description, &dataType, &created_at, &changed_at, &version)
 		if err != nil {
 			return nil, err
 		}
 
-		metadata = append(metadata, model.Metadata{
+		if !scopeAllows(ctx, dataType, static_id) {
+			continue
+		}
+
+		record := model.Metadata{
 			StaticID:    static_id,
 			DynamicID:   dynamic_id,
 			Name:        name,
@@ -239,7 +407,19 @@ func (dbData PostgreDB) GetMetadataByUserID(ctx context.Context, userID string)
 			UserID:      userID,
 			Created:     created_at,
 			Changed:     changed_at,
-		})
+		}
+
+		err = openMetadata(accountKey, &record, version)
+		if errors.Is(err, encryption.ErrIntegrity) {
+			// подмененная запись не отдается, остальные синхронизируются как обычно
+			logIntegrityViolation(metadataRecord(static_id, userID, dataType), userID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		metadata = append(metadata, record)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -266,36 +446,51 @@ func (dbData PostgreDB) tableExists(ctx context.Context, tableName string) (bool
 	return exists, nil
 }
 
+// Delete удаляет запись аккаунта. Владение проверяется без расшифровки,
+// поэтому поврежденную запись тоже можно удалить.
 func (dbData PostgreDB) Delete(ctx context.Context, deleteData model.DataToDelete) error {
-	decryptedData, err := dataAccess(ctx, dbData, deleteData.StaticID, deleteData.DataType)
+	userID, err := accountFromContext(ctx)
 	if err != nil {
 		return err
 	}
 
-	if len(decryptedData) == 0 {
-		return errors.New("data is not accessible")
+	if _, err := dbData.loadRecord(ctx, userID, deleteData.StaticID, deleteData.DataType); err != nil {
+		return err
 	}
 
-	tx, err := dbData.DatabaseConnection.Begin()
+	kind, err := kinds.Lookup(deleteData.DataType)
 	if err != nil {
 		return err
 	}
 
-	deleteFromInfosStmt := "DELETE FROM infos WHERE static_id = $1 AND account_uuid = $2"
-	_, err = dbData.DatabaseConnection.ExecContext(ctx, deleteFromInfosStmt, deleteData.StaticID, deleteData.UserID)
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	deleteFromInfosStmt := "DELETE FROM infos WHERE static_id = $1 AND account_uuid = $2 AND type = $3"
+	result, err := tx.ExecContext(ctx, deleteFromInfosStmt, deleteData.StaticID, userID, deleteData.DataType)
+
+	if err != nil {
+		return err
+	}
 
+	deleted, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
-	dataType := deleteData.DataType
+	// запись успели удалить параллельно
+	if deleted == 0 {
+		return ErrRecordNotFound
+	}
 
-	deleteFromDataStmt := "DELETE FROM " + dataType + " WHERE id = $1"
-	_, err = dbData.DatabaseConnection.ExecContext(ctx, deleteFromDataStmt, deleteData.StaticID)
+	deleteFromDataStmt := "DELETE FROM " + kind.Table + " WHERE id = $1"
+	_, err = tx.ExecContext(ctx, deleteFromDataStmt, deleteData.StaticID)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
 
 	err = tx.Commit()
 	if err != nil {
@@ -318,46 +513,111 @@ func (dbData PostgreDB) Read(ctx context.Context, readData model.DataToRead) (st
 	return decryptedData, nil
 }
 
-func (dbData PostgreDB) Edit(ctx context.Context, editData model.EditData, data string, sk string) error {
-	decryptedData, err := dataAccess(ctx, dbData, editData.StaticID, editData.DataType)
+// Edit перешифровывает данные новым ключом объекта и обновляет метаданные.
+// Старые данные не расшифровываются, так что поврежденную запись можно перезаписать.
+func (dbData PostgreDB) Edit(ctx context.Context, editData model.EditData, data string) error {
+	userID, err := accountFromContext(ctx)
 	if err != nil {
 		return err
 	}
 
-	if len(decryptedData) == 0 {
-		return errors.New("data is not accessible")
+	if _, err := dbData.loadRecord(ctx, userID, editData.StaticID, editData.DataType); err != nil {
+		return err
 	}
 
-	stmt := "UPDATE infos SET dynamic_id = $1, description = $2, changed_at = $3 WHERE static_id = $4 AND account_uuid = $5"
+	kind, err := kinds.Lookup(editData.DataType)
+	if err != nil {
+		return err
+	}
 
-	dynamicID := uuid.New().String()
-	_, err = dbData.DatabaseConnection.ExecContext(ctx, stmt, dynamicID, editData.Description, time.Now(), editData.StaticID, editData.UserID)
+	record := encryption.Record{
+		StaticID: editData.StaticID,
+		UserID:   userID,
+		DataType: kind.Name,
+		KeyID:    encryption.AccountWrappedKeyID,
+	}
+	encryptedData, encryptedSK, err := dbData.encryptForAccount(ctx, record, data)
+	if err != nil {
+		return err
+	}
 
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	dataType := editData.DataType
+	err = dbData.updateInfo(ctx, tx, userID, editData)
+	if err != nil {
+		return err
+	}
 
-	secondStmt := "UPDATE " + dataType + " SET (data, sk) = ($1, $2) WHERE id = $3"
-	_, err = dbData.DatabaseConnection.ExecContext(ctx, secondStmt, data, sk, editData.StaticID)
+	secondStmt := "UPDATE " + kind.Table + " SET (data, sk, key_id) = ($1, $2, $3) WHERE id = $4"
+	_, err = tx.ExecContext(ctx, secondStmt, encryptedData, encryptedSK, encryption.AccountWrappedKeyID, editData.StaticID)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
-func dataAccess(ctx context.Context, dbData PostgreDB, id string, dataType string) (string, error) {
-	stmt := "SELECT data, sk FROM " + dataType + " WHERE id = $1"
-	var data, sk string
-	err := dbData.DatabaseConnection.QueryRowContext(ctx, stmt, id).Scan(&data, &sk)
+// storedRecord - строка с данными вместе с идентичностью записи и ключом её владельца.
+type storedRecord struct {
+	record     encryption.Record
+	accountKey []byte
+	data, sk   string
+}
+
+// loadRecord читает запись аккаунта userID. Чужая и несуществующая запись одинаково
+// дают ErrRecordNotFound, чтобы по ответу нельзя было узнать, есть ли запись.
+func (dbData PostgreDB) loadRecord(ctx context.Context, userID string, id string, dataType string) (storedRecord, error) {
+	stored := storedRecord{record: encryption.Record{StaticID: id, UserID: userID, DataType: dataType}}
+	kind, err := kinds.Lookup(dataType)
+	if err != nil {
+		return stored, err
+	}
+
+	// запись вне ограничений персонального токена для него не существует
+	if !scopeAllows(ctx, kind.Name, id) {
+		return stored, ErrRecordNotFound
+	}
+
+	stmt := "SELECT d.data, d.sk, d.key_id FROM " + kind.Table + " d JOIN infos i ON i.static_id = d.id" +
+		" WHERE d.id = $1 AND i.account_uuid = $2 AND i.type = $3"
+	err = dbData.DatabaseConnection.QueryRowContext(ctx, stmt, id, userID, kind.Name).Scan(&stored.data, &stored.sk, &stored.record.KeyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return stored, ErrRecordNotFound
+	}
 	if err != nil {
 		log.Printf("Failed to find correlated data")
+		return stored, err
+	}
+
+	stored.accountKey, err = dbData.accountKey(ctx, userID)
+	if err != nil {
+		return stored, err
+	}
+
+	return stored, nil
+}
+
+// dataAccess расшифровывает запись аккаунта из контекста.
+func dataAccess(ctx context.Context, dbData PostgreDB, id string, dataType string) (string, error) {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	stored, err := dbData.loadRecord(ctx, userID, id, dataType)
+	if err != nil {
 		return "", err
 	}
 
-	decryptedData, err := encryption.DecryptData(sk, data)
+	decryptedData, err := encryption.DecryptData(stored.accountKey, stored.record, stored.sk, stored.data)
+	if errors.Is(err, encryption.ErrIntegrity) {
+		logIntegrityViolation(stored.record, userID)
+		return "", err
+	}
 	if err != nil {
 		log.Printf("Failed to check if data is valid")
 		return "", err
@@ -365,3 +625,21 @@ func dataAccess(ctx context.Context, dbData PostgreDB, id string, dataType strin
 
 	return decryptedData, nil
 }
+
+// accountFromContext возвращает аккаунт, от имени которого выполняется запрос. Владелец
+// записи всегда берется отсюда, а не из тела запроса.
+func accountFromContext(ctx context.Context) (string, error) {
+	userID, ok := auth.UserIDFromContext(ctx)
+	if !ok {
+		return "", ErrUnauthenticated
+	}
+
+	return userID, nil
+}
+
+// logIntegrityViolation записывает событие безопасности о шифртексте, не принадлежащем записи.
+func logIntegrityViolation(record encryption.Record, userID string) {
+	logger.SecurityEvent("record_integrity_violation",
+		"static_id", record.StaticID, "owner", record.UserID, "requested_by", userID,
+		"data_type", record.DataType, "key_id", record.KeyID)
+}
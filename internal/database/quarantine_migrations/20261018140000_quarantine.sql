@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS quarantine(
+    id             SERIAL PRIMARY KEY,
+    static_id      TEXT NOT NULL,
+    data_type      TEXT NOT NULL,
+    account_uuid   TEXT,
+    reason         TEXT NOT NULL,
+    info           JSONB,
+    data           JSONB,
+    chunks         JSONB,
+    quarantined_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+CREATE INDEX IF NOT EXISTS quarantine_static_id_idx ON quarantine (static_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS quarantine;
+-- +goose StatementEnd
@@ -0,0 +1,6 @@
+package quarantinemigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedQuarantine embed.FS
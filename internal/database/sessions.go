@@ -0,0 +1,144 @@
+package database
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"time"
+
+	sessionsmigrations "gophkeep/internal/database/sessions_migrations"
+)
+
+var (
+	// ErrSessionNotFound - сессии нет, она отозвана или истекла.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrRefreshTokenReused - предъявлен уже использованный токен обновления. Сессия при этом отзывается:
+	// старым токеном мог воспользоваться тот, кто его украл.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// Причины отзыва сессии.
+const (
+	revokeLogout    = "logout"
+	revokeLogoutAll = "logout all"
+	revokeReuse     = "refresh token reuse"
+)
+
+func (dbData PostgreDB) CreateSessionsTable(ctx context.Context) error {
+	return dbData.upMigrations(ctx, sessionsmigrations.EmbedSessions)
+}
+
+// CreateSession сохраняет новую сессию устройства deviceID с хешем токена обновления.
+func (dbData PostgreDB) CreateSession(ctx context.Context, sessionID string, userID string, deviceID string, refreshHash []byte, lifetime time.Duration) error {
+	stmt := "INSERT INTO sessions (id, account_uuid, device_id, refresh_hash, expires_at)" +
+		" VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + make_interval(secs => $5))"
+	_, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, sessionID, userID, deviceID, refreshHash, lifetime.Seconds())
+	return err
+}
+
+// RotateRefreshToken заменяет токен обновления сессии новым и продлевает ее. Возвращает аккаунт сессии.
+// Сессия хранит хеш только последнего выданного токена, поэтому любой более старый токен этой
+// сессии считается повторно использованным, и сессия отзывается.
+func (dbData PostgreDB) RotateRefreshToken(ctx context.Context, sessionID string, refreshHash []byte, newRefreshHash []byte, lifetime time.Duration) (string, error) {
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var userID string
+	var storedHash []byte
+	var active bool
+	stmt := "SELECT account_uuid, refresh_hash, revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP" +
+		" FROM sessions WHERE id = $1 FOR UPDATE"
+	err = tx.QueryRowContext(ctx, stmt, sessionID).Scan(&userID, &storedHash, &active)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrSessionNotFound
+		}
+		return "", err
+	}
+
+	if !active {
+		return "", ErrSessionNotFound
+	}
+
+	if subtle.ConstantTimeCompare(storedHash, refreshHash) != 1 {
+		stmt = "UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = $2 WHERE id = $1"
+		if _, err = tx.ExecContext(ctx, stmt, sessionID, revokeReuse); err != nil {
+			return "", err
+		}
+		if err = tx.Commit(); err != nil {
+			return "", err
+		}
+		return "", ErrRefreshTokenReused
+	}
+
+	stmt = "UPDATE sessions SET refresh_hash = $2, refreshed_at = CURRENT_TIMESTAMP," +
+		" expires_at = CURRENT_TIMESTAMP + make_interval(secs => $3) WHERE id = $1"
+	_, err = tx.ExecContext(ctx, stmt, sessionID, newRefreshHash, lifetime.Seconds())
+	if err != nil {
+		return "", err
+	}
+
+	return userID, tx.Commit()
+}
+
+// SessionActive сообщает, что сессия аккаунта не отозвана и не истекла, а аккаунт не
+// отключен. По нему auth.CookieMiddleware отклоняет токены доступа отозванных сессий.
+func (dbData PostgreDB) SessionActive(ctx context.Context, sessionID string, userID string) (bool, error) {
+	var active bool
+	stmt := "SELECT s.revoked_at IS NULL AND s.expires_at > CURRENT_TIMESTAMP AND a.disabled_at IS NULL" +
+		" FROM sessions s JOIN " + accountsTableName + " a ON a.uuid = s.account_uuid WHERE s.id = $1 AND s.account_uuid = $2"
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, stmt, sessionID, userID).Scan(&active)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return active, nil
+}
+
+// RevokeSession отзывает сессию аккаунта из контекста.
+func (dbData PostgreDB) RevokeSession(ctx context.Context, sessionID string) error {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	stmt := "UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = $3" +
+		" WHERE id = $1 AND account_uuid = $2 AND revoked_at IS NULL"
+	_, err = dbData.DatabaseConnection.ExecContext(ctx, stmt, sessionID, userID, revokeLogout)
+	return err
+}
+
+// RevokeAllSessions отзывает все сессии аккаунта из контекста и возвращает их количество.
+func (dbData PostgreDB) RevokeAllSessions(ctx context.Context) (int64, error) {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := "UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = $2" +
+		" WHERE account_uuid = $1 AND revoked_at IS NULL"
+	result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, userID, revokeLogoutAll)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteExpiredSessions удаляет сессии, истекшие или отозванные раньше, чем retention назад.
+func (dbData PostgreDB) DeleteExpiredSessions(ctx context.Context, retention time.Duration) (int64, error) {
+	stmt := "DELETE FROM sessions WHERE COALESCE(revoked_at, expires_at) < CURRENT_TIMESTAMP - make_interval(secs => $1)"
+	result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
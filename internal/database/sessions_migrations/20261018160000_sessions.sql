@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS sessions(
+    id            TEXT PRIMARY KEY,
+    account_uuid  TEXT NOT NULL,
+    refresh_hash  BYTEA NOT NULL,
+    created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    refreshed_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at    TIMESTAMP NOT NULL,
+    revoked_at    TIMESTAMP,
+    revoke_reason TEXT
+    );
+CREATE INDEX IF NOT EXISTS sessions_account_uuid_idx ON sessions (account_uuid);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS sessions;
+-- +goose StatementEnd
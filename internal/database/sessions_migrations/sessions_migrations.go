@@ -0,0 +1,6 @@
+package sessionsmigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedSessions embed.FS
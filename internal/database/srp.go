@@ -0,0 +1,189 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"gophkeep/internal/model"
+	"time"
+
+	srpmigrations "gophkeep/internal/database/srp_migrations"
+)
+
+const serverSecretLength = 32
+
+var (
+	// ErrSRPNotEnabled - у аккаунта нет верификатора SRP, он входит по паролю.
+	ErrSRPNotEnabled = errors.New("account has no SRP verifier")
+	// ErrHandshakeNotFound - рукопожатие SRP не найдено, уже использовано или истекло.
+	ErrHandshakeNotFound = errors.New("SRP handshake not found")
+)
+
+// SRPHandshake - начатый вход по SRP. Хранится в базе, чтобы второй шаг мог прийти на
+// другую реплику. UserID пуст для несуществующего логина: такое рукопожатие не завершится.
+type SRPHandshake struct {
+	ID            string
+	UserID        string
+	Login         string
+	ClientPublic  []byte
+	ServerPrivate []byte
+	ServerPublic  []byte
+	Verifier      model.SRPVerifier
+}
+
+func (dbData PostgreDB) CreateSRPTables(ctx context.Context) error {
+	return dbData.upMigrations(ctx, srpmigrations.EmbedSRP)
+}
+
+// GetSRPVerifier возвращает uuid аккаунта и его верификатор. Для несуществующего логина
+// возвращается пустой uuid без ошибки.
+func (dbData PostgreDB) GetSRPVerifier(ctx context.Context, login string) (string, model.SRPVerifier, error) {
+	var userID string
+	var verifier model.SRPVerifier
+
+	stmt := "SELECT uuid, srp_salt, srp_verifier FROM " + accountsTableName + " WHERE username = $1"
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, stmt, login).Scan(&userID, &verifier.Salt, &verifier.Verifier)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", verifier, nil
+	}
+	if err != nil {
+		return "", verifier, err
+	}
+
+	if verifier.Salt == nil || verifier.Verifier == nil {
+		return userID, verifier, ErrSRPNotEnabled
+	}
+
+	return userID, verifier, nil
+}
+
+// SetSRPVerifier переводит аккаунт из контекста на вход по SRP: сохраняет верификатор
+// и удаляет хеш пароля.
+func (dbData PostgreDB) SetSRPVerifier(ctx context.Context, verifier model.SRPVerifier) error {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	stmt := "UPDATE " + accountsTableName + " SET (password, srp_salt, srp_verifier) = (NULL, $1, $2) WHERE uuid = $3"
+	_, err = dbData.DatabaseConnection.ExecContext(ctx, stmt, verifier.Salt, verifier.Verifier, userID)
+	return err
+}
+
+// ServerSecret возвращает постоянный секрет сервера с именем name, при первом обращении
+// создает его. Секрет общий для всех реплик и не меняется при ротации ключей.
+func (dbData PostgreDB) ServerSecret(ctx context.Context, name string) ([]byte, error) {
+	secret := make([]byte, serverSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	stmt := "INSERT INTO server_secrets (name, secret) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING"
+	if _, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, name, secret); err != nil {
+		return nil, err
+	}
+
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, "SELECT secret FROM server_secrets WHERE name = $1", name).Scan(&secret)
+	return secret, err
+}
+
+// PasswordLoginAccount возвращает аккаунт с логином login, который входит по паролю и
+// еще не перешел на SRP, или пустую строку, если такого аккаунта нет.
+func (dbData PostgreDB) PasswordLoginAccount(ctx context.Context, login string) (string, error) {
+	var userID string
+	stmt := "SELECT uuid FROM " + accountsTableName + " WHERE username = $1 AND password IS NOT NULL AND srp_verifier IS NULL"
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, stmt, login).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return userID, err
+}
+
+// CreateSRPHandshake сохраняет начатое рукопожатие на lifetime.
+func (dbData PostgreDB) CreateSRPHandshake(ctx context.Context, handshake SRPHandshake, lifetime time.Duration) error {
+	userID := sql.NullString{String: handshake.UserID, Valid: len(handshake.UserID) != 0}
+
+	stmt := "INSERT INTO srp_handshakes (id, account_uuid, login, client_public, server_private, server_public, expires_at)" +
+		" VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP + make_interval(secs => $7))"
+	_, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, handshake.ID, userID, handshake.Login,
+		handshake.ClientPublic, handshake.ServerPrivate, handshake.ServerPublic, lifetime.Seconds())
+	return err
+}
+
+// TakeSRPHandshake удаляет рукопожатие и возвращает его вместе с текущим верификатором
+// аккаунта. Каждое рукопожатие можно использовать только один раз.
+func (dbData PostgreDB) TakeSRPHandshake(ctx context.Context, id string) (SRPHandshake, error) {
+	var handshake SRPHandshake
+	var userID sql.NullString
+
+	stmt := "WITH h AS (DELETE FROM srp_handshakes WHERE id = $1 AND expires_at > CURRENT_TIMESTAMP" +
+		" RETURNING id, account_uuid, login, client_public, server_private, server_public)" +
+		" SELECT h.id, h.account_uuid, h.login, h.client_public, h.server_private, h.server_public, a.srp_salt, a.srp_verifier" +
+		" FROM h LEFT JOIN " + accountsTableName + " a ON a.uuid = h.account_uuid"
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, stmt, id).Scan(&handshake.ID, &userID, &handshake.Login,
+		&handshake.ClientPublic, &handshake.ServerPrivate, &handshake.ServerPublic,
+		&handshake.Verifier.Salt, &handshake.Verifier.Verifier)
+	if errors.Is(err, sql.ErrNoRows) {
+		return handshake, ErrHandshakeNotFound
+	}
+	if err != nil {
+		return handshake, err
+	}
+
+	// верификатор могли удалить, пока шло рукопожатие
+	if handshake.Verifier.Verifier != nil {
+		handshake.UserID = userID.String
+	}
+
+	return handshake, nil
+}
+
+// DeleteExpiredSRPHandshakes удаляет брошенные рукопожатия.
+func (dbData PostgreDB) DeleteExpiredSRPHandshakes(ctx context.Context) (int64, error) {
+	stmt := "DELETE FROM srp_handshakes WHERE expires_at < CURRENT_TIMESTAMP"
+	result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// CreateReauthToken сохраняет выданный сессии sessionID токен повторной проверки пароля
+// на lifetime. Токен принимается, только пока его строка есть в базе.
+func (dbData PostgreDB) CreateReauthToken(ctx context.Context, id string, userID string, sessionID string, lifetime time.Duration) error {
+	stmt := "INSERT INTO reauth_tokens (id, account_uuid, session_id, expires_at)" +
+		" VALUES ($1, $2, $3, CURRENT_TIMESTAMP + make_interval(secs => $4))"
+	_, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, id, userID, sessionID, lifetime.Seconds())
+	return err
+}
+
+// RedeemReauthToken удаляет токен повторной проверки пароля и сообщает, был ли он
+// действителен для аккаунта и сессии. Каждый токен подтверждает одно изменение.
+func (dbData PostgreDB) RedeemReauthToken(ctx context.Context, id string, userID string, sessionID string) (bool, error) {
+	stmt := "DELETE FROM reauth_tokens" +
+		" WHERE id = $1 AND account_uuid = $2 AND session_id = $3 AND expires_at > CURRENT_TIMESTAMP"
+	result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, id, userID, sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	redeemed, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return redeemed == 1, nil
+}
+
+// DeleteExpiredReauthTokens удаляет неиспользованные истекшие токены повторной проверки пароля.
+func (dbData PostgreDB) DeleteExpiredReauthTokens(ctx context.Context) (int64, error) {
+	stmt := "DELETE FROM reauth_tokens WHERE expires_at < CURRENT_TIMESTAMP"
+	result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
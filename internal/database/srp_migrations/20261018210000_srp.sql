@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS srp_salt BYTEA;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS srp_verifier BYTEA;
+ALTER TABLE accounts ALTER COLUMN password DROP NOT NULL;
+CREATE TABLE IF NOT EXISTS srp_handshakes(
+    id             TEXT PRIMARY KEY,
+    account_uuid   TEXT,
+    login          TEXT NOT NULL,
+    client_public  BYTEA NOT NULL,
+    server_private BYTEA NOT NULL,
+    server_public  BYTEA NOT NULL,
+    expires_at     TIMESTAMP NOT NULL
+    );
+CREATE INDEX IF NOT EXISTS srp_handshakes_expires_at_idx ON srp_handshakes (expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS srp_handshakes;
+ALTER TABLE accounts DROP COLUMN IF EXISTS srp_verifier;
+ALTER TABLE accounts DROP COLUMN IF EXISTS srp_salt;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS reauth_tokens(
+    id           TEXT PRIMARY KEY,
+    account_uuid TEXT NOT NULL,
+    session_id   TEXT NOT NULL,
+    expires_at   TIMESTAMP NOT NULL
+    );
+CREATE INDEX IF NOT EXISTS reauth_tokens_expires_at_idx ON reauth_tokens (expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS reauth_tokens;
+-- +goose StatementEnd
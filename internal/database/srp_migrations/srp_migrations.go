@@ -0,0 +1,6 @@
+package srpmigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedSRP embed.FS
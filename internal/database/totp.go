@@ -0,0 +1,300 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/encryption"
+	"gophkeep/internal/model"
+	"time"
+
+	totpmigrations "gophkeep/internal/database/totp_migrations"
+)
+
+var (
+	// ErrTOTPNotEnrolled - для аккаунта не начато подключение TOTP.
+	ErrTOTPNotEnrolled = errors.New("totp is not enrolled")
+	// ErrTOTPEnabled - TOTP уже подключен, повторное подключение возможно только после отключения.
+	ErrTOTPEnabled = errors.New("totp is already enabled")
+	// ErrInvalidOTP - код TOTP или код восстановления не подошел.
+	ErrInvalidOTP = errors.New("invalid one-time code")
+)
+
+func (dbData PostgreDB) CreateTOTPTables(ctx context.Context) error {
+	return dbData.upMigrations(ctx, totpmigrations.EmbedTOTP)
+}
+
+// GetAccountLogin возвращает логин аккаунта из контекста.
+func (dbData PostgreDB) GetAccountLogin(ctx context.Context) (string, error) {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var login string
+	stmt := "SELECT username FROM " + accountsTableName + " WHERE uuid = $1"
+	err = dbData.DatabaseConnection.QueryRowContext(ctx, stmt, userID).Scan(&login)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUnauthenticated
+	}
+
+	return login, err
+}
+
+// EnrollTOTP сохраняет новый секрет TOTP аккаунта из контекста, заменяя неподтвержденный.
+// 2FA включается только после ConfirmTOTP. Возвращает true, если TOTP уже подключен.
+func (dbData PostgreDB) EnrollTOTP(ctx context.Context, secret string) (bool, error) {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	accountKey, err := dbData.accountKey(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	encryptedSecret, err := encryption.EncryptAccountSecret(accountKey, userID, encryption.AccountSecretTOTP, secret)
+	if err != nil {
+		return false, err
+	}
+
+	stmt := "INSERT INTO account_totp (account_uuid, secret) VALUES ($1, $2)" +
+		" ON CONFLICT (account_uuid) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0, created_at = CURRENT_TIMESTAMP" +
+		" WHERE account_totp.enabled_at IS NULL"
+	result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, userID, encryptedSecret)
+	if err != nil {
+		return false, err
+	}
+
+	saved, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return saved == 0, nil
+}
+
+// ConfirmTOTP включает 2FA для аккаунта из контекста, если код подходит к сохраненному
+// секрету, и возвращает новые коды восстановления. На сервере хранятся только их хеши.
+func (dbData PostgreDB) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var enabled bool
+	err = tx.QueryRowContext(ctx, "SELECT enabled_at IS NOT NULL FROM account_totp WHERE account_uuid = $1 FOR UPDATE",
+		userID).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTOTPEnabled
+	}
+
+	if err = dbData.useTOTPCode(ctx, tx, userID, code); err != nil {
+		return nil, err
+	}
+
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE account_uuid = $1", userID); err != nil {
+		return nil, err
+	}
+	for _, recoveryCode := range codes {
+		_, err = tx.ExecContext(ctx, "INSERT INTO recovery_codes (account_uuid, code_hash) VALUES ($1, $2)",
+			userID, auth.HashRecoveryCode(recoveryCode))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE account_totp SET enabled_at = CURRENT_TIMESTAMP WHERE account_uuid = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, tx.Commit()
+}
+
+// TOTPEnabled сообщает, что для входа в аккаунт нужен второй фактор.
+func (dbData PostgreDB) TOTPEnabled(ctx context.Context, userID string) (bool, error) {
+	var enabled bool
+	stmt := "SELECT EXISTS (SELECT 1 FROM account_totp WHERE account_uuid = $1 AND enabled_at IS NOT NULL)"
+	err := dbData.DatabaseConnection.QueryRowContext(ctx, stmt, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// CreateMFAChallenge сохраняет ожидание второго фактора на lifetime. Токен ожидания
+// действует, только пока ожидание есть в базе.
+func (dbData PostgreDB) CreateMFAChallenge(ctx context.Context, id string, userID string, lifetime time.Duration) error {
+	stmt := "INSERT INTO mfa_challenges (id, account_uuid, expires_at)" +
+		" VALUES ($1, $2, CURRENT_TIMESTAMP + make_interval(secs => $3))"
+	_, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, id, userID, lifetime.Seconds())
+	return err
+}
+
+// TakeMFAAttempt засчитывает попытку ввода кода по ожиданию. Возвращает false, если
+// ожидание не найдено, истекло или исчерпало maxAttempts попыток.
+func (dbData PostgreDB) TakeMFAAttempt(ctx context.Context, id string, userID string, maxAttempts int) (bool, error) {
+	stmt := "UPDATE mfa_challenges SET attempts = attempts + 1" +
+		" WHERE id = $1 AND account_uuid = $2 AND expires_at > CURRENT_TIMESTAMP AND attempts < $3"
+	result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt, id, userID, maxAttempts)
+	if err != nil {
+		return false, err
+	}
+
+	taken, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return taken == 1, nil
+}
+
+// DeleteMFAChallenge удаляет ожидание после успешного входа, чтобы токен нельзя было использовать повторно.
+func (dbData PostgreDB) DeleteMFAChallenge(ctx context.Context, id string) error {
+	_, err := dbData.DatabaseConnection.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE id = $1", id)
+	return err
+}
+
+// DeleteExpiredMFAChallenges удаляет истекшие ожидания второго фактора.
+func (dbData PostgreDB) DeleteExpiredMFAChallenges(ctx context.Context) (int64, error) {
+	stmt := "DELETE FROM mfa_challenges WHERE expires_at < CURRENT_TIMESTAMP"
+	result, err := dbData.DatabaseConnection.ExecContext(ctx, stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// CheckSecondFactor проверяет код TOTP или, если он передан, код восстановления.
+// Каждый код принимается один раз: код восстановления помечается использованным,
+// а коды TOTP не старше последнего принятого шага отклоняются.
+func (dbData PostgreDB) CheckSecondFactor(ctx context.Context, userID string, otp model.OTPData) (bool, error) {
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	err = dbData.checkSecondFactor(ctx, tx, userID, otp)
+	if errors.Is(err, ErrInvalidOTP) || errors.Is(err, ErrTOTPNotEnrolled) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// DisableTOTP отключает 2FA аккаунта из контекста после проверки второго фактора.
+func (dbData PostgreDB) DisableTOTP(ctx context.Context, otp model.OTPData) error {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbData.DatabaseConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = dbData.checkSecondFactor(ctx, tx, userID, otp); err != nil {
+		return err
+	}
+
+	for _, stmt := range []string{
+		"DELETE FROM recovery_codes WHERE account_uuid = $1",
+		"DELETE FROM account_totp WHERE account_uuid = $1",
+	} {
+		if _, err = tx.ExecContext(ctx, stmt, userID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (dbData PostgreDB) checkSecondFactor(ctx context.Context, tx *sql.Tx, userID string, otp model.OTPData) error {
+	var enabled bool
+	err := tx.QueryRowContext(ctx, "SELECT enabled_at IS NOT NULL FROM account_totp WHERE account_uuid = $1 FOR UPDATE",
+		userID).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTOTPNotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrTOTPNotEnrolled
+	}
+
+	if len(otp.RecoveryCode) == 0 {
+		return dbData.useTOTPCode(ctx, tx, userID, otp.Code)
+	}
+
+	stmt := "UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP" +
+		" WHERE account_uuid = $1 AND code_hash = $2 AND used_at IS NULL"
+	result, err := tx.ExecContext(ctx, stmt, userID, auth.HashRecoveryCode(otp.RecoveryCode))
+	if err != nil {
+		return err
+	}
+
+	used, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if used == 0 {
+		return ErrInvalidOTP
+	}
+
+	return nil
+}
+
+// useTOTPCode проверяет код по секрету аккаунта и запоминает его шаг. Строка
+// account_totp должна быть заблокирована в транзакции tx.
+func (dbData PostgreDB) useTOTPCode(ctx context.Context, tx *sql.Tx, userID string, code string) error {
+	var encryptedSecret string
+	var lastStep int64
+	err := tx.QueryRowContext(ctx, "SELECT secret, last_step FROM account_totp WHERE account_uuid = $1",
+		userID).Scan(&encryptedSecret, &lastStep)
+	if err != nil {
+		return err
+	}
+
+	accountKey, err := dbData.accountKey(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	secret, err := encryption.DecryptAccountSecret(accountKey, userID, encryption.AccountSecretTOTP, encryptedSecret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := auth.ValidateTOTP(secret, code, time.Now())
+	if !ok || step <= lastStep {
+		return ErrInvalidOTP
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE account_totp SET last_step = $2 WHERE account_uuid = $1", userID, step)
+	return err
+}
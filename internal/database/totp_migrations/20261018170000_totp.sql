@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS account_totp(
+    account_uuid TEXT PRIMARY KEY,
+    secret       TEXT NOT NULL,
+    last_step    BIGINT NOT NULL DEFAULT 0,
+    enabled_at   TIMESTAMP,
+    created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+CREATE TABLE IF NOT EXISTS recovery_codes(
+    account_uuid TEXT NOT NULL,
+    code_hash    BYTEA NOT NULL,
+    used_at      TIMESTAMP,
+    PRIMARY KEY (account_uuid, code_hash)
+    );
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS account_totp;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS mfa_challenges(
+    id           TEXT PRIMARY KEY,
+    account_uuid TEXT NOT NULL,
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    expires_at   TIMESTAMP NOT NULL
+    );
+CREATE INDEX IF NOT EXISTS mfa_challenges_expires_at_idx ON mfa_challenges (expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS mfa_challenges;
+-- +goose StatementEnd
@@ -0,0 +1,6 @@
+package totpmigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedTOTP embed.FS
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gophkeep/internal/model"
+
+	vaultsmigrations "gophkeep/internal/database/vaults_migrations"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func (dbData PostgreDB) CreateVaultTable(ctx context.Context) error {
+	return dbData.upMigrations(ctx, vaultsmigrations.EmbedVaults)
+}
+
+// GetVault возвращает параметры хранилища с шифрованием на клиенте, если аккаунт из контекста его включил.
+func (dbData PostgreDB) GetVault(ctx context.Context) (model.VaultParams, bool, error) {
+	var params model.VaultParams
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return params, false, err
+	}
+
+	stmt := "SELECT kdf, salt, time_cost, memory_cost, threads, checksum FROM vaults WHERE account_uuid = $1"
+	err = dbData.DatabaseConnection.QueryRowContext(ctx, stmt, userID).Scan(
+		&params.KDF, &params.Salt, &params.Time, &params.Memory, &params.Threads, &params.Check)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return params, false, nil
+		}
+		return params, false, err
+	}
+
+	return params, true, nil
+}
+
+// AddVault включает шифрование на клиенте для аккаунта из контекста. Возвращает true, если оно уже было включено.
+func (dbData PostgreDB) AddVault(ctx context.Context, params model.VaultParams) (bool, error) {
+	userID, err := accountFromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	stmt := "INSERT INTO vaults (account_uuid, kdf, salt, time_cost, memory_cost, threads, checksum) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	_, err = dbData.DatabaseConnection.ExecContext(ctx, stmt,
+		userID, params.KDF, params.Salt, params.Time, params.Memory, params.Threads, params.Check)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return true, nil
+		}
+		return false, err
+	}
+
+	return false, nil
+}
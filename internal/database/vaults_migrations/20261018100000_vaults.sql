@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS vaults(
+    account_uuid TEXT PRIMARY KEY,
+    kdf          TEXT NOT NULL,
+    salt         TEXT NOT NULL,
+    time_cost    INTEGER NOT NULL,
+    memory_cost  INTEGER NOT NULL,
+    threads      INTEGER NOT NULL,
+    checksum     TEXT NOT NULL,
+    created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS vaults;
+-- +goose StatementEnd
@@ -0,0 +1,6 @@
+package vaultsmigrations
+
+import "embed"
+
+//go:embed *.sql
+var EmbedVaults embed.FS
@@ -0,0 +1,42 @@
+package encryption
+
+const (
+	// formatAccountSecretV8 - секрет аккаунта, зашифрованный ключом аккаунта: version(1) | nonce(12) | ciphertext+tag.
+	formatAccountSecretV8 byte = 8
+	accountSecretKeyInfo       = "gophkeep account secret v1"
+)
+
+// Секреты аккаунта, которые хранятся зашифрованными.
+const (
+	AccountSecretTOTP = "totp"
+)
+
+// EncryptAccountSecret шифрует секрет аккаунта, например ключ TOTP, ключом, выведенным
+// из ключа аккаунта. Шифртекст привязан к аккаунту и к имени секрета.
+func EncryptAccountSecret(accountKey []byte, userID string, name string, value string) (string, error) {
+	key, err := deriveAccountSubkey(accountKey, accountSecretKeyInfo)
+	if err != nil {
+		return "", err
+	}
+
+	return sealWithAAD(key, []byte(value), accountSecretAAD(userID, name))
+}
+
+// DecryptAccountSecret расшифровывает секрет, зашифрованный EncryptAccountSecret.
+func DecryptAccountSecret(accountKey []byte, userID string, name string, value string) (string, error) {
+	key, err := deriveAccountSubkey(accountKey, accountSecretKeyInfo)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := openWithAAD(key, value, accountSecretAAD(userID, name))
+	if err != nil {
+		return "", integrityError(err)
+	}
+
+	return string(plaintext), nil
+}
+
+func accountSecretAAD(userID string, name string) []byte {
+	return append(Record{UserID: userID}.additionalData(formatAccountSecretV8), name...)
+}
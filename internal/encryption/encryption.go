@@ -1,131 +1,286 @@
 package encryption
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/binary"
 	"errors"
-	"log"
-	"os"
-	"path/filepath"
-
-	"github.com/golang-jwt/jwt/v4"
+	"io"
 )
 
 const (
-	dataKeyLength = 16
-	fileWithKey   = "sk/encryption.txt"
+	// formatV1 - версия бинарного формата: version(1) | nonce(12) | ciphertext+tag.
+	formatV1 byte = 1
+	// formatWrappedV2 - ключ, обернутый мастер-ключом: version(1) | keyID(4) | nonce(12) | ciphertext+tag.
+	formatWrappedV2 byte = 2
+	// formatAccountWrappedV3 - ключ объекта, обернутый ключом аккаунта: version(1) | nonce(12) | ciphertext+tag.
+	formatAccountWrappedV3 byte = 3
+	// formatRecordDataV4 - данные, привязанные к записи: version(1) | nonce(12) | ciphertext+tag.
+	formatRecordDataV4 byte = 4
+	// formatRecordKeyV5 - ключ объекта, обернутый ключом аккаунта и привязанный к записи.
+	formatRecordKeyV5 byte = 5
+	dataKeyLength          = 32
+	// AccountWrappedKeyID - значение key_id у ключей объектов, обернутых ключом аккаунта.
+	AccountWrappedKeyID uint32 = 0
 )
 
-type Claims struct {
-	jwt.RegisteredClaims
-	Data string
-}
+var (
+	ErrUnknownFormat = errors.New("unknown ciphertext format")
+	ErrDecrypt       = errors.New("ciphertext could not be decrypted")
+	// ErrIntegrity - шифртекст не принадлежит записи, в которой он хранится, или изменен.
+	ErrIntegrity = errors.New("record integrity check failed")
+)
 
-func GenerateSK(data string) (string, string, error) {
-	var newKey string
+// Иерархия ключей: мастер-ключ -> ключ аккаунта -> ключи объектов.
+// Удаление ключа аккаунта делает все данные пользователя нерасшифровываемыми.
 
-	if len(data) <= 0 {
-		return "", "", errors.New("expected length is not valid")
+// GenerateAccountKey создает ключ аккаунта и возвращает его в виде,
+// обернутом активным мастер-ключом, вместе с самим ключом.
+func GenerateAccountKey() (string, []byte, error) {
+	newKey, err := randomKey()
+	if err != nil {
+		return "", nil, err
 	}
 
-	hash := make([]byte, dataKeyLength)
-	_, err := rand.Read(hash)
+	provider, err := currentProvider()
 	if err != nil {
-		return "", "", err
+		return "", nil, err
 	}
 
-	encodedHash := base64.StdEncoding.EncodeToString(hash)
-	newKey = encodedHash[:dataKeyLength]
+	wrappedKey, err := wrapKey(provider, newKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return wrappedKey, newKey, nil
+}
+
+// UnwrapAccountKey разворачивает ключ аккаунта мастер-ключом.
+func UnwrapAccountKey(wrappedKey string) ([]byte, error) {
+	return decryptDataSK(wrappedKey)
+}
+
+// Record - идентичность записи, которая аутентифицируется как дополнительные данные
+// при шифровании её данных и ключа. Шифртекст, перенесенный в чужую строку, не расшифруется.
+type Record struct {
+	StaticID string
+	UserID   string
+	DataType string
+	// KeyID - значение key_id строки, то есть версия ключа, которым обернут ключ объекта.
+	KeyID uint32
+}
+
+// additionalData кодирует заголовок формата и поля записи с префиксами длины.
+func (record Record) additionalData(version byte) []byte {
+	aad := []byte{version}
+	for _, field := range []string{record.StaticID, record.UserID, record.DataType} {
+		aad = binary.BigEndian.AppendUint16(aad, uint16(len(field)))
+		aad = append(aad, field...)
+	}
 
-	path := filepath.FromSlash(fileWithKey)
+	return binary.BigEndian.AppendUint32(aad, record.KeyID)
+}
 
-	encryptionSK, err := getEncryptionKeyFromFile(path)
+// GenerateSK создает случайный ключ объекта и возвращает его в виде,
+// обернутом ключом аккаунта и привязанном к записи, вместе с самим ключом.
+func GenerateSK(accountKey []byte, record Record) (string, []byte, error) {
+	newKey, err := randomKey()
 	if err != nil {
-		return "", "", err
+		return "", nil, err
 	}
-	encryptedDataSK, err := buildJWTString(encryptionSK, newKey)
+
+	encryptedDataSK, err := sealWithAAD(accountKey, newKey, record.additionalData(formatRecordKeyV5))
 	if err != nil {
-		return "", "", err
+		return "", nil, err
 	}
 
 	return encryptedDataSK, newKey, nil
 }
 
-func EncryptSimpleData(sk string, data string) (string, error) {
-	return buildJWTString(sk, data)
+// EncryptSimpleData шифрует данные ключом объекта, привязывая шифртекст к записи.
+func EncryptSimpleData(sk []byte, record Record, data string) (string, error) {
+	return sealWithAAD(sk, []byte(data), record.additionalData(formatRecordDataV4))
 }
 
-func DecryptData(dataSK string, encryptedData string) (string, error) {
-	realDataSk, err := decryptDataSK(dataSK)
+// DecryptData расшифровывает запись. Если данные или ключ привязаны к другой записи
+// либо изменены, возвращается ErrIntegrity. Записи старых форматов без привязки
+// читаются до перешифрования при запуске сервера.
+func DecryptData(accountKey []byte, record Record, dataSK string, encryptedData string) (string, error) {
+	if isLegacy(dataSK) || isLegacy(encryptedData) {
+		return decryptLegacyData(dataSK, encryptedData)
+	}
 
+	realDataSK, err := unwrapObjectKey(accountKey, record, dataSK)
 	if err != nil {
 		return "", err
 	}
-	claims := new(Claims)
-	token, err := jwt.ParseWithClaims(encryptedData, claims,
-		func(t *jwt.Token) (interface{}, error) {
-			return []byte(realDataSk), nil
-		})
+
+	var data []byte
+	if formatOf(encryptedData) == formatRecordDataV4 {
+		data, err = openWithAAD(realDataSK, encryptedData, record.additionalData(formatRecordDataV4))
+		err = integrityError(err)
+	} else {
+		data, err = open(realDataSK, encryptedData)
+	}
 	if err != nil {
 		return "", err
 	}
 
-	if !token.Valid {
-		log.Printf("Token is not valid")
-		return "", errors.New("token is not valid")
-	}
+	return string(data), nil
+}
+
+// UnwrapSK разворачивает ключ объекта записи, например чтобы расшифровать её поток.
+func UnwrapSK(accountKey []byte, record Record, dataSK string) ([]byte, error) {
+	return unwrapObjectKey(accountKey, record, dataSK)
+}
 
-	return claims.Data, nil
+// IsBound сообщает, что ключ и данные записи зашифрованы в текущем формате с привязкой к записи.
+func IsBound(dataSK string, encryptedData string) bool {
+	return formatOf(dataSK) == formatRecordKeyV5 && formatOf(encryptedData) == formatRecordDataV4
 }
 
-func decryptDataSK(dataSK string) (string, error) {
-	encryptionKey, err := getEncryptionKeyFromFile(fileWithKey)
+// Reencrypt расшифровывает запись старого формата и шифрует её заново с новым ключом объекта,
+// привязанным к record. Для расшифровки используется идентичность строки до перешифрования.
+func Reencrypt(accountKey []byte, stored Record, record Record, dataSK string, encryptedData string) (string, string, error) {
+	data, err := DecryptData(accountKey, stored, dataSK, encryptedData)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	claims := new(Claims)
-	token, err := jwt.ParseWithClaims(dataSK, claims,
-		func(t *jwt.Token) (interface{}, error) {
-			return []byte(encryptionKey), nil
-		})
+
+	newSK, realSK, err := GenerateSK(accountKey, record)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	if !token.Valid {
-		log.Printf("Token is not valid")
-		return "", errors.New("token is not valid")
+	newData, err := EncryptSimpleData(realSK, record, data)
+	if err != nil {
+		return "", "", err
 	}
 
-	return claims.Data, nil
+	return newSK, newData, nil
 }
 
-func buildJWTString(sk string, data string) (string, error) {
-	// создаём новый токен с алгоритмом подписи HS256 и утверждениями — Claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
-		RegisteredClaims: jwt.RegisteredClaims{},
-		// собственное утверждение
-		Data: data,
-	})
+func unwrapObjectKey(accountKey []byte, record Record, dataSK string) ([]byte, error) {
+	switch formatOf(dataSK) {
+	case formatRecordKeyV5:
+		if accountKey == nil {
+			return nil, ErrIntegrity
+		}
+		realDataSK, err := openWithAAD(accountKey, dataSK, record.additionalData(formatRecordKeyV5))
+		return realDataSK, integrityError(err)
+	case formatAccountWrappedV3:
+		if accountKey == nil {
+			return nil, ErrDecrypt
+		}
+		return openWithVersion(accountKey, dataSK, formatAccountWrappedV3)
+	}
 
-	// создаём строку токена
-	tokenString, err := token.SignedString([]byte(sk))
+	return decryptDataSK(dataSK)
+}
+
+// integrityError заменяет ошибку аутентификации привязанного шифртекста на ErrIntegrity.
+func integrityError(err error) error {
+	if errors.Is(err, ErrDecrypt) {
+		return ErrIntegrity
+	}
+
+	return err
+}
+
+// formatOf возвращает байт версии формата или 0, если строка не похожа на шифртекст.
+func formatOf(encoded string) byte {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) == 0 {
+		return 0
+	}
+
+	return raw[0]
+}
+
+func decryptDataSK(dataSK string) ([]byte, error) {
+	provider, err := currentProvider()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// возвращаем строку токена
-	return tokenString, nil
+	return unwrapKey(provider, dataSK)
 }
 
-func getEncryptionKeyFromFile(filename string) (string, error) {
-	var encryptionKey string
-	content, err := os.ReadFile(filename)
+func randomKey() ([]byte, error) {
+	key := make([]byte, dataKeyLength)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// open расшифровывает данные формата v1 без привязки к записи.
+func open(key []byte, encoded string) ([]byte, error) {
+	return openWithVersion(key, encoded, formatV1)
+}
+
+func openWithVersion(key []byte, encoded string, version byte) ([]byte, error) {
+	return openWithAAD(key, encoded, []byte{version})
+}
+
+// sealWithAAD шифрует данные AES-256-GCM со случайным nonce и записывает перед
+// шифртекстом байт версии формата aad[0]. Все aad аутентифицируются как дополнительные данные.
+func sealWithAAD(key []byte, plaintext []byte, aad []byte) (string, error) {
+	aead, err := newAEAD(key)
 	if err != nil {
 		return "", err
 	}
 
-	encryptionKey = string(content)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	out := make([]byte, 0, 1+len(nonce)+len(plaintext)+aead.Overhead())
+	out = append(out, aad[0])
+	out = append(out, nonce...)
+	out = aead.Seal(out, nonce, plaintext, aad)
+
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+func openWithAAD(key []byte, encoded string, aad []byte) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrUnknownFormat
+	}
+
+	if len(raw) == 0 || raw[0] != aad[0] {
+		return nil, ErrUnknownFormat
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) < 1+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrUnknownFormat
+	}
+
+	nonce := raw[1 : 1+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, raw[1+aead.NonceSize():], aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
 
-	return encryptionKey, nil
+	return cipher.NewGCM(block)
 }
@@ -0,0 +1,627 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// useKeyring задает провайдер мастер-ключа с кольцом из content до конца теста.
+func useKeyring(t *testing.T, content string) {
+	t.Helper()
+
+	p, err := newSecretKeyProvider(func() (string, error) { return content, nil })
+	if err != nil {
+		t.Fatalf("newSecretKeyProvider: %v", err)
+	}
+
+	SetKeyProvider(p)
+	t.Cleanup(func() { SetKeyProvider(nil) })
+}
+
+func mustRandomKey(t *testing.T) []byte {
+	t.Helper()
+
+	key, err := randomKey()
+	if err != nil {
+		t.Fatalf("randomKey: %v", err)
+	}
+
+	return key
+}
+
+// tamper возвращает шифртекст с инвертированным байтом pos; отрицательный pos считается с конца.
+func tamper(t *testing.T, encoded string, pos int) string {
+	t.Helper()
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("decode ciphertext: %v", err)
+	}
+	if pos < 0 {
+		pos += len(raw)
+	}
+	raw[pos] ^= 0xff
+
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func TestParseKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[uint32]string
+		wantErr bool
+	}{
+		{name: "legacy secret", content: "secret", want: map[uint32]string{1: "secret"}},
+		{name: "versions", content: "1:old\n2:new\n", want: map[uint32]string{1: "old", 2: "new"}},
+		{name: "blank lines and spaces", content: "\n  3:third \n\n7:seventh\n", want: map[uint32]string{3: "third", 7: "seventh"}},
+		{name: "line without id", content: "1:old\nplain", want: map[uint32]string{1: "1:old\nplain"}},
+		{name: "empty", content: " \n\n", wantErr: true},
+		{name: "zero id", content: "0:secret", wantErr: true},
+		{name: "id overflow", content: "4294967296:secret", wantErr: true},
+		{name: "duplicate id", content: "1:old\n1:new", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseKeyring(tt.content)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseKeyring(%q) = %v, want error", tt.content, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseKeyring(%q): %v", tt.content, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseKeyring(%q) = %v, want %v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyringWrapUnwrap(t *testing.T) {
+	ring, err := newKeyring(map[uint32]string{1: "old", 2: "new"})
+	if err != nil {
+		t.Fatalf("newKeyring: %v", err)
+	}
+	if ring.ActiveKeyID() != 2 {
+		t.Fatalf("ActiveKeyID() = %d, want 2", ring.ActiveKeyID())
+	}
+
+	dataKey := mustRandomKey(t)
+	aad := []byte("header")
+
+	tests := []struct {
+		name      string
+		wrapID    uint32
+		unwrapID  uint32
+		aad       []byte
+		mutate    func([]byte) []byte
+		wantErr   error
+		wrapError bool
+	}{
+		{name: "active key", wrapID: 2, unwrapID: 2, aad: aad},
+		{name: "old key", wrapID: 1, unwrapID: 1, aad: aad},
+		{name: "unknown key", wrapID: 3, wantErr: ErrUnknownMasterKey, wrapError: true},
+		{name: "unwrap with unknown key", wrapID: 2, unwrapID: 5, aad: aad, wantErr: ErrUnknownMasterKey},
+		{name: "unwrap with other key", wrapID: 1, unwrapID: 2, aad: aad, wantErr: ErrDecrypt},
+		{name: "other aad", wrapID: 2, unwrapID: 2, aad: []byte("other"), wantErr: ErrDecrypt},
+		{
+			name: "flipped byte", wrapID: 2, unwrapID: 2, aad: aad, wantErr: ErrDecrypt,
+			mutate: func(b []byte) []byte { b[len(b)-1] ^= 1; return b },
+		},
+		{
+			name: "too short", wrapID: 2, unwrapID: 2, aad: aad, wantErr: ErrUnknownFormat,
+			mutate: func(b []byte) []byte { return b[:10] },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapped, err := ring.Wrap(tt.wrapID, dataKey, aad)
+			if tt.wrapError {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Wrap(%d) error = %v, want %v", tt.wrapID, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Wrap(%d): %v", tt.wrapID, err)
+			}
+			if tt.mutate != nil {
+				wrapped = tt.mutate(wrapped)
+			}
+
+			got, err := ring.Unwrap(tt.unwrapID, wrapped, tt.aad)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Unwrap(%d) error = %v, want %v", tt.unwrapID, err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !bytes.Equal(got, dataKey) {
+				t.Errorf("Unwrap(%d) = %x, want %x", tt.unwrapID, got, dataKey)
+			}
+		})
+	}
+}
+
+func TestAccountKeyRotation(t *testing.T) {
+	useKeyring(t, "1:old")
+
+	wrapped, accountKey, err := GenerateAccountKey()
+	if err != nil {
+		t.Fatalf("GenerateAccountKey: %v", err)
+	}
+	if formatOf(wrapped) != formatWrappedV2 {
+		t.Fatalf("wrapped account key format = %d, want %d", formatOf(wrapped), formatWrappedV2)
+	}
+	if id, err := WrappingKeyID(wrapped); err != nil || id != 1 {
+		t.Fatalf("WrappingKeyID() = %d, %v, want 1", id, err)
+	}
+
+	useKeyring(t, "1:old\n2:new")
+
+	rewrapped, activeID, err := RewrapSK(wrapped)
+	if err != nil {
+		t.Fatalf("RewrapSK: %v", err)
+	}
+	if activeID != 2 {
+		t.Fatalf("RewrapSK key id = %d, want 2", activeID)
+	}
+	if id, err := WrappingKeyID(rewrapped); err != nil || id != 2 {
+		t.Fatalf("WrappingKeyID(rewrapped) = %d, %v, want 2", id, err)
+	}
+
+	// подмена идентификатора ключа в заголовке ломает аутентификацию
+	raw, _ := base64.StdEncoding.DecodeString(rewrapped)
+	raw[4] = 1
+	forged := base64.StdEncoding.EncodeToString(raw)
+
+	useKeyring(t, "2:new")
+
+	tests := []struct {
+		name    string
+		wrapped string
+		wantErr error
+	}{
+		{name: "rewrapped key", wrapped: rewrapped},
+		{name: "retired key", wrapped: wrapped, wantErr: ErrUnknownMasterKey},
+		{name: "forged key id", wrapped: forged, wantErr: ErrUnknownMasterKey},
+		{name: "flipped byte", wrapped: tamper(t, rewrapped, -1), wantErr: ErrDecrypt},
+		{name: "unknown format", wrapped: tamper(t, rewrapped, 0), wantErr: ErrUnknownFormat},
+		{name: "not base64", wrapped: "%%%", wantErr: ErrUnknownFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnwrapAccountKey(tt.wrapped)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UnwrapAccountKey() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !bytes.Equal(got, accountKey) {
+				t.Errorf("UnwrapAccountKey() = %x, want %x", got, accountKey)
+			}
+		})
+	}
+
+	useKeyring(t, "1:old\n2:new")
+	if _, err := UnwrapAccountKey(forged); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("UnwrapAccountKey(forged) error = %v, want %v", err, ErrDecrypt)
+	}
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	accountKey := mustRandomKey(t)
+	record := Record{StaticID: "static", UserID: "user", DataType: "text", KeyID: AccountWrappedKeyID}
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "empty", data: ""},
+		{name: "card", data: `{"number":"4111 1111 1111 1111","cvv":"123"}`},
+		{name: "unicode", data: "пароль от почты"},
+		{name: "large", data: strings.Repeat("x", 1<<20)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataSK, sk, err := GenerateSK(accountKey, record)
+			if err != nil {
+				t.Fatalf("GenerateSK: %v", err)
+			}
+			if len(sk) != dataKeyLength {
+				t.Fatalf("GenerateSK() key length = %d, want %d", len(sk), dataKeyLength)
+			}
+
+			data, err := EncryptSimpleData(sk, record, tt.data)
+			if err != nil {
+				t.Fatalf("EncryptSimpleData: %v", err)
+			}
+			if !IsBound(dataSK, data) {
+				t.Fatalf("IsBound() = false for a new record")
+			}
+			if len(tt.data) > 0 && strings.Contains(data, base64.StdEncoding.EncodeToString([]byte(tt.data))) {
+				t.Fatalf("ciphertext contains the plaintext")
+			}
+
+			got, err := DecryptData(accountKey, record, dataSK, data)
+			if err != nil {
+				t.Fatalf("DecryptData: %v", err)
+			}
+			if got != tt.data {
+				t.Errorf("DecryptData() returned %d bytes, want %d", len(got), len(tt.data))
+			}
+		})
+	}
+}
+
+func TestFormatsRoundTrip(t *testing.T) {
+	useKeyring(t, "1:secret")
+
+	accountKey := mustRandomKey(t)
+	record := Record{StaticID: "static", UserID: "user", DataType: "password", KeyID: AccountWrappedKeyID}
+	const plaintext = "card 4111 1111 1111 1111"
+
+	sk := mustRandomKey(t)
+	skV3, err := sealWithAAD(accountKey, sk, []byte{formatAccountWrappedV3})
+	if err != nil {
+		t.Fatalf("seal v3 key: %v", err)
+	}
+	skV5, realSK, err := GenerateSK(accountKey, record)
+	if err != nil {
+		t.Fatalf("GenerateSK: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		format    byte
+		encrypt   func() (string, error)
+		decrypt   func(string) (string, error)
+		tamperErr error
+	}{
+		{
+			name:   "v1 data",
+			format: formatV1,
+			encrypt: func() (string, error) {
+				return sealWithAAD(sk, []byte(plaintext), []byte{formatV1})
+			},
+			decrypt: func(data string) (string, error) {
+				return DecryptData(accountKey, record, skV3, data)
+			},
+			tamperErr: ErrDecrypt,
+		},
+		{
+			name:   "v3 key",
+			format: formatAccountWrappedV3,
+			encrypt: func() (string, error) {
+				return skV3, nil
+			},
+			decrypt: func(key string) (string, error) {
+				data, err := sealWithAAD(sk, []byte(plaintext), []byte{formatV1})
+				if err != nil {
+					return "", err
+				}
+				return DecryptData(accountKey, record, key, data)
+			},
+			tamperErr: ErrDecrypt,
+		},
+		{
+			name:   "v4 data",
+			format: formatRecordDataV4,
+			encrypt: func() (string, error) {
+				return EncryptSimpleData(realSK, record, plaintext)
+			},
+			decrypt: func(data string) (string, error) {
+				return DecryptData(accountKey, record, skV5, data)
+			},
+			tamperErr: ErrIntegrity,
+		},
+		{
+			name:   "v5 key",
+			format: formatRecordKeyV5,
+			encrypt: func() (string, error) {
+				return skV5, nil
+			},
+			decrypt: func(key string) (string, error) {
+				data, err := EncryptSimpleData(realSK, record, plaintext)
+				if err != nil {
+					return "", err
+				}
+				return DecryptData(accountKey, record, key, data)
+			},
+			tamperErr: ErrIntegrity,
+		},
+		{
+			name:   "v7 metadata",
+			format: formatMetadataV7,
+			encrypt: func() (string, error) {
+				return EncryptMetadata(accountKey, record, MetadataName, plaintext)
+			},
+			decrypt: func(value string) (string, error) {
+				return DecryptMetadata(accountKey, record, MetadataName, value)
+			},
+			tamperErr: ErrIntegrity,
+		},
+		{
+			name:   "v8 account secret",
+			format: formatAccountSecretV8,
+			encrypt: func() (string, error) {
+				return EncryptAccountSecret(accountKey, record.UserID, AccountSecretTOTP, plaintext)
+			},
+			decrypt: func(value string) (string, error) {
+				return DecryptAccountSecret(accountKey, record.UserID, AccountSecretTOTP, value)
+			},
+			tamperErr: ErrIntegrity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := tt.encrypt()
+			if err != nil {
+				t.Fatalf("encrypt: %v", err)
+			}
+			if formatOf(encrypted) != tt.format {
+				t.Fatalf("format = %d, want %d", formatOf(encrypted), tt.format)
+			}
+
+			got, err := tt.decrypt(encrypted)
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if got != plaintext {
+				t.Fatalf("decrypt = %q, want %q", got, plaintext)
+			}
+
+			for _, pos := range []int{1, -1} {
+				if _, err := tt.decrypt(tamper(t, encrypted, pos)); !errors.Is(err, tt.tamperErr) {
+					t.Errorf("decrypt with byte %d flipped: error = %v, want %v", pos, err, tt.tamperErr)
+				}
+			}
+
+			raw, _ := base64.StdEncoding.DecodeString(encrypted)
+			if _, err := tt.decrypt(base64.StdEncoding.EncodeToString(raw[:5])); !errors.Is(err, ErrUnknownFormat) {
+				t.Errorf("decrypt of truncated ciphertext: error = %v, want %v", err, ErrUnknownFormat)
+			}
+
+			raw[0] = 99
+			if _, err := tt.decrypt(base64.StdEncoding.EncodeToString(raw)); !errors.Is(err, ErrUnknownFormat) {
+				t.Errorf("decrypt with unknown version: error = %v, want %v", err, ErrUnknownFormat)
+			}
+		})
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	useKeyring(t, "1:secret")
+
+	accountKey := mustRandomKey(t)
+	stored := Record{StaticID: "static", UserID: "user", DataType: "text", KeyID: 1}
+	record := Record{StaticID: "static", UserID: "user", DataType: "text", KeyID: AccountWrappedKeyID}
+	const plaintext = "note"
+
+	sk := mustRandomKey(t)
+	provider, _ := currentProvider()
+	wrappedSK, err := wrapKey(provider, sk)
+	if err != nil {
+		t.Fatalf("wrapKey: %v", err)
+	}
+	data, err := sealWithAAD(sk, []byte(plaintext), []byte{formatV1})
+	if err != nil {
+		t.Fatalf("seal v1 data: %v", err)
+	}
+	if IsBound(wrappedSK, data) {
+		t.Fatalf("IsBound() = true for unbound record")
+	}
+
+	newSK, newData, err := Reencrypt(accountKey, stored, record, wrappedSK, data)
+	if err != nil {
+		t.Fatalf("Reencrypt: %v", err)
+	}
+	if !IsBound(newSK, newData) {
+		t.Fatalf("IsBound() = false after Reencrypt")
+	}
+
+	got, err := DecryptData(accountKey, record, newSK, newData)
+	if err != nil {
+		t.Fatalf("DecryptData: %v", err)
+	}
+	if got != plaintext {
+		t.Errorf("DecryptData() = %q, want %q", got, plaintext)
+	}
+}
+
+func TestDecryptLegacyData(t *testing.T) {
+	const (
+		secret    = "legacy secret"
+		objectKey = "object key"
+		plaintext = "legacy data"
+	)
+
+	sign := func(t *testing.T, key string, data string) string {
+		t.Helper()
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, legacyClaims{Data: data}).SignedString([]byte(key))
+		if err != nil {
+			t.Fatalf("sign legacy token: %v", err)
+		}
+		return token
+	}
+
+	dataSK := sign(t, secret, objectKey)
+	data := sign(t, objectKey, plaintext)
+
+	tests := []struct {
+		name    string
+		keyring string
+		dataSK  string
+		wantErr bool
+	}{
+		{name: "legacy secret", keyring: secret, dataSK: dataSK},
+		{name: "legacy secret in keyring", keyring: "1:" + secret + "\n2:new", dataSK: dataSK},
+		{name: "other secret", keyring: "other", dataSK: dataSK, wantErr: true},
+		{name: "no legacy key", keyring: "2:" + secret, dataSK: dataSK, wantErr: true},
+		{name: "forged key token", keyring: secret, dataSK: sign(t, "other", objectKey), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useKeyring(t, tt.keyring)
+
+			got, err := DecryptData(nil, Record{}, tt.dataSK, data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("DecryptData() = %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecryptData: %v", err)
+			}
+			if got != plaintext {
+				t.Errorf("DecryptData() = %q, want %q", got, plaintext)
+			}
+		})
+	}
+}
+
+func TestRecordBinding(t *testing.T) {
+	accountKey := mustRandomKey(t)
+	record := Record{StaticID: "static", UserID: "user", DataType: "card", KeyID: AccountWrappedKeyID}
+	const plaintext = "4111 1111 1111 1111"
+
+	dataSK, sk, err := GenerateSK(accountKey, record)
+	if err != nil {
+		t.Fatalf("GenerateSK: %v", err)
+	}
+	data, err := EncryptSimpleData(sk, record, plaintext)
+	if err != nil {
+		t.Fatalf("EncryptSimpleData: %v", err)
+	}
+	metadata, err := EncryptMetadata(accountKey, record, MetadataName, plaintext)
+	if err != nil {
+		t.Fatalf("EncryptMetadata: %v", err)
+	}
+
+	// ключ и данные другой записи того же пользователя
+	otherRecord := record
+	otherRecord.StaticID = "other"
+	otherSK, otherKey, err := GenerateSK(accountKey, otherRecord)
+	if err != nil {
+		t.Fatalf("GenerateSK: %v", err)
+	}
+	otherData, err := EncryptSimpleData(otherKey, otherRecord, plaintext)
+	if err != nil {
+		t.Fatalf("EncryptSimpleData: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		record       Record
+		dataSK       string
+		data         string
+		noAccountKey bool
+		wantErr      error
+	}{
+		{name: "same record", record: record, dataSK: dataSK, data: data},
+		{name: "other static id", record: Record{StaticID: "other", UserID: "user", DataType: "card"}, dataSK: dataSK, data: data, wantErr: ErrIntegrity},
+		{name: "other user", record: Record{StaticID: "static", UserID: "other", DataType: "card"}, dataSK: dataSK, data: data, wantErr: ErrIntegrity},
+		{name: "other data type", record: Record{StaticID: "static", UserID: "user", DataType: "text"}, dataSK: dataSK, data: data, wantErr: ErrIntegrity},
+		{name: "other key id", record: Record{StaticID: "static", UserID: "user", DataType: "card", KeyID: 1}, dataSK: dataSK, data: data, wantErr: ErrIntegrity},
+		{name: "field boundary shift", record: Record{StaticID: "staticuser", DataType: "card"}, dataSK: dataSK, data: data, wantErr: ErrIntegrity},
+		{name: "data from other record", record: record, dataSK: dataSK, data: otherData, wantErr: ErrIntegrity},
+		{name: "key from other record", record: record, dataSK: otherSK, data: data, wantErr: ErrIntegrity},
+		{name: "swapped key and data", record: otherRecord, dataSK: otherSK, data: data, wantErr: ErrIntegrity},
+		{name: "no account key", record: record, dataSK: dataSK, data: data, noAccountKey: true, wantErr: ErrIntegrity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := accountKey
+			if tt.noAccountKey {
+				key = nil
+			}
+
+			got, err := DecryptData(key, tt.record, tt.dataSK, tt.data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DecryptData() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && got != plaintext {
+				t.Errorf("DecryptData() = %q, want %q", got, plaintext)
+			}
+		})
+	}
+
+	metadataTests := []struct {
+		name    string
+		record  Record
+		field   string
+		wantErr error
+	}{
+		{name: "same field", record: record, field: MetadataName},
+		{name: "other field", record: record, field: MetadataDescription, wantErr: ErrIntegrity},
+		{name: "other record", record: otherRecord, field: MetadataName, wantErr: ErrIntegrity},
+	}
+
+	for _, tt := range metadataTests {
+		t.Run("metadata "+tt.name, func(t *testing.T) {
+			_, err := DecryptMetadata(accountKey, tt.record, tt.field, metadata)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("DecryptMetadata() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFileKeyProviderReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "encryption.txt")
+	if err := os.WriteFile(path, []byte("1:old"), 0600); err != nil {
+		t.Fatalf("write key file: %v", err)
+	}
+
+	p, err := NewFileKeyProvider(path)
+	if err != nil {
+		t.Fatalf("NewFileKeyProvider: %v", err)
+	}
+	SetKeyProvider(p)
+	t.Cleanup(func() { SetKeyProvider(nil) })
+
+	wrapped, _, err := GenerateAccountKey()
+	if err != nil {
+		t.Fatalf("GenerateAccountKey: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("1:old\n2:new"), 0600); err != nil {
+		t.Fatalf("write key file: %v", err)
+	}
+	if id, _ := ActiveKeyID(); id != 1 {
+		t.Fatalf("ActiveKeyID() before reload = %d, want 1", id)
+	}
+	if err := ReloadKeys(); err != nil {
+		t.Fatalf("ReloadKeys: %v", err)
+	}
+	if id, _ := ActiveKeyID(); id != 2 {
+		t.Fatalf("ActiveKeyID() after reload = %d, want 2", id)
+	}
+	if _, _, err := RewrapSK(wrapped); err != nil {
+		t.Fatalf("RewrapSK after reload: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(" \n"), 0600); err != nil {
+		t.Fatalf("write key file: %v", err)
+	}
+	if err := ReloadKeys(); err == nil {
+		t.Fatalf("ReloadKeys() accepted an empty key file")
+	}
+	if id, _ := ActiveKeyID(); id != 2 {
+		t.Errorf("ActiveKeyID() after failed reload = %d, want 2", id)
+	}
+}
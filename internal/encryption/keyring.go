@@ -0,0 +1,256 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	masterKeyInfo = "gophkeep master key v1"
+	// legacyKeyID - идентификатор ключа из файла без версий и ключей формата v1.
+	legacyKeyID uint32 = 1
+)
+
+var (
+	ErrUnknownMasterKey = errors.New("master key with such id is not available")
+	keyringLine         = regexp.MustCompile(`^(\d+):(.+)$`)
+)
+
+// keyring - набор версий мастер-ключа, загруженных в память. Новые ключи объектов
+// оборачиваются активным (самым новым) ключом, старые версии нужны для чтения и ротации.
+type keyring struct {
+	keys    map[uint32][]byte
+	secrets map[uint32]string
+	active  uint32
+}
+
+func newKeyring(secrets map[uint32]string) (*keyring, error) {
+	ring := &keyring{
+		keys:    make(map[uint32][]byte, len(secrets)),
+		secrets: secrets,
+	}
+	for id, secret := range secrets {
+		key, err := deriveMasterKey(secret)
+		if err != nil {
+			return nil, err
+		}
+		ring.keys[id] = key
+		if id > ring.active {
+			ring.active = id
+		}
+	}
+
+	return ring, nil
+}
+
+func (ring *keyring) ActiveKeyID() uint32 {
+	return ring.active
+}
+
+func (ring *keyring) Wrap(keyID uint32, dataKey []byte, aad []byte) ([]byte, error) {
+	masterKey, ok := ring.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownMasterKey, keyID)
+	}
+
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dataKey)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, dataKey, aad), nil
+}
+
+func (ring *keyring) Unwrap(keyID uint32, wrapped []byte, aad []byte) ([]byte, error) {
+	masterKey, ok := ring.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownMasterKey, keyID)
+	}
+
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrUnknownFormat
+	}
+
+	dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return dataKey, nil
+}
+
+func (ring *keyring) legacySecret() (string, bool) {
+	secret, ok := ring.secrets[legacyKeyID]
+	return secret, ok
+}
+
+// ActiveKeyID возвращает идентификатор мастер-ключа, которым оборачиваются новые ключи объектов.
+func ActiveKeyID() (uint32, error) {
+	provider, err := currentProvider()
+	if err != nil {
+		return 0, err
+	}
+
+	if Sealed() {
+		return 0, ErrSealed
+	}
+
+	return provider.ActiveKeyID(), nil
+}
+
+// WrappingKeyID возвращает идентификатор мастер-ключа, которым обернут ключ,
+// или AccountWrappedKeyID для ключей объектов, обернутых ключом аккаунта.
+func WrappingKeyID(dataSK string) (uint32, error) {
+	raw, err := base64.StdEncoding.DecodeString(dataSK)
+	if err != nil || len(raw) == 0 {
+		return 0, ErrUnknownFormat
+	}
+
+	switch raw[0] {
+	case formatAccountWrappedV3, formatRecordKeyV5:
+		return AccountWrappedKeyID, nil
+	case formatV1:
+		return legacyKeyID, nil
+	case formatWrappedV2:
+		if len(raw) < 5 {
+			return 0, ErrUnknownFormat
+		}
+		return binary.BigEndian.Uint32(raw[1:5]), nil
+	}
+
+	return 0, ErrUnknownFormat
+}
+
+// RewrapSK переоборачивает ключ активным мастер-ключом. Сами данные не затрагиваются.
+func RewrapSK(dataSK string) (string, uint32, error) {
+	realDataSK, err := decryptDataSK(dataSK)
+	if err != nil {
+		return "", 0, err
+	}
+
+	provider, err := currentProvider()
+	if err != nil {
+		return "", 0, err
+	}
+
+	newSK, err := wrapKey(provider, realDataSK)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return newSK, provider.ActiveKeyID(), nil
+}
+
+// wrapKey оборачивает ключ активным мастер-ключом. Заголовок с версией
+// формата и идентификатором ключа аутентифицируется как дополнительные данные.
+func wrapKey(provider KeyProvider, dataSK []byte) (string, error) {
+	keyID := provider.ActiveKeyID()
+
+	header := make([]byte, 5)
+	header[0] = formatWrappedV2
+	binary.BigEndian.PutUint32(header[1:], keyID)
+
+	wrapped, err := provider.Wrap(keyID, dataSK, header)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(append(header, wrapped...)), nil
+}
+
+func unwrapKey(provider KeyProvider, dataSK string) ([]byte, error) {
+	keyID, err := WrappingKeyID(dataSK)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, _ := base64.StdEncoding.DecodeString(dataSK)
+	if keyID == AccountWrappedKeyID {
+		return nil, ErrUnknownFormat
+	}
+	if raw[0] == formatV1 {
+		return provider.Unwrap(keyID, raw[1:], raw[:1])
+	}
+
+	return provider.Unwrap(keyID, raw[5:], raw[:5])
+}
+
+// parseKeyring разбирает строки вида "<id>:<secret>". Содержимое без версий
+// задает единственный ключ с id 1.
+func parseKeyring(content string) (map[uint32]string, error) {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) != 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) == 0 {
+		return nil, errors.New("master key is empty")
+	}
+
+	secrets := make(map[uint32]string, len(lines))
+	for _, line := range lines {
+		match := keyringLine.FindStringSubmatch(line)
+		if match == nil {
+			// старый формат: всё содержимое - один ключ
+			return map[uint32]string{legacyKeyID: content}, nil
+		}
+
+		id, err := strconv.ParseUint(match[1], 10, 32)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("invalid master key id %q", match[1])
+		}
+		if _, ok := secrets[uint32(id)]; ok {
+			return nil, fmt.Errorf("duplicate master key id %d", id)
+		}
+		secrets[uint32(id)] = match[2]
+	}
+
+	return secrets, nil
+}
+
+// deriveMasterKey выводит 256-битный мастер-ключ из секрета.
+func deriveMasterKey(secret string) ([]byte, error) {
+	key := make([]byte, dataKeyLength)
+	_, err := io.ReadFull(hkdf.New(sha256.New, []byte(secret), nil, []byte(masterKeyInfo)), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func getEncryptionKeyFromFile(filename string) (string, error) {
+	var encryptionKey string
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	encryptionKey = string(content)
+
+	return encryptionKey, nil
+}
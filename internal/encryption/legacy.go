@@ -0,0 +1,65 @@
+package encryption
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Старая схема хранила данные и ключи объектов внутри подписанных HS256 JWT.
+// Она оставлена только для чтения и перешифрования существующих записей.
+
+type legacyClaims struct {
+	jwt.RegisteredClaims
+	Data string
+}
+
+// isLegacy отличает JWT (три сегмента через точку) от base64 нового формата.
+func isLegacy(value string) bool {
+	return strings.Count(value, ".") == 2
+}
+
+func decryptLegacyData(dataSK string, encryptedData string) (string, error) {
+	provider, err := currentProvider()
+	if err != nil {
+		return "", err
+	}
+
+	// старые записи подписаны исходным секретом, который в кольце ключей имеет id 1
+	secretProvider, ok := provider.(legacySecretProvider)
+	if !ok {
+		return "", ErrUnknownMasterKey
+	}
+
+	secret, ok := secretProvider.legacySecret()
+	if !ok {
+		return "", ErrUnknownMasterKey
+	}
+
+	realDataSK, err := parseLegacyToken(secret, dataSK)
+	if err != nil {
+		return "", err
+	}
+
+	return parseLegacyToken(realDataSK, encryptedData)
+}
+
+func parseLegacyToken(sk string, tokenString string) (string, error) {
+	claims := new(legacyClaims)
+	token, err := jwt.ParseWithClaims(tokenString, claims,
+		func(t *jwt.Token) (interface{}, error) {
+			return []byte(sk), nil
+		})
+	if err != nil {
+		return "", err
+	}
+
+	if !token.Valid {
+		log.Printf("Token is not valid")
+		return "", errors.New("token is not valid")
+	}
+
+	return claims.Data, nil
+}
@@ -0,0 +1,77 @@
+package encryption
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Поля метаданных записи, которые хранятся зашифрованными.
+const (
+	MetadataName        = "name"
+	MetadataDescription = "description"
+)
+
+const (
+	// formatMetadataV7 - поле метаданных, зашифрованное ключом аккаунта: version(1) | nonce(12) | ciphertext+tag.
+	formatMetadataV7  byte = 7
+	metadataKeyInfo        = "gophkeep metadata v1"
+	blindIndexKeyInfo      = "gophkeep blind index v1"
+)
+
+// EncryptMetadata шифрует поле метаданных ключом, выведенным из ключа аккаунта.
+// Шифртекст привязан к записи и к имени поля.
+func EncryptMetadata(accountKey []byte, record Record, field string, value string) (string, error) {
+	key, err := deriveAccountSubkey(accountKey, metadataKeyInfo)
+	if err != nil {
+		return "", err
+	}
+
+	return sealWithAAD(key, []byte(value), metadataAAD(record, field))
+}
+
+// DecryptMetadata расшифровывает поле, зашифрованное EncryptMetadata.
+func DecryptMetadata(accountKey []byte, record Record, field string, value string) (string, error) {
+	key, err := deriveAccountSubkey(accountKey, metadataKeyInfo)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := openWithAAD(key, value, metadataAAD(record, field))
+	if err != nil {
+		return "", integrityError(err)
+	}
+
+	return string(plaintext), nil
+}
+
+// BlindIndex возвращает слепой индекс значения: HMAC-SHA256 на ключе, выведенном
+// из ключа аккаунта. По нему ищутся точные совпадения без расшифровки, а у разных
+// пользователей одинаковые значения дают разные индексы.
+func BlindIndex(accountKey []byte, value string) (string, error) {
+	key, err := deriveAccountSubkey(accountKey, blindIndexKeyInfo)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func metadataAAD(record Record, field string) []byte {
+	return append(record.additionalData(formatMetadataV7), field...)
+}
+
+func deriveAccountSubkey(accountKey []byte, info string) ([]byte, error) {
+	key := make([]byte, dataKeyLength)
+	_, err := io.ReadFull(hkdf.New(sha256.New, accountKey, nil, []byte(info)), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
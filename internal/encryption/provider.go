@@ -0,0 +1,266 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/term"
+)
+
+const (
+	passphraseSaltLength   = 16
+	passphraseCheckMessage = "gophkeep passphrase check"
+)
+
+var (
+	ErrNoKeyProvider = errors.New("master key provider is not configured")
+	// ErrWrongPassphrase - пароль не совпадает с проверочным значением из файла соли.
+	ErrWrongPassphrase = errors.New("passphrase does not match the stored check value")
+)
+
+// KeyProvider хранит версии мастер-ключа и оборачивает ими ключи объектов.
+// Реализации, которые держат ключи в памяти, загружают их один раз при создании.
+type KeyProvider interface {
+	// ActiveKeyID возвращает версию ключа, которой оборачиваются новые ключи.
+	ActiveKeyID() uint32
+	Wrap(keyID uint32, dataKey []byte, aad []byte) ([]byte, error)
+	Unwrap(keyID uint32, wrapped []byte, aad []byte) ([]byte, error)
+	// Reload перечитывает ключи из источника, например после добавления новой версии.
+	Reload() error
+}
+
+// legacySecretProvider реализуют провайдеры, которые знают исходный секрет
+// и могут расшифровать записи старой схемы на JWT.
+type legacySecretProvider interface {
+	legacySecret() (string, bool)
+}
+
+var (
+	providerMu sync.RWMutex
+	provider   KeyProvider
+)
+
+// SetKeyProvider задает провайдер мастер-ключа, которым пользуется пакет.
+func SetKeyProvider(p KeyProvider) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	provider = p
+}
+
+// ReloadKeys перечитывает мастер-ключи текущего провайдера.
+func ReloadKeys() error {
+	p, err := currentProvider()
+	if err != nil {
+		return err
+	}
+
+	return p.Reload()
+}
+
+func currentProvider() (KeyProvider, error) {
+	providerMu.RLock()
+	defer providerMu.RUnlock()
+	if provider == nil {
+		return nil, ErrNoKeyProvider
+	}
+
+	return provider, nil
+}
+
+// secretKeyProvider держит кольцо ключей, прочитанное из текстового источника.
+type secretKeyProvider struct {
+	mu     sync.RWMutex
+	ring   *keyring
+	source func() (string, error)
+}
+
+func newSecretKeyProvider(source func() (string, error)) (*secretKeyProvider, error) {
+	p := &secretKeyProvider{source: source}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *secretKeyProvider) Reload() error {
+	content, err := p.source()
+	if err != nil {
+		return err
+	}
+
+	secrets, err := parseKeyring(content)
+	if err != nil {
+		return err
+	}
+
+	ring, err := newKeyring(secrets)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.ring = ring
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *secretKeyProvider) keys() *keyring {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.ring
+}
+
+func (p *secretKeyProvider) ActiveKeyID() uint32 {
+	return p.keys().ActiveKeyID()
+}
+
+func (p *secretKeyProvider) Wrap(keyID uint32, dataKey []byte, aad []byte) ([]byte, error) {
+	return p.keys().Wrap(keyID, dataKey, aad)
+}
+
+func (p *secretKeyProvider) Unwrap(keyID uint32, wrapped []byte, aad []byte) ([]byte, error) {
+	return p.keys().Unwrap(keyID, wrapped, aad)
+}
+
+func (p *secretKeyProvider) legacySecret() (string, bool) {
+	return p.keys().legacySecret()
+}
+
+// NewFileKeyProvider читает кольцо мастер-ключей из файла. Относительный путь
+// переводится в абсолютный при создании, чтобы не зависеть от смены рабочей папки.
+func NewFileKeyProvider(path string) (KeyProvider, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSecretKeyProvider(func() (string, error) {
+		return getEncryptionKeyFromFile(absPath)
+	})
+}
+
+// NewEnvKeyProvider читает кольцо мастер-ключей из переменной окружения.
+func NewEnvKeyProvider(name string) (KeyProvider, error) {
+	return newSecretKeyProvider(func() (string, error) {
+		content, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return content, nil
+	})
+}
+
+// NewPassphraseKeyProvider запрашивает пароль при запуске и выводит из него
+// мастер-ключ с указанным id через Argon2id. Соль и проверочное значение ключа
+// хранятся в saltPath и создаются при первом запуске. Неверный пароль не дает
+// запустить сервер с чужим мастер-ключом.
+func NewPassphraseKeyProvider(in *os.File, out io.Writer, saltPath string, keyID uint32) (KeyProvider, error) {
+	salt, check, err := loadSalt(saltPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprint(out, "Enter master key passphrase: ")
+	passphrase, err := readPassphrase(in)
+	fmt.Fprintln(out)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase is empty")
+	}
+
+	key := argon2.IDKey(passphrase, salt, 3, 64*1024, 4, dataKeyLength)
+	expected := passphraseCheck(key)
+	switch {
+	case check == nil:
+		// первый запуск или соль старой версии без проверочного значения
+		if err := saveSalt(saltPath, salt, expected); err != nil {
+			return nil, err
+		}
+	case !hmac.Equal(check, expected):
+		return nil, ErrWrongPassphrase
+	}
+
+	ring := &keyring{
+		keys:    map[uint32][]byte{keyID: key},
+		secrets: map[uint32]string{},
+		active:  keyID,
+	}
+
+	return &staticKeyProvider{keyring: ring}, nil
+}
+
+func passphraseCheck(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(passphraseCheckMessage))
+	return mac.Sum(nil)
+}
+
+// staticKeyProvider держит ключи, которые нельзя перечитать без участия оператора.
+type staticKeyProvider struct {
+	*keyring
+}
+
+func (p *staticKeyProvider) Reload() error {
+	return nil
+}
+
+func readPassphrase(in *os.File) ([]byte, error) {
+	if term.IsTerminal(int(in.Fd())) {
+		return term.ReadPassword(int(in.Fd()))
+	}
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// loadSalt читает соль и проверочное значение. Файла еще нет - создается новая соль
+// без проверочного значения, его сохраняет saveSalt.
+func loadSalt(path string) ([]byte, []byte, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		salt := make([]byte, passphraseSaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, nil, err
+		}
+		return salt, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch len(content) {
+	case passphraseSaltLength:
+		return content, nil, nil
+	case passphraseSaltLength + sha256.Size:
+		return content[:passphraseSaltLength], content[passphraseSaltLength:], nil
+	default:
+		return nil, nil, errors.New("invalid passphrase salt file")
+	}
+}
+
+func saveSalt(path string, salt []byte, check []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	content := append(append([]byte(nil), salt...), check...)
+	return os.WriteFile(path, content, 0600)
+}
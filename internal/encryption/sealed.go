@@ -0,0 +1,309 @@
+package encryption
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const sealCheckMessage = "gophkeep unseal check"
+
+var (
+	ErrSealed         = errors.New("server is sealed")
+	ErrNotSealable    = errors.New("master key provider does not support sealing")
+	ErrUnsealFailed   = errors.New("shares do not reconstruct the master key")
+	ErrDuplicateShare = errors.New("share has already been submitted")
+)
+
+// SealConfig хранится на диске рядом с сервером. Сам ключ в нем не хранится,
+// только число долей, порог и проверочное значение для собранного ключа.
+type SealConfig struct {
+	Shares    int    `json:"shares"`
+	Threshold int    `json:"threshold"`
+	Check     string `json:"check"`
+}
+
+// SealStatus - состояние запечатанного сервера.
+type SealStatus struct {
+	Sealed    bool `json:"sealed"`
+	Threshold int  `json:"threshold"`
+	Shares    int  `json:"shares"`
+	// Progress - сколько долей уже принято в текущей попытке распечатывания.
+	Progress int `json:"progress"`
+}
+
+// Sealer реализуют провайдеры, которые стартуют запечатанными и получают
+// мастер-ключ из долей Шамира, введенных операторами.
+type Sealer interface {
+	Unseal(share []byte) (SealStatus, error)
+	Seal() error
+	Status() SealStatus
+}
+
+// sealedKeyProvider держит кольцо ключей только в памяти и только после распечатывания.
+// Кольцо собирается из долей в том же текстовом формате, что и файл с ключами.
+type sealedKeyProvider struct {
+	mu     sync.RWMutex
+	config SealConfig
+	shares [][]byte
+	ring   *keyring
+}
+
+// NewSealedKeyProvider создает запечатанный провайдер по файлу конфигурации из InitSeal.
+func NewSealedKeyProvider(configPath string) (KeyProvider, error) {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var config SealConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, err
+	}
+
+	if config.Threshold < 2 || config.Shares < config.Threshold || len(config.Check) == 0 {
+		return nil, errors.New("invalid seal config")
+	}
+
+	return &sealedKeyProvider{config: config}, nil
+}
+
+// InitSeal делит кольцо ключей на доли и сохраняет конфигурацию в configPath.
+// Если keyringContent пустой, создается новое кольцо с одним случайным ключом.
+func InitSeal(configPath string, keyringContent string, shares int, threshold int) ([][]byte, error) {
+	if len(keyringContent) == 0 {
+		secret := make([]byte, dataKeyLength)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		keyringContent = "1:" + base64.StdEncoding.EncodeToString(secret)
+	}
+
+	if _, err := parseKeyring(keyringContent); err != nil {
+		return nil, err
+	}
+
+	parts, err := SplitSecret([]byte(keyringContent), shares, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	config := SealConfig{
+		Shares:    shares,
+		Threshold: threshold,
+		Check:     sealCheck([]byte(keyringContent)),
+	}
+
+	content, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(configPath), 0700); err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(configPath, content, 0600); err != nil {
+		return nil, err
+	}
+
+	return parts, nil
+}
+
+func sealCheck(secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(sealCheckMessage))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (p *sealedKeyProvider) Unseal(share []byte) (SealStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ring != nil {
+		return p.status(), nil
+	}
+
+	for _, submitted := range p.shares {
+		if len(submitted) == len(share) && len(share) > 0 && submitted[len(submitted)-1] == share[len(share)-1] {
+			return p.status(), ErrDuplicateShare
+		}
+	}
+
+	p.shares = append(p.shares, append([]byte(nil), share...))
+	if len(p.shares) < p.config.Threshold {
+		return p.status(), nil
+	}
+
+	// после попытки доли сбрасываются, чтобы неверная доля не блокировала ввод
+	shares := p.shares
+	p.shares = nil
+
+	secret, err := CombineShares(shares)
+	if err != nil {
+		return p.status(), ErrUnsealFailed
+	}
+
+	check, _ := base64.StdEncoding.DecodeString(p.config.Check)
+	expected, _ := base64.StdEncoding.DecodeString(sealCheck(secret))
+	if !hmac.Equal(check, expected) {
+		return p.status(), ErrUnsealFailed
+	}
+
+	secrets, err := parseKeyring(string(secret))
+	if err != nil {
+		return p.status(), err
+	}
+
+	ring, err := newKeyring(secrets)
+	if err != nil {
+		return p.status(), err
+	}
+
+	p.ring = ring
+	return p.status(), nil
+}
+
+func (p *sealedKeyProvider) Seal() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ring = nil
+	p.shares = nil
+	return nil
+}
+
+func (p *sealedKeyProvider) Status() SealStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.status()
+}
+
+func (p *sealedKeyProvider) status() SealStatus {
+	return SealStatus{
+		Sealed:    p.ring == nil,
+		Threshold: p.config.Threshold,
+		Shares:    p.config.Shares,
+		Progress:  len(p.shares),
+	}
+}
+
+func (p *sealedKeyProvider) keys() (*keyring, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.ring == nil {
+		return nil, ErrSealed
+	}
+
+	return p.ring, nil
+}
+
+func (p *sealedKeyProvider) ActiveKeyID() uint32 {
+	ring, err := p.keys()
+	if err != nil {
+		return 0
+	}
+
+	return ring.ActiveKeyID()
+}
+
+func (p *sealedKeyProvider) Wrap(keyID uint32, dataKey []byte, aad []byte) ([]byte, error) {
+	ring, err := p.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	return ring.Wrap(keyID, dataKey, aad)
+}
+
+func (p *sealedKeyProvider) Unwrap(keyID uint32, wrapped []byte, aad []byte) ([]byte, error) {
+	ring, err := p.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	return ring.Unwrap(keyID, wrapped, aad)
+}
+
+func (p *sealedKeyProvider) legacySecret() (string, bool) {
+	ring, err := p.keys()
+	if err != nil {
+		return "", false
+	}
+
+	return ring.legacySecret()
+}
+
+// Reload ничего не делает: новые ключи попадают в запечатанный провайдер
+// только через новый набор долей и распечатывание.
+func (p *sealedKeyProvider) Reload() error {
+	return nil
+}
+
+// Sealed сообщает, что мастер-ключ сейчас недоступен.
+func Sealed() bool {
+	p, err := currentProvider()
+	if err != nil {
+		return true
+	}
+
+	sealer, ok := p.(Sealer)
+	return ok && sealer.Status().Sealed
+}
+
+// CurrentSealStatus возвращает состояние текущего провайдера. Провайдеры без
+// распечатывания всегда считаются распечатанными.
+func CurrentSealStatus() SealStatus {
+	p, err := currentProvider()
+	if err != nil {
+		return SealStatus{Sealed: true}
+	}
+
+	if sealer, ok := p.(Sealer); ok {
+		return sealer.Status()
+	}
+
+	return SealStatus{}
+}
+
+// Unseal передает долю текущему провайдеру.
+func Unseal(share []byte) (SealStatus, error) {
+	sealer, err := currentSealer()
+	if err != nil {
+		return SealStatus{}, err
+	}
+
+	return sealer.Unseal(share)
+}
+
+// Seal забывает мастер-ключ до следующего распечатывания.
+func Seal() error {
+	sealer, err := currentSealer()
+	if err != nil {
+		return err
+	}
+
+	return sealer.Seal()
+}
+
+func currentSealer() (Sealer, error) {
+	p, err := currentProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	sealer, ok := p.(Sealer)
+	if !ok {
+		return nil, ErrNotSealable
+	}
+
+	return sealer, nil
+}
@@ -0,0 +1,147 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+// Разделение секрета Шамира над полем GF(2^8). Каждый байт секрета - свободный член
+// отдельного многочлена степени threshold-1. Доля - значения многочленов в точке x,
+// за которыми идет сам x.
+
+var (
+	ErrInvalidShares = errors.New("invalid secret shares")
+)
+
+// SplitSecret делит секрет на parts долей, любые threshold из которых восстанавливают его.
+func SplitSecret(secret []byte, parts int, threshold int) ([][]byte, error) {
+	if len(secret) == 0 || threshold < 2 || parts < threshold || parts > 255 {
+		return nil, ErrInvalidShares
+	}
+
+	xs, err := randomPoints(parts)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([][]byte, parts)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = xs[i]
+	}
+
+	coefficients := make([]byte, threshold)
+	for b, value := range secret {
+		coefficients[0] = value
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+
+		for i, x := range xs {
+			shares[i][b] = evaluatePolynomial(coefficients, x)
+		}
+	}
+
+	return shares, nil
+}
+
+// CombineShares восстанавливает секрет интерполяцией Лагранжа в нуле. Проверить,
+// что долей хватило и они верны, можно только по известному значению секрета.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, ErrInvalidShares
+	}
+
+	length := len(shares[0])
+	if length < 2 {
+		return nil, ErrInvalidShares
+	}
+
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool, len(shares))
+	for i, share := range shares {
+		if len(share) != length {
+			return nil, ErrInvalidShares
+		}
+		x := share[length-1]
+		if x == 0 || seen[x] {
+			return nil, ErrInvalidShares
+		}
+		seen[x] = true
+		xs[i] = x
+	}
+
+	secret := make([]byte, length-1)
+	for b := range secret {
+		var value byte
+		for i, share := range shares {
+			// базисный многочлен Лагранжа в нуле: произведение x_j / (x_j - x_i)
+			basis := byte(1)
+			for j, x := range xs {
+				if i == j {
+					continue
+				}
+				basis = gfMul(basis, gfDiv(x, x^xs[i]))
+			}
+			value ^= gfMul(share[b], basis)
+		}
+		secret[b] = value
+	}
+
+	return secret, nil
+}
+
+// randomPoints выбирает различные ненулевые x для долей.
+func randomPoints(parts int) ([]byte, error) {
+	points := make([]byte, 255)
+	for i := range points {
+		points[i] = byte(i + 1)
+	}
+
+	random := make([]byte, len(points))
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+
+	// перемешивание Фишера-Йетса; небольшой сдвиг распределения x не влияет на стойкость
+	for i := len(points) - 1; i > 0; i-- {
+		j := int(random[i]) % (i + 1)
+		points[i], points[j] = points[j], points[i]
+	}
+
+	return points[:parts], nil
+}
+
+func evaluatePolynomial(coefficients []byte, x byte) byte {
+	// схема Горнера
+	result := coefficients[len(coefficients)-1]
+	for i := len(coefficients) - 2; i >= 0; i-- {
+		result = gfMul(result, x) ^ coefficients[i]
+	}
+
+	return result
+}
+
+// gfMul умножает в GF(2^8) с многочленом x^8 + x^4 + x^3 + x + 1 без ветвлений по данным.
+func gfMul(a byte, b byte) byte {
+	var product byte
+	for i := 0; i < 8; i++ {
+		product ^= -(b & 1) & a
+		carry := -(a >> 7) & 0x1b
+		a = a<<1 ^ carry
+		b >>= 1
+	}
+
+	return product
+}
+
+// gfDiv делит в GF(2^8): обратный элемент равен a^254.
+func gfDiv(a byte, b byte) byte {
+	inverse := b
+	for i := 0; i < 6; i++ {
+		inverse = gfMul(gfMul(inverse, inverse), b)
+	}
+	inverse = gfMul(inverse, inverse)
+
+	return gfMul(a, inverse)
+}
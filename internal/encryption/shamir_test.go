@@ -0,0 +1,266 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// subsets вызывает f для каждого подмножества shares из k элементов.
+func subsets(shares [][]byte, k int, f func([][]byte)) {
+	chosen := make([][]byte, 0, k)
+	var walk func(start int)
+	walk = func(start int) {
+		if len(chosen) == k {
+			f(chosen)
+			return
+		}
+		for i := start; i <= len(shares)-(k-len(chosen)); i++ {
+			chosen = append(chosen, shares[i])
+			walk(i + 1)
+			chosen = chosen[:len(chosen)-1]
+		}
+	}
+	walk(0)
+}
+
+func TestGaloisField(t *testing.T) {
+	// примеры умножения из FIPS 197, раздел 4.2
+	tests := []struct {
+		a, b, want byte
+	}{
+		{a: 0x57, b: 0x83, want: 0xc1},
+		{a: 0x57, b: 0x13, want: 0xfe},
+		{a: 0x57, b: 0x02, want: 0xae},
+		{a: 0x57, b: 0x10, want: 0x07},
+	}
+
+	for _, tt := range tests {
+		if got := gfMul(tt.a, tt.b); got != tt.want {
+			t.Errorf("gfMul(%#x, %#x) = %#x, want %#x", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	for a := 0; a < 256; a++ {
+		if got := gfMul(byte(a), 1); got != byte(a) {
+			t.Fatalf("gfMul(%#x, 1) = %#x", a, got)
+		}
+		if got := gfMul(byte(a), 0); got != 0 {
+			t.Fatalf("gfMul(%#x, 0) = %#x", a, got)
+		}
+		for b := 1; b < 256; b++ {
+			product := gfMul(byte(a), byte(b))
+			if product != gfMul(byte(b), byte(a)) {
+				t.Fatalf("gfMul is not commutative for %#x, %#x", a, b)
+			}
+			if got := gfDiv(product, byte(b)); got != byte(a) {
+				t.Fatalf("gfDiv(gfMul(%#x, %#x), %#x) = %#x", a, b, b, got)
+			}
+		}
+	}
+}
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("1:master key secret\n2:next master key secret")
+
+	tests := []struct {
+		parts     int
+		threshold int
+	}{
+		{parts: 2, threshold: 2},
+		{parts: 3, threshold: 2},
+		{parts: 5, threshold: 3},
+		{parts: 5, threshold: 5},
+		{parts: 10, threshold: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d of %d", tt.threshold, tt.parts), func(t *testing.T) {
+			shares, err := SplitSecret(secret, tt.parts, tt.threshold)
+			if err != nil {
+				t.Fatalf("SplitSecret(%d, %d): %v", tt.parts, tt.threshold, err)
+			}
+			if len(shares) != tt.parts {
+				t.Fatalf("SplitSecret(%d, %d) returned %d shares", tt.parts, tt.threshold, len(shares))
+			}
+
+			for k := 2; k <= tt.parts; k++ {
+				subsets(shares, k, func(chosen [][]byte) {
+					got, err := CombineShares(chosen)
+					if err != nil {
+						t.Fatalf("CombineShares(%d of %d): %v", k, tt.parts, err)
+					}
+					if k >= tt.threshold && !bytes.Equal(got, secret) {
+						t.Fatalf("CombineShares(%d of %d) = %q, want %q", k, tt.parts, got, secret)
+					}
+					if k < tt.threshold && bytes.Equal(got, secret) {
+						t.Fatalf("CombineShares(%d of %d) recovered the secret below threshold %d", k, tt.parts, tt.threshold)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestSplitCombineMaxParts(t *testing.T) {
+	secret := []byte("secret")
+	shares, err := SplitSecret(secret, 255, 3)
+	if err != nil {
+		t.Fatalf("SplitSecret(255, 3): %v", err)
+	}
+
+	seen := make(map[byte]bool, len(shares))
+	for _, share := range shares {
+		x := share[len(share)-1]
+		if x == 0 || seen[x] {
+			t.Fatalf("share point %d is zero or repeated", x)
+		}
+		seen[x] = true
+	}
+
+	for _, chosen := range [][][]byte{shares[:3], shares[252:], {shares[0], shares[127], shares[254]}} {
+		got, err := CombineShares(chosen)
+		if err != nil {
+			t.Fatalf("CombineShares: %v", err)
+		}
+		if !bytes.Equal(got, secret) {
+			t.Errorf("CombineShares() = %q, want %q", got, secret)
+		}
+	}
+}
+
+func TestSplitSecretInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    []byte
+		parts     int
+		threshold int
+	}{
+		{name: "empty secret", secret: nil, parts: 3, threshold: 2},
+		{name: "threshold one", secret: []byte("s"), parts: 3, threshold: 1},
+		{name: "parts below threshold", secret: []byte("s"), parts: 2, threshold: 3},
+		{name: "too many parts", secret: []byte("s"), parts: 256, threshold: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := SplitSecret(tt.secret, tt.parts, tt.threshold); !errors.Is(err, ErrInvalidShares) {
+				t.Errorf("SplitSecret() error = %v, want %v", err, ErrInvalidShares)
+			}
+		})
+	}
+}
+
+func TestCombineSharesInvalid(t *testing.T) {
+	shares, err := SplitSecret([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatalf("SplitSecret: %v", err)
+	}
+
+	zeroPoint := bytes.Clone(shares[1])
+	zeroPoint[len(zeroPoint)-1] = 0
+
+	tests := []struct {
+		name   string
+		shares [][]byte
+	}{
+		{name: "no shares", shares: nil},
+		{name: "one share", shares: shares[:1]},
+		{name: "point only", shares: [][]byte{{1}, {2}}},
+		{name: "length mismatch", shares: [][]byte{shares[0], shares[1][1:]}},
+		{name: "zero point", shares: [][]byte{shares[0], zeroPoint}},
+		{name: "repeated point", shares: [][]byte{shares[0], shares[0]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CombineShares(tt.shares); !errors.Is(err, ErrInvalidShares) {
+				t.Errorf("CombineShares() error = %v, want %v", err, ErrInvalidShares)
+			}
+		})
+	}
+}
+
+func TestUnseal(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "seal.json")
+
+	shares, err := InitSeal(configPath, "1:old\n2:new", 3, 2)
+	if err != nil {
+		t.Fatalf("InitSeal: %v", err)
+	}
+	otherShares, err := InitSeal(filepath.Join(dir, "other.json"), "", 3, 2)
+	if err != nil {
+		t.Fatalf("InitSeal: %v", err)
+	}
+
+	p, err := NewSealedKeyProvider(configPath)
+	if err != nil {
+		t.Fatalf("NewSealedKeyProvider: %v", err)
+	}
+	sealer := p.(Sealer)
+
+	if status := sealer.Status(); !status.Sealed || status.Threshold != 2 || status.Shares != 3 {
+		t.Fatalf("Status() = %+v, want sealed 2 of 3", status)
+	}
+	if _, err := p.Wrap(2, []byte("key"), nil); !errors.Is(err, ErrSealed) {
+		t.Fatalf("Wrap() while sealed error = %v, want %v", err, ErrSealed)
+	}
+
+	tampered := bytes.Clone(shares[1])
+	tampered[0] ^= 1
+
+	tests := []struct {
+		name    string
+		shares  [][]byte
+		wantErr error
+	}{
+		{name: "tampered share", shares: [][]byte{shares[0], tampered}, wantErr: ErrUnsealFailed},
+		{name: "share of other seal", shares: [][]byte{shares[0], otherShares[1]}, wantErr: ErrUnsealFailed},
+		{name: "repeated share", shares: [][]byte{shares[2], shares[2]}, wantErr: ErrDuplicateShare},
+		// после повтора уже принятая доля остается в счете, хватает еще одной
+		{name: "quorum", shares: [][]byte{shares[0]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var status SealStatus
+			var err error
+			for _, share := range tt.shares {
+				if status, err = sealer.Unseal(share); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Unseal() error = %v, want %v", err, tt.wantErr)
+			}
+			if status.Sealed != (tt.wantErr != nil) {
+				t.Fatalf("Unseal() status = %+v", status)
+			}
+			wantProgress := 0
+			if errors.Is(tt.wantErr, ErrDuplicateShare) {
+				wantProgress = 1
+			}
+			if status.Progress != wantProgress {
+				t.Fatalf("Progress = %d, want %d", status.Progress, wantProgress)
+			}
+		})
+	}
+
+	if p.ActiveKeyID() != 2 {
+		t.Fatalf("ActiveKeyID() = %d, want 2", p.ActiveKeyID())
+	}
+	wrapped, err := p.Wrap(1, []byte("data key"), []byte("aad"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	if err := sealer.Seal(); err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if _, err := p.Unwrap(1, wrapped, []byte("aad")); !errors.Is(err, ErrSealed) {
+		t.Fatalf("Unwrap() after Seal error = %v, want %v", err, ErrSealed)
+	}
+}
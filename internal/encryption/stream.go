@@ -0,0 +1,267 @@
+package encryption
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Потоковый формат в духе STREAM: данные режутся на куски по StreamChunkSize байт,
+// каждый кусок шифруется отдельно. Nonce куска - его номер и флаг последнего куска,
+// поэтому перестановка, удаление и обрезка кусков обнаруживаются при расшифровке.
+//
+// header: version(1) | salt(16), затем куски: ciphertext+tag.
+const (
+	formatStreamV6  byte = 6
+	StreamChunkSize      = 64 * 1024
+	streamSaltSize       = 16
+	streamKeyInfo        = "gophkeep stream v1"
+	streamLastChunk byte = 1
+)
+
+var (
+	// ErrStreamTruncated - поток закончился до последнего куска.
+	ErrStreamTruncated = errors.New("encrypted stream is truncated")
+	errStreamOverflow  = errors.New("encrypted stream is too long")
+	errStreamClosed    = errors.New("encrypted stream is closed")
+)
+
+// StreamHeaderSize - размер заголовка потока перед первым куском.
+const StreamHeaderSize = 1 + streamSaltSize
+
+// NewRecordStreamWriter шифрует поток ключом объекта с привязкой к записи.
+func NewRecordStreamWriter(w io.Writer, sk []byte, record Record) (io.WriteCloser, error) {
+	return NewStreamWriter(w, sk, record.additionalData(formatStreamV6))
+}
+
+// NewRecordStreamReader расшифровывает поток, записанный NewRecordStreamWriter.
+func NewRecordStreamReader(r io.Reader, sk []byte, record Record) (io.Reader, error) {
+	return NewStreamReader(r, sk, record.additionalData(formatStreamV6))
+}
+
+type streamCipher struct {
+	aead    cipher.AEAD
+	aad     []byte
+	counter uint64
+	nonce   []byte
+}
+
+// newStreamCipher выводит ключ потока из ключа и соли, чтобы один ключ объекта
+// можно было безопасно использовать для нескольких потоков.
+func newStreamCipher(key []byte, salt []byte, aad []byte) (*streamCipher, error) {
+	streamKey := make([]byte, dataKeyLength)
+	_, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte(streamKeyInfo)), streamKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(streamKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamCipher{aead: aead, aad: aad, nonce: make([]byte, aead.NonceSize())}, nil
+}
+
+// nextNonce собирает nonce из номера куска (11 байт) и флага последнего куска.
+func (s *streamCipher) nextNonce(last bool) ([]byte, error) {
+	if s.counter >= 1<<63 {
+		return nil, errStreamOverflow
+	}
+
+	clear(s.nonce)
+	binary.BigEndian.PutUint64(s.nonce[3:11], s.counter)
+	if last {
+		s.nonce[11] = streamLastChunk
+	}
+	s.counter++
+
+	return s.nonce, nil
+}
+
+type streamWriter struct {
+	w      io.Writer
+	cipher *streamCipher
+	buf    []byte
+	out    []byte
+	closed bool
+}
+
+// NewStreamWriter возвращает писателя, который шифрует данные кусками и пишет их в w.
+// aad аутентифицируется вместе с каждым куском. Close обязателен: он записывает
+// последний кусок, без которого поток считается обрезанным.
+func NewStreamWriter(w io.Writer, key []byte, aad []byte) (io.WriteCloser, error) {
+	header := make([]byte, StreamHeaderSize)
+	header[0] = formatStreamV6
+	if _, err := rand.Read(header[1:]); err != nil {
+		return nil, err
+	}
+
+	streamCipher, err := newStreamCipher(key, header[1:], aad)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &streamWriter{
+		w:      w,
+		cipher: streamCipher,
+		buf:    make([]byte, 0, StreamChunkSize),
+		out:    make([]byte, 0, StreamChunkSize+streamCipher.aead.Overhead()),
+	}, nil
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, errStreamClosed
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// полный кусок пишется только когда известно, что он не последний
+		if len(sw.buf) == StreamChunkSize {
+			if err := sw.flush(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(sw.buf[len(sw.buf):cap(sw.buf)], p)
+		sw.buf = sw.buf[:len(sw.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (sw *streamWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+
+	return sw.flush(true)
+}
+
+func (sw *streamWriter) flush(last bool) error {
+	nonce, err := sw.cipher.nextNonce(last)
+	if err != nil {
+		return err
+	}
+
+	sw.out = sw.cipher.aead.Seal(sw.out[:0], nonce, sw.buf, sw.cipher.aad)
+	sw.buf = sw.buf[:0]
+
+	_, err = sw.w.Write(sw.out)
+	return err
+}
+
+type streamReader struct {
+	r      io.Reader
+	cipher *streamCipher
+	in     []byte
+	buf    []byte
+	plain  []byte
+	done   bool
+}
+
+// NewStreamReader возвращает читателя, который расшифровывает поток из r кусками.
+// Измененный или переставленный кусок дает ErrIntegrity, обрезанный поток - ErrStreamTruncated.
+func NewStreamReader(r io.Reader, key []byte, aad []byte) (io.Reader, error) {
+	header := make([]byte, StreamHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrStreamTruncated
+		}
+		return nil, err
+	}
+
+	if header[0] != formatStreamV6 {
+		return nil, ErrUnknownFormat
+	}
+
+	streamCipher, err := newStreamCipher(key, header[1:], aad)
+	if err != nil {
+		return nil, err
+	}
+
+	// читаем на байт больше куска, чтобы узнать, есть ли за ним следующий
+	return &streamReader{
+		r:      r,
+		cipher: streamCipher,
+		in:     make([]byte, 0, StreamChunkSize+streamCipher.aead.Overhead()+1),
+		buf:    make([]byte, 0, StreamChunkSize),
+	}, nil
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	for len(sr.plain) == 0 {
+		if sr.done {
+			return 0, io.EOF
+		}
+		if err := sr.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, sr.plain)
+	sr.plain = sr.plain[n:]
+	return n, nil
+}
+
+func (sr *streamReader) readChunk() error {
+	chunkSize := StreamChunkSize + sr.cipher.aead.Overhead()
+
+	n, err := io.ReadFull(sr.r, sr.in[len(sr.in):cap(sr.in)])
+	sr.in = sr.in[:len(sr.in)+n]
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	last := len(sr.in) <= chunkSize
+	chunk := sr.in
+	if !last {
+		chunk = sr.in[:chunkSize]
+	}
+
+	if len(chunk) < sr.cipher.aead.Overhead() {
+		return ErrStreamTruncated
+	}
+
+	nonce, err := sr.cipher.nextNonce(last)
+	if err != nil {
+		return err
+	}
+
+	plain, err := sr.cipher.aead.Open(sr.buf[:0], nonce, chunk, sr.cipher.aad)
+	if err != nil {
+		if last {
+			// кусок без флага последнего в конце потока: хвост отрезан
+			nonce[11] = 0
+			if _, errNotLast := sr.cipher.aead.Open(nil, nonce, chunk, sr.cipher.aad); errNotLast == nil {
+				return ErrStreamTruncated
+			}
+		}
+		return ErrIntegrity
+	}
+
+	sr.plain = plain
+	if last {
+		sr.done = true
+		sr.in = sr.in[:0]
+		return nil
+	}
+
+	// лишний прочитанный байт переносим в начало буфера следующего куска
+	rest := copy(sr.in[:cap(sr.in)], sr.in[chunkSize:])
+	sr.in = sr.in[:rest]
+	return nil
+}
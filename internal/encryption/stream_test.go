@@ -0,0 +1,236 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+const streamTagSize = 16
+
+// sealedChunkSize - размер полного куска в шифртексте потока.
+const sealedChunkSize = StreamChunkSize + streamTagSize
+
+func encryptStream(t *testing.T, key []byte, aad []byte, data []byte, writeSize int) []byte {
+	t.Helper()
+
+	var out bytes.Buffer
+	w, err := NewStreamWriter(&out, key, aad)
+	if err != nil {
+		t.Fatalf("NewStreamWriter: %v", err)
+	}
+
+	for rest := data; len(rest) > 0; {
+		n := min(writeSize, len(rest))
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		rest = rest[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	return out.Bytes()
+}
+
+func decryptStream(key []byte, aad []byte, encrypted []byte) ([]byte, error) {
+	r, err := NewStreamReader(bytes.NewReader(encrypted), key, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(r)
+}
+
+// streamChunk возвращает i-й зашифрованный кусок потока.
+func streamChunk(encrypted []byte, i int) []byte {
+	start := StreamHeaderSize + i*sealedChunkSize
+	return encrypted[start:min(start+sealedChunkSize, len(encrypted))]
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("rand.Read: %v", err)
+	}
+
+	return data
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := mustRandomKey(t)
+	aad := []byte("file")
+
+	tests := []struct {
+		name      string
+		size      int
+		writeSize int
+		chunks    int
+	}{
+		{name: "empty", size: 0, writeSize: 1, chunks: 1},
+		{name: "one byte", size: 1, writeSize: 1, chunks: 1},
+		{name: "chunk minus one", size: StreamChunkSize - 1, writeSize: StreamChunkSize, chunks: 1},
+		{name: "exact chunk", size: StreamChunkSize, writeSize: StreamChunkSize, chunks: 1},
+		{name: "chunk plus one", size: StreamChunkSize + 1, writeSize: StreamChunkSize, chunks: 2},
+		{name: "three chunks", size: 3 * StreamChunkSize, writeSize: 3 * StreamChunkSize, chunks: 3},
+		{name: "small writes", size: 2*StreamChunkSize + 10, writeSize: 1000, chunks: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := randomBytes(t, tt.size)
+			encrypted := encryptStream(t, key, aad, data, tt.writeSize)
+
+			wantSize := StreamHeaderSize + tt.size + tt.chunks*streamTagSize
+			if len(encrypted) != wantSize {
+				t.Fatalf("encrypted size = %d, want %d", len(encrypted), wantSize)
+			}
+			if encrypted[0] != formatStreamV6 {
+				t.Fatalf("format = %d, want %d", encrypted[0], formatStreamV6)
+			}
+
+			r, err := NewStreamReader(iotest.HalfReader(bytes.NewReader(encrypted)), key, aad)
+			if err != nil {
+				t.Fatalf("NewStreamReader: %v", err)
+			}
+			got, err := io.ReadAll(iotest.OneByteReader(r))
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("decrypted %d bytes, want %d bytes of original data", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestStreamTamper(t *testing.T) {
+	key := mustRandomKey(t)
+	aad := []byte("file")
+	data := randomBytes(t, 2*StreamChunkSize+10)
+	encrypted := encryptStream(t, key, aad, data, len(data))
+	header := encrypted[:StreamHeaderSize]
+
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	flip := func(pos int) []byte {
+		changed := bytes.Clone(encrypted)
+		changed[pos] ^= 1
+		return changed
+	}
+
+	tests := []struct {
+		name      string
+		encrypted []byte
+		key       []byte
+		aad       []byte
+		wantErr   error
+	}{
+		{name: "empty", encrypted: nil, wantErr: ErrStreamTruncated},
+		{name: "short header", encrypted: encrypted[:StreamHeaderSize-1], wantErr: ErrStreamTruncated},
+		{name: "header only", encrypted: header, wantErr: ErrStreamTruncated},
+		{name: "last chunk cut off", encrypted: encrypted[:StreamHeaderSize+2*sealedChunkSize], wantErr: ErrStreamTruncated},
+		{name: "two chunks cut off", encrypted: encrypted[:StreamHeaderSize+sealedChunkSize], wantErr: ErrStreamTruncated},
+		{name: "cut inside chunk", encrypted: encrypted[:StreamHeaderSize+sealedChunkSize+100], wantErr: ErrIntegrity},
+		{name: "last byte cut off", encrypted: encrypted[:len(encrypted)-1], wantErr: ErrIntegrity},
+		{name: "trailing data", encrypted: join(encrypted, []byte{0}), wantErr: ErrIntegrity},
+		{
+			name:      "swapped chunks",
+			encrypted: join(header, streamChunk(encrypted, 1), streamChunk(encrypted, 0), streamChunk(encrypted, 2)),
+			wantErr:   ErrIntegrity,
+		},
+		{
+			name:      "repeated chunk",
+			encrypted: join(header, streamChunk(encrypted, 0), streamChunk(encrypted, 0), streamChunk(encrypted, 2)),
+			wantErr:   ErrIntegrity,
+		},
+		{
+			name:      "dropped middle chunk",
+			encrypted: join(header, streamChunk(encrypted, 0), streamChunk(encrypted, 2)),
+			wantErr:   ErrIntegrity,
+		},
+		{name: "flipped salt", encrypted: flip(1), wantErr: ErrIntegrity},
+		{name: "flipped first chunk", encrypted: flip(StreamHeaderSize), wantErr: ErrIntegrity},
+		{name: "flipped last tag", encrypted: flip(len(encrypted) - 1), wantErr: ErrIntegrity},
+		{name: "unknown format", encrypted: flip(0), wantErr: ErrUnknownFormat},
+		{name: "other key", encrypted: encrypted, key: mustRandomKey(t), wantErr: ErrIntegrity},
+		{name: "other aad", encrypted: encrypted, aad: []byte("other file"), wantErr: ErrIntegrity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readKey, readAAD := key, aad
+			if tt.key != nil {
+				readKey = tt.key
+			}
+			if tt.aad != nil {
+				readAAD = tt.aad
+			}
+
+			got, err := decryptStream(readKey, readAAD, tt.encrypted)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("decrypt error = %v, want %v", err, tt.wantErr)
+			}
+			if !bytes.HasPrefix(data, got) {
+				t.Errorf("decrypt returned %d bytes that are not a prefix of the original data", len(got))
+			}
+		})
+	}
+}
+
+func TestRecordStreamBinding(t *testing.T) {
+	sk := mustRandomKey(t)
+	record := Record{StaticID: "static", UserID: "user", DataType: "file", KeyID: AccountWrappedKeyID}
+	data := []byte("file contents")
+
+	var out bytes.Buffer
+	w, err := NewRecordStreamWriter(&out, sk, record)
+	if err != nil {
+		t.Fatalf("NewRecordStreamWriter: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := w.Write(data); !errors.Is(err, errStreamClosed) {
+		t.Fatalf("Write after Close error = %v, want %v", err, errStreamClosed)
+	}
+
+	tests := []struct {
+		name    string
+		record  Record
+		wantErr error
+	}{
+		{name: "same record", record: record},
+		{name: "other static id", record: Record{StaticID: "other", UserID: "user", DataType: "file"}, wantErr: ErrIntegrity},
+		{name: "other user", record: Record{StaticID: "static", UserID: "other", DataType: "file"}, wantErr: ErrIntegrity},
+		{name: "other data type", record: Record{StaticID: "static", UserID: "user", DataType: "text"}, wantErr: ErrIntegrity},
+		{name: "other key id", record: Record{StaticID: "static", UserID: "user", DataType: "file", KeyID: 2}, wantErr: ErrIntegrity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRecordStreamReader(bytes.NewReader(out.Bytes()), sk, tt.record)
+			if err != nil {
+				t.Fatalf("NewRecordStreamReader: %v", err)
+			}
+
+			got, err := io.ReadAll(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadAll error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !bytes.Equal(got, data) {
+				t.Errorf("ReadAll = %q, want %q", got, data)
+			}
+		})
+	}
+}
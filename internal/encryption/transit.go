@@ -0,0 +1,150 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Пути API внешнего сервиса ключей в стиле transit: мастер-ключ не покидает
+// сервис, а сервер отправляет ему ключи на оборачивание и разворачивание.
+const (
+	TransitKeysPath    = "/v1/transit/keys"
+	TransitEncryptPath = "/v1/transit/encrypt"
+	TransitDecryptPath = "/v1/transit/decrypt"
+	transitTimeout     = 10 * time.Second
+)
+
+type TransitKeysResponse struct {
+	ActiveKeyID uint32 `json:"active_key_id"`
+}
+
+type TransitEncryptRequest struct {
+	KeyID     uint32 `json:"key_id"`
+	Plaintext []byte `json:"plaintext"`
+	AAD       []byte `json:"aad"`
+}
+
+type TransitDecryptRequest struct {
+	KeyID      uint32 `json:"key_id"`
+	Ciphertext []byte `json:"ciphertext"`
+	AAD        []byte `json:"aad"`
+}
+
+type TransitResponse struct {
+	Data []byte `json:"data"`
+}
+
+type transitKeyProvider struct {
+	mu         sync.RWMutex
+	active     uint32
+	address    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewTransitKeyProvider подключается к сервису ключей по HTTP. При создании
+// запрашивается только номер активной версии ключа.
+func NewTransitKeyProvider(address string, token string) (KeyProvider, error) {
+	p := &transitKeyProvider{
+		address:    strings.TrimRight(address, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: transitTimeout},
+	}
+
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *transitKeyProvider) ActiveKeyID() uint32 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.active
+}
+
+func (p *transitKeyProvider) Reload() error {
+	var keys TransitKeysResponse
+	if err := p.call(http.MethodGet, TransitKeysPath, nil, &keys); err != nil {
+		return err
+	}
+
+	if keys.ActiveKeyID == 0 {
+		return fmt.Errorf("key service returned no active key")
+	}
+
+	p.mu.Lock()
+	p.active = keys.ActiveKeyID
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *transitKeyProvider) Wrap(keyID uint32, dataKey []byte, aad []byte) ([]byte, error) {
+	var response TransitResponse
+	request := TransitEncryptRequest{KeyID: keyID, Plaintext: dataKey, AAD: aad}
+	if err := p.call(http.MethodPost, TransitEncryptPath, request, &response); err != nil {
+		return nil, err
+	}
+
+	return response.Data, nil
+}
+
+func (p *transitKeyProvider) Unwrap(keyID uint32, wrapped []byte, aad []byte) ([]byte, error) {
+	var response TransitResponse
+	request := TransitDecryptRequest{KeyID: keyID, Ciphertext: wrapped, AAD: aad}
+	if err := p.call(http.MethodPost, TransitDecryptPath, request, &response); err != nil {
+		return nil, err
+	}
+
+	return response.Data, nil
+}
+
+func (p *transitKeyProvider) call(method string, path string, request any, response any) error {
+	var body io.Reader
+	if request != nil {
+		payload, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, p.address+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(p.token) != 0 {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	res, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnprocessableEntity:
+		return ErrDecrypt
+	case http.StatusNotFound:
+		return ErrUnknownMasterKey
+	default:
+		return fmt.Errorf("key service responded with status %d", res.StatusCode)
+	}
+
+	payload, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(payload, response)
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/database"
+	"gophkeep/internal/logger"
+	"gophkeep/internal/model"
+	"gophkeep/internal/srp"
+	"net/http"
+	"strings"
+)
+
+// ChangePasswordHandle меняет пароль после повторной проверки текущего. Новый пароль
+// передается верификатором SRP или, для старых клиентов, открытым текстом. Остальные
+// сессии аккаунта отзываются, текущая продолжает работать.
+func (env Env) ChangePasswordHandle(res http.ResponseWriter, req *http.Request) {
+	change, ok := readAccountChange(res, req)
+	if !ok {
+		return
+	}
+
+	if change.NewSRP != nil && !srp.ValidVerifier(change.NewSRP.Salt, change.NewSRP.Verifier) {
+		http.Error(res, "invalid SRP verifier", http.StatusBadRequest)
+		return
+	}
+
+	if len(change.NewPassword) == 0 && change.NewSRP == nil {
+		http.Error(res, "new password is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok = env.reauthenticate(res, req, change); !ok {
+		return
+	}
+
+	revoked, err := env.Storage.ChangePassword(req.Context(), change.NewPassword, change.NewSRP)
+	if errors.Is(err, auth.ErrPasswordTooLong) {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Sugar.Infow("Password changed", "revoked sessions", revoked)
+	env.audit(req, database.AuditPasswordChange, "")
+	res.WriteHeader(http.StatusOK)
+}
+
+// ChangeLoginHandle переименовывает аккаунт после повторной проверки пароля.
+func (env Env) ChangeLoginHandle(res http.ResponseWriter, req *http.Request) {
+	change, ok := readAccountChange(res, req)
+	if !ok {
+		return
+	}
+
+	change.NewLogin = strings.TrimSpace(change.NewLogin)
+	if len(change.NewLogin) == 0 {
+		http.Error(res, "new login is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok = env.reauthenticate(res, req, change); !ok {
+		return
+	}
+
+	err := env.Storage.ChangeLogin(req.Context(), change.NewLogin)
+	if errors.Is(err, database.ErrLoginInUse) {
+		http.Error(res, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	env.audit(req, database.AuditLoginChange, change.NewLogin)
+	res.WriteHeader(http.StatusOK)
+}
+
+// DeleteAccountHandle после повторной проверки пароля удаляет аккаунт со всеми записями,
+// ключами и сессиями одной транзакцией.
+func (env Env) DeleteAccountHandle(res http.ResponseWriter, req *http.Request) {
+	change, ok := readAccountChange(res, req)
+	if !ok {
+		return
+	}
+
+	userID, ok := env.reauthenticate(res, req, change)
+	if !ok {
+		return
+	}
+
+	if err := env.Storage.DeleteAccount(req.Context(), userID); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Sugar.Infow("Account deleted", "account", userID)
+	clearSessionCookies(res)
+	res.WriteHeader(http.StatusOK)
+}
+
+// reauthenticate проверяет, что изменение подтверждено паролем аккаунта из контекста:
+// одноразовым токеном повторной проверки SRP (SRPReauthHandle), выданным этой же сессии,
+// или, если у аккаунта нет верификатора, самим паролем. Неверный пароль дает 403, а не 401, чтобы клиент не путал его с истекшей
+// сессией. Попытки ввода пароля ограничиваются так же, как вход.
+func (env Env) reauthenticate(res http.ResponseWriter, req *http.Request, change model.AccountChange) (string, bool) {
+	ctx := req.Context()
+
+	userID, ok := auth.UserIDFromContext(ctx)
+	if !ok {
+		res.WriteHeader(http.StatusUnauthorized)
+		return "", false
+	}
+
+	if len(change.ReauthToken) != 0 {
+		sessionID, _ := auth.SessionIDFromContext(ctx)
+		id, tokenSession, tokenID, ok := auth.ParseReauthToken(change.ReauthToken)
+		if !ok || id != userID || tokenSession != sessionID {
+			http.Error(res, "invalid reauthentication token", http.StatusForbidden)
+			return "", false
+		}
+
+		redeemed, err := env.Storage.RedeemReauthToken(ctx, tokenID, userID, sessionID)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return "", false
+		}
+		if !redeemed {
+			http.Error(res, "invalid reauthentication token", http.StatusForbidden)
+			return "", false
+		}
+		return userID, true
+	}
+
+	login, err := env.Storage.GetAccountLogin(ctx)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+
+	account := env.Limiter.Account(login)
+	client := env.Limiter.Client(env.clientIP(req))
+	if !env.allowAttempt(res, req, account, client) {
+		return "", false
+	}
+
+	id, err := env.Storage.CheckLogin(ctx, model.SimpleAccountData{Login: login, Password: change.Password})
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+
+	if id != userID {
+		env.failAttempt(req, account, client)
+		http.Error(res, "wrong password", http.StatusForbidden)
+		return "", false
+	}
+
+	env.resetAttempts(req, account)
+	return userID, true
+}
+
+func readAccountChange(res http.ResponseWriter, req *http.Request) (model.AccountChange, bool) {
+	var change model.AccountChange
+	var buf bytes.Buffer
+
+	// читаем тело запроса
+	_, err := buf.ReadFrom(req.Body)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return change, false
+	}
+
+	if err = json.Unmarshal(buf.Bytes(), &change); err != nil {
+		logger.Log.Info("could not unmarshal account change")
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return change, false
+	}
+
+	return change, true
+}
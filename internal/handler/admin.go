@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/database"
+	"gophkeep/internal/logger"
+	"gophkeep/internal/model"
+	"net/http"
+)
+
+var errOwnAccount = errors.New("administrators cannot disable, demote or delete their own account")
+
+// ListAccountsHandle возвращает аккаунты с количеством и объемом записей. Содержимое
+// записей администратору недоступно.
+func (env Env) ListAccountsHandle(res http.ResponseWriter, req *http.Request) {
+	accounts, err := env.Storage.ListAccounts(req.Context())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, accounts)
+}
+
+// DisableAccountHandle отключает аккаунт: его сессии отзываются, а токены и сертификаты
+// перестают приниматься сразу.
+func (env Env) DisableAccountHandle(res http.ResponseWriter, req *http.Request) {
+	action, ok := env.readAccountAction(res, req, true)
+	if !ok {
+		return
+	}
+
+	if !env.accountActionDone(res, env.Storage.SetAccountDisabled(req.Context(), action.ID, true)) {
+		return
+	}
+
+	env.auditAdmin(req, action.ID, database.AuditAdminDisable, "")
+	res.WriteHeader(http.StatusOK)
+}
+
+// EnableAccountHandle снова включает отключенный аккаунт.
+func (env Env) EnableAccountHandle(res http.ResponseWriter, req *http.Request) {
+	action, ok := env.readAccountAction(res, req, false)
+	if !ok {
+		return
+	}
+
+	if !env.accountActionDone(res, env.Storage.SetAccountDisabled(req.Context(), action.ID, false)) {
+		return
+	}
+
+	env.auditAdmin(req, action.ID, database.AuditAdminEnable, "")
+	res.WriteHeader(http.StatusOK)
+}
+
+// ForceLogoutHandle отзывает все сессии аккаунта.
+func (env Env) ForceLogoutHandle(res http.ResponseWriter, req *http.Request) {
+	action, ok := env.readAccountAction(res, req, false)
+	if !ok {
+		return
+	}
+
+	revoked, err := env.Storage.RevokeAccountSessions(req.Context(), action.ID)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	env.auditAdmin(req, action.ID, database.AuditAdminLogout, fmt.Sprintf("%d sessions", revoked))
+	res.WriteHeader(http.StatusOK)
+}
+
+// SetAccountRoleHandle назначает аккаунту роль. Сессии аккаунта отзываются, чтобы новая
+// роль попала в токены доступа.
+func (env Env) SetAccountRoleHandle(res http.ResponseWriter, req *http.Request) {
+	action, ok := env.readAccountAction(res, req, false)
+	if !ok {
+		return
+	}
+
+	if !auth.ValidRole(action.Role) {
+		http.Error(res, "role must be user or admin", http.StatusBadRequest)
+		return
+	}
+	if adminID, _ := auth.UserIDFromContext(req.Context()); adminID == action.ID && action.Role != auth.RoleAdmin {
+		http.Error(res, errOwnAccount.Error(), http.StatusConflict)
+		return
+	}
+
+	if !env.accountActionDone(res, env.Storage.SetAccountRole(req.Context(), action.ID, action.Role)) {
+		return
+	}
+
+	env.auditAdmin(req, action.ID, database.AuditAdminRole, action.Role)
+	res.WriteHeader(http.StatusOK)
+}
+
+// AdminDeleteAccountHandle удаляет аккаунт со всеми записями, ключами и сессиями.
+func (env Env) AdminDeleteAccountHandle(res http.ResponseWriter, req *http.Request) {
+	action, ok := env.readAccountAction(res, req, true)
+	if !ok {
+		return
+	}
+
+	// журнал аккаунта удаляется вместе с ним, поэтому удаление записывается заранее
+	// и только в журнал администраторов. Без записи аккаунт не удаляется
+	adminID, _ := auth.UserIDFromContext(req.Context())
+	err := env.Storage.RecordAdminAudit(req.Context(), adminID, action.ID, database.AuditAdminDelete, "")
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !env.accountActionDone(res, env.Storage.DeleteAccount(req.Context(), action.ID)) {
+		return
+	}
+
+	logger.Sugar.Infow("Account deleted by administrator", "account", action.ID, "admin", adminID)
+	res.WriteHeader(http.StatusOK)
+}
+
+// readAccountAction читает аккаунт действия. Если notSelf, администратор не может выполнить
+// действие над собой, чтобы не остаться без доступа.
+func (env Env) readAccountAction(res http.ResponseWriter, req *http.Request, notSelf bool) (model.AccountAction, bool) {
+	var action model.AccountAction
+	if !readJSONBody(res, req, &action, "could not unmarshal account action") {
+		return action, false
+	}
+
+	if len(action.ID) == 0 {
+		http.Error(res, "account id is required", http.StatusBadRequest)
+		return action, false
+	}
+
+	if adminID, _ := auth.UserIDFromContext(req.Context()); notSelf && adminID == action.ID {
+		http.Error(res, errOwnAccount.Error(), http.StatusConflict)
+		return action, false
+	}
+
+	return action, true
+}
+
+func (env Env) accountActionDone(res http.ResponseWriter, err error) bool {
+	if errors.Is(err, database.ErrAccountNotFound) {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// auditAdmin записывает действие администратора в журнал аккаунта, над которым оно выполнено,
+// и в журнал администраторов.
+func (env Env) auditAdmin(req *http.Request, userID string, event string, detail string) {
+	adminID, _ := auth.UserIDFromContext(req.Context())
+	logger.Sugar.Infow("Administrator action", "event", event, "account", userID, "admin", adminID)
+
+	if err := env.Storage.RecordAdminAudit(req.Context(), adminID, userID, event, detail); err != nil {
+		logger.Sugar.Errorw("Failed to record admin audit event", "event", event, "error", err)
+	}
+
+	if len(detail) != 0 {
+		detail = " " + detail
+	}
+	env.audit(req.WithContext(auth.WithUserID(req.Context(), userID)), event, "by "+adminID+detail)
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"fmt"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/database"
+	"gophkeep/internal/logger"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AuditHandle возвращает последние события журнала аккаунта. Количество задается
+// параметром limit.
+func (env Env) AuditHandle(res http.ResponseWriter, req *http.Request) {
+	limit := defaultAuditLimit
+	if value := req.URL.Query().Get("limit"); len(value) != 0 {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxAuditLimit {
+			http.Error(res, fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	events, err := env.Storage.GetAuditLog(req.Context(), limit)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, events)
+}
+
+// AuditMiddleware записывает в журнал аккаунта каждый запрос по персональному токену
+// вместе с кодом ответа. Ставится после auth.CookieMiddleware.
+func (env Env) AuditMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.PersonalTokenFromContext(r.Context()); !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+
+		env.audit(r, database.AuditTokenUse, fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, sw.status))
+	})
+}
+
+// audit записывает событие в журнал. Ошибка журнала не отменяет уже выполненный запрос.
+func (env Env) audit(req *http.Request, event string, detail string) {
+	if err := env.Storage.RecordAudit(req.Context(), event, detail); err != nil {
+		logger.Sugar.Errorw("Failed to record audit event", "event", event, "error", err)
+	}
+}
+
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(statusCode int) {
+	w.status = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
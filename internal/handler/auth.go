@@ -6,6 +6,8 @@ import (
 	"gophkeep/internal/auth"
 	"gophkeep/internal/logger"
 	"gophkeep/internal/model"
+	"gophkeep/internal/ratelimit"
+	"gophkeep/internal/srp"
 	"net/http"
 )
 
@@ -27,6 +29,12 @@ func (env Env) AuthHandle(res http.ResponseWriter, req *http.Request) {
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
+
+	keys := []ratelimit.Key{env.Limiter.Account(loginData.Login), env.Limiter.Client(env.clientIP(req))}
+	if !env.allowAttempt(res, req, keys...) {
+		return
+	}
+
 	id, err := env.Storage.CheckLogin(ctx, loginData)
 	if err != nil {
 		logger.Log.Info("could not check login data")
@@ -35,15 +43,43 @@ func (env Env) AuthHandle(res http.ResponseWriter, req *http.Request) {
 	}
 
 	if len(id) == 0 {
+		env.failAttempt(req, keys...)
 		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// клиент с поддержкой SRP переводит аккаунт на вход без передачи пароля
+	if loginData.SRP != nil && srp.ValidVerifier(loginData.SRP.Salt, loginData.SRP.Verifier) {
+		if err = env.Storage.SetSRPVerifier(auth.WithUserID(ctx, id), *loginData.SRP); err != nil {
+			logger.Sugar.Errorw("Could not store SRP verifier", "error", err)
+		}
 	}
 
-	cookie, err := auth.CreateNewCookie(id)
+	mfaRequired, err := env.Storage.TOTPEnabled(ctx, id)
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	http.SetCookie(res, &cookie)
 
-	res.WriteHeader(http.StatusOK)
+	// с 2FA пароль дает только токен ожидания, сессия выдается после OTPHandle. Счетчик
+	// неудач аккаунта сбрасывается только после второго фактора
+	if mfaRequired {
+		token, err := env.createMFAToken(ctx, id)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(res, http.StatusAccepted, model.MFAChallenge{MFAToken: token})
+		return
+	}
+
+	env.resetAttempts(req, keys[0])
+
+	info, err := env.startSession(res, req, id, loginData.Device)
+	if err != nil {
+		sessionError(res, err)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, info)
 }
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"errors"
+	"gophkeep/internal/database"
+	"gophkeep/internal/model"
+	"gophkeep/internal/mtls"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// maxServiceNameLength - наибольшая длина имени сервиса в клиентском сертификате.
+const maxServiceNameLength = 64
+
+var errCertificatesDisabled = errors.New("client certificates are disabled, start the server with -mtls")
+
+// IssueCertificateHandle подписывает CSR клиента сертификатом аккаунта или его сервиса.
+// Субъект сертификата задает сервер, из CSR берется только ключ.
+func (env Env) IssueCertificateHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if env.CA == nil {
+		http.Error(res, errCertificatesDisabled.Error(), http.StatusNotFound)
+		return
+	}
+
+	var certRequest model.CertificateRequest
+	if !readJSONBody(res, req, &certRequest, "could not unmarshal certificate request") {
+		return
+	}
+
+	identity := mtls.Identity{Kind: mtls.KindService, Name: strings.TrimSpace(certRequest.Service)}
+	if len(identity.Name) == 0 {
+		login, err := env.Storage.GetAccountLogin(ctx)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		identity = mtls.Identity{Kind: mtls.KindAccount, Name: login}
+	} else if !validServiceName(identity.Name) {
+		http.Error(res, "service name must be printable and at most 64 characters", http.StatusBadRequest)
+		return
+	}
+
+	cert, certPEM, err := env.CA.SignCSR([]byte(certRequest.CSR), identity, env.ConfigStruct.FlagClientCertTTL)
+	if errors.Is(err, mtls.ErrInvalidCSR) {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	issued := model.ClientCertificate{
+		Serial:      mtls.Serial(cert),
+		Kind:        identity.Kind,
+		Name:        identity.Name,
+		NotAfter:    cert.NotAfter,
+		Created:     cert.NotBefore,
+		Certificate: string(certPEM),
+		CA:          string(env.CA.CertPEM()),
+	}
+	if err = env.Storage.SaveClientCertificate(ctx, issued); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	env.audit(req, database.AuditCertificateIssue, issued.Serial+" "+issued.Kind+" "+issued.Name)
+	writeJSON(res, http.StatusCreated, issued)
+}
+
+// ListCertificatesHandle возвращает клиентские сертификаты аккаунта.
+func (env Env) ListCertificatesHandle(res http.ResponseWriter, req *http.Request) {
+	certs, err := env.Storage.GetClientCertificates(req.Context())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, certs)
+}
+
+// RevokeCertificateHandle отзывает клиентский сертификат аккаунта. Отозванный сертификат
+// проходит проверку TLS, но не принимается как удостоверение.
+func (env Env) RevokeCertificateHandle(res http.ResponseWriter, req *http.Request) {
+	var toRevoke model.CertificateToRevoke
+	if !readJSONBody(res, req, &toRevoke, "could not unmarshal certificate to revoke") {
+		return
+	}
+
+	err := env.Storage.RevokeClientCertificate(req.Context(), toRevoke.Serial)
+	if errors.Is(err, database.ErrCertificateNotFound) {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	env.audit(req, database.AuditCertificateRevoke, toRevoke.Serial)
+	res.WriteHeader(http.StatusOK)
+}
+
+// CAHandle отдает сертификат локального CA, которому должны доверять клиенты.
+func (env Env) CAHandle(res http.ResponseWriter, req *http.Request) {
+	if env.CA == nil {
+		http.Error(res, errCertificatesDisabled.Error(), http.StatusNotFound)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/x-pem-file")
+	res.WriteHeader(http.StatusOK)
+	res.Write(env.CA.CertPEM())
+}
+
+func validServiceName(name string) bool {
+	if len(name) > maxServiceNameLength {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
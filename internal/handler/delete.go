@@ -3,7 +3,9 @@ package handler
 import (
 	"bytes"
 	"encoding/json"
-	"gophkeep/internal/auth"
+	"errors"
+	"gophkeep/internal/database"
+	"gophkeep/internal/kinds"
 	"gophkeep/internal/logger"
 	"gophkeep/internal/model"
 	"net/http"
@@ -11,8 +13,6 @@ import (
 
 func (env Env) DeleteHandle(res http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
-	userID := ctx.Value(auth.KeyUserID).(string)
-
 	var deleteData model.DataToDelete
 	var buf bytes.Buffer
 
@@ -29,12 +29,15 @@ func (env Env) DeleteHandle(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if (deleteData.UserID != userID) {
-		res.WriteHeader(http.StatusUnauthorized)
+	if _, err = kinds.Lookup(deleteData.DataType); writeKindError(res, err) {
 		return
 	}
 
 	err = env.Storage.Delete(ctx, deleteData)
+	if errors.Is(err, database.ErrRecordNotFound) {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		logger.Log.Debug("could not delete")
 		http.Error(res, err.Error(), http.StatusInternalServerError)
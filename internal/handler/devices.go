@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"gophkeep/internal/database"
+	"gophkeep/internal/logger"
+	"gophkeep/internal/model"
+	"net/http"
+)
+
+// ListDevicesHandle возвращает устройства аккаунта с временем и адресом последней активности.
+func (env Env) ListDevicesHandle(res http.ResponseWriter, req *http.Request) {
+	devices, err := env.Storage.GetDevices(req.Context())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, devices)
+}
+
+// RevokeDeviceHandle отзывает устройство и завершает все его сессии.
+func (env Env) RevokeDeviceHandle(res http.ResponseWriter, req *http.Request) {
+	var toRevoke model.DeviceToRevoke
+	var buf bytes.Buffer
+
+	// читаем тело запроса
+	_, err := buf.ReadFrom(req.Body)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = json.Unmarshal(buf.Bytes(), &toRevoke); err != nil {
+		logger.Log.Info("could not unmarshal device to revoke")
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = env.Storage.RevokeDevice(req.Context(), toRevoke.ID)
+	if errors.Is(err, database.ErrDeviceNotFound) {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	env.audit(req, database.AuditDeviceRevoke, toRevoke.ID)
+	res.WriteHeader(http.StatusOK)
+}
@@ -3,14 +3,13 @@ package handler
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"gophkeep/internal/auth"
-	"gophkeep/internal/encryption"
+	"gophkeep/internal/database"
 	"gophkeep/internal/logger"
 	"gophkeep/internal/model"
 	"net/http"
 	"time"
-
-	"github.com/google/uuid"
 )
 
 func (env Env) EditHandle(res http.ResponseWriter, req *http.Request) {
@@ -27,33 +26,36 @@ func (env Env) EditHandle(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// создаем и шифруем этот ключ ключом шифрования
-	encryptedSK, realSK, err := encryption.GenerateSK(uuid.NewString())
-	if err != nil {
-		logger.Log.Info("could not create key")
-		http.Error(res, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
 	if err = json.Unmarshal(buf.Bytes(), &editData); err != nil {
 		logger.Log.Info("could not unmarshal initial data")
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	encryptedData, err := encryption.EncryptSimpleData(realSK, editData.Data)
+	kind, err := resolveKind(editData.DataType, false)
+	if writeKindError(res, err) {
+		return
+	}
+
+	vault, err := env.checkVaultData(ctx, editData.Data)
 	if err != nil {
+		if errors.Is(err, errNotVaultCiphertext) {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if editData.UserID != userID {
-		res.WriteHeader(http.StatusUnauthorized)
+	if writeKindError(res, kind.Validate(editData.Data, vault)) {
 		return
 	}
 
-	err = env.Storage.Edit(ctx, editData, encryptedData, encryptedSK)
-
+	err = env.Storage.Edit(ctx, editData, editData.Data)
+	if errors.Is(err, database.ErrRecordNotFound) {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
@@ -61,7 +63,7 @@ func (env Env) EditHandle(res http.ResponseWriter, req *http.Request) {
 
 	metadata := model.Metadata{
 		StaticID:    editData.StaticID,
-		UserID:      editData.UserID,
+		UserID:      userID,
 		Changed:     time.Now(),
 		Name:        editData.Name,
 		Description: editData.Description,
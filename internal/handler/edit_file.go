@@ -1,17 +1,15 @@
 package handler
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/json"
+	"errors"
 	"gophkeep/internal/auth"
-	"gophkeep/internal/encryption"
+	"gophkeep/internal/database"
 	"gophkeep/internal/logger"
 	"gophkeep/internal/model"
-	"io"
 	"net/http"
 	"time"
-
-	"github.com/google/uuid"
 )
 
 func (env Env) EditFileHandle(res http.ResponseWriter, req *http.Request) {
@@ -19,63 +17,36 @@ func (env Env) EditFileHandle(res http.ResponseWriter, req *http.Request) {
 	userID := ctx.Value(auth.KeyUserID).(string)
 
 	var editData model.EditData
-	req.ParseMultipartForm(2097152)
 
-	metadataJson := req.FormValue("metadata")
-	file, header, err := req.FormFile("file")
+	file, err := readFileForm(req, &editData)
 	if err != nil {
 		logger.Log.Info("could not take file")
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	buf := bytes.NewBuffer(nil)
-
-	if _, err := io.Copy(buf, file); err != nil {
-		logger.Log.Info("could not read file")
-		http.Error(res, err.Error(), http.StatusBadRequest)
+	if _, err = resolveKind(editData.DataType, true); writeKindError(res, err) {
 		return
 	}
 
-	fileData := model.FileData{
-		Name: header.Filename,
-		Size: header.Size,
-		Data: buf.String(),
-	}
-
-	fileJSON, err := json.Marshal(fileData)
-	if err != nil {
-		logger.Log.Debug("could not marshal response")
-		http.Error(res, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	// создаем и шифруем этот ключ ключом шифрования
-	encryptedSK, realSK, err := encryption.GenerateSK(uuid.NewString())
-	if err != nil {
-		logger.Log.Info("could not create key")
+	content := bufio.NewReader(file)
+	if err = env.checkVaultStream(ctx, content); err != nil {
+		if errors.Is(err, errNotVaultCiphertext) {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if err = json.Unmarshal([]byte(metadataJson), &editData); err != nil {
-		logger.Log.Info("could not unmarshal initial data")
-		http.Error(res, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	encryptedData, err := encryption.EncryptSimpleData(realSK, string(fileJSON))
-	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+	err = env.Storage.EditFile(ctx, editData, file.FileName(), content)
+	if writeKindError(res, err) {
 		return
 	}
-
-	if editData.UserID != userID {
-		res.WriteHeader(http.StatusUnauthorized)
+	if errors.Is(err, database.ErrRecordNotFound) {
+		http.Error(res, err.Error(), http.StatusNotFound)
 		return
 	}
-
-	err = env.Storage.Edit(ctx, editData, encryptedData, encryptedSK)
-
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
@@ -83,7 +54,7 @@ func (env Env) EditFileHandle(res http.ResponseWriter, req *http.Request) {
 
 	metadata := model.Metadata{
 		StaticID:    editData.StaticID,
-		UserID:      editData.UserID,
+		UserID:      userID,
 		Changed:     time.Now(),
 		Name:        editData.Name,
 		Description: editData.Description,
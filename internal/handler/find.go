@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"gophkeep/internal/database"
+	"gophkeep/internal/logger"
+	"gophkeep/internal/model"
+	"net/http"
+)
+
+// FindHandle ищет запись пользователя по точному имени.
+func (env Env) FindHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	var lookup model.NameLookup
+	var buf bytes.Buffer
+
+	// читаем тело запроса
+	_, err := buf.ReadFrom(req.Body)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = json.Unmarshal(buf.Bytes(), &lookup); err != nil {
+		logger.Log.Info("could not unmarshal name lookup")
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := env.Storage.GetMetadataByName(ctx, lookup.Name)
+	if errors.Is(err, database.ErrRecordNotFound) {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(metadata)
+	if err != nil {
+		logger.Log.Debug("could not marshal response")
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write([]byte(resp))
+}
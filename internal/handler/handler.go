@@ -2,10 +2,16 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"gophkeep/internal/config"
 	"gophkeep/internal/database"
-	"gophkeep/internal/encryption"
+	"gophkeep/internal/kinds"
 	"gophkeep/internal/model"
+	"gophkeep/internal/mtls"
+	"gophkeep/internal/oidc"
+	"gophkeep/internal/ratelimit"
+	"net/http"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,29 +20,104 @@ import (
 type Env struct {
 	ConfigStruct *config.Config
 	Storage      database.Storage
-	UserID       string
+	Limiter      *ratelimit.Limiter
+	// CA - локальный CA клиентских сертификатов, nil без -mtls
+	CA *mtls.CA
+	// OIDC - провайдер единого входа, nil без -oidc-issuer
+	OIDC   *oidc.Provider
+	UserID string
 }
 
-func StorageData(ctx context.Context, initialData model.InitialData, userID string, env Env, realSK string, encryptedSK string, data string) (model.Metadata, error) {
-	metadata := model.Metadata{
+func StorageData(ctx context.Context, initialData model.InitialData, userID string, env Env, data string) (model.Metadata, error) {
+	metadata := newMetadata(initialData, userID)
+
+	err := env.Storage.AddData(ctx, metadata, data)
+	if err != nil {
+		return metadata, err
+	}
+	return metadata, err
+}
+
+func newMetadata(initialData model.InitialData, userID string) model.Metadata {
+	staticID := initialData.StaticID
+	if len(staticID) == 0 {
+		staticID = uuid.New().String()
+	}
+
+	return model.Metadata{
 		Name:        initialData.Name,
 		Description: initialData.Description,
 		DataType:    initialData.DataType,
 		Created:     time.Now(),
 		Changed:     time.Now(),
-		StaticID:    uuid.New().String(),
+		StaticID:    staticID,
 		DynamicID:   uuid.New().String(),
 		UserID:      userID,
 	}
+}
+
+var errStaticID = errors.New("static_id must be a UUID")
+
+// checkStaticID проверяет id записи, выбранный клиентом.
+func checkStaticID(initialData model.InitialData) error {
+	if len(initialData.StaticID) == 0 {
+		return nil
+	}
+	if _, err := uuid.Parse(initialData.StaticID); err != nil {
+		return errStaticID
+	}
+	return nil
+}
 
-	encryptedData, err := encryption.EncryptSimpleData(realSK, data)
+var (
+	errStreamKind   = errors.New("content of this kind is uploaded as a file")
+	errInRecordKind = errors.New("content of this kind is not a file")
+)
+
+// resolveKind ищет вид данных из запроса в реестре. stream - запрос работает
+// с потоком файла, а не с полем data.
+func resolveKind(dataType string, stream bool) (kinds.Kind, error) {
+	kind, err := kinds.Lookup(dataType)
 	if err != nil {
-		return metadata, err
+		return kind, err
+	}
+
+	if kind.Stream && !stream {
+		return kind, &kinds.InvalidPayloadError{Kind: kind.Name, Err: errStreamKind}
 	}
+	if !kind.Stream && stream {
+		return kind, &kinds.InvalidPayloadError{Kind: kind.Name, Err: errInRecordKind}
+	}
+
+	return kind, nil
+}
+
+// writeKindError отвечает на ошибки реестра видов данных. Возвращает false, если
+// ошибка не из реестра и ответ еще не записан.
+func writeKindError(res http.ResponseWriter, err error) bool {
+	var unknownKind *kinds.UnknownKindError
+	var invalidPayload *kinds.InvalidPayloadError
 
-	err = env.Storage.AddData(ctx, metadata, encryptedData, encryptedSK, initialData.DataType)
+	switch {
+	case errors.Is(err, kinds.ErrTooLarge):
+		http.Error(res, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.As(err, &unknownKind), errors.As(err, &invalidPayload):
+		http.Error(res, err.Error(), http.StatusBadRequest)
+	default:
+		return false
+	}
+
+	return true
+}
+
+func writeJSON(res http.ResponseWriter, status int, value any) {
+	resp, err := json.Marshal(value)
 	if err != nil {
-		return metadata, err
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	return metadata, err
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	res.Write(resp)
 }
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"errors"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/config"
+	"gophkeep/internal/database"
+	"gophkeep/internal/model"
+	"net/http"
+)
+
+var (
+	errRegistrationClosed = errors.New("registration is closed")
+	errInviteRequired     = errors.New("registration requires an invite code")
+	errNoInviteQuota      = errors.New("this account cannot create invites")
+)
+
+// RegistrationModeHandle сообщает клиенту режим регистрации, чтобы он заранее спросил код
+// приглашения.
+func (env Env) RegistrationModeHandle(res http.ResponseWriter, req *http.Request) {
+	writeJSON(res, http.StatusOK, model.RegistrationMode{Mode: env.ConfigStruct.FlagRegistration})
+}
+
+// CreateInviteHandle выдает одноразовый код приглашения. Код показывается только в этом
+// ответе. Администраторы не ограничены, остальные аккаунты - квотой -invite-quota.
+func (env Env) CreateInviteHandle(res http.ResponseWriter, req *http.Request) {
+	if env.ConfigStruct.FlagRegistration == config.RegistrationClosed {
+		http.Error(res, errRegistrationClosed.Error(), http.StatusForbidden)
+		return
+	}
+
+	quota := env.ConfigStruct.FlagInviteQuota
+	if auth.RoleFromContext(req.Context()) == auth.RoleAdmin {
+		quota = -1
+	}
+	if quota == 0 {
+		http.Error(res, errNoInviteQuota.Error(), http.StatusForbidden)
+		return
+	}
+
+	code, err := auth.NewInviteCode()
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	invite, err := env.Storage.CreateInvite(req.Context(), auth.HashInviteCode(code), env.ConfigStruct.FlagInviteTTL, quota)
+	if errors.Is(err, database.ErrInviteQuota) {
+		http.Error(res, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	env.audit(req, database.AuditInviteCreate, invite.ID)
+	invite.Code = code
+	writeJSON(res, http.StatusCreated, invite)
+}
+
+// ListInvitesHandle возвращает приглашения аккаунта и кто по ним зарегистрировался.
+func (env Env) ListInvitesHandle(res http.ResponseWriter, req *http.Request) {
+	invites, err := env.Storage.GetInvites(req.Context())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, invites)
+}
+
+// RevokeInviteHandle отзывает неиспользованное приглашение.
+func (env Env) RevokeInviteHandle(res http.ResponseWriter, req *http.Request) {
+	var invite model.Invite
+	if !readJSONBody(res, req, &invite, "could not unmarshal invite") {
+		return
+	}
+
+	err := env.Storage.RevokeInvite(req.Context(), invite.ID)
+	if errors.Is(err, database.ErrInviteNotFound) {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	env.audit(req, database.AuditInviteRevoke, invite.ID)
+	res.WriteHeader(http.StatusOK)
+}
@@ -3,19 +3,18 @@ package handler
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"gophkeep/internal/auth"
-	"gophkeep/internal/encryption"
+	"gophkeep/internal/database"
 	"gophkeep/internal/logger"
 	"gophkeep/internal/model"
 	"net/http"
-
-	"github.com/google/uuid"
 )
 
 func (env Env) KeepHandle(res http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	userID := ctx.Value(auth.KeyUserID).(string)
-	
+
 	var initialData model.InitialData
 	var buf bytes.Buffer
 
@@ -32,17 +31,41 @@ func (env Env) KeepHandle(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// создаем и шифруем этот ключ ключом шифрования
-	encryptedSK, realSK, err := encryption.GenerateSK(uuid.NewString())
+	if err = checkStaticID(initialData); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	kind, err := resolveKind(initialData.DataType, false)
+	if writeKindError(res, err) {
+		return
+	}
+
+	vault, err := env.checkVaultData(ctx, initialData.Data)
 	if err != nil {
-		logger.Log.Info("could not create key")
+		if errors.Is(err, errNotVaultCiphertext) {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if writeKindError(res, kind.Validate(initialData.Data, vault)) {
+		return
+	}
+
 	var metadata model.Metadata
 
-	metadata, err = StorageData(ctx, initialData, userID, env, realSK, encryptedSK, initialData.Data)
+	metadata, err = StorageData(ctx, initialData, userID, env, initialData.Data)
+	if errors.Is(err, database.ErrDuplicateName) {
+		http.Error(res, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, database.ErrForbidden) {
+		http.Error(res, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
@@ -1,78 +1,71 @@
 package handler
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/json"
+	"errors"
 	"gophkeep/internal/auth"
-	"gophkeep/internal/encryption"
+	"gophkeep/internal/database"
 	"gophkeep/internal/logger"
 	"gophkeep/internal/model"
 	"io"
+	"mime/multipart"
 	"net/http"
-
-	"github.com/google/uuid"
 )
 
+const maxFileMetadataSize = 64 * 1024
+
 func (env Env) KeepFileHandle(res http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	userID := ctx.Value(auth.KeyUserID).(string)
 
 	var initialData model.InitialData
 
-	req.ParseMultipartForm(2097152)
-
-	metadataJson := req.FormValue("metadata")
-	file, header, err := req.FormFile("file")
+	file, err := readFileForm(req, &initialData)
 	if err != nil {
 		logger.Log.Info("could not take file")
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	buf := bytes.NewBuffer(nil)
-
-	if _, err := io.Copy(buf, file); err != nil {
-		logger.Log.Info("could not read file")
+	if err = checkStaticID(initialData); err != nil {
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	fileData := model.FileData{
-		Name: header.Filename,
-		Size: header.Size,
-		Data: buf.String(),
+	if _, err = resolveKind(initialData.DataType, true); writeKindError(res, err) {
+		return
 	}
 
-	fileJSON, err := json.Marshal(fileData)
-	if err != nil {
-		logger.Log.Debug("could not marshal response")
+	content := bufio.NewReader(file)
+	if err = env.checkVaultStream(ctx, content); err != nil {
+		if errors.Is(err, errNotVaultCiphertext) {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// создаем и шифруем этот ключ ключом шифрования
-	encryptedSK, realSK, err := encryption.GenerateSK(uuid.NewString())
-	if err != nil {
-		logger.Log.Info("could not create key")
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+	metadata := newMetadata(initialData, userID)
+	err = env.Storage.AddFile(ctx, metadata, file.FileName(), content)
+	if errors.Is(err, database.ErrDuplicateName) {
+		http.Error(res, err.Error(), http.StatusConflict)
 		return
 	}
-
-	if err = json.Unmarshal([]byte(metadataJson), &initialData); err != nil {
-		logger.Log.Info("could not unmarshal initial data")
-		http.Error(res, err.Error(), http.StatusBadRequest)
+	if errors.Is(err, database.ErrForbidden) {
+		http.Error(res, err.Error(), http.StatusForbidden)
+		return
+	}
+	if writeKindError(res, err) {
 		return
 	}
-
-	metadata, err := StorageData(ctx, initialData, userID, env, realSK, encryptedSK, string(fileJSON))
 	if err != nil {
 		logger.Log.Info("could not keep file data")
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	defer file.Close()
-
 	resp, err := json.Marshal(metadata)
 	if err != nil {
 		logger.Log.Debug("could not marshal response")
@@ -84,3 +77,36 @@ func (env Env) KeepFileHandle(res http.ResponseWriter, req *http.Request) {
 	res.WriteHeader(http.StatusOK)
 	res.Write([]byte(resp))
 }
+
+// readFileForm читает поле metadata и возвращает часть с файлом, не загружая файл
+// в память. Поле metadata должно идти в форме перед файлом.
+func readFileForm(req *http.Request, metadata any) (*multipart.Part, error) {
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	part, err := reader.NextPart()
+	if err != nil {
+		return nil, err
+	}
+
+	if part.FormName() != "metadata" {
+		return nil, errors.New("metadata must precede file")
+	}
+
+	if err = json.NewDecoder(io.LimitReader(part, maxFileMetadataSize)).Decode(metadata); err != nil {
+		return nil, err
+	}
+
+	file, err := reader.NextPart()
+	if err != nil {
+		return nil, err
+	}
+
+	if file.FormName() != "file" {
+		return nil, errors.New("file is missing")
+	}
+
+	return file, nil
+}
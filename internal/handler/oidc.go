@@ -0,0 +1,333 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/config"
+	"gophkeep/internal/database"
+	"gophkeep/internal/logger"
+	"gophkeep/internal/model"
+	"gophkeep/internal/oidc"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// oidcLoginExp - сколько ждать возвращения пользователя от провайдера
+	oidcLoginExp = time.Minute * 10
+	// completionCodeLength - длина одноразового кода, которым клиент завершает вход
+	completionCodeLength = 32
+)
+
+var errOIDCDisabled = errors.New("single sign-on is disabled, start the server with -oidc-issuer")
+
+// OIDCStartHandle начинает вход через провайдер OpenID Connect и возвращает адрес, который
+// клиент открывает в браузере. После входа у провайдера браузер вернется на redirect_uri
+// клиента с одноразовым кодом.
+func (env Env) OIDCStartHandle(res http.ResponseWriter, req *http.Request) {
+	env.startOIDC(res, req, "")
+}
+
+// OIDCLinkStartHandle начинает привязку учетной записи провайдера к текущему аккаунту.
+func (env Env) OIDCLinkStartHandle(res http.ResponseWriter, req *http.Request) {
+	userID, _ := auth.UserIDFromContext(req.Context())
+	env.startOIDC(res, req, userID)
+}
+
+func (env Env) startOIDC(res http.ResponseWriter, req *http.Request, linkAccount string) {
+	if env.OIDC == nil {
+		http.Error(res, errOIDCDisabled.Error(), http.StatusNotFound)
+		return
+	}
+
+	var start model.OIDCStart
+	if !readJSONBody(res, req, &start, "could not unmarshal OIDC start") {
+		return
+	}
+
+	if !loopbackRedirect(start.RedirectURI) {
+		http.Error(res, "redirect_uri must be an http address on the loopback interface", http.StatusBadRequest)
+		return
+	}
+	if len(start.ClientChallenge) == 0 {
+		http.Error(res, "client_challenge is required", http.StatusBadRequest)
+		return
+	}
+
+	login := database.OIDCLogin{
+		ClientChallenge: start.ClientChallenge,
+		ClientRedirect:  start.RedirectURI,
+		LinkAccount:     linkAccount,
+	}
+
+	var err error
+	for _, value := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		if *value, err = oidc.NewVerifier(); err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err = env.Storage.CreateOIDCLogin(req.Context(), login, oidcLoginExp); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	authURL := env.OIDC.AuthCodeURL(login.State, login.Nonce, oidc.Challenge(login.CodeVerifier))
+	writeJSON(res, http.StatusOK, model.OIDCAuthorization{AuthURL: authURL})
+}
+
+// OIDCCallbackHandle принимает браузер, вернувшийся от провайдера: обменивает код
+// авторизации на ID-токен и перенаправляет браузер на адрес клиента с одноразовым кодом.
+// Сессию выдает OIDCFinishHandle, а не этот запрос, поэтому код, перехваченный в
+// браузере, без секрета клиента бесполезен.
+func (env Env) OIDCCallbackHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if env.OIDC == nil {
+		http.Error(res, errOIDCDisabled.Error(), http.StatusNotFound)
+		return
+	}
+
+	query := req.URL.Query()
+	login, err := env.Storage.GetOIDCLogin(ctx, query.Get("state"))
+	if errors.Is(err, database.ErrOIDCLoginNotFound) {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if providerError := query.Get("error"); len(providerError) != 0 {
+		redirectToClient(res, req, login.ClientRedirect, url.Values{"error": {providerError}})
+		return
+	}
+
+	claims, err := env.OIDC.Exchange(ctx, query.Get("code"), login.CodeVerifier, login.Nonce)
+	if err != nil {
+		logger.Sugar.Infow("OIDC code exchange failed", "error", err)
+		redirectToClient(res, req, login.ClientRedirect, url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	code := make([]byte, completionCodeLength)
+	if _, err = rand.Read(code); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	completionCode := base64.RawURLEncoding.EncodeToString(code)
+
+	identity := model.OIDCIdentity{Issuer: env.OIDC.Issuer(), Subject: claims.Subject}
+	if claims.EmailVerified {
+		identity.Email = claims.Email
+	}
+
+	err = env.Storage.CompleteOIDCLogin(ctx, login.State, identity, oidcUsername(claims), completionHash(completionCode))
+	if errors.Is(err, database.ErrOIDCLoginNotFound) {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	redirectToClient(res, req, login.ClientRedirect, url.Values{"code": {completionCode}})
+}
+
+// OIDCFinishHandle меняет одноразовый код и секрет клиента на сессию. Если у провайдера
+// вошел новый пользователь, для него создается аккаунт без пароля, но только при открытой
+// регистрации. При привязке ответ пустой, а сессия не меняется.
+func (env Env) OIDCFinishHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if env.OIDC == nil {
+		http.Error(res, errOIDCDisabled.Error(), http.StatusNotFound)
+		return
+	}
+
+	var finish model.OIDCFinish
+	if !readJSONBody(res, req, &finish, "could not unmarshal OIDC finish") {
+		return
+	}
+
+	client := env.Limiter.Client(env.clientIP(req))
+	if !env.allowAttempt(res, req, client) {
+		return
+	}
+
+	login, err := env.Storage.TakeOIDCLogin(ctx, completionHash(finish.Code))
+	if errors.Is(err, database.ErrOIDCLoginNotFound) {
+		env.failAttempt(req, client)
+		http.Error(res, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	challenge := oidc.Challenge(finish.ClientVerifier)
+	if subtle.ConstantTimeCompare([]byte(challenge), []byte(login.ClientChallenge)) != 1 {
+		env.failAttempt(req, client)
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if len(login.LinkAccount) != 0 {
+		linkCtx := auth.WithUserID(ctx, login.LinkAccount)
+		err = env.Storage.LinkOIDCIdentity(linkCtx, login.Identity)
+		if errors.Is(err, database.ErrIdentityLinked) {
+			http.Error(res, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		env.audit(req.WithContext(linkCtx), database.AuditOIDCLink, login.Identity.Issuer+" "+login.Identity.Subject)
+		res.WriteHeader(http.StatusOK)
+		return
+	}
+
+	userID, err := env.Storage.FindOIDCAccount(ctx, login.Identity)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(userID) == 0 && env.ConfigStruct.FlagRegistration != config.RegistrationOpen {
+		http.Error(res, "no account is linked to this identity and registration is not open", http.StatusForbidden)
+		return
+	}
+
+	if len(userID) == 0 {
+		userID, err = env.Storage.ProvisionOIDCAccount(ctx, login.Identity, login.Username)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		env.audit(req.WithContext(auth.WithUserID(ctx, userID)), database.AuditOIDCProvision,
+			login.Identity.Issuer+" "+login.Identity.Subject)
+	}
+
+	mfaRequired, err := env.Storage.TOTPEnabled(ctx, userID)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if mfaRequired {
+		token, err := env.createMFAToken(ctx, userID)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(res, http.StatusAccepted, model.MFAChallenge{MFAToken: token})
+		return
+	}
+
+	var device model.DeviceInfo
+	if finish.Device != nil {
+		device = *finish.Device
+	}
+
+	info, err := env.startSession(res, req, userID, device)
+	if err != nil {
+		sessionError(res, err)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, info)
+}
+
+// ListOIDCIdentitiesHandle возвращает учетные записи провайдеров, привязанные к аккаунту.
+func (env Env) ListOIDCIdentitiesHandle(res http.ResponseWriter, req *http.Request) {
+	identities, err := env.Storage.GetOIDCIdentities(req.Context())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, identities)
+}
+
+// UnlinkOIDCIdentityHandle отвязывает учетную запись провайдера от аккаунта.
+func (env Env) UnlinkOIDCIdentityHandle(res http.ResponseWriter, req *http.Request) {
+	var identity model.OIDCIdentity
+	if !readJSONBody(res, req, &identity, "could not unmarshal OIDC identity") {
+		return
+	}
+
+	err := env.Storage.UnlinkOIDCIdentity(req.Context(), identity)
+	if errors.Is(err, database.ErrIdentityNotFound) {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	env.audit(req, database.AuditOIDCUnlink, identity.Issuer+" "+identity.Subject)
+	res.WriteHeader(http.StatusOK)
+}
+
+// loopbackRedirect разрешает возвращать браузер только на http-адрес этой же машины,
+// где его ждет клиент (RFC 8252).
+func loopbackRedirect(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	if err != nil || u.Scheme != "http" || len(u.Fragment) != 0 || u.User != nil {
+		return false
+	}
+
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func redirectToClient(res http.ResponseWriter, req *http.Request, clientRedirect string, values url.Values) {
+	u, err := url.Parse(clientRedirect)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := u.Query()
+	for key, value := range values {
+		query[key] = value
+	}
+	u.RawQuery = query.Encode()
+
+	http.Redirect(res, req, u.String(), http.StatusFound)
+}
+
+// oidcUsername выбирает логин нового аккаунта из утверждений ID-токена.
+func oidcUsername(claims oidc.Claims) string {
+	if username := strings.TrimSpace(claims.PreferredUsername); len(username) != 0 {
+		return username
+	}
+	if claims.EmailVerified && len(claims.Email) != 0 {
+		return claims.Email
+	}
+	return "oidc-" + claims.Subject
+}
+
+// completionHash - в базе хранится только хеш одноразового кода.
+func completionHash(code string) []byte {
+	sum := sha256.Sum256([]byte(code))
+	return sum[:]
+}
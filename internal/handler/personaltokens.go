@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/database"
+	"gophkeep/internal/kinds"
+	"gophkeep/internal/logger"
+	"gophkeep/internal/model"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxPersonalTokenLifetime - наибольший срок действия персонального токена.
+const maxPersonalTokenLifetime = time.Hour * 24 * 365
+
+// CreatePersonalTokenHandle создает персональный токен доступа. Токен возвращается
+// только в этом ответе, сервер сохраняет лишь хеш его секрета.
+func (env Env) CreatePersonalTokenHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	var token model.PersonalToken
+	var buf bytes.Buffer
+
+	// читаем тело запроса
+	_, err := buf.ReadFrom(req.Body)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = json.Unmarshal(buf.Bytes(), &token); err != nil {
+		logger.Log.Info("could not unmarshal personal token")
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token.Name = strings.TrimSpace(token.Name)
+	if len(token.Name) == 0 {
+		http.Error(res, "token name is required", http.StatusBadRequest)
+		return
+	}
+
+	lifetime := time.Until(token.ExpiresAt)
+	if lifetime <= 0 || lifetime > maxPersonalTokenLifetime {
+		http.Error(res, fmt.Sprintf("token must expire within %s", maxPersonalTokenLifetime), http.StatusBadRequest)
+		return
+	}
+
+	for i, dataType := range token.Scopes.DataTypes {
+		kind, err := kinds.Lookup(dataType)
+		if writeKindError(res, err) {
+			return
+		}
+		token.Scopes.DataTypes[i] = kind.Name
+	}
+
+	var tokenHash []byte
+	token.ID, token.Token, tokenHash, err = auth.NewPersonalToken()
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = env.Storage.CreatePersonalToken(ctx, token, tokenHash)
+	if errors.Is(err, database.ErrRecordNotFound) {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	env.audit(req, database.AuditTokenCreate, token.ID+" "+token.Name)
+
+	token.Created = time.Now()
+	writeJSON(res, http.StatusCreated, token)
+}
+
+// ListPersonalTokensHandle возвращает персональные токены аккаунта без самих токенов.
+func (env Env) ListPersonalTokensHandle(res http.ResponseWriter, req *http.Request) {
+	tokens, err := env.Storage.GetPersonalTokens(req.Context())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, tokens)
+}
+
+// RevokePersonalTokenHandle отзывает персональный токен аккаунта.
+func (env Env) RevokePersonalTokenHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	var toRevoke model.TokenToRevoke
+	var buf bytes.Buffer
+
+	// читаем тело запроса
+	_, err := buf.ReadFrom(req.Body)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = json.Unmarshal(buf.Bytes(), &toRevoke); err != nil {
+		logger.Log.Info("could not unmarshal token to revoke")
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = env.Storage.RevokePersonalToken(ctx, toRevoke.ID)
+	if errors.Is(err, database.ErrTokenNotFound) {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	env.audit(req, database.AuditTokenRevoke, toRevoke.ID)
+	res.WriteHeader(http.StatusOK)
+}
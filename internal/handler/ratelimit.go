@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"gophkeep/internal/logger"
+	"gophkeep/internal/ratelimit"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// allowAttempt отвечает 429 с Retry-After, если попытки по одному из ключей временно запрещены.
+func (env Env) allowAttempt(res http.ResponseWriter, req *http.Request, keys ...ratelimit.Key) bool {
+	wait, err := env.Limiter.RetryAfter(req.Context(), keys...)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	if wait > 0 {
+		res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(res, "too many failed attempts, try again later", http.StatusTooManyRequests)
+		return false
+	}
+
+	return true
+}
+
+// failAttempt записывает неудачную попытку. Ошибка хранилища счетчиков не меняет ответ.
+func (env Env) failAttempt(req *http.Request, keys ...ratelimit.Key) {
+	if err := env.Limiter.Fail(req.Context(), keys...); err != nil {
+		logger.Sugar.Errorw("Failed to record failed attempt", "error", err)
+	}
+}
+
+// resetAttempts сбрасывает счетчик после успешной попытки.
+func (env Env) resetAttempts(req *http.Request, key ratelimit.Key) {
+	if err := env.Limiter.Reset(req.Context(), key); err != nil {
+		logger.Sugar.Errorw("Failed to reset failed attempts", "error", err)
+	}
+}
+
+// clientIP возвращает адрес клиента. Заголовку прокси (-real-ip-header) сервер
+// верит, только если он задан: иначе клиент мог бы подставить любой адрес.
+func (env Env) clientIP(req *http.Request) string {
+	if env.ConfigStruct != nil && len(env.ConfigStruct.FlagRealIPHeader) != 0 {
+		// в X-Forwarded-For последний адрес добавлен ближайшим прокси
+		values := strings.Split(req.Header.Get(env.ConfigStruct.FlagRealIPHeader), ",")
+		if ip := strings.TrimSpace(values[len(values)-1]); len(ip) != 0 {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -3,7 +3,8 @@ package handler
 import (
 	"bytes"
 	"encoding/json"
-	"gophkeep/internal/auth"
+	"errors"
+	"gophkeep/internal/database"
 	"gophkeep/internal/logger"
 	"gophkeep/internal/model"
 	"net/http"
@@ -11,8 +12,6 @@ import (
 
 func (env Env) ReadHandle(res http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
-	userID := ctx.Value(auth.KeyUserID).(string)
-
 	var readData model.DataToRead
 	var buf bytes.Buffer
 
@@ -29,13 +28,15 @@ func (env Env) ReadHandle(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if readData.UserID != userID {
-		res.WriteHeader(http.StatusUnauthorized)
+	if _, err = resolveKind(readData.DataType, false); writeKindError(res, err) {
 		return
 	}
 
 	data, err := env.Storage.Read(ctx, readData)
-
+	if errors.Is(err, database.ErrRecordNotFound) {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
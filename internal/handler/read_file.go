@@ -3,16 +3,18 @@ package handler
 import (
 	"bytes"
 	"encoding/json"
-	"gophkeep/internal/auth"
+	"errors"
+	"gophkeep/internal/database"
 	"gophkeep/internal/logger"
 	"gophkeep/internal/model"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
 )
 
 func (env Env) ReadFileHandle(res http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
-	userID := ctx.Value(auth.KeyUserID).(string)
-
 	var readData model.DataToRead
 	var buf bytes.Buffer
 
@@ -29,19 +31,28 @@ func (env Env) ReadFileHandle(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if readData.UserID != userID {
-		res.WriteHeader(http.StatusUnauthorized)
+	if _, err = resolveKind(readData.DataType, true); writeKindError(res, err) {
 		return
 	}
 
-	data, err := env.Storage.Read(ctx, readData)
-
+	fileData, content, err := env.Storage.ReadFile(ctx, readData)
+	if errors.Is(err, database.ErrRecordNotFound) {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
+	defer content.Close()
 
+	// при ошибке расшифровки посреди файла ответ обрывается короче Content-Length
 	res.Header().Set("Content-Type", "application/octet-stream")
+	res.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileData.Name}))
+	res.Header().Set("Content-Length", strconv.FormatInt(fileData.Size, 10))
 	res.WriteHeader(http.StatusOK)
-	res.Write([]byte(data))
+
+	if _, err = io.Copy(res, content); err != nil {
+		logger.Sugar.Errorw("Could not stream file", "static_id", readData.StaticID, "error", err)
+	}
 }
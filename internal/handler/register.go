@@ -3,15 +3,21 @@ package handler
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"gophkeep/internal/auth"
+	"gophkeep/internal/config"
+	"gophkeep/internal/database"
 	"gophkeep/internal/logger"
 	"gophkeep/internal/model"
+	"gophkeep/internal/srp"
 	"log"
 	"net/http"
 
 	"go.uber.org/zap"
 )
 
+// RegisterHandle создает аккаунт. В режиме invite нужен код приглашения, в режиме closed
+// регистрация отклоняется.
 func (env Env) RegisterHandle(res http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 
@@ -31,8 +37,46 @@ func (env Env) RegisterHandle(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if registrationData.SRP != nil && !srp.ValidVerifier(registrationData.SRP.Salt, registrationData.SRP.Verifier) {
+		http.Error(res, "invalid SRP verifier", http.StatusBadRequest)
+		return
+	}
+
+	mode := env.ConfigStruct.FlagRegistration
+	if mode == config.RegistrationClosed {
+		http.Error(res, errRegistrationClosed.Error(), http.StatusForbidden)
+		return
+	}
+	if mode == config.RegistrationInvite && len(registrationData.Invite) == 0 {
+		http.Error(res, errInviteRequired.Error(), http.StatusForbidden)
+		return
+	}
+
+	// регистрацией можно перебирать занятые логины и коды приглашений, поэтому конфликты
+	// и неверные коды считаются неудачами адреса
+	client := env.Limiter.Client(env.clientIP(req))
+	if !env.allowAttempt(res, req, client) {
+		return
+	}
+
 	storage := env.Storage
-	loginAlreadyInUse, id, err := storage.AddNewAccount(ctx, registrationData)
+	var loginAlreadyInUse bool
+	var id, invitedBy string
+	if len(registrationData.Invite) != 0 {
+		loginAlreadyInUse, id, invitedBy, err = storage.AddInvitedAccount(ctx, registrationData,
+			auth.HashInviteCode(registrationData.Invite))
+	} else {
+		loginAlreadyInUse, id, err = storage.AddNewAccount(ctx, registrationData)
+	}
+	if errors.Is(err, database.ErrInviteInvalid) {
+		env.failAttempt(req, client)
+		http.Error(res, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, auth.ErrPasswordTooLong) {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		logger.Log.Info("could not complete user registration", zap.String("Attempted login", string(registrationData.Login)))
 		http.Error(res, err.Error(), http.StatusInternalServerError)
@@ -41,17 +85,23 @@ func (env Env) RegisterHandle(res http.ResponseWriter, req *http.Request) {
 
 	if loginAlreadyInUse {
 		logger.Log.Info("login already in use", zap.String("Attempted login", string(registrationData.Login)))
+		env.failAttempt(req, client)
 		res.WriteHeader(http.StatusConflict)
 		return
 	}
 
-	cookie, err := auth.CreateNewCookie(id)
+	if len(invitedBy) != 0 {
+		env.audit(req.WithContext(auth.WithUserID(ctx, id)), database.AuditRegistered, "invited by "+invitedBy)
+		env.audit(req.WithContext(auth.WithUserID(ctx, invitedBy)), database.AuditInviteUsed,
+			"by "+registrationData.Login+" "+id)
+	}
+
+	info, err := env.startSession(res, req, id, registrationData.Device)
 	if err != nil {
-		log.Printf("could not create cookie: " + err.Error())
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		log.Printf("could not start session: " + err.Error())
+		sessionError(res, err)
 		return
 	}
-	http.SetCookie(res, &cookie)
 
-	res.WriteHeader(http.StatusOK)
+	writeJSON(res, http.StatusOK, info)
 }
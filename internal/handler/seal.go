@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"gophkeep/internal/encryption"
+	"gophkeep/internal/logger"
+	"gophkeep/internal/model"
+	"net/http"
+	"strings"
+)
+
+// UnsealHandle принимает одну долю мастер-ключа. Когда долей набирается достаточно,
+// сервер распечатывается и переводит данные старых форматов на текущие.
+func (env Env) UnsealHandle(res http.ResponseWriter, req *http.Request) {
+	var unsealRequest model.UnsealRequest
+	if err := json.NewDecoder(req.Body).Decode(&unsealRequest); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	share, err := base64.StdEncoding.DecodeString(unsealRequest.Share)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	wasSealed := encryption.Sealed()
+	status, err := encryption.Unseal(share)
+	switch {
+	case errors.Is(err, encryption.ErrNotSealable):
+		http.Error(res, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, encryption.ErrUnsealFailed):
+		logger.SecurityEvent("unseal_failed", "remote_addr", req.RemoteAddr)
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if wasSealed && !status.Sealed {
+		logger.Sugar.Infow("Server unsealed")
+		go env.migrateEncryptedData()
+	}
+
+	writeSealStatus(res, status)
+}
+
+// SealHandle запечатывает сервер: мастер-ключ удаляется из памяти.
+func (env Env) SealHandle(res http.ResponseWriter, req *http.Request) {
+	if !env.adminTokenIsValid(req) {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	err := encryption.Seal()
+	if errors.Is(err, encryption.ErrNotSealable) {
+		http.Error(res, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Sugar.Infow("Server sealed")
+	writeSealStatus(res, encryption.CurrentSealStatus())
+}
+
+func (env Env) SealStatusHandle(res http.ResponseWriter, _ *http.Request) {
+	writeSealStatus(res, encryption.CurrentSealStatus())
+}
+
+// HealthHandle сообщает о доступности базы и о том, запечатан ли сервер.
+// Запечатанный сервер отвечает 503, чтобы балансировщик не слал ему запросы.
+func (env Env) HealthHandle(res http.ResponseWriter, _ *http.Request) {
+	health := model.HealthResponse{Database: "ok", Sealed: encryption.Sealed()}
+	status := http.StatusOK
+	if err := env.Storage.PingDB(); err != nil {
+		health.Database = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	if health.Sealed {
+		status = http.StatusServiceUnavailable
+	}
+
+	resp, err := json.Marshal(health)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	res.Write(resp)
+}
+
+// SealedMiddleware не пускает запросы к данным, пока сервер запечатан.
+func SealedMiddleware(h http.Handler) http.Handler {
+	sealedFn := func(res http.ResponseWriter, req *http.Request) {
+		if encryption.Sealed() {
+			http.Error(res, encryption.ErrSealed.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(res, req)
+	}
+	return http.HandlerFunc(sealedFn)
+}
+
+func (env Env) adminTokenIsValid(req *http.Request) bool {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || len(env.ConfigStruct.FlagAdminToken) == 0 {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(env.ConfigStruct.FlagAdminToken)) == 1
+}
+
+func (env Env) migrateEncryptedData() {
+	if err := env.Storage.MigrateEncryptedData(context.Background()); err != nil {
+		logger.Sugar.Errorw("Could not migrate encrypted data", "error", err)
+	}
+}
+
+func writeSealStatus(res http.ResponseWriter, status encryption.SealStatus) {
+	resp, err := json.Marshal(status)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write(resp)
+}
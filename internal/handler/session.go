@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/database"
+	"gophkeep/internal/logger"
+	"gophkeep/internal/model"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// startSession регистрирует устройство, создает на нем сессию аккаунта и отдает клиенту
+// токены доступа и обновления. Ответ с id устройства отправляет вызывающий. Вход с нового
+// устройства попадает в журнал аккаунта. Отключенный аккаунт получает ErrAccountDisabled.
+func (env Env) startSession(res http.ResponseWriter, req *http.Request, userID string, device model.DeviceInfo) (model.SessionInfo, error) {
+	ctx := auth.WithUserID(req.Context(), userID)
+	ip := env.clientIP(req)
+
+	role, err := env.Storage.AccountRole(ctx, userID)
+	if err != nil {
+		return model.SessionInfo{}, err
+	}
+
+	// клиенты без сведений об устройстве (curl, скрипты) различаются по User-Agent
+	if len(device.Name) == 0 {
+		device.Name = req.UserAgent()
+	}
+
+	deviceID, isNew, err := env.Storage.RegisterDevice(ctx, userID, device, ip)
+	if err != nil {
+		return model.SessionInfo{}, err
+	}
+
+	if isNew {
+		env.audit(req.WithContext(ctx), database.AuditNewDevice,
+			fmt.Sprintf("%s (%s, client %s) from %s", device.Name, device.OS, device.ClientVersion, ip))
+	}
+
+	sessionID := uuid.New().String()
+	refreshToken, refreshHash, err := auth.NewRefreshToken(sessionID)
+	if err != nil {
+		return model.SessionInfo{}, err
+	}
+
+	if err = env.Storage.CreateSession(ctx, sessionID, userID, deviceID, refreshHash, auth.RefreshTokenExp()); err != nil {
+		return model.SessionInfo{}, err
+	}
+
+	if err = setSessionCookies(res, userID, sessionID, role, refreshToken); err != nil {
+		return model.SessionInfo{}, err
+	}
+
+	return model.SessionInfo{DeviceID: deviceID}, nil
+}
+
+// sessionError отвечает на ошибку startSession: отключенный аккаунт получает 403.
+func sessionError(res http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrAccountDisabled) {
+		http.Error(res, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(res, err.Error(), http.StatusInternalServerError)
+}
+
+func setSessionCookies(res http.ResponseWriter, userID string, sessionID string, role string, refreshToken string) error {
+	cookie, err := auth.CreateNewCookie(userID, sessionID, role)
+	if err != nil {
+		return err
+	}
+	refreshCookie := auth.CreateRefreshCookie(refreshToken)
+
+	http.SetCookie(res, &cookie)
+	http.SetCookie(res, &refreshCookie)
+	return nil
+}
+
+func clearSessionCookies(res http.ResponseWriter) {
+	for _, cookie := range auth.ExpiredCookies() {
+		http.SetCookie(res, &cookie)
+	}
+}
+
+// RefreshHandle обменивает токен обновления на новую пару токенов. Повторное
+// использование токена отзывает всю сессию.
+func (env Env) RefreshHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	cookie, err := req.Cookie(auth.RefreshCookieName)
+	if err != nil {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	sessionID, refreshHash, err := auth.ParseRefreshToken(cookie.Value)
+	if err != nil {
+		clearSessionCookies(res)
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	newToken, newHash, err := auth.NewRefreshToken(sessionID)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, err := env.Storage.RotateRefreshToken(ctx, sessionID, refreshHash, newHash, auth.RefreshTokenExp())
+	if err != nil {
+		if errors.Is(err, database.ErrRefreshTokenReused) {
+			logger.Sugar.Warnw("Refresh token reused, session revoked", "session", sessionID)
+		}
+		if errors.Is(err, database.ErrRefreshTokenReused) || errors.Is(err, database.ErrSessionNotFound) {
+			clearSessionCookies(res)
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// роль берется из аккаунта, а не из старого токена, отключенный аккаунт сессию не продлевает
+	role, err := env.Storage.AccountRole(ctx, userID)
+	if errors.Is(err, database.ErrAccountDisabled) || errors.Is(err, database.ErrAccountNotFound) {
+		clearSessionCookies(res)
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err = setSessionCookies(res, userID, sessionID, role, newToken); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err = env.Storage.TouchDevice(ctx, sessionID, env.clientIP(req)); err != nil {
+		logger.Sugar.Errorw("Failed to update device activity", "session", sessionID, "error", err)
+	}
+
+	res.WriteHeader(http.StatusOK)
+}
+
+// LogoutHandle отзывает сессию, которой выдан токен запроса.
+func (env Env) LogoutHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	sessionID, ok := auth.SessionIDFromContext(ctx)
+	if !ok {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err := env.Storage.RevokeSession(ctx, sessionID); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	clearSessionCookies(res)
+	res.WriteHeader(http.StatusOK)
+}
+
+// LogoutAllHandle отзывает все сессии аккаунта, в том числе текущую.
+func (env Env) LogoutAllHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	count, err := env.Storage.RevokeAllSessions(ctx)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Sugar.Infow("All sessions revoked", "count", count)
+	clearSessionCookies(res)
+	res.WriteHeader(http.StatusOK)
+}
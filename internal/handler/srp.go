@@ -0,0 +1,254 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/database"
+	"gophkeep/internal/logger"
+	"gophkeep/internal/model"
+	"gophkeep/internal/ratelimit"
+	"gophkeep/internal/srp"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// SRPStartHandle - первый шаг входа по SRP: по логину и A отвечает солью и B. Для
+// несуществующего логина и аккаунта без верификатора ответ выглядит так же, но рукопожатие
+// не завершится: о том, что аккаунт входит по паролю, сервер сообщает только после
+// неудачной попытки (writeSRPFailure).
+func (env Env) SRPStartHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	var start model.SRPStart
+	if !readJSONBody(res, req, &start, "could not unmarshal SRP start") {
+		return
+	}
+
+	if !srp.ValidPublic(start.ClientPublic) {
+		http.Error(res, srp.ErrInvalidPublic.Error(), http.StatusBadRequest)
+		return
+	}
+
+	keys := []ratelimit.Key{env.Limiter.Account(start.Login), env.Limiter.Client(env.clientIP(req))}
+	if !env.allowAttempt(res, req, keys...) {
+		return
+	}
+
+	userID, verifier, err := env.Storage.GetSRPVerifier(ctx, start.Login)
+	if errors.Is(err, database.ErrSRPNotEnabled) {
+		userID, err = "", nil
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// B для несуществующего логина или аккаунта без верификатора строится от случайного
+	// элемента группы вместо верификатора и неотличим от настоящего
+	if len(userID) == 0 {
+		verifier.Salt, err = auth.FakeSRPSalt(start.Login)
+		if err == nil {
+			_, verifier.Verifier, err = srp.ClientStart()
+		}
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	private, public, err := srp.ServerStart(verifier.Verifier)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	handshake := database.SRPHandshake{
+		ID:            uuid.New().String(),
+		UserID:        userID,
+		Login:         start.Login,
+		ClientPublic:  start.ClientPublic,
+		ServerPrivate: private,
+		ServerPublic:  public,
+	}
+	if err = env.Storage.CreateSRPHandshake(ctx, handshake, auth.SRPHandshakeExp); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, model.SRPChallenge{HandshakeID: handshake.ID, Salt: verifier.Salt, ServerPublic: public})
+}
+
+// SRPLoginHandle - второй шаг входа по SRP: проверяет доказательство клиента и выдает
+// сессию (или токен ожидания второго фактора) вместе с доказательством сервера.
+func (env Env) SRPLoginHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	var proof model.SRPProof
+	if !readJSONBody(res, req, &proof, "could not unmarshal SRP proof") {
+		return
+	}
+
+	handshake, serverProof, ok := env.verifySRP(res, req, proof, http.StatusUnauthorized)
+	if !ok {
+		return
+	}
+	userID := handshake.UserID
+
+	mfaRequired, err := env.Storage.TOTPEnabled(ctx, userID)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if mfaRequired {
+		token, err := env.createMFAToken(ctx, userID)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(res, http.StatusAccepted, model.MFAChallenge{MFAToken: token, ServerProof: serverProof})
+		return
+	}
+
+	// с 2FA счетчик неудач аккаунта сбрасывается только после второго фактора
+	env.resetAttempts(req, env.Limiter.Account(handshake.Login))
+
+	var device model.DeviceInfo
+	if proof.Device != nil {
+		device = *proof.Device
+	}
+
+	info, err := env.startSession(res, req, userID, device)
+	if err != nil {
+		sessionError(res, err)
+		return
+	}
+
+	info.ServerProof = serverProof
+	writeJSON(res, http.StatusOK, info)
+}
+
+// SRPReauthHandle повторно проверяет пароль текущего аккаунта тем же рукопожатием, что и
+// вход, и выдает токен для смены пароля, логина или удаления аккаунта. Неудача дает 403,
+// чтобы клиент не путал ее с истекшей сессией.
+func (env Env) SRPReauthHandle(res http.ResponseWriter, req *http.Request) {
+	var proof model.SRPProof
+	if !readJSONBody(res, req, &proof, "could not unmarshal SRP proof") {
+		return
+	}
+
+	handshake, serverProof, ok := env.verifySRP(res, req, proof, http.StatusForbidden)
+	if !ok {
+		return
+	}
+
+	ctx := req.Context()
+	if current, _ := auth.UserIDFromContext(ctx); current != handshake.UserID {
+		http.Error(res, "handshake belongs to another account", http.StatusForbidden)
+		return
+	}
+	env.resetAttempts(req, env.Limiter.Account(handshake.Login))
+
+	// токен годится только для сессии, которая прошла проверку, и только один раз
+	sessionID, ok := auth.SessionIDFromContext(ctx)
+	if !ok {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	tokenID := uuid.New().String()
+	if err := env.Storage.CreateReauthToken(ctx, tokenID, handshake.UserID, sessionID, auth.ReauthTokenExp); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	token, err := auth.CreateReauthToken(handshake.UserID, sessionID, tokenID)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, model.ReauthInfo{ReauthToken: token, ServerProof: serverProof})
+}
+
+// verifySRP завершает рукопожатие и возвращает его вместе с доказательством сервера M2.
+// Неверное доказательство считается неудачной попыткой входа и дает failStatus. Счетчик
+// неудач аккаунта сбрасывает вызывающий, когда вход действительно завершен.
+func (env Env) verifySRP(res http.ResponseWriter, req *http.Request, proof model.SRPProof, failStatus int) (database.SRPHandshake, []byte, bool) {
+	handshake, err := env.Storage.TakeSRPHandshake(req.Context(), proof.HandshakeID)
+	if errors.Is(err, database.ErrHandshakeNotFound) {
+		http.Error(res, err.Error(), failStatus)
+		return handshake, nil, false
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return handshake, nil, false
+	}
+
+	keys := []ratelimit.Key{env.Limiter.Account(handshake.Login), env.Limiter.Client(env.clientIP(req))}
+	if !env.allowAttempt(res, req, keys...) {
+		return handshake, nil, false
+	}
+
+	var serverProof []byte
+	ok := false
+	if len(handshake.UserID) != 0 {
+		serverProof, ok = srp.ServerVerify(handshake.Login, handshake.Verifier.Salt, handshake.Verifier.Verifier,
+			handshake.ClientPublic, handshake.ServerPrivate, handshake.ServerPublic, proof.Proof)
+	}
+
+	if !ok {
+		env.failAttempt(req, keys...)
+		env.writeSRPFailure(res, req, handshake, failStatus)
+		return handshake, nil, false
+	}
+
+	return handshake, serverProof, true
+}
+
+// writeSRPFailure отвечает failStatus на неудачное рукопожатие. Если логин принадлежит
+// аккаунту без верификатора, ответ содержит password_login, и клиент может предложить
+// пользователю войти паролем. При повторной проверке признак выдается только для
+// аккаунта текущей сессии. Попытка уже засчитана как неудачная, поэтому перебирать
+// логины так не быстрее, чем пароли.
+func (env Env) writeSRPFailure(res http.ResponseWriter, req *http.Request, handshake database.SRPHandshake, failStatus int) {
+	ctx := req.Context()
+
+	if len(handshake.UserID) == 0 {
+		legacyID, err := env.Storage.PasswordLoginAccount(ctx, handshake.Login)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		current, authenticated := auth.UserIDFromContext(ctx)
+		if len(legacyID) != 0 && (!authenticated || current == legacyID) {
+			writeJSON(res, failStatus, model.SRPFailure{PasswordLogin: true})
+			return
+		}
+	}
+
+	res.WriteHeader(failStatus)
+}
+
+func readJSONBody(res http.ResponseWriter, req *http.Request, v any, failMessage string) bool {
+	var buf bytes.Buffer
+
+	// читаем тело запроса
+	_, err := buf.ReadFrom(req.Body)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	if err = json.Unmarshal(buf.Bytes(), v); err != nil {
+		logger.Log.Info(failMessage)
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
@@ -2,16 +2,14 @@ package handler
 
 import (
 	"encoding/json"
-	"gophkeep/internal/auth"
 	"gophkeep/internal/logger"
 	"net/http"
 )
 
 func (env Env) SyncHandle(res http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
-	userID := ctx.Value(auth.KeyUserID).(string)
 
-	metadata, err := env.Storage.GetMetadataByUserID(ctx, userID)
+	metadata, err := env.Storage.GetMetadata(ctx)
 	if err != nil {
 		logger.Log.Debug("could not get urls by user id")
 		http.Error(res, err.Error(), http.StatusInternalServerError)
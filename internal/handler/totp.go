@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"gophkeep/internal/auth"
+	"gophkeep/internal/database"
+	"gophkeep/internal/logger"
+	"gophkeep/internal/model"
+	"gophkeep/internal/ratelimit"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// createMFAToken сохраняет ожидание второго фактора и выдает токен на него.
+func (env Env) createMFAToken(ctx context.Context, userID string) (string, error) {
+	challengeID := uuid.New().String()
+	if err := env.Storage.CreateMFAChallenge(ctx, challengeID, userID, auth.MFATokenExp); err != nil {
+		return "", err
+	}
+
+	return auth.CreateMFAToken(userID, challengeID)
+}
+
+// OTPHandle завершает вход с 2FA: обменивает токен ожидания и код на сессию.
+// Токен одноразовый: после успешного входа он больше не принимается.
+func (env Env) OTPHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	otp, ok := readOTPData(res, req)
+	if !ok {
+		return
+	}
+
+	userID, challengeID, ok := auth.ParseMFAToken(otp.MFAToken)
+	if !ok {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	login, err := env.Storage.GetAccountLogin(auth.WithUserID(ctx, userID))
+	if errors.Is(err, database.ErrUnauthenticated) {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// неверные коды считаются вместе с неверными паролями аккаунта: иначе, зная пароль,
+	// код можно было бы перебирать с разных адресов
+	keys := []ratelimit.Key{env.Limiter.Account(login), env.Limiter.Client(env.clientIP(req))}
+	if !env.allowAttempt(res, req, keys...) {
+		return
+	}
+
+	// по одному токену можно проверить лишь несколько кодов, дальше нужно снова ввести пароль
+	ok, err = env.Storage.TakeMFAAttempt(ctx, challengeID, userID, auth.MFAMaxAttempts)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		env.failAttempt(req, keys...)
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	valid, err := env.Storage.CheckSecondFactor(ctx, userID, otp)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !valid {
+		env.failAttempt(req, keys...)
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err = env.Storage.DeleteMFAChallenge(ctx, challengeID); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	env.resetAttempts(req, keys[0])
+
+	var device model.DeviceInfo
+	if otp.Device != nil {
+		device = *otp.Device
+	}
+
+	info, err := env.startSession(res, req, userID, device)
+	if err != nil {
+		sessionError(res, err)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, info)
+}
+
+// EnrollTOTPHandle создает новый секрет TOTP. 2FA включится после подтверждения кодом.
+func (env Env) EnrollTOTPHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	login, err := env.Storage.GetAccountLogin(ctx)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	alreadyEnabled, err := env.Storage.EnrollTOTP(ctx, secret)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if alreadyEnabled {
+		res.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, model.TOTPEnrollment{Secret: secret, URI: auth.TOTPURI(secret, login)})
+}
+
+// ConfirmTOTPHandle включает 2FA и возвращает коды восстановления.
+func (env Env) ConfirmTOTPHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	otp, ok := readOTPData(res, req)
+	if !ok {
+		return
+	}
+
+	keys, ok := env.otpAttemptKeys(res, req)
+	if !ok {
+		return
+	}
+
+	codes, err := env.Storage.ConfirmTOTP(ctx, otp.Code)
+	if errors.Is(err, database.ErrInvalidOTP) {
+		env.failAttempt(req, keys...)
+	}
+	if err != nil {
+		writeTOTPError(res, err)
+		return
+	}
+	env.resetAttempts(req, keys[0])
+
+	writeJSON(res, http.StatusOK, model.RecoveryCodes{Codes: codes})
+}
+
+// DisableTOTPHandle отключает 2FA. Кроме действующего кода TOTP или кода восстановления
+// нужно подтверждение паролем, как при изменении аккаунта: одной украденной сессии мало.
+func (env Env) DisableTOTPHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	otp, ok := readOTPData(res, req)
+	if !ok {
+		return
+	}
+
+	if _, ok = env.reauthenticate(res, req, model.AccountChange{Password: otp.Password, ReauthToken: otp.ReauthToken}); !ok {
+		return
+	}
+
+	keys, ok := env.otpAttemptKeys(res, req)
+	if !ok {
+		return
+	}
+
+	err := env.Storage.DisableTOTP(ctx, otp)
+	if errors.Is(err, database.ErrInvalidOTP) {
+		env.failAttempt(req, keys...)
+	}
+	if err != nil {
+		writeTOTPError(res, err)
+		return
+	}
+	env.resetAttempts(req, keys[0])
+
+	res.WriteHeader(http.StatusOK)
+}
+
+// otpAttemptKeys проверяет ограничение попыток ввода кода для аккаунта из контекста.
+// Коды считаются вместе с паролями аккаунта, как при входе с 2FA.
+func (env Env) otpAttemptKeys(res http.ResponseWriter, req *http.Request) ([]ratelimit.Key, bool) {
+	login, err := env.Storage.GetAccountLogin(req.Context())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	keys := []ratelimit.Key{env.Limiter.Account(login), env.Limiter.Client(env.clientIP(req))}
+	if !env.allowAttempt(res, req, keys...) {
+		return nil, false
+	}
+
+	return keys, true
+}
+
+func readOTPData(res http.ResponseWriter, req *http.Request) (model.OTPData, bool) {
+	var otp model.OTPData
+	var buf bytes.Buffer
+
+	// читаем тело запроса
+	_, err := buf.ReadFrom(req.Body)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return otp, false
+	}
+
+	if err = json.Unmarshal(buf.Bytes(), &otp); err != nil {
+		logger.Log.Info("could not unmarshal one-time code")
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return otp, false
+	}
+
+	return otp, true
+}
+
+func writeTOTPError(res http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrInvalidOTP):
+		http.Error(res, err.Error(), http.StatusForbidden)
+	case errors.Is(err, database.ErrTOTPNotEnrolled):
+		http.Error(res, err.Error(), http.StatusNotFound)
+	case errors.Is(err, database.ErrTOTPEnabled):
+		http.Error(res, err.Error(), http.StatusConflict)
+	default:
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+	}
+}
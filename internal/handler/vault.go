@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"gophkeep/internal/logger"
+	"gophkeep/internal/model"
+	"net/http"
+	"strings"
+)
+
+var errNotVaultCiphertext = errors.New("zero-knowledge mode is enabled, data must be encrypted on the client")
+
+// VaultHandle возвращает параметры ключа хранилища, чтобы новое устройство могло его разблокировать.
+func (env Env) VaultHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	params, enabled, err := env.Storage.GetVault(ctx)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !enabled {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	resp, err := json.Marshal(params)
+	if err != nil {
+		logger.Log.Debug("could not marshal response")
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write([]byte(resp))
+}
+
+// EnableVaultHandle включает шифрование на клиенте для аккаунта.
+func (env Env) EnableVaultHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	var params model.VaultParams
+	var buf bytes.Buffer
+
+	// читаем тело запроса
+	_, err := buf.ReadFrom(req.Body)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = json.Unmarshal(buf.Bytes(), &params); err != nil {
+		logger.Log.Info("could not unmarshal vault params")
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(params.KDF) == 0 || len(params.Salt) == 0 || !strings.HasPrefix(params.Check, model.VaultCiphertextPrefix) {
+		http.Error(res, "incomplete vault params", http.StatusBadRequest)
+		return
+	}
+
+	alreadyEnabled, err := env.Storage.AddVault(ctx, params)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if alreadyEnabled {
+		res.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+}
+
+// checkVaultData не дает сохранить открытые данные аккаунту с шифрованием на клиенте
+// и сообщает, включено ли оно.
+func (env Env) checkVaultData(ctx context.Context, data string) (bool, error) {
+	_, enabled, err := env.Storage.GetVault(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if enabled && !model.IsVaultCiphertext(data) {
+		return true, errNotVaultCiphertext
+	}
+
+	return enabled, nil
+}
+
+// checkVaultStream проверяет начало потока файла, не вычитывая его.
+func (env Env) checkVaultStream(ctx context.Context, content *bufio.Reader) error {
+	_, enabled, err := env.Storage.GetVault(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !enabled {
+		return nil
+	}
+
+	prefix, err := content.Peek(len(model.VaultStreamPrefix))
+	if err != nil || !model.IsVaultStream(string(prefix)) {
+		return errNotVaultCiphertext
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package kinds
+
+import (
+	"errors"
+	"gophkeep/internal/model"
+)
+
+func init() {
+	Register(Kind{
+		Name:    Passwords,
+		Table:   "passwords",
+		MaxSize: 64 * 1024,
+		Schema:  func() any { return &model.LoginAndPasswordData{} },
+		Check: func(payload any) error {
+			data := payload.(*model.LoginAndPasswordData)
+			if len(data.Login) == 0 && len(data.Password) == 0 {
+				return errors.New("login or password is required")
+			}
+			return nil
+		},
+	})
+
+	Register(Kind{
+		Name:    Cards,
+		Table:   "cards",
+		MaxSize: 4 * 1024,
+		Schema:  func() any { return &model.CardData{} },
+		Check: func(payload any) error {
+			data := payload.(*model.CardData)
+			if len(data.CardNumber) == 0 {
+				return errors.New("card number is required")
+			}
+			return nil
+		},
+	})
+
+	Register(Kind{
+		Name:    Files,
+		Table:   "files",
+		Stream:  true,
+		MaxSize: 1 << 30,
+	})
+}
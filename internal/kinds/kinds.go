@@ -0,0 +1,160 @@
+// Package kinds - реестр видов данных, которые хранит сервер. Вид определяет таблицу
+// с данными, схему полезной нагрузки и ограничения на неё. Значение data_type
+// от клиента всегда проходит через реестр и никогда не попадает в SQL напрямую.
+package kinds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	Passwords = "passwords"
+	Cards     = "cards"
+	Files     = "files"
+)
+
+// ErrTooLarge - данные превышают ограничение размера своего вида.
+var ErrTooLarge = errors.New("payload exceeds the size limit of its kind")
+
+// UnknownKindError - клиент указал вид данных, которого нет в реестре.
+type UnknownKindError struct {
+	Kind string
+}
+
+func (e *UnknownKindError) Error() string {
+	return fmt.Sprintf("unknown data kind %q", e.Kind)
+}
+
+// InvalidPayloadError - данные не соответствуют схеме или ограничениям вида.
+type InvalidPayloadError struct {
+	Kind string
+	Err  error
+}
+
+func (e *InvalidPayloadError) Error() string {
+	return fmt.Sprintf("invalid %s payload: %v", e.Kind, e.Err)
+}
+
+func (e *InvalidPayloadError) Unwrap() error {
+	return e.Err
+}
+
+// Kind - вид данных.
+type Kind struct {
+	// Name - значение data_type в запросах и колонке infos.type.
+	Name string
+	// Table - таблица с данными этого вида.
+	Table string
+	// Stream - содержимое передается потоком через /api/keepfile, а не полем data.
+	Stream bool
+	// MaxSize - наибольший размер данных в байтах.
+	MaxSize int64
+	// Schema возвращает указатель на структуру полезной нагрузки для разбора JSON.
+	// Для потоковых видов не задается.
+	Schema func() any
+	// Check проверяет разобранную полезную нагрузку.
+	Check func(payload any) error
+}
+
+// Validate проверяет размер и схему данных. Если у аккаунта включено шифрование на
+// клиенте (vault), данные проверяются только по размеру: сервер не видит их содержимое.
+func (kind Kind) Validate(data string, vault bool) error {
+	if int64(len(data)) > kind.MaxSize {
+		return &InvalidPayloadError{Kind: kind.Name, Err: ErrTooLarge}
+	}
+
+	if kind.Schema == nil || vault {
+		return nil
+	}
+
+	payload := kind.Schema()
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(payload); err != nil {
+		return &InvalidPayloadError{Kind: kind.Name, Err: err}
+	}
+
+	if kind.Check != nil {
+		if err := kind.Check(payload); err != nil {
+			return &InvalidPayloadError{Kind: kind.Name, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// LimitReader возвращает поток, который завершается ошибкой ErrTooLarge, если
+// содержимое длиннее MaxSize.
+func (kind Kind) LimitReader(r io.Reader) io.Reader {
+	return &limitedReader{r: r, left: kind.MaxSize, kind: kind.Name}
+}
+
+type limitedReader struct {
+	r    io.Reader
+	left int64
+	kind string
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	// читаем на байт больше лимита, чтобы отличить файл ровно по лимиту от более длинного
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return 0, &InvalidPayloadError{Kind: l.kind, Err: ErrTooLarge}
+	}
+
+	return n, err
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]Kind)
+)
+
+// Register добавляет вид в реестр. Вызывается из init пакетов с видами данных.
+func Register(kind Kind) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := registry[kind.Name]; ok {
+		panic("kinds: kind " + kind.Name + " is registered twice")
+	}
+	registry[kind.Name] = kind
+}
+
+// Lookup ищет вид по значению data_type от клиента.
+func Lookup(name string) (Kind, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	kind, ok := registry[name]
+	if !ok {
+		return Kind{}, &UnknownKindError{Kind: name}
+	}
+
+	return kind, nil
+}
+
+// All возвращает все виды в порядке имен.
+func All() []Kind {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	all := make([]Kind, 0, len(registry))
+	for _, kind := range registry {
+		all = append(all, kind)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+
+	return all
+}
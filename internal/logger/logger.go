@@ -76,3 +76,9 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.ResponseWriter.WriteHeader(statusCode)
 	r.responseData.status = statusCode // захватываем код статуса
 }
+
+// SecurityEvent записывает событие безопасности, например попытку подмены шифртекста.
+// Такие записи помечаются полем security_event, чтобы их было легко отобрать.
+func SecurityEvent(event string, keysAndValues ...interface{}) {
+	Sugar.Warnw("Security event", append([]interface{}{"security_event", event}, keysAndValues...)...)
+}
@@ -1,12 +1,19 @@
 package model
 
 import (
+	"strings"
 	"time"
 )
 
 type SimpleAccountData struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	Login    string     `json:"login"`
+	Password string     `json:"password,omitempty"`
+	Device   DeviceInfo `json:"device"`
+	// SRP - верификатор пароля. При регистрации заменяет пароль, при входе по паролю
+	// переводит аккаунт на вход по SRP
+	SRP *SRPVerifier `json:"srp,omitempty"`
+	// Invite - код приглашения, нужен при регистрации по приглашениям
+	Invite string `json:"invite,omitempty"`
 }
 
 type InitialData struct {
@@ -14,6 +21,9 @@ type InitialData struct {
 	Description string `json:"description"`
 	DataType    string `json:"data_type"`
 	Data        string `json:"data"`
+	// StaticID - id новой записи, выбранный клиентом, чтобы привязать к нему шифртекст
+	// хранилища. Если не задан, id выдает сервер
+	StaticID string `json:"static_id,omitempty"`
 }
 
 type Metadata struct {
@@ -39,10 +49,12 @@ type CardData struct {
 	Code           string `json:"code"`
 }
 
+// FileData - имя и размер файла. Data заполнено только у файлов, сохраненных
+// до потокового шифрования, содержимое новых файлов хранится отдельно.
 type FileData struct {
 	Name string `json:"name"`
 	Size int64  `json:"size"`
-	Data string `json:"data"`
+	Data string `json:"data,omitempty"`
 }
 
 type SimpleMetadata struct {
@@ -53,16 +65,19 @@ type SimpleMetadata struct {
 
 type DataToDelete struct {
 	StaticID string `json:"static_id"`
-	UserID   string `json:"user_id"`
 	DataType string `json:"data_type"`
 }
 
 type DataToRead struct {
 	StaticID string `json:"static_id"`
-	UserID   string `json:"user_id"`
 	DataType string `json:"data_type"`
 }
 
+// NameLookup - запрос записи по точному имени.
+type NameLookup struct {
+	Name string `json:"name"`
+}
+
 type ReadResponse struct {
 	StaticID string `json:"static_id"`
 	Data     string `json:"data"`
@@ -74,10 +89,297 @@ type EditData struct {
 	DataType    string `json:"data_type"`
 	Data        string `json:"data"`
 	StaticID    string `json:"static_id"`
-	UserID      string `json:"user_id"`
 }
 
 type TestFileData struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 }
+
+// VaultCiphertextPrefix помечает данные, зашифрованные на клиенте ключом хранилища.
+// Этим форматом шифруется проверочное значение хранилища, а записи шифровались до
+// появления VaultRecordPrefix.
+const VaultCiphertextPrefix = "zk1:"
+
+// VaultStreamPrefix помечает файлы, зашифрованные на клиенте потоком ключом хранилища
+// до появления VaultRecordStreamPrefix.
+const VaultStreamPrefix = "zks1:"
+
+// VaultRecordPrefix помечает данные записи, зашифрованные на клиенте с привязкой к id
+// и виду записи.
+const VaultRecordPrefix = "zk2:"
+
+// VaultRecordStreamPrefix помечает файлы, зашифрованные на клиенте потоком с привязкой
+// к id и виду записи.
+const VaultRecordStreamPrefix = "zks2:"
+
+// IsVaultCiphertext сообщает, что данные зашифрованы на клиенте ключом хранилища.
+func IsVaultCiphertext(data string) bool {
+	return strings.HasPrefix(data, VaultRecordPrefix) || strings.HasPrefix(data, VaultCiphertextPrefix)
+}
+
+// IsVaultStream сообщает, что содержимое файла начинается с префикса потока хранилища.
+func IsVaultStream(prefix string) bool {
+	return strings.HasPrefix(prefix, VaultRecordStreamPrefix) || strings.HasPrefix(prefix, VaultStreamPrefix)
+}
+
+// VaultParams - параметры вывода ключа хранилища из мастер-пароля. Сервер хранит их
+// только для синхронизации между устройствами и не может расшифровать данные.
+type VaultParams struct {
+	KDF     string `json:"kdf"`
+	Salt    string `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	Check   string `json:"check"`
+}
+
+// UnsealRequest - доля Шамира мастер-ключа в base64.
+type UnsealRequest struct {
+	Share string `json:"share"`
+}
+
+// HealthResponse - состояние сервера для мониторинга.
+type HealthResponse struct {
+	Database string `json:"database"`
+	Sealed   bool   `json:"sealed"`
+}
+
+// MFAChallenge - ответ на вход в аккаунт с 2FA: пароль верный, нужен второй фактор.
+type MFAChallenge struct {
+	MFAToken    string `json:"mfa_token"`
+	ServerProof []byte `json:"server_proof,omitempty"`
+}
+
+// OTPData - второй фактор: код TOTP или одноразовый код восстановления.
+type OTPData struct {
+	MFAToken     string `json:"mfa_token,omitempty"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+	// Device - устройство, на котором завершается вход
+	Device *DeviceInfo `json:"device,omitempty"`
+	// Password или ReauthToken подтверждают отключение 2FA, как в AccountChange
+	Password    string `json:"password,omitempty"`
+	ReauthToken string `json:"reauth_token,omitempty"`
+}
+
+// TOTPEnrollment - секрет TOTP и otpauth:// URI для приложения-аутентификатора.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// RecoveryCodes - одноразовые коды восстановления, показываются только при включении 2FA.
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// TokenScopes ограничивает персональный токен доступа. Пустые списки означают
+// все виды данных и все записи аккаунта.
+type TokenScopes struct {
+	ReadOnly  bool     `json:"read_only"`
+	DataTypes []string `json:"data_types,omitempty"`
+	Records   []string `json:"records,omitempty"`
+}
+
+// PersonalToken - персональный токен доступа для скриптов и CI. Сам токен сервер
+// не хранит и показывает только при создании.
+type PersonalToken struct {
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	Scopes     TokenScopes `json:"scopes"`
+	ExpiresAt  time.Time   `json:"expires_at"`
+	Created    time.Time   `json:"created"`
+	LastUsedAt *time.Time  `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time  `json:"revoked_at,omitempty"`
+	Token      string      `json:"token,omitempty"`
+}
+
+// TokenToRevoke - персональный токен, который нужно отозвать.
+type TokenToRevoke struct {
+	ID string `json:"id"`
+}
+
+// AuditEvent - запись журнала действий аккаунта.
+type AuditEvent struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	TokenID string    `json:"token_id,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+// AccountChange - запрос на изменение аккаунта. Смену пароля или логина и удаление аккаунта
+// пользователь подтверждает токеном повторной проверки SRP, а аккаунт без верификатора -
+// текущим паролем.
+type AccountChange struct {
+	Password    string       `json:"password,omitempty"`
+	ReauthToken string       `json:"reauth_token,omitempty"`
+	NewPassword string       `json:"new_password,omitempty"`
+	NewSRP      *SRPVerifier `json:"new_srp,omitempty"`
+	NewLogin    string       `json:"new_login,omitempty"`
+}
+
+// DeviceInfo - устройство, с которого клиент входит в аккаунт. ID выдает сервер при
+// первом входе, клиент присылает его при следующих.
+type DeviceInfo struct {
+	ID            string `json:"id,omitempty"`
+	Name          string `json:"name"`
+	OS            string `json:"os"`
+	ClientVersion string `json:"client_version"`
+}
+
+// Device - зарегистрированное устройство аккаунта. Current отмечает устройство запроса.
+type Device struct {
+	DeviceInfo
+	Created    time.Time `json:"created"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	LastIP     string    `json:"last_ip"`
+	Current    bool      `json:"current"`
+}
+
+// SessionInfo - ответ на вход: устройство, к которому привязана новая сессия.
+type SessionInfo struct {
+	DeviceID    string `json:"device_id"`
+	ServerProof []byte `json:"server_proof,omitempty"`
+}
+
+// DeviceToRevoke - устройство, которое нужно отозвать.
+type DeviceToRevoke struct {
+	ID string `json:"id"`
+}
+
+// SRPVerifier - соль и верификатор пароля SRP-6a. Пароль по ним проверяется только перебором.
+type SRPVerifier struct {
+	Salt     []byte `json:"salt"`
+	Verifier []byte `json:"verifier"`
+}
+
+// SRPStart - первый шаг входа по SRP: логин и публичное значение клиента A.
+type SRPStart struct {
+	Login        string `json:"login"`
+	ClientPublic []byte `json:"client_public"`
+}
+
+// SRPChallenge - ответ на первый шаг: соль аккаунта и публичное значение сервера B.
+type SRPChallenge struct {
+	HandshakeID  string `json:"handshake_id"`
+	Salt         []byte `json:"salt"`
+	ServerPublic []byte `json:"server_public"`
+}
+
+// SRPFailure - тело ответа на неудачное рукопожатие. PasswordLogin означает, что у аккаунта
+// с этим логином нет верификатора и войти в него можно только паролем.
+type SRPFailure struct {
+	PasswordLogin bool `json:"password_login,omitempty"`
+}
+
+// SRPProof - второй шаг: доказательство клиента M1.
+type SRPProof struct {
+	HandshakeID string `json:"handshake_id"`
+	Proof       []byte `json:"proof"`
+	// Device - устройство, на котором выполняется вход
+	Device *DeviceInfo `json:"device,omitempty"`
+}
+
+// ReauthInfo - ответ на повторную проверку пароля: токен для изменения аккаунта и
+// доказательство сервера M2.
+type ReauthInfo struct {
+	ReauthToken string `json:"reauth_token"`
+	ServerProof []byte `json:"server_proof"`
+}
+
+// CertificateRequest - запрос на клиентский сертификат. Без Service сертификат выдается
+// на аккаунт, с Service - на сервис аккаунта с доступом только к данным.
+type CertificateRequest struct {
+	CSR     string `json:"csr"`
+	Service string `json:"service,omitempty"`
+}
+
+// ClientCertificate - выданный клиентский сертификат. Certificate и CA заполнены только
+// в ответе на выпуск.
+type ClientCertificate struct {
+	Serial      string     `json:"serial"`
+	Kind        string     `json:"kind"`
+	Name        string     `json:"name"`
+	NotAfter    time.Time  `json:"not_after"`
+	Created     time.Time  `json:"created"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	Certificate string     `json:"certificate,omitempty"`
+	CA          string     `json:"ca,omitempty"`
+}
+
+// CertificateToRevoke - клиентский сертификат, который нужно отозвать.
+type CertificateToRevoke struct {
+	Serial string `json:"serial"`
+}
+
+// OIDCStart - начало входа через провайдер OpenID Connect. RedirectURI - адрес на loopback,
+// куда браузер вернется с одноразовым кодом, ClientChallenge - S256 от секрета клиента,
+// без которого этот код не обменять на сессию.
+type OIDCStart struct {
+	RedirectURI     string `json:"redirect_uri"`
+	ClientChallenge string `json:"client_challenge"`
+}
+
+// OIDCAuthorization - адрес, который клиент открывает в браузере.
+type OIDCAuthorization struct {
+	AuthURL string `json:"auth_url"`
+}
+
+// OIDCFinish - завершение входа: одноразовый код из redirect и секрет клиента.
+type OIDCFinish struct {
+	Code           string `json:"code"`
+	ClientVerifier string `json:"client_verifier"`
+	// Device - устройство, на котором выполняется вход
+	Device *DeviceInfo `json:"device,omitempty"`
+}
+
+// OIDCIdentity - учетная запись у провайдера OpenID Connect, привязанная к аккаунту.
+type OIDCIdentity struct {
+	Issuer      string     `json:"issuer"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email,omitempty"`
+	Created     time.Time  `json:"created"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+// AccountSummary - аккаунт в списке администратора. Содержит только количество и объем
+// записей, но не сами записи.
+type AccountSummary struct {
+	ID         string     `json:"id"`
+	Login      string     `json:"login"`
+	Role       string     `json:"role"`
+	Created    time.Time  `json:"created"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	// InvitedBy - id аккаунта, выдавшего приглашение
+	InvitedBy string `json:"invited_by,omitempty"`
+	// Records - количество записей по видам данных
+	Records map[string]int64 `json:"records"`
+	// StorageBytes - объем зашифрованных данных аккаунта в байтах
+	StorageBytes int64 `json:"storage_bytes"`
+	// Sessions - количество действующих сессий
+	Sessions int64 `json:"sessions"`
+}
+
+// AccountAction - аккаунт, над которым администратор выполняет действие. Role задается
+// только при смене роли.
+type AccountAction struct {
+	ID   string `json:"id"`
+	Role string `json:"role,omitempty"`
+}
+
+// Invite - одноразовое приглашение на регистрацию. Code есть только в ответе на создание,
+// на сервере хранится его хеш.
+type Invite struct {
+	ID      string     `json:"id"`
+	Code    string     `json:"code,omitempty"`
+	Created time.Time  `json:"created"`
+	Expires time.Time  `json:"expires"`
+	UsedBy  string     `json:"used_by,omitempty"`
+	UsedAt  *time.Time `json:"used_at,omitempty"`
+}
+
+// RegistrationMode - режим регистрации сервера: open, invite или closed.
+type RegistrationMode struct {
+	Mode string `json:"mode"`
+}
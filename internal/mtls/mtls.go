@@ -0,0 +1,300 @@
+// Package mtls - локальный удостоверяющий центр для входа по клиентским сертификатам.
+// CA создается при первом запуске сервера, выпускает сертификат самого сервера и
+// подписывает запросы на сертификаты (CSR) клиентов. Субъект клиентского сертификата
+// задает сервер: CN - имя, OU - вид удостоверения (аккаунт или сервис), поэтому
+// содержимое CSR, кроме ключа, не важно.
+package mtls
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Виды удостоверений в клиентском сертификате.
+const (
+	KindAccount = "account"
+	KindService = "service"
+)
+
+const (
+	caCertFile = "ca.crt"
+	caKeyFile  = "ca.key"
+
+	caLifetime     = time.Hour * 24 * 365 * 10
+	serverLifetime = time.Hour * 24 * 365
+	// сертификат действует с небольшим запасом на расхождение часов
+	clockSkew = time.Minute * 5
+
+	serialLength = 16
+	caCommonName = "gophkeep local CA"
+)
+
+var ErrInvalidCSR = errors.New("invalid certificate signing request")
+
+// Identity - кому выдан клиентский сертификат.
+type Identity struct {
+	Kind string
+	Name string
+}
+
+// CA - локальный удостоверяющий центр.
+type CA struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+}
+
+// LoadOrCreateCA загружает CA из dir или создает новый, если его там нет.
+func LoadOrCreateCA(dir string) (*CA, error) {
+	certPath := filepath.Join(dir, caCertFile)
+	keyPath := filepath.Join(dir, caKeyFile)
+
+	if _, err := os.Stat(certPath); errors.Is(err, os.ErrNotExist) {
+		if err = createCA(dir, certPath, keyPath); err != nil {
+			return nil, err
+		}
+	}
+
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{cert: cert, certPEM: certPEM, key: key}, nil
+}
+
+func createCA(dir string, certPath string, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: caCommonName},
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              now.Add(caLifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return err
+	}
+
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err = os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// CertPEM возвращает сертификат CA, которому должны доверять клиенты.
+func (ca *CA) CertPEM() []byte {
+	return ca.certPEM
+}
+
+// ServerTLSConfig выпускает сертификат сервера для hosts и возвращает настройки TLS,
+// при которых сервер принимает, но не требует клиентские сертификаты этого CA.
+func (ca *CA) ServerTLSConfig(hosts []string) (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    now.Add(-clockSkew),
+		NotAfter:     now.Add(serverLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key}},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// SignCSR выпускает клиентский сертификат для identity на ключ из CSR.
+func (ca *CA) SignCSR(csrPEM []byte, identity Identity, lifetime time.Duration) (*x509.Certificate, []byte, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, nil, ErrInvalidCSR
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	if err = csr.CheckSignature(); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: identity.Name, OrganizationalUnit: []string{identity.Kind}},
+		NotBefore:    now.Add(-clockSkew),
+		NotAfter:     now.Add(lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// NewCSR создает ключ и запрос на сертификат для него.
+func NewCSR(commonName string) (csrPEM []byte, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM, err = encodePrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), keyPEM, nil
+}
+
+// Serial возвращает серийный номер сертификата в виде, в котором он хранится на сервере.
+func Serial(cert *x509.Certificate) string {
+	return hex.EncodeToString(cert.SerialNumber.Bytes())
+}
+
+// ParsePrivateKey разбирает ключ в PEM (PKCS #8).
+func ParsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("private key must be a PKCS #8 PEM block")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key cannot sign")
+	}
+	return signer, nil
+}
+
+func encodePrivateKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func parseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("certificate must be a PEM block")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func newSerial() (*big.Int, error) {
+	raw := make([]byte, serialLength)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	// старший бит сброшен, чтобы номер был положительным и занимал ровно serialLength байт в DER
+	raw[0] &= 0x7f
+	raw[0] |= 0x40
+	return new(big.Int).SetBytes(raw), nil
+}
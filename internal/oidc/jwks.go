@@ -0,0 +1,170 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval - не чаще этого ключи перечитываются из-за неизвестного kid,
+// чтобы поддельные токены не заставляли сервер ходить к провайдеру на каждый запрос.
+const jwksRefreshInterval = time.Minute
+
+// JWK - открытый ключ в формате RFC 7517. Поддерживаются RSA, EC P-256 и Ed25519.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS - набор ключей провайдера.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+type keySet struct {
+	url        string
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]any
+	fetchedAt time.Time
+}
+
+func newKeySet(url string, httpClient *http.Client) *keySet {
+	return &keySet{url: url, httpClient: httpClient}
+}
+
+// key возвращает ключ kid. Неизвестный kid означает, что провайдер сменил ключи,
+// и набор перечитывается.
+func (s *keySet) key(ctx context.Context, kid string, alg string) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[kid]
+	if !ok && time.Since(s.fetchedAt) > jwksRefreshInterval {
+		if err := s.fetch(ctx); err != nil {
+			return nil, err
+		}
+		key, ok = s.keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown ID token key %q", kid)
+	}
+
+	if !keyMatches(key, alg) {
+		return nil, fmt.Errorf("ID token key %q does not match algorithm %s", kid, alg)
+	}
+	return key, nil
+}
+
+func (s *keySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+
+	var set JWKS
+	provider := Provider{httpClient: s.httpClient}
+	if err = provider.doJSON(req, &set); err != nil {
+		return fmt.Errorf("could not load OIDC signing keys: %w", err)
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if len(jwk.Use) != 0 && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			// ключ неизвестного вида не мешает остальным
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	s.keys = keys
+	s.fetchedAt = time.Now()
+	return nil
+}
+
+// PublicKey возвращает открытый ключ JWK.
+func (jwk JWK) PublicKey() (any, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || n.BitLen() < 2048 {
+			return nil, errors.New("RSA key is too weak")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if jwk.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Curve)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if jwk.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("unsupported OKP key %q", jwk.Curve)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", jwk.KeyType)
+}
+
+func keyMatches(key any, alg string) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256"
+	case *ecdsa.PublicKey:
+		return alg == "ES256"
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+	return false
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
@@ -0,0 +1,224 @@
+// Package oidc - вход через внешний провайдер OpenID Connect по коду авторизации с PKCE.
+// Адреса провайдера берутся из /.well-known/openid-configuration, ID-токен проверяется
+// ключами из jwks_uri.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// DiscoveryPath - путь документа с настройками провайдера относительно издателя.
+	DiscoveryPath = "/.well-known/openid-configuration"
+
+	requestTimeout = time.Second * 10
+	// ответ провайдера больше этого не читается
+	maxResponseSize = 1 << 20
+	verifierLength  = 32
+)
+
+var (
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	ErrNonceMismatch  = errors.New("ID token nonce does not match the login")
+)
+
+// Discovery - поля документа /.well-known/openid-configuration, которые нужны серверу.
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// TokenResponse - ответ token_endpoint.
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in,omitempty"`
+}
+
+// Claims - утверждения ID-токена.
+type Claims struct {
+	jwt.RegisteredClaims
+	Nonce             string `json:"nonce,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     bool   `json:"email_verified,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+}
+
+// Provider - провайдер OpenID Connect, в котором сервер зарегистрирован как клиент.
+type Provider struct {
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	discovery    Discovery
+	keys         *keySet
+	httpClient   *http.Client
+}
+
+// Discover загружает настройки провайдера issuer. Издатель в документе должен совпадать
+// с запрошенным, иначе токены подделанного провайдера прошли бы проверку.
+func Discover(ctx context.Context, issuer string, clientID string, clientSecret string, redirectURL string) (*Provider, error) {
+	provider := &Provider{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		httpClient:   &http.Client{Timeout: requestTimeout},
+	}
+
+	issuer = strings.TrimSuffix(issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+DiscoveryPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = provider.doJSON(req, &provider.discovery); err != nil {
+		return nil, fmt.Errorf("could not load OIDC discovery document: %w", err)
+	}
+
+	if strings.TrimSuffix(provider.discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("OIDC discovery document is for issuer %q, not %q", provider.discovery.Issuer, issuer)
+	}
+	if len(provider.discovery.AuthorizationEndpoint) == 0 || len(provider.discovery.TokenEndpoint) == 0 ||
+		len(provider.discovery.JWKSURI) == 0 {
+		return nil, errors.New("OIDC discovery document has no authorization, token or JWKS endpoint")
+	}
+
+	provider.keys = newKeySet(provider.discovery.JWKSURI, provider.httpClient)
+	return provider, nil
+}
+
+// Issuer возвращает издателя провайдера. Вместе с sub он однозначно задает пользователя.
+func (p *Provider) Issuer() string {
+	return p.discovery.Issuer
+}
+
+// AuthCodeURL возвращает адрес, на котором пользователь входит у провайдера.
+func (p *Provider) AuthCodeURL(state string, nonce string, codeChallenge string) string {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientID},
+		"redirect_uri":          {p.redirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(p.discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.discovery.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange обменивает код авторизации на токены и возвращает проверенные утверждения
+// ID-токена. nonce должен совпадать с выданным при входе.
+func (p *Provider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"client_id":     {p.clientID},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(p.clientSecret) != 0 {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	var tokens TokenResponse
+	if err = p.doJSON(req, &tokens); err != nil {
+		return Claims{}, fmt.Errorf("could not exchange authorization code: %w", err)
+	}
+
+	claims, err := p.VerifyIDToken(ctx, tokens.IDToken)
+	if err != nil {
+		return Claims{}, err
+	}
+	if claims.Nonce != nonce {
+		return Claims{}, ErrNonceMismatch
+	}
+
+	return claims, nil
+}
+
+// VerifyIDToken проверяет подпись, издателя, аудиторию и срок действия ID-токена.
+func (p *Provider) VerifyIDToken(ctx context.Context, idToken string) (Claims, error) {
+	var claims Claims
+
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}))
+	_, err := parser.ParseWithClaims(idToken, &claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.keys.key(ctx, kid, token.Method.Alg())
+	})
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if !claims.VerifyIssuer(p.discovery.Issuer, true) {
+		return Claims{}, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	}
+	if !claims.VerifyAudience(p.clientID, true) {
+		return Claims{}, fmt.Errorf("%w: token is not for this client", ErrInvalidIDToken)
+	}
+	if claims.ExpiresAt == nil || len(claims.Subject) == 0 {
+		return Claims{}, fmt.Errorf("%w: exp and sub are required", ErrInvalidIDToken)
+	}
+
+	return claims, nil
+}
+
+func (p *Provider) doJSON(req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+
+	response, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded %d: %s", req.URL.Host, response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// NewVerifier создает случайную строку для PKCE, state или nonce.
+func NewVerifier() (string, error) {
+	raw := make([]byte, verifierLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Challenge возвращает code_challenge метода S256 для verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
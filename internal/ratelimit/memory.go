@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore хранит счетчики в памяти процесса. Подходит для одного сервера,
+// после перезапуска счетчики начинаются заново.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+type memoryEntry struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// NewMemoryStore создает пустое хранилище счетчиков в памяти.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+func (s *MemoryStore) LoginBlockedFor(_ context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return 0, nil
+	}
+
+	return max(entry.blockedUntil.Sub(time.Now()), 0), nil
+}
+
+func (s *MemoryStore) AddLoginFailure(_ context.Context, key string, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &memoryEntry{}
+		s.entries[key] = entry
+	}
+
+	if now.Sub(entry.lastFailure) > window {
+		entry.failures = 0
+	}
+	entry.failures++
+	entry.lastFailure = now
+
+	return entry.failures, nil
+}
+
+func (s *MemoryStore) BlockLogin(_ context.Context, key string, duration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &memoryEntry{}
+		s.entries[key] = entry
+	}
+
+	if until := time.Now().Add(duration); until.After(entry.blockedUntil) {
+		entry.blockedUntil = until
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) ResetLoginFailures(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) DeleteStaleLoginFailures(_ context.Context, olderThan time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var deleted int64
+	for key, entry := range s.entries {
+		if now.Sub(entry.lastFailure) > olderThan && !entry.blockedUntil.After(now) {
+			delete(s.entries, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,148 @@
+// Package ratelimit ограничивает попытки входа и регистрации. Неудачные попытки
+// считаются отдельно по аккаунту и по адресу клиента: после нескольких бесплатных
+// попыток каждая следующая откладывает новую на экспоненциально растущее время,
+// а после порога ключ блокируется целиком.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Store хранит счетчики неудачных попыток. Реплики сервера с общим Store
+// видят одни и те же счетчики.
+type Store interface {
+	// LoginBlockedFor возвращает, сколько еще заблокирован ключ. Ноль - ключ не заблокирован.
+	LoginBlockedFor(ctx context.Context, key string) (time.Duration, error)
+	// AddLoginFailure добавляет неудачную попытку и возвращает число попыток подряд.
+	// Счетчик начинается заново, если с прошлой неудачи прошло больше window.
+	AddLoginFailure(ctx context.Context, key string, window time.Duration) (int, error)
+	// BlockLogin блокирует ключ на duration. Более долгая блокировка не сокращается.
+	BlockLogin(ctx context.Context, key string, duration time.Duration) error
+	// ResetLoginFailures сбрасывает счетчик ключа.
+	ResetLoginFailures(ctx context.Context, key string) error
+	// DeleteStaleLoginFailures удаляет незаблокированные счетчики без неудач дольше olderThan.
+	DeleteStaleLoginFailures(ctx context.Context, olderThan time.Duration) (int64, error)
+}
+
+// Policy - правила блокировки одного вида ключей.
+type Policy struct {
+	// FreeAttempts неудачных попыток подряд не замедляют следующую.
+	FreeAttempts int
+	// BaseDelay - задержка после первой платной неудачи, дальше она удваивается до MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// После LockoutAfter неудач подряд ключ блокируется на LockoutDuration.
+	LockoutAfter    int
+	LockoutDuration time.Duration
+	// Window - через сколько после последней неудачи счетчик начинается заново.
+	Window time.Duration
+}
+
+// DefaultAccountPolicy - правила для логина: 3 попытки без задержки, блокировка после 10.
+var DefaultAccountPolicy = Policy{
+	FreeAttempts:    3,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Minute,
+	LockoutAfter:    10,
+	LockoutDuration: time.Minute * 15,
+	Window:          time.Hour,
+}
+
+// DefaultClientPolicy - правила для адреса клиента. За одним адресом может быть
+// несколько пользователей, поэтому порог выше.
+var DefaultClientPolicy = Policy{
+	FreeAttempts:    10,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Minute,
+	LockoutAfter:    50,
+	LockoutDuration: time.Minute * 15,
+	Window:          time.Hour,
+}
+
+// Delay возвращает, на сколько откладывается следующая попытка после failures неудач подряд.
+func (p Policy) Delay(failures int) time.Duration {
+	if p.LockoutAfter > 0 && failures >= p.LockoutAfter {
+		return p.LockoutDuration
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, p.MaxDelay)
+}
+
+// Key - счетчик попыток вместе с правилами его блокировки.
+type Key struct {
+	ID     string
+	Policy Policy
+}
+
+// Limiter применяет правила к счетчикам из Store.
+type Limiter struct {
+	store   Store
+	account Policy
+	client  Policy
+}
+
+// New создает Limiter с правилами для логинов и адресов клиентов.
+func New(store Store, account Policy, client Policy) *Limiter {
+	return &Limiter{store: store, account: account, client: client}
+}
+
+// Account возвращает ключ логина.
+func (l *Limiter) Account(login string) Key {
+	return Key{ID: "account:" + login, Policy: l.account}
+}
+
+// Client возвращает ключ адреса клиента.
+func (l *Limiter) Client(ip string) Key {
+	return Key{ID: "client:" + ip, Policy: l.client}
+}
+
+// RetryAfter возвращает, через сколько можно повторить попытку. Ноль - попытка разрешена.
+func (l *Limiter) RetryAfter(ctx context.Context, keys ...Key) (time.Duration, error) {
+	var wait time.Duration
+	for _, key := range keys {
+		blocked, err := l.store.LoginBlockedFor(ctx, key.ID)
+		if err != nil {
+			return 0, err
+		}
+		wait = max(wait, blocked)
+	}
+
+	return wait, nil
+}
+
+// Fail записывает неудачную попытку по всем ключам и блокирует те, что исчерпали попытки.
+func (l *Limiter) Fail(ctx context.Context, keys ...Key) error {
+	for _, key := range keys {
+		failures, err := l.store.AddLoginFailure(ctx, key.ID, key.Policy.Window)
+		if err != nil {
+			return err
+		}
+
+		if delay := key.Policy.Delay(failures); delay > 0 {
+			if err = l.store.BlockLogin(ctx, key.ID, delay); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Reset сбрасывает счетчик после успешной попытки.
+func (l *Limiter) Reset(ctx context.Context, key Key) error {
+	return l.store.ResetLoginFailures(ctx, key.ID)
+}
+
+// DeleteStale удаляет счетчики, которые больше не влияют на попытки.
+func (l *Limiter) DeleteStale(ctx context.Context) (int64, error) {
+	return l.store.DeleteStaleLoginFailures(ctx, max(l.account.Window, l.client.Window))
+}
@@ -0,0 +1,247 @@
+// Package srp реализует SRP-6a (RFC 5054): клиент доказывает серверу знание пароля,
+// не передавая его, а сервер хранит только верификатор, по которому пароль не
+// проверить без перебора. Группа - 2048-битная из приложения A RFC 5054, хеш - SHA-256.
+//
+// В отличие от RFC, x не зависит от логина, чтобы аккаунт можно было переименовать
+// без нового верификатора, а пароль перед хешированием растягивается Argon2id,
+// чтобы перебор по украденному верификатору был дорогим.
+package srp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"hash"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// SaltLength - длина соли верификатора.
+	SaltLength = 16
+
+	privateLength = 32
+
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+
+	// rfc5054Group2048 - простое N 2048-битной группы из приложения A RFC 5054.
+	rfc5054Group2048 = "" +
+		"AC6BDB41324A9A9BF166DE5E1389582FAF72B6651987EE07FC3192943DB56050" +
+		"A37329CBB4A099ED8193E0757767A13DD52312AB4B03310DCD7F48A9DA04FD50" +
+		"E8083969EDB767B0CF6095179A163AB3661A05FBD5FAAAE82918A9962F0B93B8" +
+		"55F97993EC975EEAA80D740ADBF4FF747359D041D5C33EA71D281E446B14773B" +
+		"CA97B43A23FB801676BD207A436C6481F1D2B9078717461A5B9D32E688F87748" +
+		"544523B524B0D57D5EA77A2775D2ECFA032CFBDBF52FB3786160279004E57AE6" +
+		"AF874E7303CE53299CCC041C7BC308D82A5698F3A8D0C38271AE35F8E9DBFBB6" +
+		"94B5C803D89F7AE435DE236D525F54759B65E372FCD68EF20FA7111F9E4AFF73"
+)
+
+// ErrInvalidPublic - публичное значение собеседника вне (0, N) или дает u = 0, с ним протокол небезопасен.
+var ErrInvalidPublic = errors.New("invalid SRP public value")
+
+// group - группа и хеш протокола. После создания не меняется, поэтому одну группу
+// можно использовать из разных горутин.
+type group struct {
+	N, g    *big.Int
+	length  int
+	newHash func() hash.Hash
+	// k = H(N | PAD(g))
+	multiplier *big.Int
+}
+
+// defaultGroup - группа, которой пользуются клиент и сервер.
+var defaultGroup = newGroup(mustHex(rfc5054Group2048), big.NewInt(2), sha256.New)
+
+func newGroup(N *big.Int, g *big.Int, newHash func() hash.Hash) *group {
+	grp := &group{N: N, g: g, length: len(N.Bytes()), newHash: newHash}
+	grp.multiplier = grp.hashInt(N.Bytes(), grp.pad(g))
+	return grp
+}
+
+func mustHex(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("srp: invalid group prime")
+	}
+	return n
+}
+
+// NewVerifier создает соль и верификатор пароля для регистрации.
+func NewVerifier(password string) (salt []byte, verifier []byte, err error) {
+	grp := defaultGroup
+
+	salt = make([]byte, SaltLength)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+
+	x := grp.passwordKey(salt, password)
+	return salt, grp.pad(new(big.Int).Exp(grp.g, x, grp.N)), nil
+}
+
+// ClientStart создает одноразовый секрет клиента a и публичное значение A = g^a.
+func ClientStart() (private []byte, public []byte, err error) {
+	grp := defaultGroup
+
+	a, err := randomPrivate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return a.Bytes(), grp.pad(new(big.Int).Exp(grp.g, a, grp.N)), nil
+}
+
+// ClientProof вычисляет доказательство клиента M1 и доказательство M2, которое должен
+// прислать сервер, знающий верификатор.
+func ClientProof(login string, password string, salt []byte, private []byte, public []byte, serverPublic []byte) (proof []byte, serverProof []byte, err error) {
+	grp := defaultGroup
+
+	B := new(big.Int).SetBytes(serverPublic)
+	if !grp.validPublic(B) {
+		return nil, nil, ErrInvalidPublic
+	}
+
+	A := new(big.Int).SetBytes(public)
+	a := new(big.Int).SetBytes(private)
+	// RFC 5054, 2.6: с u = 0 общий секрет не зависит от пароля, клиент прерывает вход
+	u := grp.scramble(A, B)
+	if u.Sign() == 0 {
+		return nil, nil, ErrInvalidPublic
+	}
+	x := grp.passwordKey(salt, password)
+
+	key := grp.hash(grp.pad(grp.clientSecret(a, x, u, B)))
+	proof = grp.clientProof(login, salt, A, B, key)
+	return proof, grp.hash(grp.pad(A), proof, key), nil
+}
+
+// ServerStart создает одноразовый секрет сервера b и публичное значение B = k*v + g^b.
+func ServerStart(verifier []byte) (private []byte, public []byte, err error) {
+	grp := defaultGroup
+
+	b, err := randomPrivate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return b.Bytes(), grp.pad(grp.serverPublic(new(big.Int).SetBytes(verifier), b)), nil
+}
+
+// ServerVerify проверяет доказательство клиента и возвращает доказательство сервера M2.
+func ServerVerify(login string, salt []byte, verifier []byte, clientPublic []byte, private []byte, public []byte, proof []byte) ([]byte, bool) {
+	grp := defaultGroup
+
+	A := new(big.Int).SetBytes(clientPublic)
+	if !grp.validPublic(A) {
+		return nil, false
+	}
+
+	B := new(big.Int).SetBytes(public)
+	b := new(big.Int).SetBytes(private)
+	u := grp.scramble(A, B)
+	if u.Sign() == 0 {
+		return nil, false
+	}
+
+	key := grp.hash(grp.pad(grp.serverSecret(new(big.Int).SetBytes(verifier), b, u, A)))
+	expected := grp.clientProof(login, salt, A, B, key)
+	if subtle.ConstantTimeCompare(expected, proof) != 1 {
+		return nil, false
+	}
+
+	return grp.hash(grp.pad(A), proof, key), true
+}
+
+// ValidPublic сообщает, можно ли продолжать протокол с публичным значением собеседника.
+func ValidPublic(public []byte) bool {
+	return defaultGroup.validPublic(new(big.Int).SetBytes(public))
+}
+
+// ValidVerifier проверяет соль и верификатор, присланные клиентом при регистрации.
+func ValidVerifier(salt []byte, verifier []byte) bool {
+	return len(salt) >= SaltLength && len(verifier) == defaultGroup.length && ValidPublic(verifier)
+}
+
+func (grp *group) validPublic(value *big.Int) bool {
+	return value.Sign() > 0 && value.Cmp(grp.N) < 0
+}
+
+// serverPublic возвращает B = k*v + g^b.
+func (grp *group) serverPublic(v *big.Int, b *big.Int) *big.Int {
+	B := new(big.Int).Mul(grp.multiplier, v)
+	B.Add(B, new(big.Int).Exp(grp.g, b, grp.N))
+	return B.Mod(B, grp.N)
+}
+
+// scramble возвращает u = H(PAD(A) | PAD(B)).
+func (grp *group) scramble(A *big.Int, B *big.Int) *big.Int {
+	return grp.hashInt(grp.pad(A), grp.pad(B))
+}
+
+// clientSecret возвращает общий секрет клиента S = (B - k * g^x) ^ (a + u * x).
+func (grp *group) clientSecret(a *big.Int, x *big.Int, u *big.Int, B *big.Int) *big.Int {
+	base := new(big.Int).Exp(grp.g, x, grp.N)
+	base.Mul(base, grp.multiplier)
+	base.Sub(B, base)
+	base.Mod(base, grp.N)
+
+	exp := new(big.Int).Mul(u, x)
+	exp.Add(exp, a)
+
+	return base.Exp(base, exp, grp.N)
+}
+
+// serverSecret возвращает общий секрет сервера S = (A * v^u) ^ b.
+func (grp *group) serverSecret(v *big.Int, b *big.Int, u *big.Int, A *big.Int) *big.Int {
+	base := new(big.Int).Exp(v, u, grp.N)
+	base.Mul(base, A)
+	base.Mod(base, grp.N)
+
+	return base.Exp(base, b, grp.N)
+}
+
+// M1 = H(H(N) xor H(g) | H(I) | s | A | B | K)
+func (grp *group) clientProof(login string, salt []byte, A *big.Int, B *big.Int, key []byte) []byte {
+	hN := grp.hash(grp.N.Bytes())
+	hG := grp.hash(grp.pad(grp.g))
+	for i := range hN {
+		hN[i] ^= hG[i]
+	}
+
+	return grp.hash(hN, grp.hash([]byte(strings.TrimSpace(login))), salt, grp.pad(A), grp.pad(B), key)
+}
+
+// passwordKey возвращает x = H(s | Argon2id(P, s)).
+func (grp *group) passwordKey(salt []byte, password string) *big.Int {
+	stretched := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, sha256.Size)
+	return grp.hashInt(salt, stretched)
+}
+
+func randomPrivate() (*big.Int, error) {
+	raw := make([]byte, privateLength)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+func (grp *group) pad(value *big.Int) []byte {
+	return value.FillBytes(make([]byte, grp.length))
+}
+
+func (grp *group) hash(parts ...[]byte) []byte {
+	h := grp.newHash()
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+func (grp *group) hashInt(parts ...[]byte) *big.Int {
+	return new(big.Int).SetBytes(grp.hash(parts...))
+}
@@ -0,0 +1,212 @@
+package srp
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func hexInt(t *testing.T, s string) *big.Int {
+	t.Helper()
+
+	n, ok := new(big.Int).SetString(strings.Join(strings.Fields(s), ""), 16)
+	if !ok {
+		t.Fatalf("invalid hex %q", s)
+	}
+	return n
+}
+
+// TestRFC5054Vectors сверяет вычисления протокола с приложением B RFC 5054.
+// Группа RFC - 1024 бит с SHA-1. x в пакете выводится через Argon2id, поэтому берется готовым из RFC.
+func TestRFC5054Vectors(t *testing.T) {
+	grp := newGroup(hexInt(t, `
+		EEAF0AB9 ADB38DD6 9C33F80A FA8FC5E8 60726187 75FF3C0B 9EA2314C
+		9C256576 D674DF74 96EA81D3 383B4813 D692C6E0 E0D5D8E2 50B98BE4
+		8E495C1D 6089DAD1 5DC7D7B4 6154D6B6 CE8EF4AD 69B15D49 82559B29
+		7BCF1885 C529F566 660E57EC 68EDBC3C 05726CC0 2FD4CBF4 976EAA9A
+		FD5138FE 8376435B 9FC61D2F C0EB06E3`), big.NewInt(2), sha1.New)
+
+	x := hexInt(t, "94B7555A ABE9127C C58CCF49 93DB6CF8 4D16C124")
+	a := hexInt(t, `
+		60975527 035CF2AD 1989806F 0407210B C81EDC04 E2762A56 AFD529DD
+		DA2D4393`)
+	b := hexInt(t, `
+		E487CB59 D31AC550 471E81F0 0F6928E0 1DDA08E9 74A004F4 9E61F5D1
+		05284D20`)
+
+	wantK := hexInt(t, "7556AA04 5AEF2CDD 07ABAF0F 665C3E81 8913186F")
+	wantV := hexInt(t, `
+		7E273DE8 696FFC4F 4E337D05 B4B375BE B0DDE156 9E8FA00A 9886D812
+		9BADA1F1 822223CA 1A605B53 0E379BA4 729FDC59 F105B478 7E5186F5
+		C671085A 1447B52A 48CF1970 B4FB6F84 00BBF4CE BFBB1681 52E08AB5
+		EA53D15C 1AFF87B2 B9DA6E04 E058AD51 CC72BFC9 033B564E 26480D78
+		E955A5E2 9E7AB245 DB2BE315 E2099AFB`)
+	wantA := hexInt(t, `
+		61D5E490 F6F1B795 47B0704C 436F523D D0E560F0 C64115BB 72557EC4
+		4352E890 3211C046 92272D8B 2D1A5358 A2CF1B6E 0BFCF99F 921530EC
+		8E393561 79EAE45E 42BA92AE ACED8251 71E1E8B9 AF6D9C03 E1327F44
+		BE087EF0 6530E69F 66615261 EEF54073 CA11CF58 58F0EDFD FE15EFEA
+		B349EF5D 76988A36 72FAC47B 0769447B`)
+	wantB := hexInt(t, `
+		BD0C6151 2C692C0C B6D041FA 01BB152D 4916A1E7 7AF46AE1 05393011
+		BAF38964 DC46A067 0DD125B9 5A981652 236F99D9 B681CBF8 7837EC99
+		6C6DA044 53728610 D0C6DDB5 8B318885 D7D82C7F 8DEB75CE 7BD4FBAA
+		37089E6F 9C6059F3 88838E7A 00030B33 1EB76840 910440B1 B27AAEAE
+		EB4012B7 D7665238 A8E3FB00 4B117B58`)
+	wantU := hexInt(t, "CE38B959 3487DA98 554ED47D 70A7AE5F 462EF019")
+	wantS := hexInt(t, `
+		B0DC82BA BCF30674 AE450C02 87745E79 90A3381F 63B387AA F271A10D
+		233861E3 59B48220 F7C4693C 9AE12B0A 6F67809F 0876E2D0 13800D6C
+		41BB59B6 D5979B5C 00A172B4 A2A5903A 0BDCAF8A 709585EB 2AFAFA8F
+		3499B200 210DCC1F 10EB3394 3CD67FC8 8A2F39A4 BE5BEC4E C0A3212D
+		C346D7E4 74B29EDE 8A469FFE CA686E5A`)
+
+	v := new(big.Int).Exp(grp.g, x, grp.N)
+	A := new(big.Int).Exp(grp.g, a, grp.N)
+	B := grp.serverPublic(v, b)
+	u := grp.scramble(A, B)
+
+	tests := []struct {
+		name string
+		got  *big.Int
+		want *big.Int
+	}{
+		{name: "k", got: grp.multiplier, want: wantK},
+		{name: "v", got: v, want: wantV},
+		{name: "A", got: A, want: wantA},
+		{name: "B", got: B, want: wantB},
+		{name: "u", got: u, want: wantU},
+		{name: "client S", got: grp.clientSecret(a, x, u, B), want: wantS},
+		{name: "server S", got: grp.serverSecret(v, b, u, A), want: wantS},
+	}
+
+	for _, tt := range tests {
+		if tt.got.Cmp(tt.want) != 0 {
+			t.Errorf("%s = %X, want %X", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+// TestGroup проверяет, что N - 2048-битное безопасное простое из приложения A RFC 5054.
+func TestGroup(t *testing.T) {
+	if defaultGroup.N.BitLen() != 2048 || defaultGroup.length != 256 {
+		t.Fatalf("N has %d bits, padded length %d", defaultGroup.N.BitLen(), defaultGroup.length)
+	}
+	if !defaultGroup.N.ProbablyPrime(20) {
+		t.Fatalf("N is not prime")
+	}
+
+	q := new(big.Int).Rsh(defaultGroup.N, 1)
+	if !q.ProbablyPrime(20) {
+		t.Fatalf("(N-1)/2 is not prime")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	const (
+		login    = "alice"
+		password = "password123"
+	)
+
+	salt, verifier, err := NewVerifier(password)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	if !ValidVerifier(salt, verifier) {
+		t.Fatalf("ValidVerifier() = false for a new verifier")
+	}
+
+	tests := []struct {
+		name        string
+		login       string
+		password    string
+		serverLogin string
+		tamper      bool
+		ok          bool
+	}{
+		{name: "right password", login: login, password: password, serverLogin: login, ok: true},
+		{name: "login with spaces", login: " alice ", password: password, serverLogin: login, ok: true},
+		{name: "wrong password", login: login, password: "password124", serverLogin: login},
+		{name: "other login", login: "bob", password: password, serverLogin: login},
+		{name: "tampered proof", login: login, password: password, serverLogin: login, tamper: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, A, err := ClientStart()
+			if err != nil {
+				t.Fatalf("ClientStart: %v", err)
+			}
+			b, B, err := ServerStart(verifier)
+			if err != nil {
+				t.Fatalf("ServerStart: %v", err)
+			}
+
+			proof, expected, err := ClientProof(tt.login, tt.password, salt, a, A, B)
+			if err != nil {
+				t.Fatalf("ClientProof: %v", err)
+			}
+			if tt.tamper {
+				proof[0] ^= 1
+			}
+
+			serverProof, ok := ServerVerify(tt.serverLogin, salt, verifier, A, b, B, proof)
+			if ok != tt.ok {
+				t.Fatalf("ServerVerify() ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && !bytes.Equal(serverProof, expected) {
+				t.Errorf("server proof does not match the one expected by the client")
+			}
+		})
+	}
+}
+
+func TestInvalidPublic(t *testing.T) {
+	_, verifier, err := NewVerifier("password")
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	b, B, err := ServerStart(verifier)
+	if err != nil {
+		t.Fatalf("ServerStart: %v", err)
+	}
+	a, A, err := ClientStart()
+	if err != nil {
+		t.Fatalf("ClientStart: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		public []byte
+	}{
+		{name: "empty", public: nil},
+		{name: "zero", public: make([]byte, defaultGroup.length)},
+		{name: "N", public: defaultGroup.pad(defaultGroup.N)},
+		{name: "2N", public: new(big.Int).Lsh(defaultGroup.N, 1).Bytes()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ValidPublic(tt.public) {
+				t.Errorf("ValidPublic() = true")
+			}
+			if ValidVerifier(make([]byte, SaltLength), tt.public) {
+				t.Errorf("ValidVerifier() = true")
+			}
+			if _, _, err := ClientProof("alice", "password", make([]byte, SaltLength), a, A, tt.public); !errors.Is(err, ErrInvalidPublic) {
+				t.Errorf("ClientProof() error = %v, want %v", err, ErrInvalidPublic)
+			}
+			// с A = 0 (mod N) общий секрет не зависит от пароля, и любое доказательство подошло бы
+			if _, ok := ServerVerify("alice", make([]byte, SaltLength), verifier, tt.public, b, B, make([]byte, 32)); ok {
+				t.Errorf("ServerVerify() accepted an invalid client public value")
+			}
+		})
+	}
+
+	if ValidVerifier(make([]byte, SaltLength-1), verifier) {
+		t.Errorf("ValidVerifier() accepted a short salt")
+	}
+}